@@ -3,13 +3,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/ardanlabs/conf"
+	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/exchange"
+	"github.com/vikramcse/the-service/internal/inventory"
 	"github.com/vikramcse/the-service/internal/platform/database"
+	"github.com/vikramcse/the-service/internal/platform/period"
+	"github.com/vikramcse/the-service/internal/product"
 	"github.com/vikramcse/the-service/internal/schema"
 )
 
@@ -29,6 +40,11 @@ func run() error {
 			Name       string `conf:"default:postgres"`
 			DisableTLS bool   `conf:"default:false"`
 		}
+		Export struct {
+			Format string `conf:"default:csv"`
+			From   string
+			To     string
+		}
 		Args conf.Args
 	}
 
@@ -69,7 +85,159 @@ func run() error {
 			return errors.Wrap(err, "seeding database")
 		}
 		fmt.Println("Seed data complete")
+
+	case "reconcile":
+		if err := reconcile(db, cfg.Args.Num(1) == "fix"); err != nil {
+			return errors.Wrap(err, "reconciling inventory")
+		}
+
+	case "import":
+		if cfg.Args.Num(1) != "products" || cfg.Args.Num(2) == "" {
+			return errors.New("usage: import products <csv or jsonl file> [chunked]")
+		}
+		if err := importProducts(db, cfg.Args.Num(2), cfg.Args.Num(3)); err != nil {
+			return errors.Wrap(err, "importing products")
+		}
+
+	case "export":
+		if cfg.Args.Num(1) != "products" && cfg.Args.Num(1) != "sales" {
+			return errors.New("usage: export <products or sales> [--export-format=csv|jsonl] [--export-from=date] [--export-to=date]")
+		}
+		if err := export(db, cfg.Args.Num(1), cfg.Export.Format, cfg.Export.From, cfg.Export.To); err != nil {
+			return errors.Wrapf(err, "exporting %s", cfg.Args.Num(1))
+		}
+
+	case "rates":
+		if cfg.Args.Num(1) != "import" || cfg.Args.Num(2) == "" {
+			return errors.New("usage: rates import <csv file>")
+		}
+		if err := importRates(db, cfg.Args.Num(2)); err != nil {
+			return errors.Wrap(err, "importing exchange rates")
+		}
+	}
+
+	return nil
+}
+
+// reconcile reports every product and variant whose stored quantity disagrees
+// with the inventory ledger. When fix is set the stored quantities are
+// rewritten to match the ledger.
+func reconcile(db *sqlx.DB, fix bool) error {
+	ctx := audit.NewContext(context.Background(), &audit.Request{Actor: "sales-admin"})
+
+	drifts, err := inventory.Reconcile(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	if len(drifts) == 0 {
+		fmt.Println("Inventory is in balance")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PRODUCT\tNAME\tQUANTITY\tSOLD\tON HAND\tDRIFT")
+	for _, d := range drifts {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%+d\n", d.ProductID, d.Name, d.Quantity, d.Sold, d.OnHand, d.Diff())
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if !fix {
+		fmt.Println("Run \"reconcile fix\" to apply the ledger quantities")
+		return nil
+	}
+
+	if err := inventory.Fix(ctx, db, drifts, time.Now()); err != nil {
+		return err
+	}
+	fmt.Printf("Fixed %d quantities\n", len(drifts))
+
+	return nil
+}
+
+// importRates records the exchange rates in the CSV file at path.
+func importRates(db *sqlx.DB, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx := audit.NewContext(context.Background(), &audit.Request{Actor: "sales-admin"})
+	n, err := exchange.Import(ctx, db, f, time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d exchange rates\n", n)
+
+	return nil
+}
+
+// importProducts creates or updates a product for every row of the catalog
+// at path, which is read as JSON Lines when its extension is .jsonl or
+// .ndjson and as CSV otherwise. A mode of chunked commits rows as they are
+// read instead of importing all of them or none.
+func importProducts(db *sqlx.DB, path, mode string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	opts := product.ImportOptions{Format: product.CSV, Mode: mode}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson":
+		opts.Format = product.JSONL
+	}
+
+	ctx := audit.NewContext(context.Background(), &audit.Request{Actor: "sales-admin"})
+	rep, err := product.Import(ctx, db, f, opts, time.Now())
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROW\tSKU\tSTATUS\tPRODUCT\tREASON")
+	for _, row := range rep.Rows {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", row.Row, row.SKU, row.Status, row.ProductID, row.Reason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Created %d, updated %d, rejected %d products\n", rep.Created, rep.Updated, rep.Rejected)
+	if !rep.Committed {
+		return errors.New("nothing was imported because rows were rejected")
+	}
+
+	return nil
+}
+
+// export writes the products or sales created between from and to to standard
+// output in format, csv or jsonl. from and to are RFC 3339 timestamps or dates
+// in UTC and either may be left empty.
+func export(db *sqlx.DB, what, format, from, to string) error {
+	var span [2]time.Time
+	for i, v := range []string{from, to} {
+		t, err := period.Parse(v, time.UTC)
+		if err != nil {
+			return errors.Wrapf(err, "parsing %q", v)
+		}
+		span[i] = t
+	}
+
+	exportFn := product.ExportProducts
+	if what == "sales" {
+		exportFn = product.ExportSales
+	}
+
+	n, err := exportFn(context.Background(), db, os.Stdout, format, span[0], span[1])
+	if err != nil {
+		return err
 	}
+	fmt.Fprintf(os.Stderr, "Exported %d %s\n", n, what)
 
 	return nil
 }
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/accounting"
+	"github.com/vikramcse/the-service/internal/platform/period"
+	"github.com/vikramcse/the-service/internal/platform/web"
+)
+
+// Accounting holds the handlers for the accounting journal.
+type Accounting struct {
+	DB  *sqlx.DB
+	Log *log.Logger
+}
+
+// Accounts gets the chart of accounts.
+func (a *Accounting) Accounts(w http.ResponseWriter, r *http.Request) error {
+	accounts, err := accounting.ListAccounts(r.Context(), a.DB)
+	if err != nil {
+		return errors.Wrap(err, "getting accounts")
+	}
+
+	return web.Respond(r.Context(), w, accounts, http.StatusOK)
+}
+
+// TrialBalance gets the balance of every account moved between the query
+// parameters from and to, read as for the sales report in UTC. Leaving out
+// from gives the balances as at to.
+func (a *Accounting) TrialBalance(w http.ResponseWriter, r *http.Request) error {
+	from, to, err := parsePeriod(r)
+	if err != nil {
+		return err
+	}
+
+	tb, err := accounting.Balances(r.Context(), a.DB, from, to)
+	if err != nil {
+		if err == period.ErrInvalidRange {
+			return web.NewRequestError(err, http.StatusBadRequest)
+		}
+		return errors.Wrap(err, "building trial balance")
+	}
+
+	return web.Respond(r.Context(), w, tb, http.StatusOK)
+}
+
+// Journal exports the entries posted between the query parameters from and
+// to as JSON, or as CSV for the general ledger when asked for with the Accept
+// header or format=csv.
+func (a *Accounting) Journal(w http.ResponseWriter, r *http.Request) error {
+	from, to, err := parsePeriod(r)
+	if err != nil {
+		return err
+	}
+
+	media := "text/csv"
+	if r.URL.Query().Get("format") != "csv" {
+		if media, err = web.Negotiate(r, "application/json", "text/csv"); err != nil {
+			return err
+		}
+	}
+
+	entries, err := accounting.Journal(r.Context(), a.DB, from, to)
+	if err != nil {
+		if err == period.ErrInvalidRange {
+			return web.NewRequestError(err, http.StatusBadRequest)
+		}
+		return errors.Wrap(err, "getting journal")
+	}
+
+	if media == "application/json" {
+		return web.RespondAs(r.Context(), w, entries, media, http.StatusOK)
+	}
+
+	var buf bytes.Buffer
+	if err := accounting.WriteCSV(&buf, entries); err != nil {
+		return errors.Wrap(err, "writing journal")
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="journal.csv"`)
+	return web.RespondRaw(r.Context(), w, buf.Bytes(), "text/csv; charset=utf-8", http.StatusOK)
+}
+
+// parsePeriod reads the from and to query parameters of a request.
+func parsePeriod(r *http.Request) (time.Time, time.Time, error) {
+	v := r.URL.Query()
+
+	from, err := period.Parse(v.Get("from"), time.UTC)
+	if err != nil {
+		return time.Time{}, time.Time{}, web.NewRequestError(errors.Wrap(err, "from"), http.StatusBadRequest)
+	}
+	to, err := period.Parse(v.Get("to"), time.UTC)
+	if err != nil {
+		return time.Time{}, time.Time{}, web.NewRequestError(errors.Wrap(err, "to"), http.StatusBadRequest)
+	}
+
+	return from, to, nil
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/platform/web"
+)
+
+// Audit holds the handlers for the audit log.
+type Audit struct {
+	DB  *sqlx.DB
+	Log *log.Logger
+}
+
+// List gets the latest entries of the audit log about the entity type named
+// by the query parameter entity, such as product, and identified by id, up to
+// limit of them.
+func (a *Audit) List(w http.ResponseWriter, r *http.Request) error {
+	v := r.URL.Query()
+
+	var limit int
+	if l := v.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			return web.NewRequestError(errors.New("limit must be a positive whole number"), http.StatusBadRequest)
+		}
+		limit = n
+	}
+	if v.Get("id") != "" && v.Get("entity") == "" {
+		return web.NewRequestError(errors.New("id must be given with entity"), http.StatusBadRequest)
+	}
+
+	entries, err := audit.List(r.Context(), a.DB, v.Get("entity"), v.Get("id"), limit)
+	if err != nil {
+		return errors.Wrap(err, "getting audit entries")
+	}
+
+	return web.Respond(r.Context(), w, entries, http.StatusOK)
+}
+
+// Verify checks that no entry of the audit log has been tampered with.
+func (a *Audit) Verify(w http.ResponseWriter, r *http.Request) error {
+	v, err := audit.Verify(r.Context(), a.DB)
+	if err != nil {
+		return errors.Wrap(err, "verifying audit log")
+	}
+
+	return web.Respond(r.Context(), w, v, http.StatusOK)
+}
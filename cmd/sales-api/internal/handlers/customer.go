@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/customer"
+	"github.com/vikramcse/the-service/internal/platform/web"
+	"github.com/vikramcse/the-service/internal/product"
+)
+
+// Customers holds the handlers for managing who we sell to.
+type Customers struct {
+	DB  *sqlx.DB
+	Log *log.Logger
+}
+
+// List gets all customers.
+func (c *Customers) List(w http.ResponseWriter, r *http.Request) error {
+	list, err := customer.List(r.Context(), c.DB)
+	if err != nil {
+		return errors.Wrap(err, "getting customer list")
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
+
+// Retrive gets a single customer.
+func (c *Customers) Retrive(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	cus, err := customer.Retrive(r.Context(), c.DB, id)
+	if err != nil {
+		if status := customerStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "getting customer %q", id)
+	}
+
+	return web.Respond(r.Context(), w, cus, http.StatusOK)
+}
+
+// Create decodes the body of a request to create a new customer. The full
+// customer with generated fields is sent back in the response.
+func (c *Customers) Create(w http.ResponseWriter, r *http.Request) error {
+	var nc customer.NewCustomer
+	if err := web.Decoder(r, &nc); err != nil {
+		return errors.Wrap(err, "decoding new customer")
+	}
+
+	cus, err := customer.Create(r.Context(), c.DB, nc, time.Now())
+	if err != nil {
+		if status := customerStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrap(err, "creating new customer")
+	}
+
+	return web.Respond(r.Context(), w, cus, http.StatusCreated)
+}
+
+// Update decodes the body of a request to change a customer. Only the fields
+// given are changed.
+func (c *Customers) Update(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	var uc customer.UpdateCustomer
+	if err := web.Decoder(r, &uc); err != nil {
+		return errors.Wrap(err, "decoding customer update")
+	}
+
+	cus, err := customer.Update(r.Context(), c.DB, id, uc, time.Now())
+	if err != nil {
+		if status := customerStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "updating customer %q", id)
+	}
+
+	return web.Respond(r.Context(), w, cus, http.StatusOK)
+}
+
+// Delete removes a customer. Their sales are kept.
+func (c *Customers) Delete(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	if err := customer.Delete(r.Context(), c.DB, id, time.Now()); err != nil {
+		if status := customerStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "deleting customer %q", id)
+	}
+
+	return web.Respond(r.Context(), w, nil, http.StatusNoContent)
+}
+
+// Sales gets the purchase history and lifetime value of a customer.
+func (c *Customers) Sales(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	h, err := product.CustomerHistory(r.Context(), c.DB, id)
+	if err != nil {
+		if status := customerStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "getting sales of customer %q", id)
+	}
+
+	return web.Respond(r.Context(), w, h, http.StatusOK)
+}
+
+// customerStatus gives the HTTP status for errors about customers the client
+// can fix, or 0 for anything else.
+func customerStatus(err error) int {
+	switch err {
+	case customer.ErrNotFound:
+		return http.StatusNotFound
+	case customer.ErrInvalidID, customer.ErrNoName, customer.ErrInvalidEmail, customer.ErrInvalidPhone:
+		return http.StatusBadRequest
+	case customer.ErrDuplicateEmail, customer.ErrDuplicatePhone:
+		return http.StatusConflict
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/exchange"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/web"
+)
+
+// Exchange holds the handlers for managing exchange rates.
+type Exchange struct {
+	DB  *sqlx.DB
+	Log *log.Logger
+}
+
+// List gets every exchange rate.
+func (ex *Exchange) List(w http.ResponseWriter, r *http.Request) error {
+	list, err := exchange.List(r.Context(), ex.DB)
+	if err != nil {
+		return errors.Wrap(err, "getting exchange rate list")
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
+
+// Set records the exchange rate in the request body.
+func (ex *Exchange) Set(w http.ResponseWriter, r *http.Request) error {
+	var nr exchange.NewRate
+	if err := web.Decoder(r, &nr); err != nil {
+		return errors.Wrap(err, "decoding new exchange rate")
+	}
+
+	rate, err := exchange.Set(r.Context(), ex.DB, nr, time.Now())
+	if err != nil {
+		switch err {
+		case money.ErrUnknownCurrency, exchange.ErrInvalidRate, exchange.ErrSamePair:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "setting exchange rate")
+		}
+	}
+
+	return web.Respond(r.Context(), w, rate, http.StatusCreated)
+}
+
+// Import records every exchange rate in the CSV request body. No rates are
+// recorded if any line is rejected.
+func (ex *Exchange) Import(w http.ResponseWriter, r *http.Request) error {
+	n, err := exchange.Import(r.Context(), ex.DB, r.Body, time.Now())
+	if err != nil {
+		switch errors.Cause(err) {
+		case exchange.ErrMalformed, money.ErrUnknownCurrency, exchange.ErrInvalidRate, exchange.ErrSamePair:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "importing exchange rates")
+		}
+	}
+
+	resp := struct {
+		Imported int `json:"imported"`
+	}{n}
+
+	return web.Respond(r.Context(), w, resp, http.StatusCreated)
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/platform/period"
+	"github.com/vikramcse/the-service/internal/platform/web"
+	"github.com/vikramcse/the-service/internal/product"
+)
+
+// Exports holds the handlers that stream data out for analysis.
+type Exports struct {
+	DB  *sqlx.DB
+	Log *log.Logger
+}
+
+// exportFunc writes the rows of an export created between from and to to w.
+type exportFunc func(ctx context.Context, db *sqlx.DB, w io.Writer, format string, from, to time.Time) (int, error)
+
+// Products streams every product created between the query parameters from
+// and to.
+func (e *Exports) Products(w http.ResponseWriter, r *http.Request) error {
+	return e.stream(w, r, "products", product.ExportProducts)
+}
+
+// Sales streams every sale made between the query parameters from and to.
+func (e *Exports) Sales(w http.ResponseWriter, r *http.Request) error {
+	return e.stream(w, r, "sales", product.ExportSales)
+}
+
+// stream sends the export named name as CSV, or as JSON Lines when asked for
+// with the Accept header or format=jsonl, a row at a time as it is read.
+func (e *Exports) stream(w http.ResponseWriter, r *http.Request, name string, export exportFunc) error {
+	from, to, err := parsePeriod(r)
+	if err != nil {
+		return err
+	}
+
+	format, media := r.URL.Query().Get("format"), "text/csv"
+	switch format {
+	case "":
+		if media, err = web.Negotiate(r, "text/csv", "application/jsonl", "application/x-ndjson"); err != nil {
+			return err
+		}
+		if format = product.CSV; media != "text/csv" {
+			format = product.JSONL
+		}
+	case product.JSONL:
+		media = "application/jsonl"
+	}
+
+	// JSON Lines go out labelled with whichever of its names was asked for.
+	contentType := media
+	if media == "text/csv" {
+		contentType += "; charset=utf-8"
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.`+format+`"`)
+	s := web.NewStream(r.Context(), w, contentType, http.StatusOK)
+
+	if _, err := export(r.Context(), e.DB, s, format, from, to); err != nil {
+		// Once rows have gone out the status has gone with them, so all
+		// that is left to do is stop.
+		if s.Started() {
+			e.Log.Printf("ERROR: streaming %s export: %+v", name, err)
+			return nil
+		}
+
+		w.Header().Del("Content-Disposition")
+		switch errors.Cause(err) {
+		case product.ErrInvalidFormat, period.ErrInvalidRange:
+			return web.NewRequestError(errors.Cause(err), http.StatusBadRequest)
+		}
+		return err
+	}
+
+	return s.Close()
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/customer"
+	"github.com/vikramcse/the-service/internal/giftcard"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/web"
+	"github.com/vikramcse/the-service/internal/product"
+)
+
+// GiftCards holds the handlers for gift cards and store credit.
+type GiftCards struct {
+	DB  *sqlx.DB
+	Log *log.Logger
+}
+
+// Issue sells the gift card described in the request body. The response
+// holds its generated code.
+func (g *GiftCards) Issue(w http.ResponseWriter, r *http.Request) error {
+	var nc giftcard.NewCard
+	if err := web.Decoder(r, &nc); err != nil {
+		return errors.Wrap(err, "decoding new gift card")
+	}
+
+	c, err := giftcard.Issue(r.Context(), g.DB, nc, time.Now())
+	if err != nil {
+		switch err {
+		case customer.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case giftcard.ErrInvalidAmount, money.ErrUnknownCurrency, customer.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "issuing gift card")
+		}
+	}
+
+	return web.Respond(r.Context(), w, c, http.StatusCreated)
+}
+
+// Retrive gets a gift card and its balance by code.
+func (g *GiftCards) Retrive(w http.ResponseWriter, r *http.Request) error {
+	code := chi.URLParam(r, "code")
+
+	c, err := giftcard.Retrive(r.Context(), g.DB, code)
+	if err != nil {
+		switch err {
+		case giftcard.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case giftcard.ErrInvalidCode:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "getting gift card")
+		}
+	}
+
+	return web.Respond(r.Context(), w, c, http.StatusOK)
+}
+
+// Transactions gets every change to the balance of a gift card.
+func (g *GiftCards) Transactions(w http.ResponseWriter, r *http.Request) error {
+	code := chi.URLParam(r, "code")
+
+	list, err := giftcard.Transactions(r.Context(), g.DB, code)
+	if err != nil {
+		switch err {
+		case giftcard.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case giftcard.ErrInvalidCode:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "getting gift card transactions")
+		}
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
+
+// Refund gives back part or all of a sale as store credit. The credit goes
+// onto the card with the code in the request body, or onto a new one. Any
+// units brought back are put back in stock.
+func (g *GiftCards) Refund(w http.ResponseWriter, r *http.Request) error {
+	var nr giftcard.NewRefund
+	if err := web.Decoder(r, &nr); err != nil {
+		return errors.Wrap(err, "decoding refund")
+	}
+	nr.SaleID = chi.URLParam(r, "id")
+
+	c, err := giftcard.RefundSale(r.Context(), g.DB, nr, time.Now())
+	if err != nil {
+		switch err {
+		case product.ErrSaleNotFound, giftcard.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case giftcard.ErrInvalidID, giftcard.ErrInvalidAmount, giftcard.ErrInvalidCode, giftcard.ErrInvalidQuantity,
+			money.ErrMismatch:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case product.ErrRefundTooMuch, product.ErrReturnTooMuch:
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrapf(err, "refunding sale %q", nr.SaleID)
+		}
+	}
+
+	return web.Respond(r.Context(), w, c, http.StatusCreated)
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/inventory"
+	"github.com/vikramcse/the-service/internal/platform/web"
+)
+
+// Inventory holds the handlers for the stock ledger of products.
+type Inventory struct {
+	DB  *sqlx.DB
+	Log *log.Logger
+}
+
+// Adjust records a manual stock movement such as a delivery or a write-off
+// for a particular product, made by whoever the X-Actor header names. That
+// header is not authenticated, see mid.Audit. The recorded movement is
+// returned to the caller.
+func (i *Inventory) Adjust(w http.ResponseWriter, r *http.Request) error {
+	var na inventory.NewAdjustment
+	if err := web.Decoder(r, &na); err != nil {
+		return errors.Wrap(err, "decoding new adjustment")
+	}
+
+	productID := chi.URLParam(r, "id")
+
+	m, err := inventory.Adjust(r.Context(), i.DB, na, productID, time.Now())
+	if err != nil {
+		switch err {
+		case inventory.ErrNotFound, inventory.ErrVariantNotFound, inventory.ErrLocationNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case inventory.ErrInvalidID, inventory.ErrInvalidKind, inventory.ErrInvalidQuantity,
+			inventory.ErrInvalidCost:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "adjusting stock of product %q", productID)
+		}
+	}
+
+	return web.Respond(r.Context(), w, m, http.StatusCreated)
+}
+
+// Movements gets the stock ledger of a particular product, or of one of its
+// variants when the variant_id query parameter is given.
+func (i *Inventory) Movements(w http.ResponseWriter, r *http.Request) error {
+	productID := chi.URLParam(r, "id")
+	variantID := r.URL.Query().Get("variant_id")
+
+	l, err := inventory.ListMovements(r.Context(), i.DB, productID, variantID)
+	if err != nil {
+		switch err {
+		case inventory.ErrNotFound, inventory.ErrVariantNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case inventory.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "getting movements of product %q", productID)
+		}
+	}
+
+	return web.Respond(r.Context(), w, l, http.StatusOK)
+}
+
+// Stock breaks down the availability of a particular product, or of one of
+// its variants when the variant_id query parameter is given, per location.
+func (i *Inventory) Stock(w http.ResponseWriter, r *http.Request) error {
+	productID := chi.URLParam(r, "id")
+	variantID := r.URL.Query().Get("variant_id")
+
+	stock, err := inventory.StockByLocation(r.Context(), i.DB, productID, variantID)
+	if err != nil {
+		switch err {
+		case inventory.ErrNotFound, inventory.ErrVariantNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case inventory.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "getting stock of product %q", productID)
+		}
+	}
+
+	return web.Respond(r.Context(), w, stock, http.StatusOK)
+}
+
+// ListLocations gets all locations stock is kept at.
+func (i *Inventory) ListLocations(w http.ResponseWriter, r *http.Request) error {
+	list, err := inventory.ListLocations(r.Context(), i.DB)
+	if err != nil {
+		return errors.Wrap(err, "getting location list")
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
+
+// CreateLocation decodes the body of a request to add a new location.
+func (i *Inventory) CreateLocation(w http.ResponseWriter, r *http.Request) error {
+	var nl inventory.NewLocation
+	if err := web.Decoder(r, &nl); err != nil {
+		return errors.Wrap(err, "decoding new location")
+	}
+
+	l, err := inventory.CreateLocation(r.Context(), i.DB, nl, time.Now())
+	if err != nil {
+		if err == inventory.ErrInvalidLocationKind {
+			return web.NewRequestError(err, http.StatusBadRequest)
+		}
+		return errors.Wrap(err, "creating new location")
+	}
+
+	return web.Respond(r.Context(), w, l, http.StatusCreated)
+}
+
+// ListTransfers gets all transfers, optionally only those with the status
+// given in the status query parameter.
+func (i *Inventory) ListTransfers(w http.ResponseWriter, r *http.Request) error {
+	list, err := inventory.ListTransfers(r.Context(), i.DB, r.URL.Query().Get("status"))
+	if err != nil {
+		return errors.Wrap(err, "getting transfer list")
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
+
+// CreateTransfer sends stock from one location to another.
+func (i *Inventory) CreateTransfer(w http.ResponseWriter, r *http.Request) error {
+	var nt inventory.NewTransfer
+	if err := web.Decoder(r, &nt); err != nil {
+		return errors.Wrap(err, "decoding new transfer")
+	}
+
+	t, err := inventory.CreateTransfer(r.Context(), i.DB, nt, time.Now())
+	if err != nil {
+		return transferError(err, "")
+	}
+
+	return web.Respond(r.Context(), w, t, http.StatusCreated)
+}
+
+// ReceiveTransfer books an in transit transfer into its destination.
+func (i *Inventory) ReceiveTransfer(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	t, err := inventory.ReceiveTransfer(r.Context(), i.DB, id, time.Now())
+	if err != nil {
+		return transferError(err, id)
+	}
+
+	return web.Respond(r.Context(), w, t, http.StatusOK)
+}
+
+// CancelTransfer returns the stock of an in transit transfer to its source.
+func (i *Inventory) CancelTransfer(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	t, err := inventory.CancelTransfer(r.Context(), i.DB, id, time.Now())
+	if err != nil {
+		return transferError(err, id)
+	}
+
+	return web.Respond(r.Context(), w, t, http.StatusOK)
+}
+
+// transferError translates errors from transfers into responses.
+func transferError(err error, id string) error {
+	switch err {
+	case inventory.ErrNotFound, inventory.ErrVariantNotFound, inventory.ErrLocationNotFound, inventory.ErrTransferNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case inventory.ErrInvalidID, inventory.ErrInvalidQuantity, inventory.ErrSameLocation:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case inventory.ErrTransferCompleted, inventory.ErrInsufficientStock:
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrapf(err, "transfer %q", id)
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/loyalty"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/web"
+)
+
+// Loyalty holds the handlers for the loyalty program and the points of
+// customers.
+type Loyalty struct {
+	DB  *sqlx.DB
+	Log *log.Logger
+}
+
+// Program gets how points are earned and what they are worth.
+func (l *Loyalty) Program(w http.ResponseWriter, r *http.Request) error {
+	p, err := loyalty.RetriveProgram(r.Context(), l.DB)
+	if err != nil {
+		return errors.Wrap(err, "getting loyalty program")
+	}
+
+	return web.Respond(r.Context(), w, p, http.StatusOK)
+}
+
+// SetProgram changes the loyalty program to the one in the request body.
+func (l *Loyalty) SetProgram(w http.ResponseWriter, r *http.Request) error {
+	var np loyalty.NewProgram
+	if err := web.Decoder(r, &np); err != nil {
+		return errors.Wrap(err, "decoding loyalty program")
+	}
+
+	p, err := loyalty.SetProgram(r.Context(), l.DB, np, time.Now())
+	if err != nil {
+		switch err {
+		case loyalty.ErrInvalidProgram, money.ErrUnknownCurrency:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "setting loyalty program")
+		}
+	}
+
+	return web.Respond(r.Context(), w, p, http.StatusOK)
+}
+
+// ListMultipliers gets the points multiplier of every category that has one.
+func (l *Loyalty) ListMultipliers(w http.ResponseWriter, r *http.Request) error {
+	list, err := loyalty.ListMultipliers(r.Context(), l.DB)
+	if err != nil {
+		return errors.Wrap(err, "getting loyalty multipliers")
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
+
+// SetMultiplier sets the points multiplier of a category.
+func (l *Loyalty) SetMultiplier(w http.ResponseWriter, r *http.Request) error {
+	var nm loyalty.NewMultiplier
+	if err := web.Decoder(r, &nm); err != nil {
+		return errors.Wrap(err, "decoding loyalty multiplier")
+	}
+
+	m, err := loyalty.SetMultiplier(r.Context(), l.DB, nm, time.Now())
+	if err != nil {
+		switch err {
+		case loyalty.ErrInvalidMultiplier:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "setting loyalty multiplier")
+		}
+	}
+
+	return web.Respond(r.Context(), w, m, http.StatusCreated)
+}
+
+// Balance gets the points a customer has.
+func (l *Loyalty) Balance(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	b, err := loyalty.BalanceOf(r.Context(), l.DB, id, time.Now())
+	if err != nil {
+		if status := customerStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "getting points of customer %q", id)
+	}
+
+	return web.Respond(r.Context(), w, b, http.StatusOK)
+}
+
+// Statement gets every change to the points of a customer.
+func (l *Loyalty) Statement(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	st, err := loyalty.StatementOf(r.Context(), l.DB, id, time.Now())
+	if err != nil {
+		if status := customerStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "getting points statement of customer %q", id)
+	}
+
+	return web.Respond(r.Context(), w, st, http.StatusOK)
+}
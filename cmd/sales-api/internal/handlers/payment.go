@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/giftcard"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/payment"
+	"github.com/vikramcse/the-service/internal/platform/web"
+	"github.com/vikramcse/the-service/internal/product"
+)
+
+// Payments holds the handlers for paying for sales.
+type Payments struct {
+	DB       *sqlx.DB
+	Log      *log.Logger
+	Provider payment.Provider
+}
+
+// Pay takes payment for a sale with the tenders in the request body.
+func (pa *Payments) Pay(w http.ResponseWriter, r *http.Request) error {
+	var np payment.NewPayment
+	if err := web.Decoder(r, &np); err != nil {
+		return errors.Wrap(err, "decoding payment")
+	}
+
+	saleID := chi.URLParam(r, "id")
+
+	list, err := payment.Pay(r.Context(), pa.DB, pa.Provider, saleID, np, time.Now())
+	if err != nil {
+		if status := paymentStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "paying for sale %q", saleID)
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusCreated)
+}
+
+// List gets every payment attempted for a sale.
+func (pa *Payments) List(w http.ResponseWriter, r *http.Request) error {
+	saleID := chi.URLParam(r, "id")
+
+	list, err := payment.List(r.Context(), pa.DB, saleID)
+	if err != nil {
+		if status := paymentStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "getting payments for sale %q", saleID)
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
+
+// Refund gives back the amount in the request body of a captured payment.
+func (pa *Payments) Refund(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		Amount int `json:"amount"`
+	}
+	if err := web.Decoder(r, &req); err != nil {
+		return errors.Wrap(err, "decoding refund")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	pm, err := payment.Refund(r.Context(), pa.DB, pa.Provider, id, req.Amount, time.Now())
+	if err != nil {
+		if status := paymentStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "refunding payment %q", id)
+	}
+
+	return web.Respond(r.Context(), w, pm, http.StatusOK)
+}
+
+// Void releases an authorized card payment.
+func (pa *Payments) Void(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	pm, err := payment.Void(r.Context(), pa.DB, pa.Provider, id, time.Now())
+	if err != nil {
+		if status := paymentStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "voiding payment %q", id)
+	}
+
+	return web.Respond(r.Context(), w, pm, http.StatusOK)
+}
+
+// paymentStatus gives the status code for errors caused by the client or the
+// card when paying. It returns 0 for any other error.
+func paymentStatus(err error) int {
+	switch errors.Cause(err) {
+	case payment.ErrNotFound, payment.ErrSaleNotFound, product.ErrSaleNotFound, giftcard.ErrNotFound:
+		return http.StatusNotFound
+	case payment.ErrInvalidID, payment.ErrNoTenders, payment.ErrInvalidTender, payment.ErrInvalidAmount,
+		payment.ErrUnderpaid, payment.ErrOverpaid, giftcard.ErrInvalidCode, money.ErrMismatch:
+		return http.StatusBadRequest
+	case payment.ErrPaid, payment.ErrInvalidTransition, payment.ErrRefundTooMuch, giftcard.ErrInsufficientBalance,
+		product.ErrRefundTooMuch:
+		return http.StatusConflict
+	case payment.ErrDeclined:
+		return http.StatusPaymentRequired
+	case payment.ErrTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/exchange"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/web"
+	"github.com/vikramcse/the-service/internal/pricing"
+	"github.com/vikramcse/the-service/internal/product"
+)
+
+// Pricing holds the handlers for promotions, coupons and quotes.
+type Pricing struct {
+	DB  *sqlx.DB
+	Log *log.Logger
+}
+
+// Quote prices the basket in the request body and explains each promotion
+// that was applied.
+func (pr *Pricing) Quote(w http.ResponseWriter, r *http.Request) error {
+	var b pricing.Basket
+	if err := web.Decoder(r, &b); err != nil {
+		return errors.Wrap(err, "decoding basket")
+	}
+
+	q, err := pricing.QuoteBasket(r.Context(), pr.DB, b, time.Now())
+	if err != nil {
+		switch err {
+		case product.ErrNotFound, product.ErrVariantNotFound, pricing.ErrCouponNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case pricing.ErrInvalidID, pricing.ErrInvalidQuantity, product.ErrInvalidID, money.ErrMismatch,
+			money.ErrUnknownCurrency, exchange.ErrNoRate:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case pricing.ErrCouponUsedUp:
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrap(err, "quoting basket")
+		}
+	}
+
+	return web.Respond(r.Context(), w, q, http.StatusOK)
+}
+
+// ListPromotions gets all promotions.
+func (pr *Pricing) ListPromotions(w http.ResponseWriter, r *http.Request) error {
+	list, err := pricing.ListPromotions(r.Context(), pr.DB)
+	if err != nil {
+		return errors.Wrap(err, "getting promotion list")
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
+
+// CreatePromotion decodes the body of a request to create a new promotion.
+func (pr *Pricing) CreatePromotion(w http.ResponseWriter, r *http.Request) error {
+	var np pricing.NewPromotion
+	if err := web.Decoder(r, &np); err != nil {
+		return errors.Wrap(err, "decoding new promotion")
+	}
+
+	p, err := pricing.CreatePromotion(r.Context(), pr.DB, np, time.Now())
+	if err != nil {
+		switch err {
+		case pricing.ErrInvalidID, pricing.ErrInvalidKind, pricing.ErrInvalidRule, pricing.ErrInvalidWindow,
+			money.ErrUnknownCurrency:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "creating new promotion")
+		}
+	}
+
+	return web.Respond(r.Context(), w, p, http.StatusCreated)
+}
+
+// ListCoupons gets the coupons for a promotion.
+func (pr *Pricing) ListCoupons(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	list, err := pricing.ListCoupons(r.Context(), pr.DB, id)
+	if err != nil {
+		switch err {
+		case pricing.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "getting coupons for promotion %q", id)
+		}
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
+
+// CreateCoupon decodes the body of a request to create a new coupon.
+func (pr *Pricing) CreateCoupon(w http.ResponseWriter, r *http.Request) error {
+	var nc pricing.NewCoupon
+	if err := web.Decoder(r, &nc); err != nil {
+		return errors.Wrap(err, "decoding new coupon")
+	}
+
+	c, err := pricing.CreateCoupon(r.Context(), pr.DB, nc, time.Now())
+	if err != nil {
+		switch err {
+		case pricing.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case pricing.ErrInvalidID, pricing.ErrInvalidCoupon, pricing.ErrInvalidQuantity:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "creating new coupon")
+		}
+	}
+
+	return web.Respond(r.Context(), w, c, http.StatusCreated)
+}
@@ -1,32 +1,123 @@
 package handlers
 
 import (
-	"encoding/json"
 	"log"
+	"mime"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/customer"
+	"github.com/vikramcse/the-service/internal/exchange"
+	"github.com/vikramcse/the-service/internal/giftcard"
+	"github.com/vikramcse/the-service/internal/inventory"
+	"github.com/vikramcse/the-service/internal/loyalty"
+	"github.com/vikramcse/the-service/internal/money"
 	"github.com/vikramcse/the-service/internal/platform/web"
+	"github.com/vikramcse/the-service/internal/pricing"
 	"github.com/vikramcse/the-service/internal/product"
+	"github.com/vikramcse/the-service/internal/register"
 )
 
 type Products struct {
 	DB  *sqlx.DB
 	Log *log.Logger
+
+	// DefaultLocation is where sales are made when the request does not say.
+	DefaultLocation string
+
+	// Costing is how the stock of products created without saying is costed.
+	Costing string
+
+	// SaleHooks take part in every sale in order.
+	SaleHooks []product.SaleHook
+
+	// Searcher finds products by what clerks type.
+	Searcher product.Searcher
 }
 
+// List gets all products. The query parameter currency converts their
+// revenue into a reporting currency.
 func (p *Products) List(w http.ResponseWriter, r *http.Request) error {
-	list, err := product.List(r.Context(), p.DB)
+	currency := r.URL.Query().Get("currency")
+
+	list, err := product.List(r.Context(), p.DB, currency, time.Now())
 	if err != nil {
-		return errors.Wrap(err, "getting product list")
+		switch err {
+		case money.ErrUnknownCurrency, exchange.ErrNoRate:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "getting product list")
+		}
 	}
 
 	return web.Respond(r.Context(), w, list, http.StatusOK)
 }
 
+// LowStock gets every product that has fallen to or below its reorder
+// threshold.
+func (p *Products) LowStock(w http.ResponseWriter, r *http.Request) error {
+	list, err := product.ListLowStock(r.Context(), p.DB)
+	if err != nil {
+		return errors.Wrap(err, "getting low stock list")
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
+
+// SetReorder changes the reorder threshold and quantity of a particular
+// product. Fields left out of the request body are not changed.
+func (p *Products) SetReorder(w http.ResponseWriter, r *http.Request) error {
+	var ur product.UpdateReorder
+	if err := web.Decoder(r, &ur); err != nil {
+		return errors.Wrap(err, "decoding reorder settings")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	prod, err := product.SetReorder(r.Context(), p.DB, id, ur, time.Now())
+	if err != nil {
+		switch err {
+		case product.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case product.ErrInvalidID, product.ErrInvalidReorder:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "setting reorder settings of product %q", id)
+		}
+	}
+
+	return web.Respond(r.Context(), w, prod, http.StatusOK)
+}
+
+// Search finds the products best matching the query parameter q, which may
+// hold partial or misspelt words, up to limit of them.
+func (p *Products) Search(w http.ResponseWriter, r *http.Request) error {
+	v := r.URL.Query()
+
+	var limit int
+	if l := v.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			return web.NewRequestError(errors.New("limit must be a positive whole number"), http.StatusBadRequest)
+		}
+		limit = n
+	}
+
+	results, err := p.Searcher.Search(r.Context(), v.Get("q"), limit)
+	if err != nil {
+		if err == product.ErrNoQuery {
+			return web.NewRequestError(err, http.StatusBadRequest)
+		}
+		return errors.Wrap(err, "searching products")
+	}
+
+	return web.Respond(r.Context(), w, results, http.StatusOK)
+}
+
 func (p *Products) Retrive(w http.ResponseWriter, r *http.Request) error {
 	id := chi.URLParam(r, "id")
 
@@ -49,36 +140,139 @@ func (p *Products) Retrive(w http.ResponseWriter, r *http.Request) error {
 func (p *Products) Create(w http.ResponseWriter, r *http.Request) error {
 	var np product.NewProduct
 
-	if err := json.NewDecoder(r.Body).Decode(&np); err != nil {
-		return errors.Wrapf(err, "decoding new product")
+	if err := web.Decoder(r, &np); err != nil {
+		return errors.Wrap(err, "decoding new product")
+	}
+
+	if np.Costing == "" {
+		np.Costing = p.Costing
 	}
 
 	prod, err := product.Create(r.Context(), p.DB, np, time.Now())
 	if err != nil {
-		return errors.Wrap(err, "creating new product")
+		switch err {
+		case money.ErrUnknownCurrency, inventory.ErrInvalidCosting, inventory.ErrInvalidCost,
+			product.ErrNoName, product.ErrInvalidCost, product.ErrInvalidReorder:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case product.ErrDuplicateSKU:
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrap(err, "creating new product")
+		}
 	}
 
 	return web.Respond(r.Context(), w, &prod, http.StatusCreated)
 }
 
+// Import creates or updates a product for every row of the catalog in the
+// request body. The query parameter format is csv or jsonl and defaults to
+// what the Content-Type says, or csv. The parameter mode is transaction, the
+// default, which imports nothing when any row is rejected, or chunked, which
+// commits chunk_size rows at a time and skips rejected rows. The report of
+// every row is returned, with status 422 when nothing was imported.
+func (p *Products) Import(w http.ResponseWriter, r *http.Request) error {
+	v := r.URL.Query()
+
+	opts := product.ImportOptions{
+		Format:  v.Get("format"),
+		Mode:    v.Get("mode"),
+		Costing: p.Costing,
+	}
+	if opts.Format == "" {
+		opts.Format = importFormat(r.Header.Get("Content-Type"))
+	}
+	if cs := v.Get("chunk_size"); cs != "" {
+		n, err := strconv.Atoi(cs)
+		if err != nil || n <= 0 {
+			return web.NewRequestError(errors.Errorf("chunk_size must be a positive number, got %q", cs), http.StatusBadRequest)
+		}
+		opts.ChunkSize = n
+	}
+
+	rep, err := product.Import(r.Context(), p.DB, r.Body, opts, time.Now())
+	if err != nil {
+		switch errors.Cause(err) {
+		case product.ErrMalformed, product.ErrInvalidFormat, product.ErrInvalidMode:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "importing products")
+		}
+	}
+
+	status := http.StatusOK
+	if !rep.Committed {
+		status = http.StatusUnprocessableEntity
+	}
+
+	return web.Respond(r.Context(), w, rep, status)
+}
+
+// importFormat gives the import format of a Content-Type, or "" when it does
+// not name one.
+func importFormat(contentType string) string {
+	media, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	switch media {
+	case "text/csv":
+		return product.CSV
+	case "application/jsonl", "application/x-ndjson", "application/x-jsonlines":
+		return product.JSONL
+	default:
+		return ""
+	}
+}
+
 // AddSale creates a new Sale for a particular product. It looks for a JSON
 // object in the request body. The full model is returned to the caller.
 func (p *Products) AddSale(w http.ResponseWriter, r *http.Request) error {
 	var ns product.NewSale
-	if err := json.NewDecoder(r.Body).Decode(&ns); err != nil {
+	if err := web.Decoder(r, &ns); err != nil {
 		return errors.Wrap(err, "decoding new sale")
 	}
 
+	// Sales rung up in a register session are made where the register is.
 	productID := chi.URLParam(r, "id")
+	if ns.LocationID == "" && ns.SessionID == "" {
+		ns.LocationID = p.DefaultLocation
+	}
 
-	sale, err := product.AddSale(r.Context(), p.DB, ns, productID, time.Now())
+	sale, err := product.AddSale(r.Context(), p.DB, ns, productID, time.Now(), p.SaleHooks...)
 	if err != nil {
+		if status := saleStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
 		return errors.Wrap(err, "adding new sale")
 	}
 
 	return web.Respond(r.Context(), w, sale, http.StatusCreated)
 }
 
+// saleStatus gives the status code for errors caused by the client when
+// recording a sale. It returns 0 for any other error.
+func saleStatus(err error) int {
+	switch err {
+	case product.ErrNotFound, product.ErrVariantNotFound, inventory.ErrLocationNotFound,
+		pricing.ErrCouponNotFound, customer.ErrNotFound, giftcard.ErrNotFound,
+		register.ErrSessionNotFound:
+		return http.StatusNotFound
+	case product.ErrInvalidID, product.ErrInvalidQuantity, product.ErrInvalidPaid,
+		inventory.ErrInvalidID, pricing.ErrCouponWithPaid, money.ErrMismatch,
+		money.ErrUnknownCurrency, exchange.ErrNoRate, customer.ErrInvalidID,
+		loyalty.ErrInvalidPoints, loyalty.ErrNoCustomer, loyalty.ErrRedeemTooMuch,
+		giftcard.ErrInvalidCode, giftcard.ErrInvalidAmount, giftcard.ErrOverpaid,
+		register.ErrInvalidID, register.ErrWrongLocation:
+		return http.StatusBadRequest
+	case product.ErrInsufficientStock, pricing.ErrCouponUsedUp, loyalty.ErrInsufficientPoints,
+		giftcard.ErrInsufficientBalance, register.ErrNotOpen:
+		return http.StatusConflict
+	default:
+		return 0
+	}
+}
+
 // ListSales gets all sales for a particular product.
 func (p *Products) ListSales(w http.ResponseWriter, r *http.Request) error {
 	id := chi.URLParam(r, "id")
@@ -90,3 +284,124 @@ func (p *Products) ListSales(w http.ResponseWriter, r *http.Request) error {
 
 	return web.Respond(r.Context(), w, list, http.StatusOK)
 }
+
+// AddVariant adds a Variant to a particular product. It looks for a JSON
+// object in the request body. The full model is returned to the caller.
+func (p *Products) AddVariant(w http.ResponseWriter, r *http.Request) error {
+	var nv product.NewVariant
+	if err := web.Decoder(r, &nv); err != nil {
+		return errors.Wrap(err, "decoding new variant")
+	}
+
+	productID := chi.URLParam(r, "id")
+
+	v, err := product.AddVariant(r.Context(), p.DB, nv, productID, time.Now())
+	if err != nil {
+		switch err {
+		case product.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case product.ErrInvalidID, product.ErrInvalidCost, product.ErrInvalidStock, inventory.ErrInvalidCost:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case product.ErrDuplicateSKU:
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrapf(err, "adding variant to product %q", productID)
+		}
+	}
+
+	return web.Respond(r.Context(), w, v, http.StatusCreated)
+}
+
+// ListVariants gets all variants for a particular product.
+func (p *Products) ListVariants(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	list, err := product.ListVariants(r.Context(), p.DB, id)
+	if err != nil {
+		if err == product.ErrInvalidID {
+			return web.NewRequestError(err, http.StatusBadRequest)
+		}
+		return errors.Wrap(err, "getting variant list")
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
+
+// SetPrice changes the cost of a particular product, either immediately or
+// from the effective_from time given in the request body.
+func (p *Products) SetPrice(w http.ResponseWriter, r *http.Request) error {
+	var np product.NewPrice
+	if err := web.Decoder(r, &np); err != nil {
+		return errors.Wrap(err, "decoding new price")
+	}
+
+	productID := chi.URLParam(r, "id")
+
+	pr, err := product.SetPrice(r.Context(), p.DB, np, productID, time.Now())
+	if err != nil {
+		switch err {
+		case product.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case product.ErrInvalidID, product.ErrInvalidCost, product.ErrPriceInPast:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "setting price of product %q", productID)
+		}
+	}
+
+	return web.Respond(r.Context(), w, pr, http.StatusCreated)
+}
+
+// ListPrices gets the price history of a particular product.
+func (p *Products) ListPrices(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	list, err := product.ListPrices(r.Context(), p.DB, id)
+	if err != nil {
+		if err == product.ErrInvalidID {
+			return web.NewRequestError(err, http.StatusBadRequest)
+		}
+		return errors.Wrap(err, "getting price list")
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
+
+// SetCurrencyPrice puts a product on the price list of another currency.
+func (p *Products) SetCurrencyPrice(w http.ResponseWriter, r *http.Request) error {
+	var ncp product.NewCurrencyPrice
+	if err := web.Decoder(r, &ncp); err != nil {
+		return errors.Wrap(err, "decoding new currency price")
+	}
+
+	productID := chi.URLParam(r, "id")
+
+	cp, err := product.SetCurrencyPrice(r.Context(), p.DB, ncp, productID, time.Now())
+	if err != nil {
+		switch err {
+		case product.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case product.ErrInvalidID, product.ErrInvalidCost, product.ErrBaseCurrency, money.ErrUnknownCurrency:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "setting currency price of product %q", productID)
+		}
+	}
+
+	return web.Respond(r.Context(), w, cp, http.StatusCreated)
+}
+
+// PriceList gets every product priced in a currency.
+func (p *Products) PriceList(w http.ResponseWriter, r *http.Request) error {
+	currency := chi.URLParam(r, "currency")
+
+	list, err := product.PriceList(r.Context(), p.DB, currency)
+	if err != nil {
+		if err == money.ErrUnknownCurrency {
+			return web.NewRequestError(err, http.StatusBadRequest)
+		}
+		return errors.Wrapf(err, "getting price list for %q", currency)
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
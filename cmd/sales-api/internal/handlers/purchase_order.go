@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/inventory"
+	"github.com/vikramcse/the-service/internal/platform/web"
+	"github.com/vikramcse/the-service/internal/supplier"
+)
+
+// PurchaseOrders holds the handlers for ordering and receiving stock.
+type PurchaseOrders struct {
+	DB  *sqlx.DB
+	Log *log.Logger
+}
+
+// List gets all purchase orders, optionally only those of the supplier given
+// in the supplier_id query parameter.
+func (po *PurchaseOrders) List(w http.ResponseWriter, r *http.Request) error {
+	list, err := supplier.ListOrders(r.Context(), po.DB, r.URL.Query().Get("supplier_id"))
+	if err != nil {
+		if err == supplier.ErrInvalidID {
+			return web.NewRequestError(err, http.StatusBadRequest)
+		}
+		return errors.Wrap(err, "getting purchase order list")
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
+
+// Retrive gets a single purchase order with its lines.
+func (po *PurchaseOrders) Retrive(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	order, err := supplier.RetriveOrder(r.Context(), po.DB, id)
+	if err != nil {
+		return orderError(err, id)
+	}
+
+	return web.Respond(r.Context(), w, order, http.StatusOK)
+}
+
+// Create raises a draft purchase order from the request body.
+func (po *PurchaseOrders) Create(w http.ResponseWriter, r *http.Request) error {
+	var npo supplier.NewPurchaseOrder
+	if err := web.Decoder(r, &npo); err != nil {
+		return errors.Wrap(err, "decoding new purchase order")
+	}
+
+	order, err := supplier.CreateOrder(r.Context(), po.DB, npo, time.Now())
+	if err != nil {
+		return orderError(err, "")
+	}
+
+	return web.Respond(r.Context(), w, order, http.StatusCreated)
+}
+
+// Send marks a draft purchase order as sent to the supplier.
+func (po *PurchaseOrders) Send(w http.ResponseWriter, r *http.Request) error {
+	return po.setStatus(w, r, supplier.Sent)
+}
+
+// Cancel cancels a purchase order that has not been received in full.
+func (po *PurchaseOrders) Cancel(w http.ResponseWriter, r *http.Request) error {
+	return po.setStatus(w, r, supplier.Cancelled)
+}
+
+// Receive books goods arriving against a purchase order, taking them into
+// stock. The receipts created are returned to the caller.
+func (po *PurchaseOrders) Receive(w http.ResponseWriter, r *http.Request) error {
+	var nr supplier.NewReceipt
+	if err := web.Decoder(r, &nr); err != nil {
+		return errors.Wrap(err, "decoding new receipt")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	receipts, err := supplier.Receive(r.Context(), po.DB, nr, id, time.Now())
+	if err != nil {
+		return orderError(err, id)
+	}
+
+	return web.Respond(r.Context(), w, receipts, http.StatusCreated)
+}
+
+// ListReceipts gets every delivery booked against a purchase order.
+func (po *PurchaseOrders) ListReceipts(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	list, err := supplier.ListReceipts(r.Context(), po.DB, id)
+	if err != nil {
+		return orderError(err, id)
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
+
+// setStatus moves the purchase order in the URL to status.
+func (po *PurchaseOrders) setStatus(w http.ResponseWriter, r *http.Request, status string) error {
+	id := chi.URLParam(r, "id")
+
+	order, err := supplier.SetStatus(r.Context(), po.DB, id, status, time.Now())
+	if err != nil {
+		return orderError(err, id)
+	}
+
+	return web.Respond(r.Context(), w, order, http.StatusOK)
+}
+
+// orderError translates errors from the supplier package into responses.
+func orderError(err error, id string) error {
+	switch err {
+	case supplier.ErrNotFound, supplier.ErrOrderNotFound, supplier.ErrLineNotFound, supplier.ErrProductNotFound,
+		inventory.ErrLocationNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case supplier.ErrInvalidID, supplier.ErrEmptyOrder, supplier.ErrInvalidQuantity, supplier.ErrOverReceipt,
+		supplier.ErrInvalidCost, inventory.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case supplier.ErrInvalidTransition:
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrapf(err, "purchase order %q", id)
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/web"
+	"github.com/vikramcse/the-service/internal/receipt"
+)
+
+// Receipts holds the handlers for printing receipts.
+type Receipts struct {
+	DB    *sqlx.DB
+	Log   *log.Logger
+	Store receipt.Store
+}
+
+// Sale gets the receipt for a sale.
+func (rc *Receipts) Sale(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	rec, err := receipt.Build(r.Context(), rc.DB, []string{id}, rc.Store)
+	if err != nil {
+		if status := receiptStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "building receipt for sale %q", id)
+	}
+
+	return respondReceipt(w, r, rec)
+}
+
+// Basket gets one receipt for several sales rung up together, given by
+// repeating the query parameter sale_id once for each sale.
+func (rc *Receipts) Basket(w http.ResponseWriter, r *http.Request) error {
+	ids := r.URL.Query()["sale_id"]
+
+	rec, err := receipt.Build(r.Context(), rc.DB, ids, rc.Store)
+	if err != nil {
+		if status := receiptStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "building receipt for sales %q", ids)
+	}
+
+	return respondReceipt(w, r, rec)
+}
+
+// Order gets the receipt for the sale a reservation was confirmed as.
+func (rc *Receipts) Order(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	rec, err := receipt.ForReservation(r.Context(), rc.DB, id, rc.Store)
+	if err != nil {
+		if status := receiptStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "building receipt for reservation %q", id)
+	}
+
+	return respondReceipt(w, r, rec)
+}
+
+// respondReceipt sends rec in the format the Accept header of the request
+// asks for: text for receipt printers, which is the default, HTML, PDF or
+// JSON.
+func respondReceipt(w http.ResponseWriter, r *http.Request, rec *receipt.Receipt) error {
+	media, err := web.Negotiate(r, "text/plain", "text/html", "application/pdf", "application/json")
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	switch media {
+	case "application/json":
+		return web.RespondAs(r.Context(), w, rec, media, http.StatusOK)
+	case "text/html":
+		err = rec.WriteHTML(&buf)
+		media += "; charset=utf-8"
+	case "application/pdf":
+		err = rec.WritePDF(&buf)
+	default:
+		err = rec.WriteText(&buf)
+		media += "; charset=utf-8"
+	}
+	if err != nil {
+		return errors.Wrap(err, "rendering receipt")
+	}
+
+	return web.RespondRaw(r.Context(), w, buf.Bytes(), media, http.StatusOK)
+}
+
+// receiptStatus gives the status code for errors caused by the client when
+// asking for a receipt. It returns 0 for any other error.
+func receiptStatus(err error) int {
+	switch err {
+	case receipt.ErrNotFound, receipt.ErrReservationNotFound:
+		return http.StatusNotFound
+	case receipt.ErrInvalidID, receipt.ErrNoSales, money.ErrMismatch:
+		return http.StatusBadRequest
+	case receipt.ErrNotConfirmed:
+		return http.StatusConflict
+	default:
+		return 0
+	}
+}
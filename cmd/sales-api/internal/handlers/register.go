@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/inventory"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/web"
+	"github.com/vikramcse/the-service/internal/register"
+)
+
+// Registers holds the handlers for tills and the sessions they are open for.
+type Registers struct {
+	DB  *sqlx.DB
+	Log *log.Logger
+}
+
+// List gets all registers.
+func (rg *Registers) List(w http.ResponseWriter, r *http.Request) error {
+	list, err := register.ListRegisters(r.Context(), rg.DB)
+	if err != nil {
+		return errors.Wrap(err, "getting register list")
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
+
+// Retrive gets a single register.
+func (rg *Registers) Retrive(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	reg, err := register.RetriveRegister(r.Context(), rg.DB, id)
+	if err != nil {
+		if status := registerStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "getting register %q", id)
+	}
+
+	return web.Respond(r.Context(), w, reg, http.StatusOK)
+}
+
+// Create adds the register in the request body.
+func (rg *Registers) Create(w http.ResponseWriter, r *http.Request) error {
+	var nr register.NewRegister
+	if err := web.Decoder(r, &nr); err != nil {
+		return errors.Wrap(err, "decoding new register")
+	}
+
+	reg, err := register.CreateRegister(r.Context(), rg.DB, nr, time.Now())
+	if err != nil {
+		if status := registerStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrap(err, "creating register")
+	}
+
+	return web.Respond(r.Context(), w, reg, http.StatusCreated)
+}
+
+// Open opens a register for a shift with the float in the request body.
+func (rg *Registers) Open(w http.ResponseWriter, r *http.Request) error {
+	var ns register.NewSession
+	if err := web.Decoder(r, &ns); err != nil {
+		return errors.Wrap(err, "decoding new session")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	s, err := register.OpenSession(r.Context(), rg.DB, id, ns, time.Now())
+	if err != nil {
+		if status := registerStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "opening register %q", id)
+	}
+
+	return web.Respond(r.Context(), w, s, http.StatusCreated)
+}
+
+// ListSessions gets every session of a register.
+func (rg *Registers) ListSessions(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	list, err := register.ListSessions(r.Context(), rg.DB, id)
+	if err != nil {
+		if status := registerStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "getting sessions of register %q", id)
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
+
+// Session gets a single session.
+func (rg *Registers) Session(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	s, err := register.RetriveSession(r.Context(), rg.DB, id)
+	if err != nil {
+		if status := registerStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "getting session %q", id)
+	}
+
+	return web.Respond(r.Context(), w, s, http.StatusOK)
+}
+
+// MoveCash records the cash put into or taken out of the drawer in the
+// request body.
+func (rg *Registers) MoveCash(w http.ResponseWriter, r *http.Request) error {
+	var nm register.NewCashMovement
+	if err := web.Decoder(r, &nm); err != nil {
+		return errors.Wrap(err, "decoding cash movement")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	m, err := register.MoveCash(r.Context(), rg.DB, id, nm, time.Now())
+	if err != nil {
+		if status := registerStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "moving cash in session %q", id)
+	}
+
+	return web.Respond(r.Context(), w, m, http.StatusCreated)
+}
+
+// ListCash gets the cash moved in and out of the drawer in a session.
+func (rg *Registers) ListCash(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	list, err := register.ListCash(r.Context(), rg.DB, id)
+	if err != nil {
+		if status := registerStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "getting cash movements of session %q", id)
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
+
+// Close closes a session with the counts in the request body and responds
+// with its Z report. The report format is settled before the session is
+// closed, so a client that accepts no format gets its 406 with nothing
+// changed.
+func (rg *Registers) Close(w http.ResponseWriter, r *http.Request) error {
+	media, err := reportMedia(r)
+	if err != nil {
+		return err
+	}
+
+	var c register.Closing
+	if err := web.Decoder(r, &c); err != nil {
+		return errors.Wrap(err, "decoding closing")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	rep, err := register.CloseSession(r.Context(), rg.DB, id, c, time.Now())
+	if err != nil {
+		if status := registerStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "closing session %q", id)
+	}
+
+	return respondReport(r, w, rep, media, http.StatusOK)
+}
+
+// Report gets the report of a session. It is printable text when the query
+// parameter format is text or the client prefers text/plain, and JSON
+// otherwise.
+func (rg *Registers) Report(w http.ResponseWriter, r *http.Request) error {
+	media, err := reportMedia(r)
+	if err != nil {
+		return err
+	}
+
+	id := chi.URLParam(r, "id")
+
+	rep, err := register.ReportOf(r.Context(), rg.DB, id)
+	if err != nil {
+		if status := registerStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "getting report of session %q", id)
+	}
+
+	return respondReport(r, w, rep, media, http.StatusOK)
+}
+
+// reportMedia gives the media type a session report is sent as: text/plain
+// when the query parameter format is text and whichever of JSON and text the
+// client prefers otherwise.
+func reportMedia(r *http.Request) (string, error) {
+	if r.URL.Query().Get("format") == "text" {
+		return "text/plain", nil
+	}
+	return web.Negotiate(r, "application/json", "text/plain")
+}
+
+// respondReport sends rep as media, which reportMedia gave.
+func respondReport(r *http.Request, w http.ResponseWriter, rep *register.Report, media string, status int) error {
+	if media == "application/json" {
+		return web.RespondAs(r.Context(), w, rep, media, status)
+	}
+
+	var buf bytes.Buffer
+	if err := rep.WriteText(&buf); err != nil {
+		return errors.Wrap(err, "printing report")
+	}
+
+	return web.RespondRaw(r.Context(), w, buf.Bytes(), "text/plain; charset=utf-8", status)
+}
+
+// registerStatus gives the status code for errors caused by the client when
+// working with registers. It returns 0 for any other error.
+func registerStatus(err error) int {
+	switch err {
+	case register.ErrNotFound, register.ErrSessionNotFound, inventory.ErrLocationNotFound:
+		return http.StatusNotFound
+	case register.ErrInvalidID, register.ErrNoName, register.ErrInvalidAmount, register.ErrInvalidKind,
+		register.ErrInvalidTender, register.ErrNoCashCount, inventory.ErrInvalidID, money.ErrUnknownCurrency:
+		return http.StatusBadRequest
+	case register.ErrAlreadyOpen, register.ErrNotOpen, register.ErrNotEnoughCash:
+		return http.StatusConflict
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/exchange"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/period"
+	"github.com/vikramcse/the-service/internal/platform/web"
+	"github.com/vikramcse/the-service/internal/report"
+)
+
+// Reports holds the handlers for the analytics endpoints.
+type Reports struct {
+	DB  *sqlx.DB
+	Log *log.Logger
+}
+
+// Sales reports units, revenue, orders and average discount bucketed by time.
+// The report is controlled by the query parameters bucket, tz, from, to,
+// product_id, top, top_by and currency. Revenue is converted into currency
+// at the exchange rates in effect now. Currency must be given when sales were
+// paid in more than one currency. Dates in from and to may be given as RFC
+// 3339 timestamps or as plain dates in the requested time zone.
+func (rp *Reports) Sales(w http.ResponseWriter, r *http.Request) error {
+	v := r.URL.Query()
+
+	sq := report.SalesQuery{
+		Bucket:    v.Get("bucket"),
+		ProductID: v.Get("product_id"),
+		TopBy:     v.Get("top_by"),
+		Top:       10,
+		Location:  time.UTC,
+		Currency:  v.Get("currency"),
+		RatesAt:   time.Now(),
+	}
+	if sq.Bucket == "" {
+		sq.Bucket = report.Day
+	}
+
+	if tz := v.Get("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return web.NewRequestError(errors.Errorf("unknown time zone %q", tz), http.StatusBadRequest)
+		}
+		sq.Location = loc
+	}
+
+	var err error
+	if sq.From, err = period.Parse(v.Get("from"), sq.Location); err != nil {
+		return web.NewRequestError(errors.Wrap(err, "from"), http.StatusBadRequest)
+	}
+	if sq.To, err = period.Parse(v.Get("to"), sq.Location); err != nil {
+		return web.NewRequestError(errors.Wrap(err, "to"), http.StatusBadRequest)
+	}
+
+	if top := v.Get("top"); top != "" {
+		n, err := strconv.Atoi(top)
+		if err != nil || n < 0 {
+			return web.NewRequestError(errors.Errorf("top must be zero or a positive number, got %q", top), http.StatusBadRequest)
+		}
+		sq.Top = n
+	}
+
+	rep, err := report.Sales(r.Context(), rp.DB, sq)
+	if err != nil {
+		switch err {
+		case report.ErrInvalidBucket, report.ErrInvalidTopBy, report.ErrInvalidID,
+			report.ErrMixedCurrency, money.ErrUnknownCurrency, exchange.ErrNoRate,
+			period.ErrInvalidRange:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "building sales report")
+		}
+	}
+
+	return web.Respond(r.Context(), w, rep, http.StatusOK)
+}
+
+// Tax totals the net, tax and gross of sales per location, tax class, rate
+// and currency. The report is controlled by the query parameters location_id, from
+// and to, which are read as for the sales report in UTC.
+func (rp *Reports) Tax(w http.ResponseWriter, r *http.Request) error {
+	v := r.URL.Query()
+
+	tq := report.TaxQuery{
+		LocationID: v.Get("location_id"),
+	}
+
+	var err error
+	if tq.From, err = period.Parse(v.Get("from"), time.UTC); err != nil {
+		return web.NewRequestError(errors.Wrap(err, "from"), http.StatusBadRequest)
+	}
+	if tq.To, err = period.Parse(v.Get("to"), time.UTC); err != nil {
+		return web.NewRequestError(errors.Wrap(err, "to"), http.StatusBadRequest)
+	}
+
+	rep, err := report.Tax(r.Context(), rp.DB, tq)
+	if err != nil {
+		switch err {
+		case report.ErrInvalidID, period.ErrInvalidRange:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "building tax report")
+		}
+	}
+
+	return web.Respond(r.Context(), w, rep, http.StatusOK)
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/platform/web"
+	"github.com/vikramcse/the-service/internal/product"
+	"github.com/vikramcse/the-service/internal/reservation"
+)
+
+// Reservations holds the handlers for holding stock while customers pay.
+type Reservations struct {
+	DB  *sqlx.DB
+	Log *log.Logger
+
+	// DefaultLocation is where stock is held when the request does not say.
+	DefaultLocation string
+
+	// SaleHooks take part in the sale made when a reservation is confirmed.
+	SaleHooks []product.SaleHook
+}
+
+// Create holds stock for the product in the request body.
+func (rs *Reservations) Create(w http.ResponseWriter, r *http.Request) error {
+	var nr reservation.NewReservation
+	if err := web.Decoder(r, &nr); err != nil {
+		return errors.Wrap(err, "decoding new reservation")
+	}
+	if nr.LocationID == "" {
+		nr.LocationID = rs.DefaultLocation
+	}
+
+	res, err := reservation.Create(r.Context(), rs.DB, nr, time.Now())
+	if err != nil {
+		return reservationError(err, "")
+	}
+
+	return web.Respond(r.Context(), w, res, http.StatusCreated)
+}
+
+// Retrive gets a single reservation.
+func (rs *Reservations) Retrive(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	res, err := reservation.Retrive(r.Context(), rs.DB, id)
+	if err != nil {
+		return reservationError(err, id)
+	}
+
+	return web.Respond(r.Context(), w, res, http.StatusOK)
+}
+
+// Confirm turns a reservation into a sale for the amount paid in the body,
+// or priced when the body gives none. The reservation is answered with the
+// sale, taxed like any other.
+func (rs *Reservations) Confirm(w http.ResponseWriter, r *http.Request) error {
+	var c reservation.Confirmation
+	if err := web.Decoder(r, &c); err != nil {
+		return errors.Wrap(err, "decoding confirmation")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	res, err := reservation.Confirm(r.Context(), rs.DB, c, id, time.Now(), rs.SaleHooks...)
+	if err != nil {
+		return reservationError(err, id)
+	}
+
+	return web.Respond(r.Context(), w, res, http.StatusOK)
+}
+
+// Release gives the stock held by a reservation back.
+func (rs *Reservations) Release(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	res, err := reservation.Release(r.Context(), rs.DB, id, time.Now())
+	if err != nil {
+		return reservationError(err, id)
+	}
+
+	return web.Respond(r.Context(), w, res, http.StatusOK)
+}
+
+// reservationError translates errors from reservations into responses.
+func reservationError(err error, id string) error {
+	switch err {
+	case reservation.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case reservation.ErrInvalidID, reservation.ErrInvalidQuantity:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case reservation.ErrInsufficientStock, reservation.ErrNotActive:
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		if status := saleStatus(err); status != 0 {
+			return web.NewRequestError(err, status)
+		}
+		return errors.Wrapf(err, "reservation %q", id)
+	}
+}
@@ -3,14 +3,83 @@ package handlers
 import (
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/vikramcse/the-service/internal/alert"
+	"github.com/vikramcse/the-service/internal/giftcard"
+	"github.com/vikramcse/the-service/internal/inventory"
+	"github.com/vikramcse/the-service/internal/loyalty"
 	"github.com/vikramcse/the-service/internal/mid"
+	"github.com/vikramcse/the-service/internal/payment"
 	"github.com/vikramcse/the-service/internal/platform/web"
+	"github.com/vikramcse/the-service/internal/pricing"
+	"github.com/vikramcse/the-service/internal/product"
+	"github.com/vikramcse/the-service/internal/receipt"
+	"github.com/vikramcse/the-service/internal/register"
+	"github.com/vikramcse/the-service/internal/tax"
 )
 
-func API(db *sqlx.DB, log *log.Logger) http.Handler {
-	app := web.NewApp(log, mid.Logger(log), mid.Errors(log), mid.Metrics())
+// Config holds the settings of the service that handlers depend on.
+type Config struct {
+	// DefaultLocation is where sales are made and stock is reserved when the
+	// client does not say. It defaults to the location created with the
+	// schema.
+	DefaultLocation string
+
+	// Costing is how the stock of new products is costed when the client
+	// does not say, inventory.FIFO or inventory.Average. It defaults to
+	// inventory.FIFO.
+	Costing string
+
+	// Payments takes card payments. It defaults to a payment.Fake.
+	Payments payment.Provider
+
+	// Receipt is printed around the sales on every receipt.
+	Receipt receipt.Store
+
+	// Search finds products. It defaults to a product.PostgresSearch.
+	Search product.Searcher
+
+	// Timeout bounds how long any call but an export has to be answered in.
+	// Calls that take longer are answered with 503. Exports stream whole
+	// tables for as long as that takes. Zero means no bound.
+	Timeout time.Duration
+}
+
+// exportsPrefix starts the path of every export, which Timeout does not
+// bound.
+const exportsPrefix = "/v1/exports/"
+
+// API constructs an http.Handler with all application routes defined.
+func API(db *sqlx.DB, log *log.Logger, cfg Config) http.Handler {
+	if cfg.DefaultLocation == "" {
+		cfg.DefaultLocation = inventory.DefaultLocation
+	}
+	if cfg.Costing == "" {
+		cfg.Costing = inventory.FIFO
+	}
+	if cfg.Payments == nil {
+		cfg.Payments = payment.NewFake()
+	}
+	if cfg.Search == nil {
+		cfg.Search = &product.PostgresSearch{DB: db}
+	}
+
+	// Changes are audited outside of error handling so the audit sees the
+	// status each call is answered with.
+	app := web.NewApp(log, mid.Logger(log), mid.Audit(db), mid.Errors(log), mid.Metrics())
+
+	// Every sale, however it is made, is rung up in its register session
+	// first so it is taxed where the register is. It is then priced and
+	// taxed the same way. Loyalty points and then a gift card pay for part of
+	// what is owed once it is taxed. Stock running low raises an alert with
+	// the sale.
+	saleHooks := []product.SaleHook{
+		register.SaleHook(), pricing.SaleHook(), tax.SaleHook(), loyalty.SaleHook(), giftcard.SaleHook(),
+		alert.SaleHook(),
+	}
 
 	{
 		c := Check{db: db}
@@ -18,15 +87,207 @@ func API(db *sqlx.DB, log *log.Logger) http.Handler {
 	}
 
 	{
-		p := Products{DB: db, Log: log}
+		p := Products{DB: db, Log: log, DefaultLocation: cfg.DefaultLocation, Costing: cfg.Costing, SaleHooks: saleHooks, Searcher: cfg.Search}
 
 		app.Handle(http.MethodGet, "/v1/products", p.List)
+		app.Handle(http.MethodGet, "/v1/products/low-stock", p.LowStock)
+		app.Handle(http.MethodGet, "/v1/products/search", p.Search)
 		app.Handle(http.MethodGet, "/v1/products/{id}", p.Retrive)
 		app.Handle(http.MethodPost, "/v1/products", p.Create)
+		app.Handle(http.MethodPost, "/v1/products/import", p.Import)
+		app.Handle(http.MethodPut, "/v1/products/{id}/reorder", p.SetReorder)
 
 		app.Handle(http.MethodPost, "/v1/products/{id}/sales", p.AddSale)
 		app.Handle(http.MethodGet, "/v1/products/{id}/sales", p.ListSales)
+
+		app.Handle(http.MethodPost, "/v1/products/{id}/variants", p.AddVariant)
+		app.Handle(http.MethodGet, "/v1/products/{id}/variants", p.ListVariants)
+
+		app.Handle(http.MethodPost, "/v1/products/{id}/prices", p.SetPrice)
+		app.Handle(http.MethodGet, "/v1/products/{id}/prices", p.ListPrices)
+
+		app.Handle(http.MethodPost, "/v1/products/{id}/currency-prices", p.SetCurrencyPrice)
+		app.Handle(http.MethodGet, "/v1/price-lists/{currency}", p.PriceList)
+	}
+
+	{
+		i := Inventory{DB: db, Log: log}
+
+		app.Handle(http.MethodPost, "/v1/products/{id}/adjustments", i.Adjust)
+		app.Handle(http.MethodGet, "/v1/products/{id}/movements", i.Movements)
+		app.Handle(http.MethodGet, "/v1/products/{id}/stock", i.Stock)
+
+		app.Handle(http.MethodGet, "/v1/locations", i.ListLocations)
+		app.Handle(http.MethodPost, "/v1/locations", i.CreateLocation)
+
+		app.Handle(http.MethodGet, "/v1/transfers", i.ListTransfers)
+		app.Handle(http.MethodPost, "/v1/transfers", i.CreateTransfer)
+		app.Handle(http.MethodPost, "/v1/transfers/{id}/receive", i.ReceiveTransfer)
+		app.Handle(http.MethodPost, "/v1/transfers/{id}/cancel", i.CancelTransfer)
+	}
+
+	{
+		rs := Reservations{DB: db, Log: log, DefaultLocation: cfg.DefaultLocation, SaleHooks: saleHooks}
+
+		app.Handle(http.MethodPost, "/v1/reservations", rs.Create)
+		app.Handle(http.MethodGet, "/v1/reservations/{id}", rs.Retrive)
+		app.Handle(http.MethodPost, "/v1/reservations/{id}/confirm", rs.Confirm)
+		app.Handle(http.MethodPost, "/v1/reservations/{id}/release", rs.Release)
+	}
+
+	{
+		pr := Pricing{DB: db, Log: log}
+
+		app.Handle(http.MethodPost, "/v1/pricing/quote", pr.Quote)
+
+		app.Handle(http.MethodGet, "/v1/pricing/promotions", pr.ListPromotions)
+		app.Handle(http.MethodPost, "/v1/pricing/promotions", pr.CreatePromotion)
+		app.Handle(http.MethodGet, "/v1/pricing/promotions/{id}/coupons", pr.ListCoupons)
+		app.Handle(http.MethodPost, "/v1/pricing/coupons", pr.CreateCoupon)
+	}
+
+	{
+		tr := Taxes{DB: db, Log: log}
+
+		app.Handle(http.MethodPost, "/v1/tax/rates", tr.SetRate)
+		app.Handle(http.MethodGet, "/v1/locations/{id}/tax-rates", tr.ListRates)
+	}
+
+	{
+		ex := Exchange{DB: db, Log: log}
+
+		app.Handle(http.MethodGet, "/v1/exchange-rates", ex.List)
+		app.Handle(http.MethodPost, "/v1/exchange-rates", ex.Set)
+		app.Handle(http.MethodPost, "/v1/exchange-rates/import", ex.Import)
+	}
+
+	{
+		c := Customers{DB: db, Log: log}
+
+		app.Handle(http.MethodGet, "/v1/customers", c.List)
+		app.Handle(http.MethodGet, "/v1/customers/{id}", c.Retrive)
+		app.Handle(http.MethodPost, "/v1/customers", c.Create)
+		app.Handle(http.MethodPut, "/v1/customers/{id}", c.Update)
+		app.Handle(http.MethodDelete, "/v1/customers/{id}", c.Delete)
+		app.Handle(http.MethodGet, "/v1/customers/{id}/sales", c.Sales)
+	}
+
+	{
+		l := Loyalty{DB: db, Log: log}
+
+		app.Handle(http.MethodGet, "/v1/loyalty/program", l.Program)
+		app.Handle(http.MethodPut, "/v1/loyalty/program", l.SetProgram)
+		app.Handle(http.MethodGet, "/v1/loyalty/multipliers", l.ListMultipliers)
+		app.Handle(http.MethodPost, "/v1/loyalty/multipliers", l.SetMultiplier)
+
+		app.Handle(http.MethodGet, "/v1/customers/{id}/points", l.Balance)
+		app.Handle(http.MethodGet, "/v1/customers/{id}/points/statement", l.Statement)
+	}
+
+	{
+		g := GiftCards{DB: db, Log: log}
+
+		app.Handle(http.MethodPost, "/v1/gift-cards", g.Issue)
+		app.Handle(http.MethodGet, "/v1/gift-cards/{code}", g.Retrive)
+		app.Handle(http.MethodGet, "/v1/gift-cards/{code}/transactions", g.Transactions)
+		app.Handle(http.MethodPost, "/v1/sales/{id}/refunds", g.Refund)
+	}
+
+	{
+		pa := Payments{DB: db, Log: log, Provider: cfg.Payments}
+
+		app.Handle(http.MethodPost, "/v1/sales/{id}/payments", pa.Pay)
+		app.Handle(http.MethodGet, "/v1/sales/{id}/payments", pa.List)
+		app.Handle(http.MethodPost, "/v1/payments/{id}/refund", pa.Refund)
+		app.Handle(http.MethodPost, "/v1/payments/{id}/void", pa.Void)
+	}
+
+	{
+		rc := Receipts{DB: db, Log: log, Store: cfg.Receipt}
+
+		app.Handle(http.MethodGet, "/v1/sales/{id}/receipt", rc.Sale)
+		app.Handle(http.MethodGet, "/v1/receipts", rc.Basket)
+		app.Handle(http.MethodGet, "/v1/reservations/{id}/receipt", rc.Order)
+	}
+
+	{
+		rg := Registers{DB: db, Log: log}
+
+		app.Handle(http.MethodGet, "/v1/registers", rg.List)
+		app.Handle(http.MethodGet, "/v1/registers/{id}", rg.Retrive)
+		app.Handle(http.MethodPost, "/v1/registers", rg.Create)
+		app.Handle(http.MethodPost, "/v1/registers/{id}/sessions", rg.Open)
+		app.Handle(http.MethodGet, "/v1/registers/{id}/sessions", rg.ListSessions)
+
+		app.Handle(http.MethodGet, "/v1/sessions/{id}", rg.Session)
+		app.Handle(http.MethodPost, "/v1/sessions/{id}/cash", rg.MoveCash)
+		app.Handle(http.MethodGet, "/v1/sessions/{id}/cash", rg.ListCash)
+		app.Handle(http.MethodPost, "/v1/sessions/{id}/close", rg.Close)
+		app.Handle(http.MethodGet, "/v1/sessions/{id}/report", rg.Report)
+	}
+
+	{
+		s := Suppliers{DB: db, Log: log}
+
+		app.Handle(http.MethodGet, "/v1/suppliers", s.List)
+		app.Handle(http.MethodGet, "/v1/suppliers/{id}", s.Retrive)
+		app.Handle(http.MethodPost, "/v1/suppliers", s.Create)
+	}
+
+	{
+		po := PurchaseOrders{DB: db, Log: log}
+
+		app.Handle(http.MethodGet, "/v1/purchase-orders", po.List)
+		app.Handle(http.MethodGet, "/v1/purchase-orders/{id}", po.Retrive)
+		app.Handle(http.MethodPost, "/v1/purchase-orders", po.Create)
+
+		app.Handle(http.MethodPost, "/v1/purchase-orders/{id}/send", po.Send)
+		app.Handle(http.MethodPost, "/v1/purchase-orders/{id}/cancel", po.Cancel)
+
+		app.Handle(http.MethodPost, "/v1/purchase-orders/{id}/receive", po.Receive)
+		app.Handle(http.MethodGet, "/v1/purchase-orders/{id}/receipts", po.ListReceipts)
+	}
+
+	{
+		rp := Reports{DB: db, Log: log}
+
+		app.Handle(http.MethodGet, "/v1/reports/sales", rp.Sales)
+		app.Handle(http.MethodGet, "/v1/reports/tax", rp.Tax)
+	}
+
+	{
+		xp := Exports{DB: db, Log: log}
+
+		app.Handle(http.MethodGet, exportsPrefix+"products", xp.Products)
+		app.Handle(http.MethodGet, exportsPrefix+"sales", xp.Sales)
+	}
+
+	{
+		ac := Accounting{DB: db, Log: log}
+
+		app.Handle(http.MethodGet, "/v1/accounting/accounts", ac.Accounts)
+		app.Handle(http.MethodGet, "/v1/accounting/trial-balance", ac.TrialBalance)
+		app.Handle(http.MethodGet, "/v1/accounting/journal", ac.Journal)
+	}
+
+	{
+		au := Audit{DB: db, Log: log}
+
+		app.Handle(http.MethodGet, "/v1/audit", au.List)
+		app.Handle(http.MethodGet, "/v1/audit/verify", au.Verify)
 	}
 
-	return app
+	if cfg.Timeout <= 0 {
+		return app
+	}
+
+	bounded := http.TimeoutHandler(app, cfg.Timeout, "Request took too long to answer")
+	h := func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, exportsPrefix) {
+			app.ServeHTTP(w, r)
+			return
+		}
+		bounded.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(h)
 }
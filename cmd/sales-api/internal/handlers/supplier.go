@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/platform/web"
+	"github.com/vikramcse/the-service/internal/supplier"
+)
+
+// Suppliers holds the handlers for managing who we buy stock from.
+type Suppliers struct {
+	DB  *sqlx.DB
+	Log *log.Logger
+}
+
+// List gets all suppliers.
+func (s *Suppliers) List(w http.ResponseWriter, r *http.Request) error {
+	list, err := supplier.List(r.Context(), s.DB)
+	if err != nil {
+		return errors.Wrap(err, "getting supplier list")
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
+
+// Retrive gets a single supplier.
+func (s *Suppliers) Retrive(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	sup, err := supplier.Retrive(r.Context(), s.DB, id)
+	if err != nil {
+		switch err {
+		case supplier.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case supplier.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "getting supplier %q", id)
+		}
+	}
+
+	return web.Respond(r.Context(), w, sup, http.StatusOK)
+}
+
+// Create decodes the body of a request to create a new supplier. The full
+// supplier with generated fields is sent back in the response.
+func (s *Suppliers) Create(w http.ResponseWriter, r *http.Request) error {
+	var ns supplier.NewSupplier
+	if err := web.Decoder(r, &ns); err != nil {
+		return errors.Wrap(err, "decoding new supplier")
+	}
+
+	sup, err := supplier.Create(r.Context(), s.DB, ns, time.Now())
+	if err != nil {
+		return errors.Wrap(err, "creating new supplier")
+	}
+
+	return web.Respond(r.Context(), w, sup, http.StatusCreated)
+}
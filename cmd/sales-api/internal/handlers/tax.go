@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/inventory"
+	"github.com/vikramcse/the-service/internal/platform/web"
+	"github.com/vikramcse/the-service/internal/tax"
+)
+
+// Taxes holds the handlers for managing tax rates.
+type Taxes struct {
+	DB  *sqlx.DB
+	Log *log.Logger
+}
+
+// SetRate schedules the tax rate in the request body.
+func (tr *Taxes) SetRate(w http.ResponseWriter, r *http.Request) error {
+	var nr tax.NewRate
+	if err := web.Decoder(r, &nr); err != nil {
+		return errors.Wrap(err, "decoding new tax rate")
+	}
+
+	rate, err := tax.SetRate(r.Context(), tr.DB, nr, time.Now())
+	if err != nil {
+		switch err {
+		case inventory.ErrLocationNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case inventory.ErrInvalidID, tax.ErrInvalidRate, tax.ErrNoClass, tax.ErrRateInPast:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "setting tax rate")
+		}
+	}
+
+	return web.Respond(r.Context(), w, rate, http.StatusCreated)
+}
+
+// ListRates gets every tax rate set for a location.
+func (tr *Taxes) ListRates(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	list, err := tax.ListRates(r.Context(), tr.DB, id)
+	if err != nil {
+		switch err {
+		case tax.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "getting tax rates for location %q", id)
+		}
+	}
+
+	return web.Respond(r.Context(), w, list, http.StatusOK)
+}
@@ -15,7 +15,14 @@ import (
 	"github.com/ardanlabs/conf"
 	"github.com/pkg/errors"
 	"github.com/vikramcse/the-service/cmd/sales-api/internal/handlers"
+	"github.com/vikramcse/the-service/internal/alert"
+	"github.com/vikramcse/the-service/internal/inventory"
+	"github.com/vikramcse/the-service/internal/loyalty"
+	"github.com/vikramcse/the-service/internal/payment"
 	"github.com/vikramcse/the-service/internal/platform/database"
+	"github.com/vikramcse/the-service/internal/product"
+	"github.com/vikramcse/the-service/internal/receipt"
+	"github.com/vikramcse/the-service/internal/reservation"
 )
 
 func main() {
@@ -35,6 +42,7 @@ func run() error {
 			Debug           string        `conf:"default:0.0.0.0:6060"`
 			ReadTimeout     time.Duration `conf:"default:5s"`
 			WriteTimeout    time.Duration `conf:"default:5s"`
+			ExportTimeout   time.Duration `conf:"default:10m,help:how long an export can take to stream"`
 			ShutdownTimeout time.Duration `conf:"default:5s"`
 		}
 		DB struct {
@@ -44,6 +52,31 @@ func run() error {
 			Name       string `conf:"default:postgres"`
 			DisableTLS bool   `conf:"default:false"`
 		}
+		Prices struct {
+			ApplyInterval time.Duration `conf:"default:1m"`
+		}
+		Inventory struct {
+			DefaultLocation string `conf:"default:00000000-0000-0000-0000-000000000001"`
+			Costing         string `conf:"default:fifo,help:how stock of new products is costed; fifo or average"`
+		}
+		Reservations struct {
+			SweepInterval time.Duration `conf:"default:30s"`
+		}
+		Loyalty struct {
+			SweepInterval time.Duration `conf:"default:1h"`
+		}
+		Payments struct {
+			Provider          string        `conf:"default:fake,help:payment provider to take card payments with; only fake is available"`
+			ReconcileInterval time.Duration `conf:"default:1m,help:how often payments the provider did not answer for are looked up"`
+		}
+		Receipt struct {
+			Header []string `conf:"default:THE SERVICE,help:lines printed at the top of receipts separated by ;"`
+			Footer []string `conf:"default:Thank you for shopping with us,help:lines printed at the bottom of receipts separated by ;"`
+		}
+		Alerts struct {
+			CheckInterval time.Duration `conf:"default:30s"`
+			File          string        `conf:"help:file to append alerts to instead of stdout"`
+		}
 	}
 
 	if err := conf.Parse(os.Args[1:], "SALES", &cfg); err != nil {
@@ -90,6 +123,116 @@ func run() error {
 		log.Println("debug service closed", err)
 	}()
 
+	// The workers below tick at these intervals and a ticker can not run at
+	// an interval that is not positive.
+	intervals := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"prices apply", cfg.Prices.ApplyInterval},
+		{"alerts check", cfg.Alerts.CheckInterval},
+		{"reservations sweep", cfg.Reservations.SweepInterval},
+		{"loyalty sweep", cfg.Loyalty.SweepInterval},
+		{"payments reconcile", cfg.Payments.ReconcileInterval},
+	}
+	for _, i := range intervals {
+		if i.d <= 0 {
+			return errors.Errorf("%s interval must be positive, got %v", i.name, i.d)
+		}
+	}
+
+	// Start Price Scheduler
+	// Scheduled price changes only become the product cost once they are in
+	// effect, so they are applied periodically until the service shuts down.
+
+	workers, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	go func() {
+		ticker := time.NewTicker(cfg.Prices.ApplyInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				n, err := product.ApplyPrices(workers, db, time.Now())
+				if err != nil {
+					log.Printf("main: applying scheduled prices: %v", err)
+					continue
+				}
+				if n > 0 {
+					log.Printf("main: applied scheduled prices to %d products", n)
+				}
+			case <-workers.Done():
+				return
+			}
+		}
+	}()
+
+	// Start Low Stock Checker
+	// Alerts are written as JSON lines to stdout unless a file is configured.
+	notifier := alert.NewWriterNotifier(os.Stdout)
+	if cfg.Alerts.File != "" {
+		f, err := os.OpenFile(cfg.Alerts.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return errors.Wrap(err, "opening alerts file")
+		}
+		defer f.Close()
+		notifier = alert.NewWriterNotifier(f)
+	}
+
+	checker := alert.Checker{
+		DB:       db,
+		Log:      log,
+		Notifier: notifier,
+		Interval: cfg.Alerts.CheckInterval,
+	}
+	go checker.Run(workers)
+
+	// Start Reservation Sweeper
+	// Reservations that were neither confirmed nor released in time give
+	// their stock back.
+	sweeper := reservation.Sweeper{
+		DB:       db,
+		Log:      log,
+		Interval: cfg.Reservations.SweepInterval,
+	}
+	go sweeper.Run(workers)
+
+	// Start Points Sweeper
+	// Loyalty points not spent in time expire.
+	points := loyalty.Sweeper{
+		DB:       db,
+		Log:      log,
+		Interval: cfg.Loyalty.SweepInterval,
+	}
+	go points.Run(workers)
+
+	// Start Payment Provider
+	// Only the in-process fake exists so far; it approves every payment.
+	var provider payment.Provider
+	switch cfg.Payments.Provider {
+	case "fake":
+		provider = payment.NewFake()
+	default:
+		return errors.Errorf("unknown payment provider %q", cfg.Payments.Provider)
+	}
+
+	// Start Payment Reconciler
+	// Card payments the provider did not answer for in time are looked up
+	// until what became of them is known.
+	reconciler := payment.Reconciler{
+		DB:       db,
+		Log:      log,
+		Provider: provider,
+		Interval: cfg.Payments.ReconcileInterval,
+	}
+	go reconciler.Run(workers)
+
+	if err := inventory.CheckCosting(cfg.Inventory.Costing); err != nil {
+		return errors.Wrapf(err, "inventory costing %q", cfg.Inventory.Costing)
+	}
+
 	// Api service configuration
 
 	// ReadTimeout: It defines how long you allow a connection to be open
@@ -97,12 +240,25 @@ func run() error {
 	// request, includig body
 
 	// WriteTimeout: It is maximum duration before timing out writes of the
-	// response.
+	// response. Exports stream whole tables, so the server lets writes run
+	// for as long as an export may and every other call is held to
+	// WriteTimeout by the handlers instead.
+	writeTimeout := cfg.Web.WriteTimeout
+	if cfg.Web.ExportTimeout > writeTimeout {
+		writeTimeout = cfg.Web.ExportTimeout
+	}
+	apiCfg := handlers.Config{
+		DefaultLocation: cfg.Inventory.DefaultLocation,
+		Costing:         cfg.Inventory.Costing,
+		Payments:        provider,
+		Receipt:         receipt.Store{Header: cfg.Receipt.Header, Footer: cfg.Receipt.Footer},
+		Timeout:         cfg.Web.WriteTimeout,
+	}
 	api := http.Server{
 		Addr:         cfg.Web.Address,
-		Handler:      handlers.API(db, log),
+		Handler:      handlers.API(db, log, apiCfg),
 		ReadTimeout:  cfg.Web.ReadTimeout,
-		WriteTimeout: cfg.Web.WriteTimeout,
+		WriteTimeout: writeTimeout,
 	}
 
 	// Make a channel to listend for errors coming from the listener. Use a
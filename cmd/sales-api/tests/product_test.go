@@ -23,7 +23,7 @@ func TestProducts(t *testing.T) {
 	}
 
 	log := log.New(os.Stderr, "Test: ", log.LstdFlags|log.Lmicroseconds|log.Lshortfile)
-	tests := ProductTests{app: handlers.API(db, log)}
+	tests := ProductTests{app: handlers.API(db, log, handlers.Config{})}
 	t.Run("List", tests.List)
 }
 
@@ -50,22 +50,44 @@ func (p *ProductTests) List(t *testing.T) {
 		{
 			"id":           "a2b0639f-2cc6-44b8-b97b-15d69dbb511e",
 			"name":         "Comic Books",
-			"cost":         float64(50),
+			"category":     "",
+			"tax_class":    "standard",
+			"cost":         map[string]interface{}{"amount": float64(50), "currency": "USD"},
 			"quantity":     float64(42),
-			"revenue":      float64(350),
+			"revenue":      map[string]interface{}{"amount": float64(350), "currency": "USD"},
 			"sold":         float64(7),
+			"available":    float64(35),
 			"date_created": "2019-01-01T00:00:01.000001Z",
 			"date_updated": "2019-01-01T00:00:01.000001Z",
+
+			"reorder_threshold": float64(0),
+			"reorder_quantity":  float64(0),
+
+			"costing":      "fifo",
+			"cogs":         map[string]interface{}{"amount": float64(0), "currency": "USD"},
+			"gross_margin": map[string]interface{}{"amount": float64(350), "currency": "USD"},
+			"stock_value":  map[string]interface{}{"amount": float64(0), "currency": "USD"},
 		},
 		{
 			"id":           "72f8b983-3eb4-48db-9ed0-e45cc6bd716b",
 			"name":         "McDonalds Toys",
-			"cost":         float64(75),
+			"category":     "",
+			"tax_class":    "standard",
+			"cost":         map[string]interface{}{"amount": float64(75), "currency": "USD"},
 			"quantity":     float64(120),
-			"revenue":      float64(255),
+			"revenue":      map[string]interface{}{"amount": float64(255), "currency": "USD"},
 			"sold":         float64(3),
+			"available":    float64(117),
 			"date_created": "2019-01-01T00:00:02.000001Z",
 			"date_updated": "2019-01-01T00:00:02.000001Z",
+
+			"reorder_threshold": float64(0),
+			"reorder_quantity":  float64(0),
+
+			"costing":      "fifo",
+			"cogs":         map[string]interface{}{"amount": float64(0), "currency": "USD"},
+			"gross_margin": map[string]interface{}{"amount": float64(255), "currency": "USD"},
+			"stock_value":  map[string]interface{}{"amount": float64(0), "currency": "USD"},
 		},
 	}
 
@@ -0,0 +1,232 @@
+// Package accounting keeps a double-entry journal of what the service does to
+// money and stock, so its numbers reconcile with the general ledger.
+package accounting
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/period"
+)
+
+var (
+	ErrTooFewLines    = errors.New("Journal entry must move at least two accounts")
+	ErrInvalidLine    = errors.New("Journal line must either debit or credit a positive amount")
+	ErrUnbalanced     = errors.New("Journal entry debits do not equal its credits")
+	ErrUnknownAccount = errors.New("Account not found")
+)
+
+// Post writes e to the journal as part of tx. Lines moving nothing are
+// dropped, and e is refused unless what is left balances in every currency.
+func Post(ctx context.Context, tx *sqlx.Tx, e Entry) (*Entry, error) {
+	lines := make([]Line, 0, len(e.Lines))
+	for _, l := range e.Lines {
+		if l.Debit == 0 && l.Credit == 0 {
+			continue
+		}
+		if l.Debit < 0 || l.Credit < 0 || (l.Debit > 0 && l.Credit > 0) {
+			return nil, ErrInvalidLine
+		}
+
+		currency, err := money.Currency(l.Currency)
+		if err != nil {
+			return nil, err
+		}
+		l.Currency = currency
+		lines = append(lines, l)
+	}
+	if len(lines) < 2 {
+		return nil, ErrTooFewLines
+	}
+	if !balanced(lines) {
+		return nil, ErrUnbalanced
+	}
+
+	e.ID = uuid.New().String()
+	e.DateCreated = e.DateCreated.UTC()
+	e.Lines = lines
+
+	const q = `
+		INSERT INTO journal_entries (entry_id, kind, source_id, memo, date_created)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	if _, err := tx.ExecContext(ctx, q, e.ID, e.Kind, e.SourceID, e.Memo, e.DateCreated); err != nil {
+		return nil, errors.Wrap(err, "inserting journal entry")
+	}
+
+	const ql = `
+		INSERT INTO journal_lines (entry_id, line_no, account, currency, debit, credit)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+
+	for i := range e.Lines {
+		l := &e.Lines[i]
+		l.EntryID = e.ID
+		if _, err := tx.ExecContext(ctx, ql, e.ID, i+1, l.Account, l.Currency, l.Debit, l.Credit); err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+				return nil, ErrUnknownAccount
+			}
+			return nil, errors.Wrap(err, "inserting journal line")
+		}
+	}
+
+	return &e, nil
+}
+
+// ListAccounts gets the chart of accounts.
+func ListAccounts(ctx context.Context, db *sqlx.DB) ([]Account, error) {
+	accounts := []Account{}
+
+	const q = `SELECT * FROM accounts ORDER BY code`
+	if err := db.SelectContext(ctx, &accounts, q); err != nil {
+		return nil, errors.Wrap(err, "selecting accounts")
+	}
+
+	return accounts, nil
+}
+
+// Balances builds the TrialBalance of the entries posted from from until to.
+// Zero times leave that end of the period open, so a zero from gives the
+// balances as at to.
+func Balances(ctx context.Context, db *sqlx.DB, from, to time.Time) (*TrialBalance, error) {
+	args, err := period.Args(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	tb := TrialBalance{Rows: []Balance{}, Totals: []Balance{}}
+	if !from.IsZero() {
+		tb.From = &from
+	}
+	if !to.IsZero() {
+		tb.To = &to
+	}
+
+	const q = `
+		SELECT
+			l.account, a.name, a.kind, l.currency,
+			SUM(l.debit) as debit, SUM(l.credit) as credit
+		FROM journal_lines as l
+		JOIN journal_entries as e ON e.entry_id = l.entry_id
+		JOIN accounts as a ON a.code = l.account
+		WHERE ($1::timestamp IS NULL OR e.date_created >= $1)
+		AND ($2::timestamp IS NULL OR e.date_created < $2)
+		GROUP BY l.account, a.name, a.kind, l.currency
+		ORDER BY l.currency, l.account`
+
+	var sums []Balance
+	if err := db.SelectContext(ctx, &sums, q, args...); err != nil {
+		return nil, errors.Wrap(err, "selecting account balances")
+	}
+
+	for _, b := range sums {
+		if b.Debit >= b.Credit {
+			b.Debit, b.Credit = b.Debit-b.Credit, 0
+		} else {
+			b.Debit, b.Credit = 0, b.Credit-b.Debit
+		}
+		tb.Rows = append(tb.Rows, b)
+
+		if n := len(tb.Totals); n == 0 || tb.Totals[n-1].Currency != b.Currency {
+			tb.Totals = append(tb.Totals, Balance{Currency: b.Currency})
+		}
+		t := &tb.Totals[len(tb.Totals)-1]
+		t.Debit += b.Debit
+		t.Credit += b.Credit
+	}
+
+	return &tb, nil
+}
+
+// Journal gets the entries posted from from until to, oldest first. Zero
+// times leave that end of the period open.
+func Journal(ctx context.Context, db *sqlx.DB, from, to time.Time) ([]Entry, error) {
+	args, err := period.Args(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []Entry{}
+	const q = `
+		SELECT * FROM journal_entries as e
+		WHERE ($1::timestamp IS NULL OR e.date_created >= $1)
+		AND ($2::timestamp IS NULL OR e.date_created < $2)
+		ORDER BY e.date_created, e.entry_id`
+
+	if err := db.SelectContext(ctx, &entries, q, args...); err != nil {
+		return nil, errors.Wrap(err, "selecting journal entries")
+	}
+
+	var lines []Line
+	const ql = `
+		SELECT l.entry_id, l.account, l.currency, l.debit, l.credit
+		FROM journal_lines as l
+		JOIN journal_entries as e ON e.entry_id = l.entry_id
+		WHERE ($1::timestamp IS NULL OR e.date_created >= $1)
+		AND ($2::timestamp IS NULL OR e.date_created < $2)
+		ORDER BY l.entry_id, l.line_no`
+
+	if err := db.SelectContext(ctx, &lines, ql, args...); err != nil {
+		return nil, errors.Wrap(err, "selecting journal lines")
+	}
+
+	index := make(map[string]int, len(entries))
+	for i := range entries {
+		index[entries[i].ID] = i
+		entries[i].Lines = []Line{}
+	}
+	for _, l := range lines {
+		e := &entries[index[l.EntryID]]
+		e.Lines = append(e.Lines, l)
+	}
+
+	return entries, nil
+}
+
+// WriteCSV writes entries to w as CSV with one row per line, the way the
+// general ledger imports them.
+func WriteCSV(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+
+	header := []string{"date", "entry_id", "kind", "source_id", "memo", "account", "currency", "debit", "credit"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		for _, l := range e.Lines {
+			row := []string{
+				e.DateCreated.Format(time.RFC3339), e.ID, e.Kind, e.SourceID, e.Memo,
+				l.Account, l.Currency, strconv.Itoa(l.Debit), strconv.Itoa(l.Credit),
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// balanced reports if the debits of lines equal their credits in every
+// currency.
+func balanced(lines []Line) bool {
+	sums := map[string]int{}
+	for _, l := range lines {
+		sums[l.Currency] += l.Debit - l.Credit
+	}
+	for _, sum := range sums {
+		if sum != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,204 @@
+package accounting_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/vikramcse/the-service/internal/accounting"
+	"github.com/vikramcse/the-service/internal/inventory"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/payment"
+	"github.com/vikramcse/the-service/internal/platform/database"
+	"github.com/vikramcse/the-service/internal/platform/period"
+	"github.com/vikramcse/the-service/internal/product"
+	"github.com/vikramcse/the-service/internal/supplier"
+	"github.com/vikramcse/the-service/internal/tax"
+	"github.com/vikramcse/the-service/internal/tests"
+)
+
+func TestWriteCSV(t *testing.T) {
+	entries := []accounting.Entry{{
+		ID:          "e1",
+		Kind:        accounting.PaymentEntry,
+		SourceID:    "p1",
+		Memo:        "paid, in full",
+		DateCreated: time.Date(2019, time.January, 1, 9, 0, 0, 0, time.UTC),
+		Lines: []accounting.Line{
+			{Account: accounting.Cash, Currency: "USD", Debit: 2500},
+			{Account: accounting.Receivable, Currency: "USD", Credit: 2500},
+		},
+	}}
+
+	var b strings.Builder
+	if err := accounting.WriteCSV(&b, entries); err != nil {
+		t.Fatalf("writing csv: %s", err)
+	}
+
+	exp := "date,entry_id,kind,source_id,memo,account,currency,debit,credit\n" +
+		"2019-01-01T09:00:00Z,e1,payment,p1,\"paid, in full\",1000,USD,2500,0\n" +
+		"2019-01-01T09:00:00Z,e1,payment,p1,\"paid, in full\",1100,USD,0,2500\n"
+	if got := b.String(); got != exp {
+		t.Fatalf("expected csv:\n%s\ngot:\n%s", exp, got)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 9, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	post := func(e accounting.Entry) error {
+		return database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+			_, err := accounting.Post(ctx, tx, e)
+			return err
+		})
+	}
+	unbalanced := accounting.Entry{Kind: accounting.SaleEntry, DateCreated: now, Lines: []accounting.Line{
+		{Account: accounting.Cash, Currency: "USD", Debit: 100},
+		{Account: accounting.Revenue, Currency: "USD", Credit: 90},
+	}}
+	if err := post(unbalanced); err != accounting.ErrUnbalanced {
+		t.Fatalf("expected %v, got %v", accounting.ErrUnbalanced, err)
+	}
+	unknown := accounting.Entry{Kind: accounting.SaleEntry, DateCreated: now, Lines: []accounting.Line{
+		{Account: "9999", Currency: "USD", Debit: 100},
+		{Account: accounting.Revenue, Currency: "USD", Credit: 100},
+	}}
+	if err := post(unknown); err != accounting.ErrUnknownAccount {
+		t.Fatalf("expected %v, got %v", accounting.ErrUnknownAccount, err)
+	}
+
+	// Ten comics bought at 10.00 and two sold for 25.00 each, paid in cash.
+	p, err := product.Create(ctx, db, product.NewProduct{Name: "Comic Book", Cost: 2500}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	s, err := supplier.Create(ctx, db, supplier.NewSupplier{Name: "Comics Wholesale"}, now)
+	if err != nil {
+		t.Fatalf("creating supplier: %s", err)
+	}
+	npo := supplier.NewPurchaseOrder{
+		SupplierID: s.ID,
+		Lines:      []supplier.NewLine{{ProductID: p.ID, Quantity: 10, UnitCost: 1000}},
+	}
+	po, err := supplier.CreateOrder(ctx, db, npo, now)
+	if err != nil {
+		t.Fatalf("creating purchase order: %s", err)
+	}
+	if _, err := supplier.SetStatus(ctx, db, po.ID, supplier.Sent, now); err != nil {
+		t.Fatalf("sending purchase order: %s", err)
+	}
+	nr := supplier.NewReceipt{Lines: []supplier.NewReceiptLine{{LineID: po.Lines[0].ID, Quantity: 10}}}
+	if _, err := supplier.Receive(ctx, db, nr, po.ID, now); err != nil {
+		t.Fatalf("receiving goods: %s", err)
+	}
+
+	// Five more comics the shop already had at 4.00 each.
+	np := product.NewProduct{Name: "Old Comic Book", Cost: 1000, Quantity: 5, UnitCost: 400}
+	if _, err := product.Create(ctx, db, np, now); err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+
+	ns := product.NewSale{Quantity: 2, Paid: &money.Money{Amount: 5000}}
+	sale, err := product.AddSale(ctx, db, ns, p.ID, now)
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	pay := payment.NewPayment{Tenders: []payment.NewTender{{Tender: payment.Cash, Amount: 6000}}}
+	if _, err := payment.Pay(ctx, db, payment.NewFake(), sale.ID, pay, now); err != nil {
+		t.Fatalf("paying for sale: %s", err)
+	}
+
+	tb, err := accounting.Balances(ctx, db, time.Time{}, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("getting trial balance: %s", err)
+	}
+
+	got := map[string]int{}
+	for _, b := range tb.Rows {
+		got[b.Account] = b.Debit - b.Credit
+	}
+	exp := map[string]int{
+		accounting.Cash:            5000,
+		accounting.Receivable:      0,
+		accounting.Inventory:       10000,
+		accounting.Payable:         -10000,
+		accounting.OpeningBalance:  -2000,
+		accounting.Revenue:         -5000,
+		accounting.CostOfGoodsSold: 2000,
+	}
+	for account, balance := range exp {
+		if got[account] != balance {
+			t.Errorf("expected account %s to balance at %d, got %d", account, balance, got[account])
+		}
+	}
+	if len(tb.Totals) != 1 || tb.Totals[0].Debit != tb.Totals[0].Credit {
+		t.Fatalf("expected one balanced total, got %+v", tb.Totals)
+	}
+
+	entries, err := accounting.Journal(ctx, db, now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("getting journal: %s", err)
+	}
+	if exp, got := 4, len(entries); exp != got {
+		t.Fatalf("expected %d entries, got %d", exp, got)
+	}
+	if _, err := accounting.Journal(ctx, db, now, now); err != period.ErrInvalidRange {
+		t.Fatalf("expected %v, got %v", period.ErrInvalidRange, err)
+	}
+}
+
+func TestRefundTax(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 9, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	p, err := product.Create(ctx, db, product.NewProduct{Name: "Comic Book", Cost: 1000, Quantity: 1}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	nr := tax.NewRate{LocationID: inventory.DefaultLocation, TaxClass: product.DefaultTaxClass, Rate: 2000, Inclusive: true}
+	if _, err := tax.SetRate(ctx, db, nr, now); err != nil {
+		t.Fatalf("setting rate: %s", err)
+	}
+
+	// 1000 paid with 167 of it tax.
+	ns := product.NewSale{Quantity: 1, Paid: &money.Money{Amount: 1000}}
+	sale, err := product.AddSale(ctx, db, ns, p.ID, now, tax.SaleHook())
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	f := payment.NewFake()
+	np := payment.NewPayment{Tenders: []payment.NewTender{{Tender: payment.Cash, Amount: 1000}}}
+	list, err := payment.Pay(ctx, db, f, sale.ID, np, now)
+	if err != nil {
+		t.Fatalf("paying for sale: %s", err)
+	}
+
+	// Refunded in thirds the tax reclaimed is 56, 55 and the 56 left, which
+	// truncating would have made 55, 55 and 55.
+	for _, amount := range []int{333, 333, 334} {
+		if _, err := payment.Refund(ctx, db, f, list[0].ID, amount, now); err != nil {
+			t.Fatalf("refunding %d: %s", amount, err)
+		}
+	}
+
+	tb, err := accounting.Balances(ctx, db, time.Time{}, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("getting trial balance: %s", err)
+	}
+	got := map[string]int{}
+	for _, b := range tb.Rows {
+		got[b.Account] = b.Debit - b.Credit
+	}
+	if got[accounting.TaxPayable] != 0 || got[accounting.Returns] != 833 {
+		t.Fatalf("expected all 167 of tax reclaimed and 833 returned, got %v", got)
+	}
+}
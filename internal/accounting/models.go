@@ -0,0 +1,94 @@
+package accounting
+
+import (
+	"time"
+)
+
+// Accounts of the chart of accounts created with the schema.
+const (
+	Cash               = "1000"
+	CardClearing       = "1010"
+	Receivable         = "1100"
+	Inventory          = "1200"
+	TaxPayable         = "2000"
+	GiftCardLiability  = "2100"
+	Payable            = "2200"
+	OpeningBalance     = "3000"
+	Revenue            = "4000"
+	Returns            = "4100"
+	CostOfGoodsSold    = "5000"
+	InventoryAdjusted  = "5100"
+	LoyaltyRedemptions = "5200"
+)
+
+// Kinds of Account.
+const (
+	Asset     = "asset"
+	Liability = "liability"
+	Equity    = "equity"
+	Income    = "revenue"
+	Expense   = "expense"
+)
+
+// Kinds of Entry, named for what caused them.
+const (
+	SaleEntry       = "sale"
+	PaymentEntry    = "payment"
+	RefundEntry     = "refund"
+	AdjustmentEntry = "adjustment"
+	ReceiptEntry    = "receipt"
+	GiftCardEntry   = "gift_card"
+	ReturnEntry     = "return"
+	OpeningEntry    = "opening"
+)
+
+// Account is one account of the general ledger, identified by its Code.
+type Account struct {
+	Code string `db:"code" json:"code"`
+	Name string `db:"name" json:"name"`
+	Kind string `db:"kind" json:"kind"`
+}
+
+// Entry is one posting to the journal. SourceID identifies what caused it,
+// such as the sale or goods receipt. The Lines of an Entry balance: in each
+// currency the debits add up to the credits.
+type Entry struct {
+	ID          string    `db:"entry_id" json:"id"`
+	Kind        string    `db:"kind" json:"kind"`
+	SourceID    string    `db:"source_id" json:"source_id,omitempty"`
+	Memo        string    `db:"memo" json:"memo,omitempty"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+	Lines       []Line    `db:"-" json:"lines"`
+}
+
+// Line debits or credits one Account in minor units of Currency. Only one of
+// Debit and Credit is set.
+type Line struct {
+	EntryID  string `db:"entry_id" json:"-"`
+	Account  string `db:"account" json:"account"`
+	Currency string `db:"currency" json:"currency"`
+	Debit    int    `db:"debit" json:"debit"`
+	Credit   int    `db:"credit" json:"credit"`
+}
+
+// TrialBalance lists the balance of every Account that moved in a period,
+// per currency. The balance of an Account is in Debit when its debits
+// outweigh its credits and in Credit otherwise, so in each currency the
+// Totals of the two columns agree.
+type TrialBalance struct {
+	From   *time.Time `json:"from,omitempty"`
+	To     *time.Time `json:"to,omitempty"`
+	Rows   []Balance  `json:"rows"`
+	Totals []Balance  `json:"totals"`
+}
+
+// Balance is one row of a TrialBalance, or the total of a currency when it
+// has no Account.
+type Balance struct {
+	Account  string `db:"account" json:"account,omitempty"`
+	Name     string `db:"name" json:"name,omitempty"`
+	Kind     string `db:"kind" json:"kind,omitempty"`
+	Currency string `db:"currency" json:"currency"`
+	Debit    int    `db:"debit" json:"debit"`
+	Credit   int    `db:"credit" json:"credit"`
+}
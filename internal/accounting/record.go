@@ -0,0 +1,314 @@
+package accounting
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/money"
+)
+
+// Sale is what the journal needs to know about a sale. Its amounts are in
+// the currency the sale was paid in; Gross includes Tax, and PointsValue and
+// GiftCardPaid are the parts of it paid with loyalty points and gift cards.
+// Cost is what the stock sold cost, in StockCurrency, the currency of the
+// product.
+type Sale struct {
+	ID            string
+	Gross         money.Money
+	Tax           money.Money
+	PointsValue   money.Money
+	GiftCardPaid  money.Money
+	Cost          int
+	StockCurrency string
+	DateCreated   time.Time
+}
+
+// RecordSale posts a sale: revenue and the tax on it are owed by the
+// customer less what points and gift cards paid, and the stock sold moves
+// from inventory to the cost of goods sold.
+func RecordSale(ctx context.Context, tx *sqlx.Tx, s Sale) error {
+	c := s.Gross.Currency
+	owed := s.Gross.Amount - s.PointsValue.Amount - s.GiftCardPaid.Amount
+
+	e := Entry{
+		Kind:        SaleEntry,
+		SourceID:    s.ID,
+		DateCreated: s.DateCreated,
+		Lines: []Line{
+			{Account: Receivable, Currency: c, Debit: owed},
+			{Account: GiftCardLiability, Currency: c, Debit: s.GiftCardPaid.Amount},
+			{Account: LoyaltyRedemptions, Currency: c, Debit: s.PointsValue.Amount},
+			{Account: Revenue, Currency: c, Credit: s.Gross.Amount - s.Tax.Amount},
+			{Account: TaxPayable, Currency: c, Credit: s.Tax.Amount},
+			{Account: CostOfGoodsSold, Currency: s.StockCurrency, Debit: s.Cost},
+			{Account: Inventory, Currency: s.StockCurrency, Credit: s.Cost},
+		},
+	}
+
+	// A sale given away for nothing of stock that cost nothing moves no
+	// money at all.
+	if _, err := Post(ctx, tx, e); err != nil && err != ErrTooFewLines {
+		return errors.Wrap(err, "posting sale")
+	}
+
+	return nil
+}
+
+// RecordPayment posts m taken into account as payment of what a customer
+// owes.
+func RecordPayment(ctx context.Context, tx *sqlx.Tx, paymentID, account string, m money.Money, now time.Time) error {
+	e := Entry{
+		Kind:        PaymentEntry,
+		SourceID:    paymentID,
+		DateCreated: now,
+		Lines: []Line{
+			{Account: account, Currency: m.Currency, Debit: m.Amount},
+			{Account: Receivable, Currency: m.Currency, Credit: m.Amount},
+		},
+	}
+
+	if _, err := Post(ctx, tx, e); err != nil {
+		return errors.Wrap(err, "posting payment")
+	}
+
+	return nil
+}
+
+// RecordRefund posts m of a sale given back out of account. The refund must
+// already be counted in what was refunded of the sale. The tax charged on the
+// sale is reclaimed in proportion to how much of what was paid in money has
+// been refunded, rounded to the nearest minor unit, and the refund that gives
+// back the rest of it reclaims the rest of the tax. Loyalty points that paid
+// for the sale are given back rather than refunded, see RecordRestore.
+func RecordRefund(ctx context.Context, tx *sqlx.Tx, saleID, sourceID, account string, m money.Money, now time.Time) error {
+	var s struct {
+		Currency string `db:"currency"`
+		Paid     int    `db:"paid"`
+		Gross    int    `db:"gross"`
+		Tax      int    `db:"tax"`
+		Refunded int    `db:"refunded"`
+	}
+	const q = `
+		SELECT currency, paid - points_value as paid, gross - points_value as gross, tax, refunded
+		FROM sales WHERE sale_id = $1`
+	if err := tx.GetContext(ctx, &s, q, saleID); err != nil {
+		return errors.Wrapf(err, "selecting sale %q", saleID)
+	}
+	if s.Currency != m.Currency {
+		return money.ErrMismatch
+	}
+
+	// reclaimed is the tax given back once refunded has been.
+	reclaimed := func(refunded int) int {
+		switch {
+		case s.Gross <= 0:
+			return 0
+		case refunded >= s.Paid:
+			return s.Tax
+		default:
+			return divRound(refunded*s.Tax, s.Gross)
+		}
+	}
+	tax := reclaimed(s.Refunded) - reclaimed(s.Refunded-m.Amount)
+
+	e := Entry{
+		Kind:        RefundEntry,
+		SourceID:    sourceID,
+		Memo:        "sale " + saleID,
+		DateCreated: now,
+		Lines: []Line{
+			{Account: Returns, Currency: m.Currency, Debit: m.Amount - tax},
+			{Account: TaxPayable, Currency: m.Currency, Debit: tax},
+			{Account: account, Currency: m.Currency, Credit: m.Amount},
+		},
+	}
+
+	if _, err := Post(ctx, tx, e); err != nil {
+		return errors.Wrap(err, "posting refund")
+	}
+
+	return nil
+}
+
+// RecordRestore posts m of a sale paid with loyalty points given back to the
+// customer as points: what the points paid for is returned and the
+// redemption undone.
+func RecordRestore(ctx context.Context, tx *sqlx.Tx, saleID string, m money.Money, now time.Time) error {
+	e := Entry{
+		Kind:        RefundEntry,
+		SourceID:    saleID,
+		Memo:        "points restored",
+		DateCreated: now,
+		Lines: []Line{
+			{Account: Returns, Currency: m.Currency, Debit: m.Amount},
+			{Account: LoyaltyRedemptions, Currency: m.Currency, Credit: m.Amount},
+		},
+	}
+
+	if _, err := Post(ctx, tx, e); err != nil && err != ErrTooFewLines {
+		return errors.Wrap(err, "posting restored points")
+	}
+
+	return nil
+}
+
+// RecordReturn posts stock of a product brought back with a refund: what it
+// cost moves from the cost of goods sold back into inventory. Stock that cost
+// nothing posts nothing.
+func RecordReturn(ctx context.Context, tx *sqlx.Tx, movementID, productID string, value int, now time.Time) error {
+	currency, err := currencyOf(ctx, tx, productID)
+	if err != nil {
+		return err
+	}
+
+	e := Entry{
+		Kind:        ReturnEntry,
+		SourceID:    movementID,
+		DateCreated: now,
+		Lines: []Line{
+			{Account: Inventory, Currency: currency, Debit: value},
+			{Account: CostOfGoodsSold, Currency: currency, Credit: value},
+		},
+	}
+
+	if _, err := Post(ctx, tx, e); err != nil && err != ErrTooFewLines {
+		return errors.Wrap(err, "posting return")
+	}
+
+	return nil
+}
+
+// RecordAdjustment posts stock of a product found or lost by hand. value is
+// what the stock cost, positive when it was found and negative when it was
+// lost. Stock that cost nothing posts nothing.
+func RecordAdjustment(ctx context.Context, tx *sqlx.Tx, movementID, productID string, value int, now time.Time) error {
+	currency, err := currencyOf(ctx, tx, productID)
+	if err != nil {
+		return err
+	}
+
+	debit, credit := Inventory, InventoryAdjusted
+	if value < 0 {
+		value = -value
+		debit, credit = credit, debit
+	}
+
+	e := Entry{
+		Kind:        AdjustmentEntry,
+		SourceID:    movementID,
+		DateCreated: now,
+		Lines: []Line{
+			{Account: debit, Currency: currency, Debit: value},
+			{Account: credit, Currency: currency, Credit: value},
+		},
+	}
+
+	if _, err := Post(ctx, tx, e); err != nil && err != ErrTooFewLines {
+		return errors.Wrap(err, "posting adjustment")
+	}
+
+	return nil
+}
+
+// RecordOpeningStock posts the stock a product or variant was created with:
+// it comes into inventory against opening balance equity, since nobody is
+// owed for it.
+func RecordOpeningStock(ctx context.Context, tx *sqlx.Tx, movementID, productID string, value int, now time.Time) error {
+	currency, err := currencyOf(ctx, tx, productID)
+	if err != nil {
+		return err
+	}
+
+	debit, credit := Inventory, OpeningBalance
+	if value < 0 {
+		value = -value
+		debit, credit = credit, debit
+	}
+
+	e := Entry{
+		Kind:        OpeningEntry,
+		SourceID:    movementID,
+		DateCreated: now,
+		Lines: []Line{
+			{Account: debit, Currency: currency, Debit: value},
+			{Account: credit, Currency: currency, Credit: value},
+		},
+	}
+
+	if _, err := Post(ctx, tx, e); err != nil && err != ErrTooFewLines {
+		return errors.Wrap(err, "posting opening stock")
+	}
+
+	return nil
+}
+
+// RecordReceipt posts goods arriving from a supplier: the stock is owed to
+// them at its landed cost per unit.
+func RecordReceipt(ctx context.Context, tx *sqlx.Tx, receiptID, productID string, quantity, landedCost int, now time.Time) error {
+	currency, err := currencyOf(ctx, tx, productID)
+	if err != nil {
+		return err
+	}
+
+	value := quantity * landedCost
+	e := Entry{
+		Kind:        ReceiptEntry,
+		SourceID:    receiptID,
+		DateCreated: now,
+		Lines: []Line{
+			{Account: Inventory, Currency: currency, Debit: value},
+			{Account: Payable, Currency: currency, Credit: value},
+		},
+	}
+
+	if _, err := Post(ctx, tx, e); err != nil && err != ErrTooFewLines {
+		return errors.Wrap(err, "posting goods receipt")
+	}
+
+	return nil
+}
+
+// RecordGiftCard posts a gift card sold for cash: the balance on it is owed
+// to whoever holds it until it is spent.
+func RecordGiftCard(ctx context.Context, tx *sqlx.Tx, cardID string, m money.Money, now time.Time) error {
+	e := Entry{
+		Kind:        GiftCardEntry,
+		SourceID:    cardID,
+		DateCreated: now,
+		Lines: []Line{
+			{Account: Cash, Currency: m.Currency, Debit: m.Amount},
+			{Account: GiftCardLiability, Currency: m.Currency, Credit: m.Amount},
+		},
+	}
+
+	if _, err := Post(ctx, tx, e); err != nil {
+		return errors.Wrap(err, "posting gift card")
+	}
+
+	return nil
+}
+
+// currencyOf gets the currency a product is sold and valued in.
+func currencyOf(ctx context.Context, db sqlx.QueryerContext, productID string) (string, error) {
+	var currency string
+	const q = `SELECT currency FROM products WHERE product_id = $1`
+	if err := sqlx.GetContext(ctx, db, &currency, q, productID); err != nil {
+		if err == sql.ErrNoRows {
+			return "", errors.Errorf("product %q not found", productID)
+		}
+		return "", errors.Wrap(err, "selecting product currency")
+	}
+
+	return currency, nil
+}
+
+// divRound divides n by the positive d, rounding halves away from zero.
+func divRound(n, d int) int {
+	if n < 0 {
+		return -((-n*2 + d) / (2 * d))
+	}
+	return (n*2 + d) / (2 * d)
+}
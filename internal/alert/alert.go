@@ -0,0 +1,189 @@
+// Package alert watches stock levels and tells someone when a product needs
+// to be reordered.
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/platform/database"
+	"github.com/vikramcse/the-service/internal/product"
+)
+
+// Alert is raised when the remaining stock of a product falls to or below
+// its reorder threshold. A product has at most one Alert open at a time; it
+// is cleared once the product is restocked above its threshold.
+type Alert struct {
+	ID               string     `db:"alert_id" json:"id"`
+	ProductID        string     `db:"product_id" json:"product_id"`
+	Name             string     `db:"name" json:"name"`
+	Remaining        int        `db:"remaining" json:"remaining"`
+	ReorderThreshold int        `db:"reorder_threshold" json:"reorder_threshold"`
+	ReorderQuantity  int        `db:"reorder_quantity" json:"reorder_quantity"`
+	Date             time.Time  `db:"date_created" json:"date"`
+	DateNotified     *time.Time `db:"date_notified" json:"-"`
+}
+
+// Notifier delivers alerts to whoever needs to act on them.
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+// WriterNotifier writes each alert as a line of JSON. It is meant for local
+// use with a file or stdout.
+type WriterNotifier struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterNotifier constructs a WriterNotifier writing to w.
+func NewWriterNotifier(w io.Writer) *WriterNotifier {
+	return &WriterNotifier{w: w}
+}
+
+// Notify implements the Notifier interface.
+func (n *WriterNotifier) Notify(ctx context.Context, a Alert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return json.NewEncoder(n.w).Encode(a)
+}
+
+// SaleHook raises an Alert as part of any Sale that takes a product to or
+// below its reorder threshold, so the Alert is stored if and only if the
+// Sale is. Sales of a Variant do not count towards the stock of its product.
+func SaleHook() product.SaleHook {
+	return product.SaleHook{After: raiseForSale}
+}
+
+// raiseForSale is the After step of SaleHook.
+func raiseForSale(ctx context.Context, tx *sqlx.Tx, s *product.Sale, ns product.NewSale) error {
+	if s.VariantID != nil {
+		return nil
+	}
+
+	l, err := product.CheckLowStock(ctx, tx, s.ProductID)
+	if err != nil || l == nil {
+		return err
+	}
+
+	return raise(ctx, tx, *l, s.DateCreated)
+}
+
+// raise opens an Alert for l as part of tx unless its product already has one
+// open.
+func raise(ctx context.Context, tx sqlx.ExecerContext, l product.LowStock, now time.Time) error {
+	const q = `
+		INSERT INTO stock_alerts
+		(alert_id, product_id, remaining, reorder_threshold, reorder_quantity, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (product_id) WHERE date_cleared IS NULL DO NOTHING`
+
+	_, err := tx.ExecContext(ctx, q,
+		uuid.New().String(), l.ProductID, l.Remaining, l.ReorderThreshold, l.ReorderQuantity, now.UTC(),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "raising alert for product %q", l.ProductID)
+	}
+
+	return nil
+}
+
+// Checker delivers Alerts. Alerts are stored before they are delivered, so
+// none is lost or sent twice across restarts, and none is sent for a Sale
+// that is rolled back. Each Check also brings the stored Alerts in line with
+// stock changed other than by Sales, such as write-offs, deliveries and
+// changed thresholds.
+type Checker struct {
+	DB       *sqlx.DB
+	Log      *log.Logger
+	Notifier Notifier
+	Interval time.Duration
+}
+
+// Run checks stock every Interval until ctx is cancelled.
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Check(ctx, time.Now()); err != nil {
+				c.Log.Printf("alert: checking stock: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check opens an Alert for every product low on stock that has none, clears
+// the Alerts of products that have been restocked and then notifies every
+// open Alert that has not been notified yet. An Alert is marked notified
+// after it has been delivered, so one whose marking fails is delivered again.
+func (c *Checker) Check(ctx context.Context, now time.Time) error {
+	err := database.WithTx(ctx, c.DB, func(tx *sqlx.Tx) error {
+		low, err := product.ListLowStock(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		ids := make([]string, len(low))
+		for i, l := range low {
+			if err := raise(ctx, tx, l, now); err != nil {
+				return err
+			}
+			ids[i] = l.ProductID
+		}
+
+		const q = `
+			UPDATE stock_alerts SET date_cleared = $1
+			WHERE date_cleared IS NULL AND NOT (product_id::text = ANY($2))`
+
+		if _, err := tx.ExecContext(ctx, q, now.UTC(), pq.Array(ids)); err != nil {
+			return errors.Wrap(err, "clearing alerts")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	pending := []Alert{}
+	const q = `
+		SELECT a.alert_id, a.product_id, p.name, a.remaining, a.reorder_threshold,
+			a.reorder_quantity, a.date_created, a.date_notified
+		FROM stock_alerts as a
+		JOIN products as p ON(p.product_id=a.product_id)
+		WHERE a.date_cleared IS NULL AND a.date_notified IS NULL
+		ORDER BY a.date_created, a.alert_id`
+
+	if err := c.DB.SelectContext(ctx, &pending, q); err != nil {
+		return errors.Wrap(err, "selecting pending alerts")
+	}
+
+	for _, a := range pending {
+		c.Log.Printf("alert: %s (%s) is low on stock: %d remaining, reorder %d",
+			a.Name, a.ProductID, a.Remaining, a.ReorderQuantity)
+
+		if err := c.Notifier.Notify(ctx, a); err != nil {
+			return errors.Wrapf(err, "notifying low stock of product %q", a.ProductID)
+		}
+
+		const u = `UPDATE stock_alerts SET date_notified = $2 WHERE alert_id = $1`
+		if _, err := c.DB.ExecContext(ctx, u, a.ID, now.UTC()); err != nil {
+			return errors.Wrapf(err, "marking alert %q notified", a.ID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package alert_test
+
+import (
+	"context"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/vikramcse/the-service/internal/alert"
+	"github.com/vikramcse/the-service/internal/inventory"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/product"
+	"github.com/vikramcse/the-service/internal/tests"
+)
+
+// recorder is a Notifier that keeps every alert it is given.
+type recorder struct {
+	alerts []alert.Alert
+}
+
+func (r *recorder) Notify(ctx context.Context, a alert.Alert) error {
+	r.alerts = append(r.alerts, a)
+	return nil
+}
+
+func TestChecker(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	np := product.NewProduct{Name: "Comic Book", Cost: 10, Quantity: 10, ReorderThreshold: 4, ReorderQuantity: 20}
+	p, err := product.Create(ctx, db, np, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+
+	var rec recorder
+	c := alert.Checker{
+		DB:       db,
+		Log:      log.New(os.Stderr, "Test: ", log.LstdFlags),
+		Notifier: &rec,
+	}
+
+	sell := func(n int) {
+		t.Helper()
+		ns := product.NewSale{Quantity: n, Paid: &money.Money{Amount: n * 10}}
+		if _, err := product.AddSale(ctx, db, ns, p.ID, now, alert.SaleHook()); err != nil {
+			t.Fatalf("adding sale: %s", err)
+		}
+	}
+	open := func(exp int) {
+		t.Helper()
+		var got int
+		if err := db.Get(&got, `SELECT COUNT(*) FROM stock_alerts WHERE date_cleared IS NULL`); err != nil {
+			t.Fatalf("counting open alerts: %s", err)
+		}
+		if exp != got {
+			t.Fatalf("expected %v open alerts, got %v", exp, got)
+		}
+	}
+	check := func(exp int) {
+		t.Helper()
+		if err := c.Check(ctx, now); err != nil {
+			t.Fatalf("checking stock: %s", err)
+		}
+		if got := len(rec.alerts); exp != got {
+			t.Fatalf("expected %v alerts, got %v", exp, got)
+		}
+	}
+
+	sell(5)
+	check(0)
+
+	// The sale crossing the threshold raises the alert, not the check.
+	sell(1)
+	open(1)
+	check(1)
+	if exp, got := 4, rec.alerts[0].Remaining; exp != got {
+		t.Fatalf("expected %v remaining, got %v", exp, got)
+	}
+
+	sell(1)
+	check(1)
+
+	na := inventory.NewAdjustment{Kind: inventory.Receipt, Quantity: 20, Reason: "delivery"}
+	if _, err := inventory.Adjust(ctx, db, na, p.ID, now); err != nil {
+		t.Fatalf("restocking: %s", err)
+	}
+	check(1)
+
+	open(0)
+
+	sell(20)
+	check(2)
+
+	// Alerts are kept in the database, so a restarted checker does not
+	// send them again.
+	c = alert.Checker{DB: db, Log: c.Log, Notifier: &rec}
+	check(2)
+
+	if _, err := inventory.Adjust(ctx, db, na, p.ID, now); err != nil {
+		t.Fatalf("restocking: %s", err)
+	}
+	check(2)
+	open(0)
+
+	// Raising the threshold makes the product low on stock without a sale.
+	threshold := 30
+	if _, err := product.SetReorder(ctx, db, p.ID, product.UpdateReorder{ReorderThreshold: &threshold}, now); err != nil {
+		t.Fatalf("setting reorder threshold: %s", err)
+	}
+	check(3)
+	if exp, got := 23, rec.alerts[2].Remaining; exp != got {
+		t.Fatalf("expected %v remaining, got %v", exp, got)
+	}
+}
@@ -0,0 +1,256 @@
+// Package audit keeps a tamper-evident log of who changed what and when.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// DefaultLimit is how many entries List gets when not told.
+const DefaultLimit = 100
+
+// lockKey identifies the advisory locks held while an entry is chained to
+// the one before it. Each chain has its own lock under lockKey.
+const lockKey = 7401
+
+// ctxKey is the type of the key a Request is kept under in a context.
+type ctxKey int
+
+const requestKey ctxKey = 1
+
+// Request is the call changes are being made for: who makes it and the ID it
+// is known by in the logs.
+type Request struct {
+	ID    string
+	Actor string
+
+	recorded bool
+}
+
+// Recorded reports if any change made for r has been recorded.
+func (r *Request) Recorded() bool {
+	return r.recorded
+}
+
+// NewContext returns a copy of ctx carrying r, which changes recorded with
+// it are attributed to.
+func NewContext(ctx context.Context, r *Request) context.Context {
+	return context.WithValue(ctx, requestKey, r)
+}
+
+// FromContext gets the Request carried by ctx.
+func FromContext(ctx context.Context) (*Request, bool) {
+	r, ok := ctx.Value(requestKey).(*Request)
+	return r, ok
+}
+
+// Actor names who changes made with ctx are made by: the actor of the
+// Request it carries, or System when it carries none.
+func Actor(ctx context.Context) string {
+	if r, ok := FromContext(ctx); ok && r.Actor != "" {
+		return r.Actor
+	}
+	return System
+}
+
+// Record adds c to the audit log as part of tx, the transaction making the
+// change, so the change and its Entry are stored or lost together. It is
+// attributed to the Request in ctx, or to System when there is none.
+//
+// Every entity has its own chain of Entries. Record takes a lock on the chain
+// of the entity changed that tx holds until it commits or rolls back, so
+// changes to one entity are recorded one after another while changes to
+// different entities do not wait for each other. Callers still record as the
+// last step of their transaction so the lock is held briefly.
+func Record(ctx context.Context, tx *sqlx.Tx, c Change, now time.Time) (*Entry, error) {
+	e := Entry{
+		ID:          uuid.New().String(),
+		Actor:       Actor(ctx),
+		Action:      c.Action,
+		EntityType:  c.EntityType,
+		EntityID:    c.EntityID,
+		Chain:       c.EntityType + "/" + c.EntityID,
+		DateCreated: now.UTC().Truncate(time.Microsecond),
+	}
+	if r, ok := FromContext(ctx); ok {
+		e.RequestID = r.ID
+		r.recorded = true
+	}
+
+	var err error
+	if e.Before, err = document(c.Before); err != nil {
+		return nil, errors.Wrap(err, "marshalling before")
+	}
+	if e.After, err = document(c.After); err != nil {
+		return nil, errors.Wrap(err, "marshalling after")
+	}
+	if e.Changes, err = diff(e.Before, e.After); err != nil {
+		return nil, err
+	}
+
+	const ql = `SELECT pg_advisory_xact_lock($1, hashtext($2))`
+	if _, err := tx.ExecContext(ctx, ql, lockKey, e.Chain); err != nil {
+		return nil, errors.Wrap(err, "locking audit chain")
+	}
+
+	const qh = `SELECT COALESCE((SELECT hash FROM audit_entries WHERE chain = $1 ORDER BY seq DESC LIMIT 1), '')`
+	if err := tx.GetContext(ctx, &e.PrevHash, qh, e.Chain); err != nil {
+		return nil, errors.Wrap(err, "selecting last audit hash")
+	}
+	e.Hash = hash(e)
+
+	const q = `
+		INSERT INTO audit_entries
+		(entry_id, actor, action, entity_type, entity_id, before, after, changes,
+		request_id, chain, prev_hash, hash, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING seq`
+
+	err = tx.GetContext(ctx, &e.Seq, q,
+		e.ID, e.Actor, e.Action, e.EntityType, e.EntityID, e.Before, e.After, e.Changes,
+		e.RequestID, e.Chain, e.PrevHash, e.Hash, e.DateCreated,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting audit entry")
+	}
+
+	return &e, nil
+}
+
+// List gets up to limit Entries about the entity of entityType identified by
+// entityID, latest first. An empty entityID gets Entries about every entity
+// of entityType and an empty entityType gets every Entry.
+func List(ctx context.Context, db *sqlx.DB, entityType, entityID string, limit int) ([]Entry, error) {
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	entries := []Entry{}
+	const q = `
+		SELECT * FROM audit_entries
+		WHERE ($1 = '' OR entity_type = $1)
+		AND ($2 = '' OR entity_id = $2)
+		ORDER BY seq DESC
+		LIMIT $3`
+
+	if err := db.SelectContext(ctx, &entries, q, entityType, entityID, limit); err != nil {
+		return nil, errors.Wrap(err, "selecting audit entries")
+	}
+
+	return entries, nil
+}
+
+// Verify walks the whole audit log in order, working out the hash of every
+// Entry again and checking it is chained to the Entry before it in its chain.
+func Verify(ctx context.Context, db *sqlx.DB) (*Verification, error) {
+	rows, err := db.QueryxContext(ctx, `SELECT * FROM audit_entries ORDER BY seq`)
+	if err != nil {
+		return nil, errors.Wrap(err, "selecting audit entries")
+	}
+	defer rows.Close()
+
+	v := Verification{Valid: true}
+	prev := map[string]string{}
+	for rows.Next() {
+		var e Entry
+		if err := rows.StructScan(&e); err != nil {
+			return nil, errors.Wrap(err, "scanning audit entry")
+		}
+		v.Entries++
+
+		if e.PrevHash != prev[e.Chain] || e.Hash != hash(e) {
+			v.Valid, v.BrokenAt = false, &e.Seq
+			return &v, nil
+		}
+		prev[e.Chain] = e.Hash
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading audit entries")
+	}
+
+	return &v, nil
+}
+
+// hash works out the Hash of e from PrevHash and the fields recorded with it.
+// The fields are hashed as a JSON array so none can be made to run into the
+// next.
+func hash(e Entry) string {
+	fields, _ := json.Marshal([]string{
+		e.PrevHash, e.ID, e.Actor, e.Action, e.EntityType, e.EntityID,
+		string(e.Before), string(e.After), string(e.Changes), e.RequestID,
+		e.DateCreated.UTC().Format(time.RFC3339Nano),
+	})
+	sum := sha256.Sum256(fields)
+	return hex.EncodeToString(sum[:])
+}
+
+// document marshals v as JSON, leaving it empty when v is nil.
+func document(v interface{}) (Document, error) {
+	if v == nil {
+		return "", nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return "", nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return Document(b), nil
+}
+
+// diff lists the fields of the JSON objects before and after that differ,
+// each with its value in both. A missing object has no fields, so every field
+// of an entity created or deleted is listed. Documents that are not objects
+// are compared whole under the field "".
+func diff(before, after Document) (Document, error) {
+	fields := func(d Document) map[string]json.RawMessage {
+		m := map[string]json.RawMessage{}
+		if d == "" {
+			return m
+		}
+		if err := json.Unmarshal([]byte(d), &m); err != nil {
+			return map[string]json.RawMessage{"": json.RawMessage(d)}
+		}
+		return m
+	}
+	b, a := fields(before), fields(after)
+
+	type change struct {
+		From json.RawMessage `json:"from"`
+		To   json.RawMessage `json:"to"`
+	}
+	null := json.RawMessage("null")
+
+	changes := map[string]change{}
+	for k, bv := range b {
+		av, ok := a[k]
+		if !ok {
+			av = null
+		}
+		if !bytes.Equal(bv, av) {
+			changes[k] = change{From: bv, To: av}
+		}
+	}
+	for k, av := range a {
+		if _, ok := b[k]; !ok {
+			changes[k] = change{From: null, To: av}
+		}
+	}
+	if len(changes) == 0 {
+		return "", nil
+	}
+
+	return document(changes)
+}
@@ -0,0 +1,108 @@
+package audit_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/customer"
+	"github.com/vikramcse/the-service/internal/tests"
+)
+
+func TestAudit(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := audit.NewContext(context.Background(), &audit.Request{ID: "req-1", Actor: "jo"})
+
+	c, err := customer.Create(ctx, db, customer.NewCustomer{Name: "Jo Bloggs"}, now)
+	if err != nil {
+		t.Fatalf("creating customer: %s", err)
+	}
+	other, err := customer.Create(ctx, db, customer.NewCustomer{Name: "Sam Smith"}, now)
+	if err != nil {
+		t.Fatalf("creating customer: %s", err)
+	}
+	name := "Joanne Bloggs"
+	if _, err := customer.Update(ctx, db, c.ID, customer.UpdateCustomer{Name: &name}, now.Add(time.Hour)); err != nil {
+		t.Fatalf("updating customer: %s", err)
+	}
+	if err := customer.Delete(ctx, db, c.ID, now.Add(2*time.Hour)); err != nil {
+		t.Fatalf("deleting customer: %s", err)
+	}
+
+	entries, err := audit.List(ctx, db, "customer", c.ID, 0)
+	if err != nil {
+		t.Fatalf("listing entries: %s", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+
+	del, update, create := entries[0], entries[1], entries[2]
+	if del.Action != audit.Delete || update.Action != audit.Update || create.Action != audit.Create {
+		t.Fatalf("expected a delete, update and create, got %q, %q and %q", del.Action, update.Action, create.Action)
+	}
+	if create.Before != "" || del.After != "" {
+		t.Fatal("expected no document for a customer that did not exist")
+	}
+	if update.Actor != "jo" || update.RequestID != "req-1" {
+		t.Fatalf("expected the request to be recorded, got %q and %q", update.Actor, update.RequestID)
+	}
+	if del.PrevHash != update.Hash || update.PrevHash != create.Hash || create.PrevHash != "" {
+		t.Fatal("expected entries to be chained")
+	}
+
+	// Each customer has a chain of its own.
+	others, err := audit.List(ctx, db, "customer", other.ID, 0)
+	if err != nil {
+		t.Fatalf("listing entries: %s", err)
+	}
+	if len(others) != 1 || others[0].PrevHash != "" || others[0].Chain == create.Chain {
+		t.Fatalf("expected a separate chain for the other customer, got %+v", others)
+	}
+
+	var changes map[string]struct {
+		From, To interface{}
+	}
+	if err := json.Unmarshal([]byte(update.Changes), &changes); err != nil {
+		t.Fatalf("decoding changes: %s", err)
+	}
+	if diff := cmp.Diff("Joanne Bloggs", changes["name"].To); diff != "" || changes["name"].From != "Jo Bloggs" {
+		t.Fatalf("unexpected name change %+v", changes["name"])
+	}
+	if _, ok := changes["email"]; ok {
+		t.Fatal("expected unchanged fields to be left out")
+	}
+
+	v, err := audit.Verify(ctx, db)
+	if err != nil {
+		t.Fatalf("verifying: %s", err)
+	}
+	if !v.Valid || v.Entries != 4 {
+		t.Fatalf("expected 4 valid entries, got %+v", v)
+	}
+
+	// Entries can not be changed, and one changed behind the log's back
+	// breaks the chain.
+	if _, err := db.ExecContext(ctx, `UPDATE audit_entries SET actor = 'mallory'`); err == nil {
+		t.Fatal("expected audit entries to be append-only")
+	}
+	if _, err := db.ExecContext(ctx, `ALTER TABLE audit_entries DISABLE TRIGGER audit_entries_append_only`); err != nil {
+		t.Fatalf("disabling trigger: %s", err)
+	}
+	if _, err := db.ExecContext(ctx, `UPDATE audit_entries SET actor = 'mallory' WHERE entry_id = $1`, create.ID); err != nil {
+		t.Fatalf("tampering: %s", err)
+	}
+
+	if v, err = audit.Verify(ctx, db); err != nil {
+		t.Fatalf("verifying: %s", err)
+	}
+	if v.Valid || v.BrokenAt == nil || *v.BrokenAt != create.Seq {
+		t.Fatalf("expected the chain to break at %d, got %+v", create.Seq, v)
+	}
+}
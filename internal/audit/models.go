@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"time"
+)
+
+// Actions recorded by the domain packages. Calls no domain package audits
+// are recorded with their method and route as the action instead, like
+// "POST /v1/sales/{id}/refunds".
+const (
+	Create = "create"
+	Update = "update"
+	Delete = "delete"
+)
+
+// Actors of changes not made by a request.
+const (
+	// System is the actor of changes made by the service itself.
+	System = "system"
+
+	// Anonymous is the actor of requests that do not say who makes them.
+	Anonymous = "anonymous"
+)
+
+// Entry is one change recorded in the audit log. Before and After are the
+// entity as JSON before and after the change, and Changes holds the fields
+// that differ between them, each as an object with its old value in "from"
+// and its new one in "to". Hash covers every other field of the Entry and the
+// Hash of the Entry before it in its Chain, PrevHash, so an Entry can not be
+// changed, removed or slipped in without breaking the chain. Chain is the
+// entity the Entry is about, as its type and ID; entries recorded before
+// each entity had its own chain are all in the chain named "".
+type Entry struct {
+	ID          string    `db:"entry_id" json:"id"`
+	Seq         int64     `db:"seq" json:"seq"`
+	Actor       string    `db:"actor" json:"actor"`
+	Action      string    `db:"action" json:"action"`
+	EntityType  string    `db:"entity_type" json:"entity_type"`
+	EntityID    string    `db:"entity_id" json:"entity_id,omitempty"`
+	Before      Document  `db:"before" json:"before"`
+	After       Document  `db:"after" json:"after"`
+	Changes     Document  `db:"changes" json:"changes"`
+	RequestID   string    `db:"request_id" json:"request_id,omitempty"`
+	Chain       string    `db:"chain" json:"chain"`
+	PrevHash    string    `db:"prev_hash" json:"prev_hash"`
+	Hash        string    `db:"hash" json:"hash"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
+
+// Document is a JSON document stored as the exact text it was recorded as,
+// so its hash can be checked. An empty Document is null.
+type Document string
+
+// MarshalJSON sends d as the JSON it holds.
+func (d Document) MarshalJSON() ([]byte, error) {
+	if d == "" {
+		return []byte("null"), nil
+	}
+	return []byte(d), nil
+}
+
+// Change is what a domain package records about a change it makes to one of
+// its entities. Before and After are marshalled as JSON and either is nil
+// when the entity did not exist.
+type Change struct {
+	EntityType string
+	EntityID   string
+	Action     string
+	Before     interface{}
+	After      interface{}
+}
+
+// Verification is the result of checking the hash chain of the audit log.
+// When it is broken, BrokenAt is the Seq of the first Entry that does not
+// match what came before it.
+type Verification struct {
+	Entries  int    `json:"entries"`
+	Valid    bool   `json:"valid"`
+	BrokenAt *int64 `json:"broken_at,omitempty"`
+}
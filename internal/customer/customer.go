@@ -0,0 +1,256 @@
+// Package customer keeps the records of who we sell to.
+package customer
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/platform/database"
+)
+
+var (
+	ErrNotFound       = errors.New("Customer not found")
+	ErrInvalidID      = errors.New("ID is not in it's proper form")
+	ErrNoName         = errors.New("Customer must have a name")
+	ErrInvalidEmail   = errors.New("Email is not a valid address")
+	ErrInvalidPhone   = errors.New("Phone must have between 7 and 15 digits")
+	ErrDuplicateEmail = errors.New("Another customer has that email")
+	ErrDuplicatePhone = errors.New("Another customer has that phone")
+)
+
+// auditEntity is the entity type changes to Customers are audited as.
+const auditEntity = "customer"
+
+// List gets all Customers.
+func List(ctx context.Context, db *sqlx.DB) ([]Customer, error) {
+	customers := []Customer{}
+
+	const q = `SELECT * FROM customers ORDER BY name`
+	if err := db.SelectContext(ctx, &customers, q); err != nil {
+		return nil, errors.Wrap(err, "selecting customers")
+	}
+
+	return customers, nil
+}
+
+// Retrive finds the Customer identified by id.
+func Retrive(ctx context.Context, db *sqlx.DB, id string) (*Customer, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var c Customer
+	const q = `SELECT * FROM customers WHERE customer_id = $1`
+	if err := db.GetContext(ctx, &c, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting customer %q", id)
+	}
+
+	return &c, nil
+}
+
+// Create adds a Customer to the database.
+func Create(ctx context.Context, db *sqlx.DB, nc NewCustomer, now time.Time) (*Customer, error) {
+	c := Customer{
+		ID:          uuid.New().String(),
+		Name:        strings.TrimSpace(nc.Name),
+		Notes:       nc.Notes,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+	if c.Name == "" {
+		return nil, ErrNoName
+	}
+
+	var err error
+	if c.Email, err = NormalizeEmail(nc.Email); err != nil {
+		return nil, err
+	}
+	if c.Phone, err = NormalizePhone(nc.Phone); err != nil {
+		return nil, err
+	}
+
+	err = database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		const q = `
+			INSERT INTO customers
+			(customer_id, name, email, phone, notes, date_created, date_updated)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+		_, err := tx.ExecContext(ctx, q, c.ID, c.Name, c.Email, c.Phone, c.Notes, c.DateCreated, c.DateUpdated)
+		if err != nil {
+			return duplicate(err, "inserting customer")
+		}
+
+		ac := audit.Change{EntityType: auditEntity, EntityID: c.ID, Action: audit.Create, After: c}
+		_, err = audit.Record(ctx, tx, ac, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// Update changes the Customer identified by id.
+func Update(ctx context.Context, db *sqlx.DB, id string, uc UpdateCustomer, now time.Time) (*Customer, error) {
+	c, err := Retrive(ctx, db, id)
+	if err != nil {
+		return nil, err
+	}
+	before := *c
+
+	if uc.Name != nil {
+		if c.Name = strings.TrimSpace(*uc.Name); c.Name == "" {
+			return nil, ErrNoName
+		}
+	}
+	if uc.Email != nil {
+		if c.Email, err = NormalizeEmail(*uc.Email); err != nil {
+			return nil, err
+		}
+	}
+	if uc.Phone != nil {
+		if c.Phone, err = NormalizePhone(*uc.Phone); err != nil {
+			return nil, err
+		}
+	}
+	if uc.Notes != nil {
+		c.Notes = *uc.Notes
+	}
+	c.DateUpdated = now.UTC()
+
+	err = database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		const q = `
+			UPDATE customers SET
+			name = $2, email = $3, phone = $4, notes = $5, date_updated = $6
+			WHERE customer_id = $1`
+
+		_, err := tx.ExecContext(ctx, q, c.ID, c.Name, c.Email, c.Phone, c.Notes, c.DateUpdated)
+		if err != nil {
+			return duplicate(err, "updating customer")
+		}
+
+		ac := audit.Change{EntityType: auditEntity, EntityID: c.ID, Action: audit.Update, Before: before, After: c}
+		_, err = audit.Record(ctx, tx, ac, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Delete removes the Customer identified by id. Their Sales are kept but are
+// no longer attributed to anyone.
+func Delete(ctx context.Context, db *sqlx.DB, id string, now time.Time) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	return database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		var c Customer
+		const q = `DELETE FROM customers WHERE customer_id = $1 RETURNING *`
+		if err := tx.GetContext(ctx, &c, q, id); err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			return errors.Wrapf(err, "deleting customer %s", id)
+		}
+
+		ac := audit.Change{EntityType: auditEntity, EntityID: c.ID, Action: audit.Delete, Before: c}
+		_, err := audit.Record(ctx, tx, ac, now)
+		return err
+	})
+}
+
+// CheckCustomer makes sure id identifies an existing Customer.
+func CheckCustomer(ctx context.Context, db sqlx.QueryerContext, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	var exists bool
+	const q = `SELECT EXISTS(SELECT 1 FROM customers WHERE customer_id = $1)`
+	if err := sqlx.GetContext(ctx, db, &exists, q, id); err != nil {
+		return errors.Wrap(err, "checking customer")
+	}
+	if !exists {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// NormalizeEmail trims and lower cases an email address. An empty address is
+// allowed and stays empty.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return "", nil
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 1 || at == len(email)-1 || strings.ContainsAny(email, " \t\r\n") {
+		return "", ErrInvalidEmail
+	}
+	if domain := email[at+1:]; !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "", ErrInvalidEmail
+	}
+
+	return email, nil
+}
+
+// NormalizePhone strips the spaces, dashes, dots and brackets people write
+// phone numbers with, keeping a leading + for international numbers. An
+// empty number is allowed and stays empty.
+func NormalizePhone(phone string) (string, error) {
+	phone = strings.TrimSpace(phone)
+	if phone == "" {
+		return "", nil
+	}
+
+	var b strings.Builder
+	for i, r := range phone {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", ErrInvalidPhone
+		}
+	}
+
+	n := strings.TrimPrefix(b.String(), "+")
+	if len(n) < 7 || len(n) > 15 {
+		return "", ErrInvalidPhone
+	}
+
+	return b.String(), nil
+}
+
+// duplicate turns a unique violation of the email or phone of a Customer into
+// the matching error and wraps anything else with msg.
+func duplicate(err error, msg string) error {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		switch pqErr.Constraint {
+		case "customers_email_key":
+			return ErrDuplicateEmail
+		case "customers_phone_key":
+			return ErrDuplicatePhone
+		}
+	}
+	return errors.Wrap(err, msg)
+}
@@ -0,0 +1,123 @@
+package customer_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vikramcse/the-service/internal/customer"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/product"
+	"github.com/vikramcse/the-service/internal/tests"
+)
+
+func TestNormalize(t *testing.T) {
+	phones := []struct {
+		in  string
+		exp string
+		err error
+	}{
+		{"+44 (20) 7946-0958", "+442079460958", nil},
+		{"555.123.4567", "5551234567", nil},
+		{"", "", nil},
+		{"123", "", customer.ErrInvalidPhone},
+		{"555-CALL-NOW", "", customer.ErrInvalidPhone},
+		{"555+1234567", "", customer.ErrInvalidPhone},
+	}
+	for _, tt := range phones {
+		got, err := customer.NormalizePhone(tt.in)
+		if got != tt.exp || err != tt.err {
+			t.Errorf("normalizing phone %q: expected %q %v, got %q %v", tt.in, tt.exp, tt.err, got, err)
+		}
+	}
+
+	emails := []struct {
+		in  string
+		exp string
+		err error
+	}{
+		{"  Jo.Bloggs@Example.COM ", "jo.bloggs@example.com", nil},
+		{"", "", nil},
+		{"jo.bloggs", "", customer.ErrInvalidEmail},
+		{"@example.com", "", customer.ErrInvalidEmail},
+		{"jo@localhost", "", customer.ErrInvalidEmail},
+	}
+	for _, tt := range emails {
+		got, err := customer.NormalizeEmail(tt.in)
+		if got != tt.exp || err != tt.err {
+			t.Errorf("normalizing email %q: expected %q %v, got %q %v", tt.in, tt.exp, tt.err, got, err)
+		}
+	}
+}
+
+func TestCustomers(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	c, err := customer.Create(ctx, db, customer.NewCustomer{Name: "Jo Bloggs", Email: "Jo@Example.com", Phone: "555 123 4567"}, now)
+	if err != nil {
+		t.Fatalf("creating customer: %s", err)
+	}
+	if c.Email != "jo@example.com" || c.Phone != "5551234567" {
+		t.Fatalf("expected normalized email and phone, got %q and %q", c.Email, c.Phone)
+	}
+
+	_, err = customer.Create(ctx, db, customer.NewCustomer{Name: "Joanne Bloggs", Email: "JO@example.com"}, now)
+	if err != customer.ErrDuplicateEmail {
+		t.Fatalf("expected %v, got %v", customer.ErrDuplicateEmail, err)
+	}
+
+	other, err := customer.Create(ctx, db, customer.NewCustomer{Name: "Sam Smith"}, now)
+	if err != nil {
+		t.Fatalf("creating customer: %s", err)
+	}
+	phone := "(555) 123-4567"
+	if _, err := customer.Update(ctx, db, other.ID, customer.UpdateCustomer{Phone: &phone}, now); err != customer.ErrDuplicatePhone {
+		t.Fatalf("expected %v, got %v", customer.ErrDuplicatePhone, err)
+	}
+
+	p, err := product.Create(ctx, db, product.NewProduct{Name: "Comic Book", Cost: 10, Quantity: 10}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	for _, paid := range []int{20, 15} {
+		ns := product.NewSale{CustomerID: c.ID, Quantity: 2, Paid: &money.Money{Amount: paid}}
+		if _, err := product.AddSale(ctx, db, ns, p.ID, now); err != nil {
+			t.Fatalf("adding sale: %s", err)
+		}
+	}
+	if _, err := product.AddSale(ctx, db, product.NewSale{Quantity: 1, Paid: &money.Money{Amount: 10}}, p.ID, now); err != nil {
+		t.Fatalf("adding anonymous sale: %s", err)
+	}
+
+	ns := product.NewSale{CustomerID: "5cf37266-3473-4006-984f-9325122678b7", Quantity: 1, Paid: &money.Money{Amount: 10}}
+	if _, err := product.AddSale(ctx, db, ns, p.ID, now); err != customer.ErrNotFound {
+		t.Fatalf("expected %v, got %v", customer.ErrNotFound, err)
+	}
+
+	h, err := product.CustomerHistory(ctx, db, c.ID)
+	if err != nil {
+		t.Fatalf("getting history: %s", err)
+	}
+	exp := []money.Money{{Amount: 35, Currency: "USD"}}
+	if h.Orders != 2 || h.Units != 4 || len(h.LifetimeValue) != 1 || h.LifetimeValue[0] != exp[0] {
+		t.Fatalf("expected 2 orders of 4 units worth %v, got %+v", exp, h)
+	}
+
+	if err := customer.Delete(ctx, db, c.ID, now); err != nil {
+		t.Fatalf("deleting customer: %s", err)
+	}
+	if _, err := customer.Retrive(ctx, db, c.ID); err != customer.ErrNotFound {
+		t.Fatalf("expected %v, got %v", customer.ErrNotFound, err)
+	}
+	sales, err := product.ListSales(ctx, db, p.ID)
+	if err != nil {
+		t.Fatalf("listing sales: %s", err)
+	}
+	if len(sales) != 3 {
+		t.Fatalf("expected sales to be kept, got %d", len(sales))
+	}
+}
@@ -0,0 +1,35 @@
+package customer
+
+import (
+	"time"
+)
+
+// Customer is someone we sell to. Email and Phone are stored normalized and
+// no two Customers share either.
+type Customer struct {
+	ID          string    `db:"customer_id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	Email       string    `db:"email" json:"email"`
+	Phone       string    `db:"phone" json:"phone"`
+	Notes       string    `db:"notes" json:"notes"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// NewCustomer is what we require from clients when adding a Customer. Email
+// and Phone are optional.
+type NewCustomer struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+	Notes string `json:"notes"`
+}
+
+// UpdateCustomer holds the changes to make to a Customer. Fields left nil are
+// not changed.
+type UpdateCustomer struct {
+	Name  *string `json:"name"`
+	Email *string `json:"email"`
+	Phone *string `json:"phone"`
+	Notes *string `json:"notes"`
+}
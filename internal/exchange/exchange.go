@@ -0,0 +1,238 @@
+// Package exchange keeps the exchange rates used to convert money between
+// currencies.
+package exchange
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/database"
+)
+
+var (
+	ErrNoRate      = errors.New("No exchange rate between the currencies")
+	ErrInvalidRate = errors.New("Exchange rate must be a positive decimal")
+	ErrSamePair    = errors.New("Exchange rate must be between different currencies")
+	ErrMalformed   = errors.New("Rates must be CSV lines of base, quote, rate and effective_from")
+)
+
+// Scale is the number of decimal places rates are kept to.
+const Scale = 8
+
+// auditEntity is the entity type changes to Rates are audited as, identified
+// by their pair of currencies like "USD/EUR".
+const auditEntity = "exchange_rate"
+
+// Set records a Rate.
+func Set(ctx context.Context, db *sqlx.DB, nr NewRate, now time.Time) (*Rate, error) {
+	var r *Rate
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		var c audit.Change
+		var err error
+		if r, c, err = set(ctx, tx, nr, now); err != nil {
+			return err
+		}
+		_, err = audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Import records a Rate for each line of a CSV document with the columns
+// base, quote, rate and an optional RFC 3339 effective_from. A first line
+// starting with "base" is taken as a header. Either every Rate is recorded or
+// none are. It returns the number of Rates recorded. Errors name the line at
+// fault and have the reason as their cause.
+func Import(ctx context.Context, db *sqlx.DB, r io.Reader, now time.Time) (int, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	records, err := cr.ReadAll()
+	if err != nil {
+		return 0, errors.Wrap(ErrMalformed, err.Error())
+	}
+	if len(records) > 0 && len(records[0]) > 0 && strings.EqualFold(records[0][0], "base") {
+		records = records[1:]
+	}
+
+	err = database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		changes := make([]audit.Change, 0, len(records))
+		for i, rec := range records {
+			if len(rec) < 3 || len(rec) > 4 {
+				return errors.Wrapf(ErrMalformed, "line %d", i+1)
+			}
+
+			nr := NewRate{Base: rec[0], Quote: rec[1], Rate: rec[2]}
+			if len(rec) == 4 && rec[3] != "" {
+				t, err := time.Parse(time.RFC3339, rec[3])
+				if err != nil {
+					return errors.Wrapf(ErrMalformed, "line %d: effective_from %q", i+1, rec[3])
+				}
+				nr.EffectiveFrom = &t
+			}
+
+			_, c, err := set(ctx, tx, nr, now)
+			if err != nil {
+				return errors.Wrapf(err, "line %d", i+1)
+			}
+			changes = append(changes, c)
+		}
+
+		// Every Rate is audited once all are recorded, so the audit log is
+		// only held for the end of the import.
+		for _, c := range changes {
+			if _, err := audit.Record(ctx, tx, c, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(records), nil
+}
+
+// List gets every Rate, latest first for each pair of currencies.
+func List(ctx context.Context, db *sqlx.DB) ([]Rate, error) {
+	rates := []Rate{}
+
+	const q = `
+		SELECT * FROM exchange_rates
+		ORDER BY base, quote, effective_from DESC`
+
+	if err := db.SelectContext(ctx, &rates, q); err != nil {
+		return nil, errors.Wrap(err, "selecting exchange rates")
+	}
+
+	return rates, nil
+}
+
+// RateAt finds how many major units of to one major unit of from is worth at
+// time t. A Rate recorded the other way round is inverted when there is no
+// Rate for the pair as asked.
+func RateAt(ctx context.Context, db sqlx.QueryerContext, from, to string, t time.Time) (*big.Rat, error) {
+	if from == to {
+		return big.NewRat(1, 1), nil
+	}
+
+	var r struct {
+		Rate    string `db:"rate"`
+		Inverse bool   `db:"inverse"`
+	}
+	const q = `
+		SELECT rate, base <> $1 as inverse FROM exchange_rates
+		WHERE ((base = $1 AND quote = $2) OR (base = $2 AND quote = $1))
+		AND effective_from <= $3
+		ORDER BY base <> $1, effective_from DESC
+		LIMIT 1`
+
+	if err := sqlx.GetContext(ctx, db, &r, q, from, to, t.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNoRate
+		}
+		return nil, errors.Wrapf(err, "selecting rate from %s to %s", from, to)
+	}
+
+	rate, ok := new(big.Rat).SetString(r.Rate)
+	if !ok {
+		return nil, errors.Errorf("stored rate %q is not a number", r.Rate)
+	}
+	if r.Inverse {
+		rate.Inv(rate)
+	}
+
+	return rate, nil
+}
+
+// Convert changes m into currency to at the Rate in effect at time t. The
+// Rate used is returned with the result.
+func Convert(ctx context.Context, db sqlx.QueryerContext, m money.Money, to string, t time.Time) (money.Money, *big.Rat, error) {
+	rate, err := RateAt(ctx, db, m.Currency, to, t)
+	if err != nil {
+		return money.Money{}, nil, err
+	}
+
+	c, err := money.Convert(m, rate, to)
+	if err != nil {
+		return money.Money{}, nil, err
+	}
+
+	return c, rate, nil
+}
+
+// set records a Rate as part of tx. It returns the Change to audit for it,
+// which corrects any Rate the pair had from the same time.
+func set(ctx context.Context, tx *sqlx.Tx, nr NewRate, now time.Time) (*Rate, audit.Change, error) {
+	base, err := money.Currency(nr.Base)
+	if err != nil {
+		return nil, audit.Change{}, err
+	}
+	quote, err := money.Currency(nr.Quote)
+	if err != nil {
+		return nil, audit.Change{}, err
+	}
+	if base == quote {
+		return nil, audit.Change{}, ErrSamePair
+	}
+
+	rate, ok := new(big.Rat).SetString(strings.TrimSpace(nr.Rate))
+	if !ok || rate.Sign() <= 0 {
+		return nil, audit.Change{}, ErrInvalidRate
+	}
+
+	r := Rate{
+		Base:          base,
+		Quote:         quote,
+		Rate:          rate.FloatString(Scale),
+		EffectiveFrom: now.UTC(),
+		DateCreated:   now.UTC(),
+	}
+	if nr.EffectiveFrom != nil {
+		r.EffectiveFrom = nr.EffectiveFrom.UTC()
+	}
+
+	c := audit.Change{EntityType: auditEntity, EntityID: r.Base + "/" + r.Quote, Action: audit.Create, After: r}
+
+	var before Rate
+	const qb = `
+		SELECT * FROM exchange_rates
+		WHERE base = $1 AND quote = $2 AND effective_from = $3
+		FOR UPDATE`
+	switch err := tx.GetContext(ctx, &before, qb, r.Base, r.Quote, r.EffectiveFrom); err {
+	case nil:
+		c.Action, c.Before = audit.Update, before
+	case sql.ErrNoRows:
+	default:
+		return nil, audit.Change{}, errors.Wrap(err, "selecting exchange rate")
+	}
+
+	// Recording a rate for a pair and time that already has one corrects it.
+	const q = `
+		INSERT INTO exchange_rates
+		(base, quote, rate, effective_from, date_created)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (base, quote, effective_from)
+		DO UPDATE SET rate = EXCLUDED.rate, date_created = EXCLUDED.date_created`
+
+	if _, err := tx.ExecContext(ctx, q, r.Base, r.Quote, r.Rate, r.EffectiveFrom, r.DateCreated); err != nil {
+		return nil, audit.Change{}, errors.Wrap(err, "inserting exchange rate")
+	}
+
+	return &r, c, nil
+}
@@ -0,0 +1,103 @@
+package exchange_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/exchange"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/product"
+	"github.com/vikramcse/the-service/internal/report"
+	"github.com/vikramcse/the-service/internal/tests"
+)
+
+func TestExchange(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	csv := "base,quote,rate,effective_from\n" +
+		"EUR,USD,1.1\n" +
+		"GBP,USD,1.25,2019-02-01T00:00:00Z\n"
+
+	n, err := exchange.Import(ctx, db, strings.NewReader(csv), now)
+	if err != nil {
+		t.Fatalf("importing rates: %s", err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 rates imported, got %d", n)
+	}
+
+	_, err = exchange.Import(ctx, db, strings.NewReader("EUR,EUR,1\n"), now)
+	if errors.Cause(err) != exchange.ErrSamePair {
+		t.Fatalf("expected %v, got %v", exchange.ErrSamePair, err)
+	}
+
+	rate, err := exchange.RateAt(ctx, db, "USD", "EUR", now)
+	if err != nil {
+		t.Fatalf("getting inverse rate: %s", err)
+	}
+	if exp, got := "0.90909091", rate.FloatString(exchange.Scale); exp != got {
+		t.Fatalf("expected rate %s, got %s", exp, got)
+	}
+	if _, err := exchange.RateAt(ctx, db, "GBP", "USD", now); err != exchange.ErrNoRate {
+		t.Fatalf("expected %v before the rate takes effect, got %v", exchange.ErrNoRate, err)
+	}
+
+	p, err := product.Create(ctx, db, product.NewProduct{Name: "Comic Book", Cost: 1000, Quantity: 10}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	if _, err := product.SetCurrencyPrice(ctx, db, product.NewCurrencyPrice{Currency: "eur", Cost: 900}, p.ID, now); err != nil {
+		t.Fatalf("setting currency price: %s", err)
+	}
+
+	ns := product.NewSale{Quantity: 2, Paid: &money.Money{Amount: 1800, Currency: "EUR"}}
+	s, err := product.AddSale(ctx, db, ns, p.ID, now)
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	if exp := (money.Money{Amount: 900, Currency: "EUR"}); s.ListPrice != exp {
+		t.Fatalf("expected list price %v, got %v", exp, s.ListPrice)
+	}
+	if exp := (money.Money{Amount: 1980, Currency: "USD"}); s.BasePaid != exp {
+		t.Fatalf("expected base paid %v, got %v", exp, s.BasePaid)
+	}
+	if exp := "1.10000000"; s.ExchangeRate != exp {
+		t.Fatalf("expected exchange rate %s, got %s", exp, s.ExchangeRate)
+	}
+
+	products, err := product.List(ctx, db, "EUR", now)
+	if err != nil {
+		t.Fatalf("listing products: %s", err)
+	}
+	if exp := (money.Money{Amount: 1800, Currency: "EUR"}); len(products) != 1 || products[0].Revenue != exp {
+		t.Fatalf("expected revenue %v, got %+v", exp, products)
+	}
+
+	rep, err := report.Sales(ctx, db, report.SalesQuery{Bucket: report.Day, Top: 1, Currency: "USD", RatesAt: now})
+	if err != nil {
+		t.Fatalf("building sales report: %s", err)
+	}
+	if len(rep.Buckets) != 1 || rep.Buckets[0].Revenue != 1980 || rep.Top[0].Revenue != 1980 {
+		t.Fatalf("expected revenue of 1980 USD, got %+v", rep)
+	}
+
+	// Euros and dollars can not be added up as they are.
+	ns = product.NewSale{Quantity: 1, Paid: &money.Money{Amount: 1000}}
+	if _, err := product.AddSale(ctx, db, ns, p.ID, now); err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	if _, err := report.Sales(ctx, db, report.SalesQuery{Bucket: report.Day}); err != report.ErrMixedCurrency {
+		t.Fatalf("expected %v, got %v", report.ErrMixedCurrency, err)
+	}
+
+	if _, err := product.SetCurrencyPrice(ctx, db, product.NewCurrencyPrice{Currency: "eur", Cost: -1}, p.ID, now); err != product.ErrInvalidCost {
+		t.Fatalf("expected %v, got %v", product.ErrInvalidCost, err)
+	}
+}
@@ -0,0 +1,25 @@
+package exchange
+
+import (
+	"time"
+)
+
+// Rate says how many major units of Quote one major unit of Base is worth
+// from EffectiveFrom until a later Rate for the same pair takes over. Rate is
+// a decimal string so it survives JSON without rounding.
+type Rate struct {
+	Base          string    `db:"base" json:"base"`
+	Quote         string    `db:"quote" json:"quote"`
+	Rate          string    `db:"rate" json:"rate"`
+	EffectiveFrom time.Time `db:"effective_from" json:"effective_from"`
+	DateCreated   time.Time `db:"date_created" json:"date_created"`
+}
+
+// NewRate is what we require from clients when recording a Rate. A nil
+// EffectiveFrom means the Rate takes effect immediately.
+type NewRate struct {
+	Base          string     `json:"base"`
+	Quote         string     `json:"quote"`
+	Rate          string     `json:"rate"`
+	EffectiveFrom *time.Time `json:"effective_from"`
+}
@@ -0,0 +1,450 @@
+// Package giftcard sells gift cards, issues store credit and takes payment
+// from both at the till.
+package giftcard
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/accounting"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/customer"
+	"github.com/vikramcse/the-service/internal/loyalty"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/database"
+	"github.com/vikramcse/the-service/internal/product"
+)
+
+var (
+	ErrNotFound            = errors.New("Gift card not found")
+	ErrInvalidCode         = errors.New("Gift card code is not in it's proper form")
+	ErrInvalidID           = errors.New("ID is not in it's proper form")
+	ErrInvalidAmount       = errors.New("Amount must be positive")
+	ErrInsufficientBalance = errors.New("Gift card balance is too low")
+	ErrOverpaid            = errors.New("Gift card amount is more than is owed")
+	ErrInvalidQuantity     = errors.New("Quantity returned can not be negative")
+)
+
+// auditEntity is the entity type changes to Cards are audited as.
+const auditEntity = "gift_card"
+
+// alphabet is what codes are made of. Letters and digits which are easily
+// mistaken for each other are left out.
+const alphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// codeLength is the number of characters in a code, not counting dashes.
+const codeLength = 16
+
+// NewCode generates a random code, formatted in groups of four like
+// "ABCD-EFGH-JKLM-NPQR".
+func NewCode() (string, error) {
+	b := make([]byte, codeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating code")
+	}
+	for i := range b {
+		// The alphabet divides 256 so every character is equally likely.
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return format(string(b)), nil
+}
+
+// NormalizeCode gives the stored form of a code however it was typed in:
+// upper case, in groups of four.
+func NormalizeCode(code string) (string, error) {
+	code = strings.ToUpper(code)
+	code = strings.NewReplacer(" ", "", "-", "").Replace(code)
+	if len(code) != codeLength {
+		return "", ErrInvalidCode
+	}
+	for _, r := range code {
+		if !strings.ContainsRune(alphabet, r) {
+			return "", ErrInvalidCode
+		}
+	}
+	return format(code), nil
+}
+
+// format splits code into dash separated groups of four.
+func format(code string) string {
+	var b strings.Builder
+	for i, r := range code {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte('-')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Issue sells a new gift card loaded with the amount of nc.
+func Issue(ctx context.Context, db *sqlx.DB, nc NewCard, now time.Time) (*Card, error) {
+	if nc.Amount <= 0 {
+		return nil, ErrInvalidAmount
+	}
+	m, err := money.New(nc.Amount, nc.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	var c *Card
+	err = database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		if nc.CustomerID != "" {
+			if err := customer.CheckCustomer(ctx, tx, nc.CustomerID); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		if c, err = issue(ctx, tx, GiftCard, m, nc.CustomerID, nil, Issued, now); err != nil {
+			return err
+		}
+		return accounting.RecordGiftCard(ctx, tx, c.ID, m, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Retrive finds the Card with code, which is how its balance is checked.
+func Retrive(ctx context.Context, db *sqlx.DB, code string) (*Card, error) {
+	code, err := NormalizeCode(code)
+	if err != nil {
+		return nil, err
+	}
+
+	return retrive(ctx, db, code, false)
+}
+
+// Transactions gets the ledger of the Card with code, oldest first.
+func Transactions(ctx context.Context, db *sqlx.DB, code string) ([]Entry, error) {
+	c, err := Retrive(ctx, db, code)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []Entry{}
+	const q = `
+		SELECT * FROM gift_card_entries
+		WHERE card_id = $1
+		ORDER BY date_created`
+
+	if err := db.SelectContext(ctx, &entries, q, c.ID); err != nil {
+		return nil, errors.Wrap(err, "selecting gift card entries")
+	}
+
+	return entries, nil
+}
+
+// RefundSale gives back part or all of what was paid for a Sale as store
+// credit. A Sale can be refunded more than once but never for more than was
+// paid for it. The Card credited is returned.
+func RefundSale(ctx context.Context, db *sqlx.DB, nr NewRefund, now time.Time) (*Card, error) {
+	var c *Card
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		var err error
+		c, err = Credit(ctx, tx, nr, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Credit is RefundSale as part of tx, for callers refunding a Sale along
+// with other changes. The Sale and the Card credited stay locked until tx
+// ends.
+func Credit(ctx context.Context, tx *sqlx.Tx, nr NewRefund, now time.Time) (*Card, error) {
+	if _, err := uuid.Parse(nr.SaleID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if nr.Amount <= 0 {
+		return nil, ErrInvalidAmount
+	}
+	if nr.Quantity < 0 {
+		return nil, ErrInvalidQuantity
+	}
+
+	s, err := product.RecordRefund(ctx, tx, nr.SaleID, nr.Amount, nr.Quantity, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := loyalty.RecordRefund(ctx, tx, s, now); err != nil {
+		return nil, err
+	}
+	credit := money.Money{Amount: nr.Amount, Currency: s.Paid.Currency}
+
+	var c *Card
+	if nr.Code == "" {
+		var customerID string
+		if s.CustomerID != nil {
+			customerID = *s.CustomerID
+		}
+
+		if c, err = issue(ctx, tx, StoreCredit, credit, customerID, &nr.SaleID, Refunded, now); err != nil {
+			return nil, err
+		}
+	} else {
+		code, err := NormalizeCode(nr.Code)
+		if err != nil {
+			return nil, err
+		}
+		if c, err = retrive(ctx, tx, code, true); err != nil {
+			return nil, err
+		}
+		if c.Balance.Currency != credit.Currency {
+			return nil, money.ErrMismatch
+		}
+		if err := move(ctx, tx, c, Refunded, credit.Amount, &nr.SaleID, now); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := accounting.RecordRefund(ctx, tx, nr.SaleID, c.ID, accounting.GiftCardLiability, credit, now); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Spend takes m off the Card with code as payment for a Sale, as part of tx.
+// The Card stays locked until tx ends.
+func Spend(ctx context.Context, tx *sqlx.Tx, code string, m money.Money, saleID string, now time.Time) (*Card, error) {
+	if m.Amount <= 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	code, err := NormalizeCode(code)
+	if err != nil {
+		return nil, err
+	}
+	c, err := retrive(ctx, tx, code, true)
+	if err != nil {
+		return nil, err
+	}
+	if c.Balance.Currency != m.Currency {
+		return nil, money.ErrMismatch
+	}
+
+	if err := move(ctx, tx, c, Redeemed, -m.Amount, &saleID, now); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// SaleHook lets customers pay for Sales with a Card. The Card is locked from
+// when the Sale is checked until it is stored, so two tills can not spend
+// the same balance. It pays for what is left after loyalty points, so it has
+// to run after any hook that prices, taxes or takes points for Sales.
+func SaleHook() product.SaleHook {
+	return product.SaleHook{Before: prepare, After: record}
+}
+
+// prepare is the Before step of SaleHook. It locks the Card and works out how
+// much of the Sale it pays for.
+func prepare(ctx context.Context, tx *sqlx.Tx, s *product.Sale, ns product.NewSale) error {
+	if ns.GiftCard == "" {
+		if ns.GiftCardAmount != 0 {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+	if ns.GiftCardAmount < 0 {
+		return ErrInvalidAmount
+	}
+
+	code, err := NormalizeCode(ns.GiftCard)
+	if err != nil {
+		return err
+	}
+	c, err := retrive(ctx, tx, code, true)
+	if err != nil {
+		return err
+	}
+	if c.Balance.Currency != s.Paid.Currency {
+		return money.ErrMismatch
+	}
+
+	owed, err := s.Paid.Sub(s.PointsValue)
+	if err != nil {
+		return err
+	}
+
+	amount := ns.GiftCardAmount
+	if amount == 0 {
+		amount = owed.Amount
+		if c.Balance.Amount < amount {
+			amount = c.Balance.Amount
+		}
+	}
+	if amount > owed.Amount {
+		return ErrOverpaid
+	}
+	if amount > c.Balance.Amount {
+		return ErrInsufficientBalance
+	}
+
+	s.GiftCardPaid = money.Money{Amount: amount, Currency: s.Paid.Currency}
+	return nil
+}
+
+// record is the After step of SaleHook. It takes what the Card paid off its
+// balance.
+func record(ctx context.Context, tx *sqlx.Tx, s *product.Sale, ns product.NewSale) error {
+	if s.GiftCardPaid.IsZero() {
+		return nil
+	}
+
+	code, err := NormalizeCode(ns.GiftCard)
+	if err != nil {
+		return err
+	}
+	c, err := retrive(ctx, tx, code, true)
+	if err != nil {
+		return err
+	}
+
+	return move(ctx, tx, c, Redeemed, -s.GiftCardPaid.Amount, &s.ID, s.DateCreated)
+}
+
+// cardColumns selects a Card from the gift_cards table.
+const cardColumns = `
+			card_id, code, kind,
+			balance as "balance.amount", currency as "balance.currency",
+			customer_id, date_created, date_updated`
+
+// retrive finds the Card with a normalized code, locking it for the rest of
+// the transaction when lock is set.
+func retrive(ctx context.Context, db sqlx.QueryerContext, code string, lock bool) (*Card, error) {
+	q := `SELECT ` + cardColumns + ` FROM gift_cards WHERE code = $1`
+	if lock {
+		q += ` FOR UPDATE`
+	}
+
+	var c Card
+	if err := sqlx.GetContext(ctx, db, &c, q, code); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting gift card")
+	}
+
+	return &c, nil
+}
+
+// issue creates a Card of a kind holding m, recording how it came to be with
+// an Entry of entryKind.
+func issue(ctx context.Context, tx *sqlx.Tx, kind string, m money.Money, customerID string, saleID *string, entryKind string, now time.Time) (*Card, error) {
+	code, err := NewCode()
+	if err != nil {
+		return nil, err
+	}
+
+	c := Card{
+		ID:          uuid.New().String(),
+		Code:        code,
+		Kind:        kind,
+		Balance:     money.Money{Currency: m.Currency},
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+	if customerID != "" {
+		c.CustomerID = &customerID
+	}
+
+	const q = `
+		INSERT INTO gift_cards
+		(card_id, code, kind, currency, balance, customer_id, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err = tx.ExecContext(ctx, q,
+		c.ID, c.Code, c.Kind, c.Balance.Currency, c.Balance.Amount, c.CustomerID,
+		c.DateCreated, c.DateUpdated,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting gift card")
+	}
+
+	if err := post(ctx, tx, &c, entryKind, m.Amount, saleID, now); err != nil {
+		return nil, err
+	}
+
+	ac := audit.Change{EntityType: auditEntity, EntityID: c.ID, Action: audit.Create, After: audited(c)}
+	if _, err := audit.Record(ctx, tx, ac, now); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// move changes the balance of a locked Card by amount, records it in the
+// ledger and audits the change.
+func move(ctx context.Context, tx *sqlx.Tx, c *Card, kind string, amount int, saleID *string, now time.Time) error {
+	before := *c
+	if err := post(ctx, tx, c, kind, amount, saleID, now); err != nil {
+		return err
+	}
+
+	ac := audit.Change{EntityType: auditEntity, EntityID: c.ID, Action: audit.Update, Before: audited(before), After: audited(*c)}
+	_, err := audit.Record(ctx, tx, ac, now)
+	return err
+}
+
+// audited is c as it is recorded in the audit log, without its code, which
+// is all it takes to spend it.
+func audited(c Card) Card {
+	c.Code = ""
+	return c
+}
+
+// post changes the balance of a locked Card by amount and records it in the
+// ledger.
+func post(ctx context.Context, tx *sqlx.Tx, c *Card, kind string, amount int, saleID *string, now time.Time) error {
+	balance, err := c.Balance.Add(money.Money{Amount: amount, Currency: c.Balance.Currency})
+	if err != nil {
+		return err
+	}
+	if balance.Amount < 0 {
+		return ErrInsufficientBalance
+	}
+
+	const update = `UPDATE gift_cards SET balance = $2, date_updated = $3 WHERE card_id = $1`
+	if _, err := tx.ExecContext(ctx, update, c.ID, balance.Amount, now.UTC()); err != nil {
+		return errors.Wrap(err, "updating gift card balance")
+	}
+
+	e := Entry{
+		ID:          uuid.New().String(),
+		CardID:      c.ID,
+		SaleID:      saleID,
+		Kind:        kind,
+		Amount:      amount,
+		Balance:     balance.Amount,
+		DateCreated: now.UTC(),
+	}
+
+	const insert = `
+		INSERT INTO gift_card_entries
+		(entry_id, card_id, sale_id, kind, amount, balance, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err = tx.ExecContext(ctx, insert, e.ID, e.CardID, e.SaleID, e.Kind, e.Amount, e.Balance, e.DateCreated)
+	if err != nil {
+		return errors.Wrap(err, "inserting gift card entry")
+	}
+
+	c.Balance = balance
+	c.DateUpdated = now.UTC()
+	return nil
+}
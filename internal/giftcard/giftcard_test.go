@@ -0,0 +1,134 @@
+package giftcard_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vikramcse/the-service/internal/giftcard"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/product"
+	"github.com/vikramcse/the-service/internal/tests"
+)
+
+func TestCodes(t *testing.T) {
+	code, err := giftcard.NewCode()
+	if err != nil {
+		t.Fatalf("generating code: %s", err)
+	}
+	if len(code) != 19 || strings.Count(code, "-") != 3 {
+		t.Fatalf("expected a code like ABCD-EFGH-JKLM-NPQR, got %q", code)
+	}
+
+	got, err := giftcard.NormalizeCode(strings.ToLower(strings.Replace(code, "-", " ", -1)))
+	if err != nil {
+		t.Fatalf("normalizing code: %s", err)
+	}
+	if got != code {
+		t.Fatalf("expected %q, got %q", code, got)
+	}
+
+	for _, bad := range []string{"", "ABCD-EFGH", "ABCD-EFGH-JKLM-NPQ0"} {
+		if _, err := giftcard.NormalizeCode(bad); err != giftcard.ErrInvalidCode {
+			t.Errorf("normalizing %q: expected %v, got %v", bad, giftcard.ErrInvalidCode, err)
+		}
+	}
+}
+
+func TestGiftCards(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	p, err := product.Create(ctx, db, product.NewProduct{Name: "Comic Book", Cost: 3000, Quantity: 10}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	c, err := giftcard.Issue(ctx, db, giftcard.NewCard{Amount: 5000, Currency: "usd"}, now)
+	if err != nil {
+		t.Fatalf("issuing gift card: %s", err)
+	}
+
+	hook := giftcard.SaleHook()
+	sale := func(paid, amount int) (*product.Sale, error) {
+		ns := product.NewSale{Quantity: 1, Paid: &money.Money{Amount: paid}, GiftCard: c.Code, GiftCardAmount: amount}
+		return product.AddSale(ctx, db, ns, p.ID, now, hook)
+	}
+
+	s, err := sale(3000, 0)
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	if exp := (money.Money{Amount: 3000, Currency: "USD"}); s.GiftCardPaid != exp {
+		t.Fatalf("expected %v paid with the gift card, got %v", exp, s.GiftCardPaid)
+	}
+
+	if _, err := sale(1000, 1500); err != giftcard.ErrOverpaid {
+		t.Fatalf("expected %v, got %v", giftcard.ErrOverpaid, err)
+	}
+	if _, err := sale(3000, 2500); err != giftcard.ErrInsufficientBalance {
+		t.Fatalf("expected %v, got %v", giftcard.ErrInsufficientBalance, err)
+	}
+
+	// Two tills spending the whole balance at once: only one can succeed.
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := sale(2000, 2000)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var failed int
+	for err := range errs {
+		if err == giftcard.ErrInsufficientBalance {
+			failed++
+		} else if err != nil {
+			t.Fatalf("spending concurrently: %s", err)
+		}
+	}
+	if failed != 1 {
+		t.Fatalf("expected exactly one till to be refused, got %d", failed)
+	}
+
+	// Only the unit sold can be brought back, and it goes back in stock.
+	if _, err := giftcard.RefundSale(ctx, db, giftcard.NewRefund{SaleID: s.ID, Amount: 3000, Quantity: 2, Code: c.Code}, now); err != product.ErrReturnTooMuch {
+		t.Fatalf("expected %v, got %v", product.ErrReturnTooMuch, err)
+	}
+	if c, err = giftcard.RefundSale(ctx, db, giftcard.NewRefund{SaleID: s.ID, Amount: 3000, Quantity: 1, Code: c.Code}, now); err != nil {
+		t.Fatalf("refunding sale: %s", err)
+	}
+	if c.Balance.Amount != 3000 {
+		t.Fatalf("expected the refund to be credited to the card, got %v", c.Balance)
+	}
+	if p, err = product.Retrive(ctx, db, p.ID); err != nil {
+		t.Fatalf("retrieving product: %s", err)
+	}
+	if p.Quantity != 11 {
+		t.Fatalf("expected the returned unit back in stock, got quantity %d", p.Quantity)
+	}
+	if _, err := giftcard.RefundSale(ctx, db, giftcard.NewRefund{SaleID: s.ID, Amount: 1}, now); err != product.ErrRefundTooMuch {
+		t.Fatalf("expected %v, got %v", product.ErrRefundTooMuch, err)
+	}
+
+	entries, err := giftcard.Transactions(ctx, db, c.Code)
+	if err != nil {
+		t.Fatalf("getting transactions: %s", err)
+	}
+	var total int
+	for _, e := range entries {
+		total += e.Amount
+	}
+	if len(entries) != 4 || total != c.Balance.Amount {
+		t.Fatalf("expected 4 entries adding up to the balance, got %+v", entries)
+	}
+}
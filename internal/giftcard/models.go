@@ -0,0 +1,68 @@
+package giftcard
+
+import (
+	"time"
+
+	"github.com/vikramcse/the-service/internal/money"
+)
+
+// Kinds of Card. Both are spent the same way; they differ only in how they
+// were paid for.
+const (
+	// GiftCard is a Card someone bought.
+	GiftCard = "gift_card"
+
+	// StoreCredit is a Card issued in place of a refund.
+	StoreCredit = "store_credit"
+)
+
+// Kinds of Entry in the ledger of a Card.
+const (
+	Issued   = "issue"
+	Redeemed = "redeem"
+	Refunded = "refund"
+)
+
+// Card is a balance customers can pay for sales with. Code is what they
+// present at the till.
+type Card struct {
+	ID          string      `db:"card_id" json:"id"`
+	Code        string      `db:"code" json:"code"`
+	Kind        string      `db:"kind" json:"kind"`
+	Balance     money.Money `db:"balance" json:"balance"`
+	CustomerID  *string     `db:"customer_id" json:"customer_id,omitempty"`
+	DateCreated time.Time   `db:"date_created" json:"date_created"`
+	DateUpdated time.Time   `db:"date_updated" json:"date_updated"`
+}
+
+// NewCard is what we require from clients when selling a gift card. Amount is
+// in minor units of Currency. CustomerID is optional.
+type NewCard struct {
+	Amount     int    `json:"amount"`
+	Currency   string `json:"currency"`
+	CustomerID string `json:"customer_id"`
+}
+
+// NewRefund is what we require from clients when refunding a Sale as store
+// credit. The credit goes onto the Card with Code when one is given and onto
+// a new StoreCredit Card otherwise. Quantity is how many of the units sold
+// were brought back, if any.
+type NewRefund struct {
+	SaleID   string `json:"sale_id"`
+	Amount   int    `json:"amount"`
+	Quantity int    `json:"quantity"`
+	Code     string `json:"code"`
+}
+
+// Entry is one change to the balance of a Card. Amount is positive when money
+// goes onto the Card and negative when it is spent, and Balance is what the
+// Card held afterwards.
+type Entry struct {
+	ID          string    `db:"entry_id" json:"id"`
+	CardID      string    `db:"card_id" json:"card_id"`
+	SaleID      *string   `db:"sale_id" json:"sale_id,omitempty"`
+	Kind        string    `db:"kind" json:"kind"`
+	Amount      int       `db:"amount" json:"amount"`
+	Balance     int       `db:"balance" json:"balance"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
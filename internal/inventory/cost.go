@@ -0,0 +1,230 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Methods of costing the stock of a product.
+const (
+	// FIFO costs stock going out at what the oldest units in stock cost.
+	FIFO = "fifo"
+
+	// Average costs stock going out at the average cost of the units in
+	// stock, which is worked out again whenever stock comes in.
+	Average = "average"
+)
+
+// ErrInvalidCosting is returned for a costing method that is neither FIFO
+// nor Average.
+var ErrInvalidCosting = errors.New("costing must be fifo or average")
+
+// CheckCosting returns ErrInvalidCosting unless method is a known costing
+// method.
+func CheckCosting(method string) error {
+	if method != FIFO && method != Average {
+		return ErrInvalidCosting
+	}
+	return nil
+}
+
+// layer is units of a product or variant that came into stock together at
+// one unit cost, in the currency of the product. remaining is how many of
+// them are still in stock.
+type layer struct {
+	movementID string
+	unitCost   int
+	quantity   int
+	remaining  int
+}
+
+// value works out what the units of m cost and takes them in or out of the
+// cost layers of its product or variant, except for transfers which only move
+// stock between locations. Stock coming in costs m.UnitCost per unit, or what
+// stock in hand costs when it is not given. Stock going out is costed by the
+// costing method of the product; when there are not enough layers to cover it
+// the rest is costed at the last known unit cost. The returned value is signed
+// like m.Quantity. A layer for stock coming in is added by addLayer once m is
+// stored.
+func value(ctx context.Context, tx *sqlx.Tx, m *Movement) (int, error) {
+	switch m.Kind {
+	case TransferOut, TransferIn:
+		return 0, nil
+	}
+
+	if m.Quantity > 0 {
+		if m.UnitCost != nil {
+			return *m.UnitCost * m.Quantity, nil
+		}
+		cost, err := currentCost(ctx, tx, m.ProductID, m.VariantID)
+		if err != nil {
+			return 0, err
+		}
+		m.UnitCost = &cost
+		return cost * m.Quantity, nil
+	}
+
+	layers, err := openLayers(ctx, tx, m.ProductID, m.VariantID)
+	if err != nil {
+		return 0, err
+	}
+
+	const u = `UPDATE cost_layers SET remaining = $2 WHERE movement_id = $1`
+
+	left, total := -m.Quantity, 0
+	for _, l := range layers {
+		if left == 0 {
+			break
+		}
+		take := l.remaining
+		if take > left {
+			take = left
+		}
+		if _, err := tx.ExecContext(ctx, u, l.movementID, l.remaining-take); err != nil {
+			return 0, errors.Wrap(err, "updating cost layer")
+		}
+		total += take * l.unitCost
+		left -= take
+	}
+
+	if left > 0 {
+		cost, err := lastCost(ctx, tx, m.ProductID, m.VariantID)
+		if err != nil {
+			return 0, err
+		}
+		total += left * cost
+	}
+
+	return -total, nil
+}
+
+// addLayer takes the units of m, which has been stored, into stock at
+// m.UnitCost. Under Average costing the layers still in stock are folded into
+// the new one at the average cost of them all.
+func addLayer(ctx context.Context, tx *sqlx.Tx, m *Movement) error {
+	if m.Quantity <= 0 || m.UnitCost == nil {
+		return nil
+	}
+
+	var method string
+	const qm = `SELECT costing FROM products WHERE product_id = $1`
+	if err := tx.GetContext(ctx, &method, qm, m.ProductID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return errors.Wrap(err, "selecting costing method")
+	}
+
+	l := layer{
+		movementID: m.ID,
+		unitCost:   *m.UnitCost,
+		quantity:   m.Quantity,
+		remaining:  m.Quantity,
+	}
+
+	if method == Average {
+		held, err := openLayers(ctx, tx, m.ProductID, m.VariantID)
+		if err != nil {
+			return err
+		}
+
+		const u = `
+			UPDATE cost_layers SET remaining = 0
+			WHERE product_id = $1 AND variant_id IS NOT DISTINCT FROM $2::uuid AND remaining > 0`
+		if _, err := tx.ExecContext(ctx, u, m.ProductID, m.VariantID); err != nil {
+			return errors.Wrap(err, "folding cost layers")
+		}
+
+		units, value := l.quantity, l.quantity*l.unitCost
+		for _, h := range held {
+			units += h.remaining
+			value += h.remaining * h.unitCost
+		}
+		l.remaining = units
+		l.unitCost = (value + units/2) / units
+	}
+
+	const q = `
+		INSERT INTO cost_layers
+		(movement_id, product_id, variant_id, unit_cost, quantity, remaining, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := tx.ExecContext(ctx, q,
+		l.movementID, m.ProductID, m.VariantID, l.unitCost, l.quantity, l.remaining, m.DateCreated,
+	)
+	if err != nil {
+		return errors.Wrap(err, "inserting cost layer")
+	}
+
+	return nil
+}
+
+// openLayers locks the layers of a product or variant still in stock and
+// gets them oldest first.
+func openLayers(ctx context.Context, tx *sqlx.Tx, productID string, variantID *string) ([]layer, error) {
+	const q = `
+		SELECT movement_id, unit_cost, quantity, remaining
+		FROM cost_layers
+		WHERE product_id = $1 AND variant_id IS NOT DISTINCT FROM $2::uuid AND remaining > 0
+		ORDER BY date_created, seq
+		FOR UPDATE`
+
+	rows, err := tx.QueryContext(ctx, q, productID, variantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "selecting cost layers")
+	}
+	defer rows.Close()
+
+	var layers []layer
+	for rows.Next() {
+		var l layer
+		if err := rows.Scan(&l.movementID, &l.unitCost, &l.quantity, &l.remaining); err != nil {
+			return nil, errors.Wrap(err, "scanning cost layer")
+		}
+		layers = append(layers, l)
+	}
+
+	return layers, rows.Err()
+}
+
+// currentCost is the average cost of a unit of a product or variant in stock,
+// or its last known cost when none is in stock.
+func currentCost(ctx context.Context, db sqlx.QueryerContext, productID string, variantID *string) (int, error) {
+	var cost sql.NullInt64
+	const q = `
+		SELECT ROUND(SUM(remaining * unit_cost)::numeric / NULLIF(SUM(remaining), 0))
+		FROM cost_layers
+		WHERE product_id = $1 AND variant_id IS NOT DISTINCT FROM $2::uuid AND remaining > 0`
+
+	if err := sqlx.GetContext(ctx, db, &cost, q, productID, variantID); err != nil {
+		return 0, errors.Wrap(err, "selecting average cost")
+	}
+	if cost.Valid {
+		return int(cost.Int64), nil
+	}
+
+	return lastCost(ctx, db, productID, variantID)
+}
+
+// lastCost is the unit cost of the stock of a product or variant that came in
+// last, or 0 when none ever has.
+func lastCost(ctx context.Context, db sqlx.QueryerContext, productID string, variantID *string) (int, error) {
+	var cost int
+	const q = `
+		SELECT unit_cost FROM cost_layers
+		WHERE product_id = $1 AND variant_id IS NOT DISTINCT FROM $2::uuid
+		ORDER BY date_created DESC, seq DESC
+		LIMIT 1`
+
+	if err := sqlx.GetContext(ctx, db, &cost, q, productID, variantID); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, errors.Wrap(err, "selecting last cost")
+	}
+
+	return cost, nil
+}
@@ -0,0 +1,254 @@
+package inventory
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/accounting"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/platform/database"
+)
+
+var (
+	ErrInvalidID        = errors.New("ID is not in it's proper form")
+	ErrNotFound         = errors.New("Product not found")
+	ErrVariantNotFound  = errors.New("Variant not found")
+	ErrInvalidKind      = errors.New("kind must be one of receipt, refund, adjustment or write-off")
+	ErrInvalidQuantity  = errors.New("quantity must be positive, or non-zero for adjustments")
+	ErrLocationNotFound = errors.New("Location not found")
+	ErrInvalidCost      = errors.New("unit cost must not be negative")
+)
+
+// Entity types changes to inventory are audited as.
+const (
+	auditProduct  = "product"
+	auditMovement = "movement"
+	auditTransfer = "transfer"
+	auditLocation = "location"
+)
+
+// auditFix is the action a stored quantity fixed to match the ledger is
+// audited as.
+const auditFix = "fix_quantity"
+
+// Record appends m to the ledger as part of tx. Movements without a location
+// happen at the DefaultLocation. Receipts, refunds, adjustments and
+// write-offs also change the stored quantity of the product or variant.
+// Sales are already counted by the sales table and transfers only move
+// stock between locations, so they leave it alone. Every movement but a
+// transfer is costed and taken in or out of the cost layers that value the
+// stock.
+func Record(ctx context.Context, tx *sqlx.Tx, m Movement) (*Movement, error) {
+	m.ID = uuid.New().String()
+	m.DateCreated = m.DateCreated.UTC()
+	if m.LocationID == "" {
+		m.LocationID = DefaultLocation
+	}
+
+	var err error
+	if m.Value, err = value(ctx, tx, &m); err != nil {
+		return nil, err
+	}
+
+	const q = `
+		INSERT INTO inventory_movements
+		(movement_id, product_id, variant_id, sale_id, location_id, kind, quantity, value, reason, actor, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+
+	_, err = tx.ExecContext(ctx, q,
+		m.ID, m.ProductID, m.VariantID, m.SaleID, m.LocationID,
+		m.Kind, m.Quantity, m.Value, m.Reason, m.Actor, m.DateCreated,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting movement")
+	}
+
+	if err := addLayer(ctx, tx, &m); err != nil {
+		return nil, err
+	}
+
+	switch m.Kind {
+	case Sale, TransferOut, TransferIn:
+		return &m, nil
+	}
+
+	if m.VariantID != nil {
+		const q = `
+			UPDATE variants SET quantity = quantity + $2, date_updated = $3
+			WHERE variant_id = $1`
+		if _, err := tx.ExecContext(ctx, q, *m.VariantID, m.Quantity, m.DateCreated); err != nil {
+			return nil, errors.Wrap(err, "updating variant quantity")
+		}
+		return &m, nil
+	}
+
+	const u = `
+		UPDATE products SET quantity = quantity + $2, date_updated = $3
+		WHERE product_id = $1`
+	if _, err := tx.ExecContext(ctx, u, m.ProductID, m.Quantity, m.DateCreated); err != nil {
+		return nil, errors.Wrap(err, "updating product quantity")
+	}
+
+	return &m, nil
+}
+
+// Adjust records a manual stock change for a product or one of its variants.
+// The change is attributed to the actor of the audit.Request in ctx.
+func Adjust(ctx context.Context, db *sqlx.DB, na NewAdjustment, productID string, now time.Time) (*Movement, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	m := Movement{
+		ProductID:   productID,
+		LocationID:  na.LocationID,
+		Kind:        na.Kind,
+		Quantity:    na.Quantity,
+		UnitCost:    na.UnitCost,
+		Reason:      na.Reason,
+		Actor:       audit.Actor(ctx),
+		DateCreated: now,
+	}
+
+	switch na.Kind {
+	case Receipt, Refund:
+		if na.Quantity <= 0 {
+			return nil, ErrInvalidQuantity
+		}
+	case WriteOff:
+		if na.Quantity <= 0 {
+			return nil, ErrInvalidQuantity
+		}
+		m.Quantity = -na.Quantity
+	case Adjustment:
+		if na.Quantity == 0 {
+			return nil, ErrInvalidQuantity
+		}
+	default:
+		return nil, ErrInvalidKind
+	}
+	if na.UnitCost != nil && *na.UnitCost < 0 {
+		return nil, ErrInvalidCost
+	}
+
+	var rec *Movement
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		if err := checkStockUnit(ctx, tx, productID, na.VariantID); err != nil {
+			return err
+		}
+		if na.LocationID != "" {
+			if err := CheckLocation(ctx, tx, na.LocationID); err != nil {
+				return err
+			}
+		}
+		if na.VariantID != "" {
+			m.VariantID = &na.VariantID
+		}
+
+		var err error
+		if rec, err = Record(ctx, tx, m); err != nil {
+			return err
+		}
+		if err := accounting.RecordAdjustment(ctx, tx, rec.ID, productID, rec.Value, now); err != nil {
+			return err
+		}
+
+		c := audit.Change{EntityType: auditMovement, EntityID: rec.ID, Action: audit.Create, After: rec}
+		_, err = audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// ListMovements gives the ledger of a product, or of one of its variants when
+// variantID is not empty, oldest movement first.
+func ListMovements(ctx context.Context, db *sqlx.DB, productID, variantID string) (*Ledger, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if err := checkStockUnit(ctx, db, productID, variantID); err != nil {
+		return nil, err
+	}
+
+	var vid interface{}
+	if variantID != "" {
+		vid = variantID
+	}
+
+	l := Ledger{
+		Movements: []Movement{},
+	}
+
+	const q = `
+		SELECT * FROM inventory_movements
+		WHERE product_id = $1 AND variant_id IS NOT DISTINCT FROM $2::uuid
+		ORDER BY date_created, movement_id`
+
+	if err := db.SelectContext(ctx, &l.Movements, q, productID, vid); err != nil {
+		return nil, errors.Wrap(err, "selecting movements")
+	}
+
+	for _, m := range l.Movements {
+		l.OnHand += m.Quantity
+	}
+
+	return &l, nil
+}
+
+// OnHand computes the stock on hand of a product, or of one of its variants
+// when variantID is not empty, from the ledger.
+func OnHand(ctx context.Context, db sqlx.QueryerContext, productID, variantID string) (int, error) {
+	var vid interface{}
+	if variantID != "" {
+		vid = variantID
+	}
+
+	var n int
+	const q = `
+		SELECT COALESCE(SUM(quantity), 0) FROM inventory_movements
+		WHERE product_id = $1 AND variant_id IS NOT DISTINCT FROM $2::uuid`
+
+	if err := sqlx.GetContext(ctx, db, &n, q, productID, vid); err != nil {
+		return 0, errors.Wrap(err, "summing movements")
+	}
+
+	return n, nil
+}
+
+// checkStockUnit makes sure the product exists and, when variantID is given,
+// that the variant belongs to it.
+func checkStockUnit(ctx context.Context, db sqlx.QueryerContext, productID, variantID string) error {
+	var exists bool
+
+	if variantID == "" {
+		const q = `SELECT EXISTS(SELECT 1 FROM products WHERE product_id = $1)`
+		if err := sqlx.GetContext(ctx, db, &exists, q, productID); err != nil {
+			return errors.Wrap(err, "checking product")
+		}
+		if !exists {
+			return ErrNotFound
+		}
+		return nil
+	}
+
+	if _, err := uuid.Parse(variantID); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `SELECT EXISTS(SELECT 1 FROM variants WHERE variant_id = $1 AND product_id = $2)`
+	if err := sqlx.GetContext(ctx, db, &exists, q, variantID, productID); err != nil {
+		return errors.Wrap(err, "checking variant")
+	}
+	if !exists {
+		return ErrVariantNotFound
+	}
+
+	return nil
+}
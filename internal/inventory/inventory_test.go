@@ -0,0 +1,253 @@
+package inventory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/inventory"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/product"
+	"github.com/vikramcse/the-service/internal/tests"
+)
+
+func TestLedger(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := audit.NewContext(context.Background(), &audit.Request{Actor: "bill"})
+
+	p, err := product.Create(ctx, db, product.NewProduct{Name: "Comic Book", Cost: 10, Quantity: 10}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	if _, err := product.AddSale(ctx, db, product.NewSale{Quantity: 3, Paid: &money.Money{Amount: 30}}, p.ID, now); err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+
+	na := inventory.NewAdjustment{Kind: inventory.WriteOff, Quantity: 2, Reason: "water damage"}
+	m, err := inventory.Adjust(ctx, db, na, p.ID, now)
+	if err != nil {
+		t.Fatalf("writing off stock: %s", err)
+	}
+	if exp, got := "bill", m.Actor; exp != got {
+		t.Fatalf("expected the write-off to be made by %q, got %q", exp, got)
+	}
+
+	na = inventory.NewAdjustment{Kind: inventory.Sale, Quantity: 1}
+	if _, err := inventory.Adjust(ctx, db, na, p.ID, now); err != inventory.ErrInvalidKind {
+		t.Fatalf("expected %v adjusting with a sale, got %v", inventory.ErrInvalidKind, err)
+	}
+
+	l, err := inventory.ListMovements(ctx, db, p.ID, "")
+	if err != nil {
+		t.Fatalf("listing movements: %s", err)
+	}
+	if exp, got := 3, len(l.Movements); exp != got {
+		t.Fatalf("expected %v movements, got %v", exp, got)
+	}
+	if exp, got := 5, l.OnHand; exp != got {
+		t.Fatalf("expected %v on hand, got %v", exp, got)
+	}
+
+	got, err := product.Retrive(ctx, db, p.ID)
+	if err != nil {
+		t.Fatalf("retrieving product: %s", err)
+	}
+	if exp, got := l.OnHand, got.Quantity-got.Sold; exp != got {
+		t.Fatalf("expected quantity minus sold to be %v, got %v", exp, got)
+	}
+
+	drifts, err := inventory.Reconcile(ctx, db)
+	if err != nil {
+		t.Fatalf("reconciling: %s", err)
+	}
+	if exp, got := 0, len(drifts); exp != got {
+		t.Fatalf("expected %v drifted products, got %v", exp, got)
+	}
+
+	if _, err := db.Exec(`UPDATE products SET quantity = 12 WHERE product_id = $1`, p.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	drifts, err = inventory.Reconcile(ctx, db)
+	if err != nil {
+		t.Fatalf("reconciling: %s", err)
+	}
+	if exp, got := 1, len(drifts); exp != got {
+		t.Fatalf("expected %v drifted products, got %v", exp, got)
+	}
+	if exp, got := 4, drifts[0].Diff(); exp != got {
+		t.Fatalf("expected drift of %v, got %v", exp, got)
+	}
+
+	if err := inventory.Fix(ctx, db, drifts, now); err != nil {
+		t.Fatalf("fixing drift: %s", err)
+	}
+	drifts, err = inventory.Reconcile(ctx, db)
+	if err != nil {
+		t.Fatalf("reconciling: %s", err)
+	}
+	if exp, got := 0, len(drifts); exp != got {
+		t.Fatalf("expected %v drifted products after fixing, got %v", exp, got)
+	}
+}
+
+func TestTransfers(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	p, err := product.Create(ctx, db, product.NewProduct{Name: "Comic Book", Cost: 10, Quantity: 10}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	shop, err := inventory.CreateLocation(ctx, db, inventory.NewLocation{Name: "High Street", Kind: inventory.Store}, now)
+	if err != nil {
+		t.Fatalf("creating location: %s", err)
+	}
+
+	nt := inventory.NewTransfer{
+		ProductID:      p.ID,
+		FromLocationID: inventory.DefaultLocation,
+		ToLocationID:   shop.ID,
+		Quantity:       11,
+	}
+	if _, err := inventory.CreateTransfer(ctx, db, nt, now); err != inventory.ErrInsufficientStock {
+		t.Fatalf("expected %v sending more than is on hand, got %v", inventory.ErrInsufficientStock, err)
+	}
+
+	nt.Quantity = 4
+	tr, err := inventory.CreateTransfer(ctx, db, nt, now)
+	if err != nil {
+		t.Fatalf("creating transfer: %s", err)
+	}
+
+	stock := func() map[string]inventory.LocationStock {
+		t.Helper()
+		list, err := inventory.StockByLocation(ctx, db, p.ID, "")
+		if err != nil {
+			t.Fatalf("getting stock: %s", err)
+		}
+		m := make(map[string]inventory.LocationStock)
+		for _, ls := range list {
+			m[ls.LocationID] = ls
+		}
+		return m
+	}
+
+	s := stock()
+	if exp, got := 6, s[inventory.DefaultLocation].OnHand; exp != got {
+		t.Fatalf("expected %v on hand at the warehouse, got %v", exp, got)
+	}
+	if exp, got := 4, s[shop.ID].InTransit; exp != got {
+		t.Fatalf("expected %v in transit to the shop, got %v", exp, got)
+	}
+
+	drifts, err := inventory.Reconcile(ctx, db)
+	if err != nil {
+		t.Fatalf("reconciling: %s", err)
+	}
+	if exp, got := 0, len(drifts); exp != got {
+		t.Fatalf("expected %v drifted products while in transit, got %v", exp, got)
+	}
+
+	if _, err := inventory.ReceiveTransfer(ctx, db, tr.ID, now); err != nil {
+		t.Fatalf("receiving transfer: %s", err)
+	}
+	if _, err := inventory.CancelTransfer(ctx, db, tr.ID, now); err != inventory.ErrTransferCompleted {
+		t.Fatalf("expected %v cancelling a received transfer, got %v", inventory.ErrTransferCompleted, err)
+	}
+
+	// The shop only has the 4 sent to it, however many there are in all.
+	ns := product.NewSale{LocationID: shop.ID, Quantity: 5, Paid: &money.Money{Amount: 50}}
+	if _, err := product.AddSale(ctx, db, ns, p.ID, now); err != product.ErrInsufficientStock {
+		t.Fatalf("expected %v selling more than the shop has, got %v", product.ErrInsufficientStock, err)
+	}
+
+	ns = product.NewSale{LocationID: shop.ID, Quantity: 1, Paid: &money.Money{Amount: 10}}
+	if _, err := product.AddSale(ctx, db, ns, p.ID, now); err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+
+	s = stock()
+	if exp, got := 3, s[shop.ID].OnHand; exp != got {
+		t.Fatalf("expected %v on hand at the shop, got %v", exp, got)
+	}
+	if exp, got := 0, s[shop.ID].InTransit; exp != got {
+		t.Fatalf("expected %v in transit to the shop, got %v", exp, got)
+	}
+}
+
+func TestCosting(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	// Ten units bought at 1.00 and ten more at 2.00, then fifteen sold for
+	// 30.00 and one written off.
+	tt := []struct {
+		costing    string
+		cogs       int
+		writeOff   int
+		stockValue int
+	}{
+		{inventory.FIFO, 2000, -200, 800},
+		{inventory.Average, 2250, -150, 600},
+	}
+
+	for _, tc := range tt {
+		np := product.NewProduct{Name: "Comic Book", Cost: 300, Quantity: 10, UnitCost: 100, Costing: tc.costing}
+		p, err := product.Create(ctx, db, np, now)
+		if err != nil {
+			t.Fatalf("%s: creating product: %s", tc.costing, err)
+		}
+
+		cost := 200
+		na := inventory.NewAdjustment{Kind: inventory.Receipt, Quantity: 10, UnitCost: &cost}
+		if _, err := inventory.Adjust(ctx, db, na, p.ID, now); err != nil {
+			t.Fatalf("%s: receiving stock: %s", tc.costing, err)
+		}
+
+		s, err := product.AddSale(ctx, db, product.NewSale{Quantity: 15, Paid: &money.Money{Amount: 3000}}, p.ID, now)
+		if err != nil {
+			t.Fatalf("%s: adding sale: %s", tc.costing, err)
+		}
+		if exp, got := tc.cogs, s.COGS.Amount; exp != got {
+			t.Errorf("%s: expected cost of goods sold %d, got %d", tc.costing, exp, got)
+		}
+
+		na = inventory.NewAdjustment{Kind: inventory.WriteOff, Quantity: 1}
+		m, err := inventory.Adjust(ctx, db, na, p.ID, now)
+		if err != nil {
+			t.Fatalf("%s: writing off stock: %s", tc.costing, err)
+		}
+		if exp, got := tc.writeOff, m.Value; exp != got {
+			t.Errorf("%s: expected write-off value %d, got %d", tc.costing, exp, got)
+		}
+
+		if p, err = product.Retrive(ctx, db, p.ID); err != nil {
+			t.Fatalf("%s: retrieving product: %s", tc.costing, err)
+		}
+		if exp, got := tc.cogs, p.COGS.Amount; exp != got {
+			t.Errorf("%s: expected product cost of goods sold %d, got %d", tc.costing, exp, got)
+		}
+		if exp, got := 3000-tc.cogs, p.GrossMargin.Amount; exp != got {
+			t.Errorf("%s: expected gross margin %d, got %d", tc.costing, exp, got)
+		}
+		if exp, got := tc.stockValue, p.StockValue.Amount; exp != got {
+			t.Errorf("%s: expected stock value %d, got %d", tc.costing, exp, got)
+		}
+	}
+
+	np := product.NewProduct{Name: "Comic Book", Costing: "lifo"}
+	if _, err := product.Create(ctx, db, np, now); err != inventory.ErrInvalidCosting {
+		t.Fatalf("expected %v, got %v", inventory.ErrInvalidCosting, err)
+	}
+}
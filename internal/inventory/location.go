@@ -0,0 +1,143 @@
+package inventory
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/platform/database"
+)
+
+var ErrInvalidLocationKind = errors.New("kind must be one of warehouse or store")
+
+// ListLocations gets all Locations.
+func ListLocations(ctx context.Context, db *sqlx.DB) ([]Location, error) {
+	locations := []Location{}
+
+	const q = `SELECT * FROM locations ORDER BY name`
+	if err := db.SelectContext(ctx, &locations, q); err != nil {
+		return nil, errors.Wrap(err, "selecting locations")
+	}
+
+	return locations, nil
+}
+
+// CreateLocation adds a Location to the database.
+func CreateLocation(ctx context.Context, db *sqlx.DB, nl NewLocation, now time.Time) (*Location, error) {
+	switch nl.Kind {
+	case Warehouse, Store:
+	default:
+		return nil, ErrInvalidLocationKind
+	}
+
+	l := Location{
+		ID:          uuid.New().String(),
+		Name:        nl.Name,
+		Kind:        nl.Kind,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `
+		INSERT INTO locations
+		(location_id, name, kind, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, q, l.ID, l.Name, l.Kind, l.DateCreated, l.DateUpdated); err != nil {
+			return errors.Wrap(err, "inserting location")
+		}
+
+		c := audit.Change{EntityType: auditLocation, EntityID: l.ID, Action: audit.Create, After: l}
+		_, err := audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+// CheckLocation returns ErrLocationNotFound when there is no Location with
+// the given id.
+func CheckLocation(ctx context.Context, db sqlx.QueryerContext, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	var exists bool
+	const q = `SELECT EXISTS(SELECT 1 FROM locations WHERE location_id = $1)`
+	if err := sqlx.GetContext(ctx, db, &exists, q, id); err != nil {
+		return errors.Wrap(err, "checking location")
+	}
+	if !exists {
+		return ErrLocationNotFound
+	}
+
+	return nil
+}
+
+// AvailableAt is the stock of a product, or of one of its variants when
+// variantID is set, on hand at a Location and not held there by an active
+// reservation. Units sent away have already left the ledger of the Location.
+// Callers lock the product first so the stock can not be taken twice.
+func AvailableAt(ctx context.Context, tx *sqlx.Tx, locationID, productID string, variantID *string, now time.Time) (int, error) {
+	var avail int
+	const q = `
+		SELECT
+			COALESCE((SELECT SUM(m.quantity) FROM inventory_movements as m
+				WHERE m.location_id = $1 AND m.product_id = $2
+				AND m.variant_id IS NOT DISTINCT FROM $3::uuid), 0)
+			- COALESCE((SELECT SUM(r.quantity) FROM reservations as r
+				WHERE r.location_id = $1 AND r.product_id = $2
+				AND r.variant_id IS NOT DISTINCT FROM $3::uuid
+				AND r.status = 'active' AND r.expires_at > $4), 0)`
+
+	if err := tx.GetContext(ctx, &avail, q, locationID, productID, variantID, now.UTC()); err != nil {
+		return 0, errors.Wrap(err, "selecting stock at location")
+	}
+
+	return avail, nil
+}
+
+// StockByLocation breaks the availability of a product, or of one of its
+// variants when variantID is not empty, down per Location.
+func StockByLocation(ctx context.Context, db *sqlx.DB, productID, variantID string) ([]LocationStock, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if err := checkStockUnit(ctx, db, productID, variantID); err != nil {
+		return nil, err
+	}
+
+	var vid interface{}
+	if variantID != "" {
+		vid = variantID
+	}
+
+	stock := []LocationStock{}
+
+	const q = `
+		SELECT
+			l.location_id,
+			l.name,
+			COALESCE((SELECT SUM(m.quantity) FROM inventory_movements as m
+				WHERE m.location_id = l.location_id AND m.product_id = $1
+				AND m.variant_id IS NOT DISTINCT FROM $2::uuid), 0) as on_hand,
+			COALESCE((SELECT SUM(t.quantity) FROM transfers as t
+				WHERE t.to_location_id = l.location_id AND t.product_id = $1
+				AND t.variant_id IS NOT DISTINCT FROM $2::uuid
+				AND t.status = 'in_transit'), 0) as in_transit
+		FROM locations as l
+		ORDER BY l.name`
+
+	if err := db.SelectContext(ctx, &stock, q, productID, vid); err != nil {
+		return nil, errors.Wrap(err, "selecting stock by location")
+	}
+
+	return stock, nil
+}
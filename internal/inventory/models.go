@@ -0,0 +1,146 @@
+package inventory
+
+import (
+	"time"
+)
+
+// Kinds of stock movement.
+const (
+	Receipt     = "receipt"
+	Sale        = "sale"
+	Refund      = "refund"
+	Adjustment  = "adjustment"
+	WriteOff    = "write-off"
+	TransferOut = "transfer-out"
+	TransferIn  = "transfer-in"
+)
+
+// DefaultLocation is the location created with the schema. Stock movements
+// that do not name a location happen here.
+const DefaultLocation = "00000000-0000-0000-0000-000000000001"
+
+// Kinds of location.
+const (
+	Warehouse = "warehouse"
+	Store     = "store"
+)
+
+// Statuses of a Transfer.
+const (
+	InTransit = "in_transit"
+	Received  = "received"
+	Cancelled = "cancelled"
+)
+
+// Movement is one entry in the append-only inventory ledger. Quantity is
+// signed: stock coming in is positive and stock going out is negative. The
+// stock on hand of a product or variant is the sum of its movements, and the
+// stock at a location the sum of the movements recorded there. Value is what
+// the units moved cost, in the currency of the product and signed like
+// Quantity. UnitCost is what each unit coming in cost; it is only given when
+// recording a Movement and, when left out, the stock in hand is costed.
+type Movement struct {
+	ID          string    `db:"movement_id" json:"id"`
+	ProductID   string    `db:"product_id" json:"product_id"`
+	VariantID   *string   `db:"variant_id" json:"variant_id,omitempty"`
+	SaleID      *string   `db:"sale_id" json:"sale_id,omitempty"`
+	LocationID  string    `db:"location_id" json:"location_id"`
+	Kind        string    `db:"kind" json:"kind"`
+	Quantity    int       `db:"quantity" json:"quantity"`
+	Value       int       `db:"value" json:"value"`
+	UnitCost    *int      `db:"-" json:"-"`
+	Reason      string    `db:"reason" json:"reason"`
+	Actor       string    `db:"actor" json:"actor"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
+
+// NewAdjustment is what we require from clients when correcting stock by
+// hand. Quantity is signed for adjustments and always positive for receipts,
+// refunds and write-offs, whose direction is implied by the kind. An empty
+// LocationID adjusts stock at the DefaultLocation. UnitCost is what each unit
+// brought into stock cost; leaving it out costs them like the stock in hand.
+// Who makes the adjustment is not taken from clients but from the request.
+type NewAdjustment struct {
+	VariantID  string `json:"variant_id"`
+	LocationID string `json:"location_id"`
+	Kind       string `json:"kind"`
+	Quantity   int    `json:"quantity"`
+	UnitCost   *int   `json:"unit_cost"`
+	Reason     string `json:"reason"`
+}
+
+// Ledger is the movement history of a product or variant along with the
+// stock on hand it adds up to.
+type Ledger struct {
+	OnHand    int        `json:"on_hand"`
+	Movements []Movement `json:"movements"`
+}
+
+// Drift describes a product or variant whose stored quantity does not agree
+// with its ledger. The stored quantity counts every unit brought into stock,
+// so Quantity minus Sold should equal OnHand. Units travelling between
+// locations are counted as on hand.
+type Drift struct {
+	ProductID string  `db:"product_id" json:"product_id"`
+	VariantID *string `db:"variant_id" json:"variant_id,omitempty"`
+	Name      string  `db:"name" json:"name"`
+	Quantity  int     `db:"quantity" json:"quantity"`
+	Sold      int     `db:"sold" json:"sold"`
+	OnHand    int     `db:"on_hand" json:"on_hand"`
+}
+
+// Diff is how many more units the stored quantity claims than the ledger.
+func (d Drift) Diff() int {
+	return d.Quantity - d.Sold - d.OnHand
+}
+
+// Location is a place stock is kept, such as a warehouse or a store.
+type Location struct {
+	ID          string    `db:"location_id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	Kind        string    `db:"kind" json:"kind"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// NewLocation is what we require from clients when adding a Location.
+type NewLocation struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// LocationStock is the availability of a product or variant at one Location.
+// InTransit counts units on their way to the Location that can not be sold
+// there yet.
+type LocationStock struct {
+	LocationID string `db:"location_id" json:"location_id"`
+	Name       string `db:"name" json:"name"`
+	OnHand     int    `db:"on_hand" json:"on_hand"`
+	InTransit  int    `db:"in_transit" json:"in_transit"`
+}
+
+// Transfer moves stock of a product or variant between two Locations. Stock
+// leaves the source when the Transfer is created and arrives at the
+// destination when it is received.
+type Transfer struct {
+	ID             string    `db:"transfer_id" json:"id"`
+	ProductID      string    `db:"product_id" json:"product_id"`
+	VariantID      *string   `db:"variant_id" json:"variant_id,omitempty"`
+	FromLocationID string    `db:"from_location_id" json:"from_location_id"`
+	ToLocationID   string    `db:"to_location_id" json:"to_location_id"`
+	Quantity       int       `db:"quantity" json:"quantity"`
+	Status         string    `db:"status" json:"status"`
+	Actor          string    `db:"actor" json:"actor"`
+	DateCreated    time.Time `db:"date_created" json:"date_created"`
+	DateUpdated    time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// NewTransfer is what we require from clients when sending stock to another
+// Location. Who sends it is taken from the request.
+type NewTransfer struct {
+	ProductID      string `json:"product_id"`
+	VariantID      string `json:"variant_id"`
+	FromLocationID string `json:"from_location_id"`
+	ToLocationID   string `json:"to_location_id"`
+	Quantity       int    `json:"quantity"`
+}
@@ -0,0 +1,89 @@
+package inventory
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/platform/database"
+)
+
+// Reconcile finds every product and variant whose stored quantity has drifted
+// from what the ledger says is on hand.
+func Reconcile(ctx context.Context, db *sqlx.DB) ([]Drift, error) {
+	drifts := []Drift{}
+
+	const q = `
+		SELECT * FROM (
+			SELECT
+				p.product_id,
+				NULL::uuid as variant_id,
+				p.name,
+				p.quantity,
+				COALESCE((SELECT SUM(s.quantity) FROM sales as s
+					WHERE s.product_id = p.product_id AND s.variant_id IS NULL), 0) as sold,
+				COALESCE((SELECT SUM(m.quantity) FROM inventory_movements as m
+					WHERE m.product_id = p.product_id AND m.variant_id IS NULL), 0) +
+				COALESCE((SELECT SUM(t.quantity) FROM transfers as t
+					WHERE t.product_id = p.product_id AND t.variant_id IS NULL
+					AND t.status = 'in_transit'), 0) as on_hand
+			FROM products as p
+			UNION ALL
+			SELECT
+				v.product_id,
+				v.variant_id,
+				p.name || ' (' || v.sku || ')' as name,
+				v.quantity,
+				COALESCE((SELECT SUM(s.quantity) FROM sales as s
+					WHERE s.variant_id = v.variant_id), 0) as sold,
+				COALESCE((SELECT SUM(m.quantity) FROM inventory_movements as m
+					WHERE m.variant_id = v.variant_id), 0) +
+				COALESCE((SELECT SUM(t.quantity) FROM transfers as t
+					WHERE t.variant_id = v.variant_id AND t.status = 'in_transit'), 0) as on_hand
+			FROM variants as v
+			JOIN products as p ON(p.product_id=v.product_id)
+		) as units
+		WHERE quantity - sold <> on_hand
+		ORDER BY name`
+
+	if err := db.SelectContext(ctx, &drifts, q); err != nil {
+		return nil, errors.Wrap(err, "selecting drift")
+	}
+
+	return drifts, nil
+}
+
+// Fix trusts the ledger and rewrites the stored quantity of every drifted
+// product and variant so that quantity minus sold equals what is on hand.
+// Each fix is audited against its product.
+func Fix(ctx context.Context, db *sqlx.DB, drifts []Drift, now time.Time) error {
+	return database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		for _, d := range drifts {
+			if d.VariantID != nil {
+				const q = `UPDATE variants SET quantity = $2 WHERE variant_id = $1`
+				if _, err := tx.ExecContext(ctx, q, *d.VariantID, d.OnHand+d.Sold); err != nil {
+					return errors.Wrapf(err, "fixing variant %q", *d.VariantID)
+				}
+				continue
+			}
+
+			const q = `UPDATE products SET quantity = $2 WHERE product_id = $1`
+			if _, err := tx.ExecContext(ctx, q, d.ProductID, d.OnHand+d.Sold); err != nil {
+				return errors.Wrapf(err, "fixing product %q", d.ProductID)
+			}
+		}
+
+		for _, d := range drifts {
+			fixed := d
+			fixed.Quantity = d.OnHand + d.Sold
+			c := audit.Change{EntityType: auditProduct, EntityID: d.ProductID, Action: auditFix, Before: d, After: fixed}
+			if _, err := audit.Record(ctx, tx, c, now); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,206 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/platform/database"
+)
+
+var (
+	ErrTransferNotFound  = errors.New("Transfer not found")
+	ErrSameLocation      = errors.New("Transfer must be between two different locations")
+	ErrTransferCompleted = errors.New("Transfer is no longer in transit")
+	ErrInsufficientStock = errors.New("not enough stock at the source location")
+)
+
+// CreateTransfer sends stock from one Location to another. The units leave
+// the source straight away and are in transit until the Transfer is
+// received, so no more can be sent than the source has on hand and not
+// reserved. The Transfer is sent by the actor of the audit.Request in ctx.
+func CreateTransfer(ctx context.Context, db *sqlx.DB, nt NewTransfer, now time.Time) (*Transfer, error) {
+	if _, err := uuid.Parse(nt.ProductID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if nt.Quantity <= 0 {
+		return nil, ErrInvalidQuantity
+	}
+	if nt.FromLocationID == nt.ToLocationID {
+		return nil, ErrSameLocation
+	}
+
+	t := Transfer{
+		ID:             uuid.New().String(),
+		ProductID:      nt.ProductID,
+		FromLocationID: nt.FromLocationID,
+		ToLocationID:   nt.ToLocationID,
+		Quantity:       nt.Quantity,
+		Status:         InTransit,
+		Actor:          audit.Actor(ctx),
+		DateCreated:    now.UTC(),
+		DateUpdated:    now.UTC(),
+	}
+	if nt.VariantID != "" {
+		t.VariantID = &nt.VariantID
+	}
+
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		if err := checkStockUnit(ctx, tx, nt.ProductID, nt.VariantID); err != nil {
+			return err
+		}
+		for _, id := range []string{t.FromLocationID, t.ToLocationID} {
+			if err := CheckLocation(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+
+		// Lock the product, as sales and reservations do, so the stock at
+		// the source can not be taken twice.
+		const lock = `SELECT true FROM products WHERE product_id = $1 FOR UPDATE`
+		var locked bool
+		if err := tx.GetContext(ctx, &locked, lock, t.ProductID); err != nil {
+			return errors.Wrap(err, "locking product")
+		}
+
+		avail, err := AvailableAt(ctx, tx, t.FromLocationID, t.ProductID, t.VariantID, now)
+		if err != nil {
+			return err
+		}
+		if avail < t.Quantity {
+			return ErrInsufficientStock
+		}
+
+		const q = `
+			INSERT INTO transfers
+			(transfer_id, product_id, variant_id, from_location_id, to_location_id,
+			quantity, status, actor, date_created, date_updated)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+		_, err = tx.ExecContext(ctx, q,
+			t.ID, t.ProductID, t.VariantID, t.FromLocationID, t.ToLocationID,
+			t.Quantity, t.Status, t.Actor, t.DateCreated, t.DateUpdated,
+		)
+		if err != nil {
+			return errors.Wrap(err, "inserting transfer")
+		}
+
+		if err := t.move(ctx, tx, TransferOut, t.FromLocationID, -t.Quantity, t.Actor, now); err != nil {
+			return err
+		}
+
+		c := audit.Change{EntityType: auditTransfer, EntityID: t.ID, Action: audit.Create, After: t}
+		_, err = audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// ListTransfers gets all Transfers, or only those with status when it is not
+// empty.
+func ListTransfers(ctx context.Context, db *sqlx.DB, status string) ([]Transfer, error) {
+	var st interface{}
+	if status != "" {
+		st = status
+	}
+
+	transfers := []Transfer{}
+
+	const q = `
+		SELECT * FROM transfers
+		WHERE $1::text IS NULL OR status = $1
+		ORDER BY date_created`
+
+	if err := db.SelectContext(ctx, &transfers, q, st); err != nil {
+		return nil, errors.Wrap(err, "selecting transfers")
+	}
+
+	return transfers, nil
+}
+
+// ReceiveTransfer books the stock of an in transit Transfer into its
+// destination.
+func ReceiveTransfer(ctx context.Context, db *sqlx.DB, id string, now time.Time) (*Transfer, error) {
+	return finishTransfer(ctx, db, id, Received, now)
+}
+
+// CancelTransfer returns the stock of an in transit Transfer to its source.
+func CancelTransfer(ctx context.Context, db *sqlx.DB, id string, now time.Time) (*Transfer, error) {
+	return finishTransfer(ctx, db, id, Cancelled, now)
+}
+
+// finishTransfer takes a Transfer out of transit, putting its stock into the
+// destination when it arrived and back into the source when it was cancelled.
+// The stock is moved by the actor of the audit.Request in ctx.
+func finishTransfer(ctx context.Context, db *sqlx.DB, id, status string, now time.Time) (*Transfer, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var t Transfer
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		const q = `SELECT * FROM transfers WHERE transfer_id = $1 FOR UPDATE`
+		if err := tx.GetContext(ctx, &t, q, id); err != nil {
+			if err == sql.ErrNoRows {
+				return ErrTransferNotFound
+			}
+			return errors.Wrapf(err, "selecting transfer %q", id)
+		}
+		if t.Status != InTransit {
+			return ErrTransferCompleted
+		}
+
+		before := t
+		t.Status = status
+		t.DateUpdated = now.UTC()
+
+		const u = `UPDATE transfers SET status = $2, date_updated = $3 WHERE transfer_id = $1`
+		if _, err := tx.ExecContext(ctx, u, t.ID, t.Status, t.DateUpdated); err != nil {
+			return errors.Wrap(err, "updating transfer")
+		}
+
+		to := t.ToLocationID
+		if status == Cancelled {
+			to = t.FromLocationID
+		}
+		if err := t.move(ctx, tx, TransferIn, to, t.Quantity, audit.Actor(ctx), now); err != nil {
+			return err
+		}
+
+		c := audit.Change{EntityType: auditTransfer, EntityID: t.ID, Action: audit.Update, Before: before, After: t}
+		_, err := audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// move records one leg of the Transfer in the ledger.
+func (t Transfer) move(ctx context.Context, tx *sqlx.Tx, kind, locationID string, quantity int, actor string, now time.Time) error {
+	m := Movement{
+		ProductID:   t.ProductID,
+		VariantID:   t.VariantID,
+		LocationID:  locationID,
+		Kind:        kind,
+		Quantity:    quantity,
+		Reason:      "transfer " + t.ID,
+		Actor:       actor,
+		DateCreated: now,
+	}
+	if _, err := Record(ctx, tx, m); err != nil {
+		return errors.Wrap(err, "recording transfer movement")
+	}
+
+	return nil
+}
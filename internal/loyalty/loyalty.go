@@ -0,0 +1,701 @@
+// Package loyalty rewards customers with points for what they buy and lets
+// them pay for sales with the points.
+package loyalty
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/accounting"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/customer"
+	"github.com/vikramcse/the-service/internal/exchange"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/database"
+	"github.com/vikramcse/the-service/internal/product"
+)
+
+var (
+	ErrInvalidProgram     = errors.New("Points per unit, point value and expiry days must not be negative")
+	ErrInvalidMultiplier  = errors.New("Multiplier must not be negative")
+	ErrInvalidPoints      = errors.New("Points must not be negative")
+	ErrNoCustomer         = errors.New("Points can only be redeemed on sales to a customer")
+	ErrInsufficientPoints = errors.New("Customer does not have enough points")
+	ErrRedeemTooMuch      = errors.New("Points are worth more than the sale")
+)
+
+// Entity types changes to loyalty are audited as. Points earned and redeemed
+// are audited with the Sale they are for.
+const (
+	auditProgram    = "loyalty_program"
+	auditMultiplier = "loyalty_multiplier"
+)
+
+// Earned is the points earned for paying amount, in minor units of the
+// Program currency, on a Product with a multiplier in percent. Part points
+// are not given.
+func Earned(amount money.Money, pointsPerUnit, multiplier int) int {
+	if amount.Amount <= 0 {
+		return 0
+	}
+	unit := int(math.Pow10(amount.Exponent()))
+	return amount.Amount * pointsPerUnit * multiplier / (100 * unit)
+}
+
+// RetriveProgram gets the Program.
+func RetriveProgram(ctx context.Context, db sqlx.QueryerContext) (*Program, error) {
+	var p Program
+	const q = `
+		SELECT points_per_unit, point_value, currency, expiry_days, date_updated
+		FROM loyalty_program WHERE program_id = 1`
+
+	if err := sqlx.GetContext(ctx, db, &p, q); err != nil {
+		return nil, errors.Wrap(err, "selecting loyalty program")
+	}
+
+	return &p, nil
+}
+
+// SetProgram changes the Program. Points already earned keep the expiry they
+// were given.
+func SetProgram(ctx context.Context, db *sqlx.DB, np NewProgram, now time.Time) (*Program, error) {
+	if np.PointsPerUnit < 0 || np.PointValue < 0 || np.ExpiryDays < 0 {
+		return nil, ErrInvalidProgram
+	}
+	currency, err := money.Currency(np.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	p := Program{
+		PointsPerUnit: np.PointsPerUnit,
+		PointValue:    np.PointValue,
+		Currency:      currency,
+		ExpiryDays:    np.ExpiryDays,
+		DateUpdated:   now.UTC(),
+	}
+
+	const q = `
+		UPDATE loyalty_program SET
+		points_per_unit = $1, point_value = $2, currency = $3, expiry_days = $4, date_updated = $5
+		WHERE program_id = 1`
+
+	err = database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		before, err := RetriveProgram(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, q, p.PointsPerUnit, p.PointValue, p.Currency, p.ExpiryDays, p.DateUpdated)
+		if err != nil {
+			return errors.Wrap(err, "updating loyalty program")
+		}
+
+		c := audit.Change{EntityType: auditProgram, Action: audit.Update, Before: before, After: p}
+		_, err = audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// ListMultipliers gets every category Multiplier.
+func ListMultipliers(ctx context.Context, db *sqlx.DB) ([]Multiplier, error) {
+	list := []Multiplier{}
+
+	const q = `SELECT * FROM loyalty_multipliers ORDER BY category`
+	if err := db.SelectContext(ctx, &list, q); err != nil {
+		return nil, errors.Wrap(err, "selecting loyalty multipliers")
+	}
+
+	return list, nil
+}
+
+// SetMultiplier sets the Multiplier of a category, replacing any it had.
+func SetMultiplier(ctx context.Context, db *sqlx.DB, nm NewMultiplier, now time.Time) (*Multiplier, error) {
+	if nm.Multiplier < 0 {
+		return nil, ErrInvalidMultiplier
+	}
+
+	m := Multiplier{
+		Category:    strings.TrimSpace(nm.Category),
+		Multiplier:  nm.Multiplier,
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `
+		INSERT INTO loyalty_multipliers
+		(category, multiplier, date_updated)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (category)
+		DO UPDATE SET multiplier = EXCLUDED.multiplier, date_updated = EXCLUDED.date_updated`
+
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		c := audit.Change{EntityType: auditMultiplier, EntityID: m.Category, Action: audit.Create, After: m}
+
+		var before Multiplier
+		const qb = `SELECT * FROM loyalty_multipliers WHERE category = $1 FOR UPDATE`
+		switch err := tx.GetContext(ctx, &before, qb, m.Category); err {
+		case nil:
+			c.Action, c.Before = audit.Update, before
+		case sql.ErrNoRows:
+		default:
+			return errors.Wrap(err, "selecting loyalty multiplier")
+		}
+
+		if _, err := tx.ExecContext(ctx, q, m.Category, m.Multiplier, m.DateUpdated); err != nil {
+			return errors.Wrap(err, "inserting loyalty multiplier")
+		}
+
+		_, err := audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// BalanceOf gets the Balance of a customer at time now. Points due to expire
+// by now are expired first.
+func BalanceOf(ctx context.Context, db *sqlx.DB, customerID string, now time.Time) (*Balance, error) {
+	if err := customer.CheckCustomer(ctx, db, customerID); err != nil {
+		return nil, err
+	}
+
+	var b *Balance
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		if err := expire(ctx, tx, customerID, now); err != nil {
+			return err
+		}
+
+		p, err := RetriveProgram(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		var totals struct {
+			Points     int        `db:"points"`
+			NextExpiry *time.Time `db:"next_expiry"`
+		}
+		const q = `
+			SELECT
+				COALESCE(SUM(points), 0) as points,
+				MIN(expires_at) FILTER (WHERE kind IN ('earn', 'restore') AND remaining > 0) as next_expiry
+			FROM loyalty_entries
+			WHERE customer_id = $1`
+		if err := tx.GetContext(ctx, &totals, q, customerID); err != nil {
+			return errors.Wrap(err, "selecting points balance")
+		}
+
+		b = &Balance{CustomerID: customerID, Points: totals.Points, NextExpiry: totals.NextExpiry}
+		if b.Value, err = (money.Money{Amount: p.PointValue, Currency: p.Currency}).Mul(b.Points); err != nil {
+			return err
+		}
+
+		if b.NextExpiry != nil {
+			const q = `
+				SELECT COALESCE(SUM(remaining), 0) FROM loyalty_entries
+				WHERE customer_id = $1 AND kind IN ('earn', 'restore') AND expires_at = $2`
+			if err := tx.GetContext(ctx, &b.ExpiringPoints, q, customerID, *b.NextExpiry); err != nil {
+				return errors.Wrap(err, "selecting expiring points")
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// StatementOf gets the Statement of a customer at time now. Points due to
+// expire by now are expired first.
+func StatementOf(ctx context.Context, db *sqlx.DB, customerID string, now time.Time) (*Statement, error) {
+	if err := customer.CheckCustomer(ctx, db, customerID); err != nil {
+		return nil, err
+	}
+
+	st := Statement{CustomerID: customerID, Lines: []StatementLine{}}
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		if err := expire(ctx, tx, customerID, now); err != nil {
+			return err
+		}
+
+		var entries []Entry
+		const q = `
+			SELECT * FROM loyalty_entries
+			WHERE customer_id = $1
+			ORDER BY date_created, kind = 'earn'`
+		if err := tx.SelectContext(ctx, &entries, q, customerID); err != nil {
+			return errors.Wrap(err, "selecting points entries")
+		}
+
+		for _, e := range entries {
+			st.Points += e.Points
+			st.Lines = append(st.Lines, StatementLine{Entry: e, Balance: st.Points})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &st, nil
+}
+
+// ExpirePoints expires the earned points of every customer that were not
+// spent before they ran out at now. It returns the number of points expired.
+func ExpirePoints(ctx context.Context, db *sqlx.DB, now time.Time) (int, error) {
+	var n int
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		var err error
+		n, err = expireWhere(ctx, tx, "", now)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// Sweeper periodically expires points that ran out.
+type Sweeper struct {
+	DB       *sqlx.DB
+	Log      *log.Logger
+	Interval time.Duration
+}
+
+// Run expires points every Interval until ctx is cancelled.
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n, err := ExpirePoints(ctx, s.DB, time.Now())
+			if err != nil {
+				s.Log.Printf("loyalty: sweeping: %v", err)
+				continue
+			}
+			if n > 0 {
+				s.Log.Printf("loyalty: expired %d points", n)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// SaleHook earns points for Sales to a customer and lets them pay with the
+// points of NewSale. Points pay for part of the Sale after tax, so the hook
+// has to run after any hook that prices or taxes Sales. Points are earned on
+// what was not paid for with points. A Sale paid in a currency with no rate
+// into the Program currency earns no points.
+func SaleHook() product.SaleHook {
+	return product.SaleHook{Before: prepare, After: record}
+}
+
+// prepare is the Before step of SaleHook. It works out the points earned and
+// spent, checking the customer has the points they spend.
+func prepare(ctx context.Context, tx *sqlx.Tx, s *product.Sale, ns product.NewSale) error {
+	if ns.Points < 0 {
+		return ErrInvalidPoints
+	}
+	if s.CustomerID == nil {
+		if ns.Points > 0 {
+			return ErrNoCustomer
+		}
+		return nil
+	}
+
+	p, err := RetriveProgram(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	if ns.Points > 0 {
+		if err := expire(ctx, tx, *s.CustomerID, s.DateCreated); err != nil {
+			return err
+		}
+
+		earned, err := unspent(ctx, tx, *s.CustomerID)
+		if err != nil {
+			return err
+		}
+		var have int
+		for _, e := range earned {
+			have += e.Remaining
+		}
+		if have < ns.Points {
+			return ErrInsufficientPoints
+		}
+
+		worth, err := (money.Money{Amount: p.PointValue, Currency: p.Currency}).Mul(ns.Points)
+		if err != nil {
+			return err
+		}
+		value, _, err := exchange.Convert(ctx, tx, worth, s.Paid.Currency, s.DateCreated)
+		if err != nil {
+			return err
+		}
+		if value.Amount > s.Paid.Amount {
+			return ErrRedeemTooMuch
+		}
+
+		s.PointsRedeemed = ns.Points
+		s.PointsValue = value
+	}
+
+	rest, err := s.Paid.Sub(s.PointsValue)
+	if err != nil {
+		return err
+	}
+	rest, _, err = exchange.Convert(ctx, tx, rest, p.Currency, s.DateCreated)
+	switch err {
+	case nil:
+	case exchange.ErrNoRate:
+		return nil
+	default:
+		return err
+	}
+
+	multiplier, err := multiplierOf(ctx, tx, s.ProductID)
+	if err != nil {
+		return err
+	}
+	s.PointsEarned = Earned(rest, p.PointsPerUnit, multiplier)
+
+	return nil
+}
+
+// record is the After step of SaleHook. It writes the points earned and spent
+// by the Sale to the ledger.
+func record(ctx context.Context, tx *sqlx.Tx, s *product.Sale, ns product.NewSale) error {
+	if s.CustomerID == nil {
+		return nil
+	}
+
+	if s.PointsRedeemed > 0 {
+		if err := spend(ctx, tx, *s.CustomerID, s.PointsRedeemed); err != nil {
+			return err
+		}
+
+		e := Entry{
+			CustomerID:  *s.CustomerID,
+			SaleID:      &s.ID,
+			Kind:        Redeem,
+			Points:      -s.PointsRedeemed,
+			DateCreated: s.DateCreated,
+		}
+		if err := insert(ctx, tx, e); err != nil {
+			return err
+		}
+	}
+
+	if s.PointsEarned > 0 {
+		p, err := RetriveProgram(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		e := Entry{
+			CustomerID:  *s.CustomerID,
+			SaleID:      &s.ID,
+			Kind:        Earn,
+			Points:      s.PointsEarned,
+			Remaining:   s.PointsEarned,
+			DateCreated: s.DateCreated,
+		}
+		if p.ExpiryDays > 0 {
+			t := s.DateCreated.AddDate(0, 0, p.ExpiryDays)
+			e.ExpiresAt = &t
+		}
+		if err := insert(ctx, tx, e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RecordRefund takes back the points a Sale earned and gives back the points
+// spent on it, both in proportion to how much of what was paid for it in
+// money has been refunded, as part of tx. s is the Sale with the refund
+// counted, as product.RecordRefund gives it, so a Sale refunded in full has
+// all its points taken back and given back. Points the customer already
+// spent can not be taken back; a later refund of the Sale tries again.
+func RecordRefund(ctx context.Context, tx *sqlx.Tx, s *product.Sale, now time.Time) error {
+	if s.CustomerID == nil {
+		return nil
+	}
+
+	paid := s.Paid.Amount - s.PointsValue.Amount
+	if paid <= 0 {
+		return nil
+	}
+
+	if err := reverse(ctx, tx, s, paid, now); err != nil {
+		return err
+	}
+	return restore(ctx, tx, s, paid, now)
+}
+
+// reverse takes back the points Sale s earned in proportion to how much of
+// paid, what it was paid for in money, has been refunded.
+func reverse(ctx context.Context, tx *sqlx.Tx, s *product.Sale, paid int, now time.Time) error {
+	if s.PointsEarned == 0 {
+		return nil
+	}
+
+	due := (2*s.PointsEarned*s.Refunded.Amount + paid) / (2 * paid)
+
+	var reversed int
+	const q = `
+		SELECT COALESCE(-SUM(points), 0) FROM loyalty_entries
+		WHERE sale_id = $1 AND kind = 'reverse'`
+	if err := tx.GetContext(ctx, &reversed, q, s.ID); err != nil {
+		return errors.Wrap(err, "selecting reversed points")
+	}
+	if due <= reversed {
+		return nil
+	}
+
+	// The points the Sale earned are taken back before any others.
+	earned, err := unspent(ctx, tx, *s.CustomerID)
+	if err != nil {
+		return err
+	}
+	fromSale := func(e Entry) bool { return e.SaleID != nil && *e.SaleID == s.ID }
+	sort.SliceStable(earned, func(i, j int) bool {
+		return fromSale(earned[i]) && !fromSale(earned[j])
+	})
+
+	n, err := take(ctx, tx, earned, due-reversed)
+	if err != nil || n == 0 {
+		return err
+	}
+
+	e := Entry{
+		CustomerID:  *s.CustomerID,
+		SaleID:      &s.ID,
+		Kind:        Reverse,
+		Points:      -n,
+		DateCreated: now,
+	}
+	return insert(ctx, tx, e)
+}
+
+// restore gives back the points spent on Sale s in proportion to how much of
+// paid, what it was paid for in money, has been refunded. The points given
+// back expire as if earned at now, and what they paid for is posted as
+// returned.
+func restore(ctx context.Context, tx *sqlx.Tx, s *product.Sale, paid int, now time.Time) error {
+	if s.PointsRedeemed == 0 {
+		return nil
+	}
+
+	due := (2*s.PointsRedeemed*s.Refunded.Amount + paid) / (2 * paid)
+
+	var restored int
+	const q = `
+		SELECT COALESCE(SUM(points), 0) FROM loyalty_entries
+		WHERE sale_id = $1 AND kind = 'restore'`
+	if err := tx.GetContext(ctx, &restored, q, s.ID); err != nil {
+		return errors.Wrap(err, "selecting restored points")
+	}
+	if due <= restored {
+		return nil
+	}
+
+	p, err := RetriveProgram(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	e := Entry{
+		CustomerID:  *s.CustomerID,
+		SaleID:      &s.ID,
+		Kind:        Restore,
+		Points:      due - restored,
+		Remaining:   due - restored,
+		DateCreated: now,
+	}
+	if p.ExpiryDays > 0 {
+		t := now.AddDate(0, 0, p.ExpiryDays)
+		e.ExpiresAt = &t
+	}
+	if err := insert(ctx, tx, e); err != nil {
+		return err
+	}
+
+	// The value of the points is worked out from the total given back so
+	// far, so rounding does not add up over several refunds.
+	value := func(points int) int {
+		return (2*points*s.PointsValue.Amount + s.PointsRedeemed) / (2 * s.PointsRedeemed)
+	}
+	m := money.Money{Amount: value(due) - value(restored), Currency: s.PointsValue.Currency}
+	return accounting.RecordRestore(ctx, tx, s.ID, m, now)
+}
+
+// multiplierOf gets the Multiplier in percent for the category of a Product.
+// Categories without one earn the normal 100 percent.
+func multiplierOf(ctx context.Context, tx *sqlx.Tx, productID string) (int, error) {
+	var m int
+	const q = `
+		SELECT m.multiplier FROM loyalty_multipliers as m
+		JOIN products as p ON(p.category = m.category)
+		WHERE p.product_id = $1`
+
+	if err := tx.GetContext(ctx, &m, q, productID); err != nil {
+		if err == sql.ErrNoRows {
+			return 100, nil
+		}
+		return 0, errors.Wrap(err, "selecting loyalty multiplier")
+	}
+
+	return m, nil
+}
+
+// unspent locks and gets the earned Entries of a customer with points left,
+// in the order they are spent: soonest to expire first.
+func unspent(ctx context.Context, tx *sqlx.Tx, customerID string) ([]Entry, error) {
+	var entries []Entry
+	const q = `
+		SELECT * FROM loyalty_entries
+		WHERE customer_id = $1 AND kind IN ('earn', 'restore') AND remaining > 0
+		ORDER BY expires_at NULLS LAST, date_created
+		FOR UPDATE`
+
+	if err := tx.SelectContext(ctx, &entries, q, customerID); err != nil {
+		return nil, errors.Wrap(err, "selecting unspent points")
+	}
+
+	return entries, nil
+}
+
+// spend takes points off the earned Entries of a customer, soonest to expire
+// first.
+func spend(ctx context.Context, tx *sqlx.Tx, customerID string, points int) error {
+	earned, err := unspent(ctx, tx, customerID)
+	if err != nil {
+		return err
+	}
+
+	n, err := take(ctx, tx, earned, points)
+	if err != nil {
+		return err
+	}
+	if n < points {
+		return ErrInsufficientPoints
+	}
+
+	return nil
+}
+
+// take takes up to points off the earned Entries in order. It returns the
+// number of points taken, which is less than asked for when the Entries do
+// not have enough.
+func take(ctx context.Context, tx *sqlx.Tx, earned []Entry, points int) (int, error) {
+	var taken int
+
+	const q = `UPDATE loyalty_entries SET remaining = $2 WHERE entry_id = $1`
+	for _, e := range earned {
+		if taken == points {
+			break
+		}
+		n := e.Remaining
+		if n > points-taken {
+			n = points - taken
+		}
+		if _, err := tx.ExecContext(ctx, q, e.ID, e.Remaining-n); err != nil {
+			return 0, errors.Wrap(err, "taking points")
+		}
+		taken += n
+	}
+
+	return taken, nil
+}
+
+// expire expires the points of one customer that ran out by now.
+func expire(ctx context.Context, tx *sqlx.Tx, customerID string, now time.Time) error {
+	_, err := expireWhere(ctx, tx, customerID, now)
+	return err
+}
+
+// expireWhere records an Expire Entry for each earned Entry with points left
+// that ran out by now, for one customer or every customer when customerID is
+// empty. It returns the number of points expired.
+func expireWhere(ctx context.Context, tx *sqlx.Tx, customerID string, now time.Time) (int, error) {
+	var c interface{}
+	if customerID != "" {
+		c = customerID
+	}
+
+	var earned []Entry
+	const q = `
+		SELECT * FROM loyalty_entries
+		WHERE ($1::uuid IS NULL OR customer_id = $1)
+		AND kind IN ('earn', 'restore') AND remaining > 0 AND expires_at <= $2
+		FOR UPDATE`
+
+	if err := tx.SelectContext(ctx, &earned, q, c, now.UTC()); err != nil {
+		return 0, errors.Wrap(err, "selecting expired points")
+	}
+
+	var n int
+	const spent = `UPDATE loyalty_entries SET remaining = 0 WHERE entry_id = $1`
+	for _, e := range earned {
+		if _, err := tx.ExecContext(ctx, spent, e.ID); err != nil {
+			return 0, errors.Wrap(err, "expiring points")
+		}
+
+		x := Entry{
+			CustomerID:  e.CustomerID,
+			Kind:        Expire,
+			Points:      -e.Remaining,
+			DateCreated: *e.ExpiresAt,
+		}
+		if err := insert(ctx, tx, x); err != nil {
+			return 0, err
+		}
+		n += e.Remaining
+	}
+
+	return n, nil
+}
+
+// insert adds an Entry to the ledger.
+func insert(ctx context.Context, tx *sqlx.Tx, e Entry) error {
+	const q = `
+		INSERT INTO loyalty_entries
+		(entry_id, customer_id, sale_id, kind, points, remaining, expires_at, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := tx.ExecContext(ctx, q,
+		uuid.New().String(), e.CustomerID, e.SaleID, e.Kind, e.Points, e.Remaining,
+		e.ExpiresAt, e.DateCreated.UTC(),
+	)
+	if err != nil {
+		return errors.Wrap(err, "inserting points entry")
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package loyalty_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vikramcse/the-service/internal/customer"
+	"github.com/vikramcse/the-service/internal/giftcard"
+	"github.com/vikramcse/the-service/internal/loyalty"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/product"
+	"github.com/vikramcse/the-service/internal/tests"
+)
+
+func TestEarned(t *testing.T) {
+	tests := []struct {
+		amount     money.Money
+		perUnit    int
+		multiplier int
+		exp        int
+	}{
+		{money.Money{Amount: 1999, Currency: "USD"}, 1, 100, 19},
+		{money.Money{Amount: 1999, Currency: "USD"}, 1, 200, 39},
+		{money.Money{Amount: 1999, Currency: "USD"}, 5, 0, 0},
+		{money.Money{Amount: 500, Currency: "JPY"}, 1, 100, 500},
+		{money.Money{Amount: -1000, Currency: "USD"}, 1, 100, 0},
+	}
+
+	for _, tt := range tests {
+		if got := loyalty.Earned(tt.amount, tt.perUnit, tt.multiplier); got != tt.exp {
+			t.Errorf("earning on %v at %d x %d%%: expected %d, got %d", tt.amount, tt.perUnit, tt.multiplier, tt.exp, got)
+		}
+	}
+}
+
+func TestLoyalty(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	np := loyalty.NewProgram{PointsPerUnit: 1, PointValue: 1, Currency: "USD", ExpiryDays: 30}
+	if _, err := loyalty.SetProgram(ctx, db, np, now); err != nil {
+		t.Fatalf("setting program: %s", err)
+	}
+	if _, err := loyalty.SetMultiplier(ctx, db, loyalty.NewMultiplier{Category: "comics", Multiplier: 200}, now); err != nil {
+		t.Fatalf("setting multiplier: %s", err)
+	}
+
+	p, err := product.Create(ctx, db, product.NewProduct{Name: "Comic Book", Category: "comics", Cost: 1000, Quantity: 10}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	c, err := customer.Create(ctx, db, customer.NewCustomer{Name: "Jo Bloggs"}, now)
+	if err != nil {
+		t.Fatalf("creating customer: %s", err)
+	}
+
+	hook := loyalty.SaleHook()
+	sale := func(paid, points int) (*product.Sale, error) {
+		ns := product.NewSale{CustomerID: c.ID, Quantity: 1, Paid: &money.Money{Amount: paid}, Points: points}
+		return product.AddSale(ctx, db, ns, p.ID, now, hook)
+	}
+
+	s, err := sale(2000, 0)
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	if exp, got := 40, s.PointsEarned; exp != got {
+		t.Fatalf("expected %d points earned at double points, got %d", exp, got)
+	}
+
+	if _, err := sale(1000, 41); err != loyalty.ErrInsufficientPoints {
+		t.Fatalf("expected %v, got %v", loyalty.ErrInsufficientPoints, err)
+	}
+	if _, err := sale(30, 40); err != loyalty.ErrRedeemTooMuch {
+		t.Fatalf("expected %v, got %v", loyalty.ErrRedeemTooMuch, err)
+	}
+	ns := product.NewSale{Quantity: 1, Paid: &money.Money{Amount: 1000}, Points: 1}
+	if _, err := product.AddSale(ctx, db, ns, p.ID, now, hook); err != loyalty.ErrNoCustomer {
+		t.Fatalf("expected %v, got %v", loyalty.ErrNoCustomer, err)
+	}
+
+	if s, err = sale(1000, 40); err != nil {
+		t.Fatalf("adding sale paid with points: %s", err)
+	}
+	if exp := (money.Money{Amount: 40, Currency: "USD"}); s.PointsRedeemed != 40 || s.PointsValue != exp {
+		t.Fatalf("expected 40 points worth %v redeemed, got %d worth %v", exp, s.PointsRedeemed, s.PointsValue)
+	}
+	if exp, got := 19, s.PointsEarned; exp != got {
+		t.Fatalf("expected %d points earned on what was not paid with points, got %d", exp, got)
+	}
+
+	b, err := loyalty.BalanceOf(ctx, db, c.ID, now)
+	if err != nil {
+		t.Fatalf("getting balance: %s", err)
+	}
+	if b.Points != 19 || b.ExpiringPoints != 19 || b.Value.Amount != 19 {
+		t.Fatalf("expected 19 points worth 19 cents, got %+v", b)
+	}
+
+	// Refunding 500 of the 960 paid in money takes back about half of the
+	// points the sale earned and gives back about half of those spent on it.
+	refunded := now.Add(time.Hour)
+	if _, err := giftcard.RefundSale(ctx, db, giftcard.NewRefund{SaleID: s.ID, Amount: 500}, refunded); err != nil {
+		t.Fatalf("refunding sale: %s", err)
+	}
+	if b, err = loyalty.BalanceOf(ctx, db, c.ID, refunded); err != nil {
+		t.Fatalf("getting balance: %s", err)
+	}
+	if b.Points != 30 {
+		t.Fatalf("expected 10 of 19 points taken back and 21 of 40 given back, got %+v", b)
+	}
+
+	// The points are given back, not refunded as store credit, so no more
+	// than was paid in money can be refunded.
+	if _, err := giftcard.RefundSale(ctx, db, giftcard.NewRefund{SaleID: s.ID, Amount: 461}, refunded); err != product.ErrRefundTooMuch {
+		t.Fatalf("expected %v refunding what points paid for, got %v", product.ErrRefundTooMuch, err)
+	}
+	if _, err := giftcard.RefundSale(ctx, db, giftcard.NewRefund{SaleID: s.ID, Amount: 460}, refunded); err != nil {
+		t.Fatalf("refunding rest of sale: %s", err)
+	}
+	if b, err = loyalty.BalanceOf(ctx, db, c.ID, refunded); err != nil {
+		t.Fatalf("getting balance: %s", err)
+	}
+	if b.Points != 40 {
+		t.Fatalf("expected all 19 points earned taken back and all 40 spent given back, got %+v", b)
+	}
+
+	later := now.AddDate(0, 0, 31)
+	if b, err = loyalty.BalanceOf(ctx, db, c.ID, later); err != nil {
+		t.Fatalf("getting balance: %s", err)
+	}
+	if b.Points != 0 {
+		t.Fatalf("expected points to have expired, got %+v", b)
+	}
+
+	st, err := loyalty.StatementOf(ctx, db, c.ID, later)
+	if err != nil {
+		t.Fatalf("getting statement: %s", err)
+	}
+	// Entries made at the same time can be listed in any order, so only how
+	// many there are of each kind is checked.
+	kinds := map[string]int{}
+	for _, l := range st.Lines {
+		kinds[l.Kind]++
+	}
+	exp := map[string]int{
+		loyalty.Earn:    2,
+		loyalty.Redeem:  1,
+		loyalty.Reverse: 2,
+		loyalty.Restore: 2,
+		loyalty.Expire:  2,
+	}
+	if diff := cmp.Diff(exp, kinds); diff != "" {
+		t.Fatalf("statement entries by kind differ:\n%s", diff)
+	}
+	if last := st.Lines[len(st.Lines)-1]; last.Kind != loyalty.Expire || last.Balance != 0 {
+		t.Fatalf("expected the points given back to expire leaving none, got %+v", last)
+	}
+
+	// Without a rate into the program currency sales still go through but
+	// earn nothing.
+	np.Currency = "GBP"
+	if _, err := loyalty.SetProgram(ctx, db, np, later); err != nil {
+		t.Fatalf("setting program: %s", err)
+	}
+	ns = product.NewSale{CustomerID: c.ID, Quantity: 1, Paid: &money.Money{Amount: 1000}}
+	if s, err = product.AddSale(ctx, db, ns, p.ID, later, hook); err != nil {
+		t.Fatalf("adding sale without a rate: %s", err)
+	}
+	if s.PointsEarned != 0 {
+		t.Fatalf("expected no points earned without a rate, got %d", s.PointsEarned)
+	}
+}
@@ -0,0 +1,100 @@
+package loyalty
+
+import (
+	"time"
+
+	"github.com/vikramcse/the-service/internal/money"
+)
+
+// Kinds of Entry in the points ledger.
+const (
+	// Earn is points given for a Sale. Earned points expire unless the
+	// Program keeps them forever.
+	Earn = "earn"
+
+	// Redeem is points spent paying for a Sale.
+	Redeem = "redeem"
+
+	// Expire is earned points that were not spent in time.
+	Expire = "expire"
+
+	// Reverse is earned points taken back because the Sale they were
+	// earned on was refunded.
+	Reverse = "reverse"
+
+	// Restore is redeemed points given back because the Sale they paid for
+	// was refunded. Restored points are spent and expire like earned points.
+	Restore = "restore"
+)
+
+// Program holds how points are earned and what they are worth. A customer
+// earns PointsPerUnit points for each major unit of Currency they pay, and
+// each point pays for PointValue minor units of Currency. Points expire
+// ExpiryDays after they are earned, or never when it is zero.
+type Program struct {
+	PointsPerUnit int       `db:"points_per_unit" json:"points_per_unit"`
+	PointValue    int       `db:"point_value" json:"point_value"`
+	Currency      string    `db:"currency" json:"currency"`
+	ExpiryDays    int       `db:"expiry_days" json:"expiry_days"`
+	DateUpdated   time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// NewProgram is what we require from clients when changing the Program.
+type NewProgram struct {
+	PointsPerUnit int    `json:"points_per_unit"`
+	PointValue    int    `json:"point_value"`
+	Currency      string `json:"currency"`
+	ExpiryDays    int    `json:"expiry_days"`
+}
+
+// Multiplier scales the points earned on Products in Category. It is in
+// percent, so 200 earns double points and 0 earns none.
+type Multiplier struct {
+	Category    string    `db:"category" json:"category"`
+	Multiplier  int       `db:"multiplier" json:"multiplier"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// NewMultiplier is what we require from clients when setting a Multiplier.
+type NewMultiplier struct {
+	Category   string `json:"category"`
+	Multiplier int    `json:"multiplier"`
+}
+
+// Entry is one change to the points of a customer. Points are positive when
+// earned and negative when spent or expired. Remaining counts the earned
+// points not yet spent or expired.
+type Entry struct {
+	ID          string     `db:"entry_id" json:"id"`
+	CustomerID  string     `db:"customer_id" json:"customer_id"`
+	SaleID      *string    `db:"sale_id" json:"sale_id,omitempty"`
+	Kind        string     `db:"kind" json:"kind"`
+	Points      int        `db:"points" json:"points"`
+	Remaining   int        `db:"remaining" json:"-"`
+	ExpiresAt   *time.Time `db:"expires_at" json:"expires_at,omitempty"`
+	DateCreated time.Time  `db:"date_created" json:"date_created"`
+}
+
+// Balance is how many points a customer has and what they are worth.
+// ExpiringPoints of them expire at NextExpiry.
+type Balance struct {
+	CustomerID     string      `json:"customer_id"`
+	Points         int         `json:"points"`
+	Value          money.Money `json:"value"`
+	ExpiringPoints int         `json:"expiring_points,omitempty"`
+	NextExpiry     *time.Time  `json:"next_expiry,omitempty"`
+}
+
+// Statement is every Entry of a customer, oldest first, with the balance
+// after each. Points spent on a Sale come before the points it earned.
+type Statement struct {
+	CustomerID string          `json:"customer_id"`
+	Points     int             `json:"points"`
+	Lines      []StatementLine `json:"lines"`
+}
+
+// StatementLine is an Entry on a Statement.
+type StatementLine struct {
+	Entry
+	Balance int `json:"balance"`
+}
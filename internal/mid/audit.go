@@ -0,0 +1,143 @@
+package mid
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/platform/database"
+	"github.com/vikramcse/the-service/internal/platform/web"
+)
+
+// Audit attributes every POST, PUT, PATCH and DELETE to whoever the X-Actor
+// header names, or audit.Anonymous, and to the ID in the X-Request-ID header,
+// or a new one sent back in that header. Domain packages record the changes
+// they make, with the entity before and after, in the transaction making
+// them. A call that succeeds without recording any change, like a quote,
+// changed nothing and is recorded after it by its method and route, against
+// the entity routeEntities gives for the route.
+//
+// X-Actor is taken on trust: nothing authenticates it, so any client can
+// name any actor and the audit log only shows who a change was made as, not
+// who made it. Until the API authenticates its callers it must only be
+// reachable by trusted clients that set X-Actor themselves.
+func Audit(db *sqlx.DB) web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(before web.Handler) web.Handler {
+
+		h := func(w http.ResponseWriter, r *http.Request) error {
+			switch r.Method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			default:
+				return before(w, r)
+			}
+
+			v, ok := r.Context().Value(web.KeyValues).(*web.Values)
+			if !ok {
+				return errors.New("web value missing from context")
+			}
+
+			req := audit.Request{
+				ID:    r.Header.Get("X-Request-ID"),
+				Actor: r.Header.Get("X-Actor"),
+			}
+			if req.ID == "" {
+				req.ID = uuid.New().String()
+			}
+			if req.Actor == "" {
+				req.Actor = audit.Anonymous
+			}
+			w.Header().Set("X-Request-ID", req.ID)
+
+			ctx := audit.NewContext(r.Context(), &req)
+			if err := before(w, r.WithContext(ctx)); err != nil {
+				return err
+			}
+			if v.StatusCode >= http.StatusBadRequest || req.Recorded() {
+				return nil
+			}
+
+			c := audit.Change{Action: r.Method + " " + r.URL.Path}
+			if rc := chi.RouteContext(ctx); rc != nil {
+				c.Action = r.Method + " " + rc.RoutePattern()
+				c.EntityType, c.EntityID = routeEntity(rc)
+			}
+
+			err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+				_, err := audit.Record(ctx, tx, c, time.Now())
+				return err
+			})
+			return errors.Wrap(err, "auditing request")
+		}
+
+		return h
+	}
+
+	return f
+}
+
+// routeEntities names the entity each route that takes a POST, PUT, PATCH or
+// DELETE acts on, and the URL parameter that identifies it when the route has
+// one. Routes creating an entity have none, as it has no ID until created.
+var routeEntities = map[string]struct{ entity, param string }{
+	"/v1/products":                      {"product", ""},
+	"/v1/products/import":               {"product", ""},
+	"/v1/products/{id}/reorder":         {"product", "id"},
+	"/v1/products/{id}/sales":           {"sale", ""},
+	"/v1/products/{id}/variants":        {"product", "id"},
+	"/v1/products/{id}/prices":          {"product", "id"},
+	"/v1/products/{id}/currency-prices": {"product", "id"},
+	"/v1/products/{id}/adjustments":     {"movement", ""},
+	"/v1/locations":                     {"location", ""},
+	"/v1/transfers":                     {"transfer", ""},
+	"/v1/transfers/{id}/receive":        {"transfer", "id"},
+	"/v1/transfers/{id}/cancel":         {"transfer", "id"},
+	"/v1/reservations":                  {"reservation", ""},
+	"/v1/reservations/{id}/confirm":     {"reservation", "id"},
+	"/v1/reservations/{id}/release":     {"reservation", "id"},
+	"/v1/pricing/quote":                 {"quote", ""},
+	"/v1/pricing/promotions":            {"promotion", ""},
+	"/v1/pricing/coupons":               {"coupon", ""},
+	"/v1/tax/rates":                     {"tax_rate", ""},
+	"/v1/exchange-rates":                {"exchange_rate", ""},
+	"/v1/exchange-rates/import":         {"exchange_rate", ""},
+	"/v1/customers":                     {"customer", ""},
+	"/v1/customers/{id}":                {"customer", "id"},
+	"/v1/loyalty/program":               {"loyalty_program", ""},
+	"/v1/loyalty/multipliers":           {"loyalty_multiplier", ""},
+	"/v1/gift-cards":                    {"gift_card", ""},
+	"/v1/sales/{id}/refunds":            {"sale", "id"},
+	"/v1/sales/{id}/payments":           {"payment", ""},
+	"/v1/payments/{id}/refund":          {"payment", "id"},
+	"/v1/payments/{id}/void":            {"payment", "id"},
+	"/v1/registers":                     {"register", ""},
+	"/v1/registers/{id}/sessions":       {"register_session", ""},
+	"/v1/sessions/{id}/cash":            {"cash_movement", ""},
+	"/v1/sessions/{id}/close":           {"register_session", "id"},
+	"/v1/suppliers":                     {"supplier", ""},
+	"/v1/purchase-orders":               {"purchase_order", ""},
+	"/v1/purchase-orders/{id}/send":     {"purchase_order", "id"},
+	"/v1/purchase-orders/{id}/cancel":   {"purchase_order", "id"},
+	"/v1/purchase-orders/{id}/receive":  {"purchase_order", "id"},
+}
+
+// routeEntity gives the entity the route of rc acts on and its ID, both
+// empty for a route routeEntities does not list.
+func routeEntity(rc *chi.Context) (string, string) {
+	e, ok := routeEntities[rc.RoutePattern()]
+	if !ok {
+		return "", ""
+	}
+
+	var id string
+	if e.param != "" {
+		id = rc.URLParam(e.param)
+	}
+
+	return e.entity, id
+}
@@ -0,0 +1,242 @@
+// Package money represents amounts of money as a whole number of minor units,
+// such as cents, in an ISO 4217 currency. Arithmetic never mixes currencies
+// and never silently overflows.
+package money
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUnknownCurrency = errors.New("currency is not a known ISO 4217 code")
+	ErrMismatch        = errors.New("amounts are in different currencies")
+	ErrOverflow        = errors.New("amount is too large")
+	ErrInvalidRatios   = errors.New("ratios must not be negative and must not all be zero")
+	ErrInvalidRate     = errors.New("exchange rate must be positive")
+)
+
+// Limits of an int, which holds amounts.
+const (
+	maxAmount = int(^uint(0) >> 1)
+	minAmount = -maxAmount - 1
+)
+
+// exponents holds the number of minor units digits of the currencies we
+// accept. Currencies not listed here are rejected.
+var exponents = map[string]int{
+	"AED": 2, "AUD": 2, "BRL": 2, "CAD": 2, "CHF": 2, "CNY": 2, "CZK": 2,
+	"DKK": 2, "EUR": 2, "GBP": 2, "HKD": 2, "HUF": 2, "IDR": 2, "ILS": 2,
+	"INR": 2, "JPY": 0, "KRW": 0, "KWD": 3, "MXN": 2, "NOK": 2, "NZD": 2,
+	"PHP": 2, "PLN": 2, "SAR": 2, "SEK": 2, "SGD": 2, "THB": 2, "TRY": 2,
+	"USD": 2, "ZAR": 2,
+}
+
+// Money is an Amount of minor units of Currency. A Money of 1999 EUR is
+// €19.99.
+type Money struct {
+	Amount   int    `db:"amount" json:"amount"`
+	Currency string `db:"currency" json:"currency"`
+}
+
+// New gives a Money of amount minor units of currency. The currency code is
+// not case sensitive.
+func New(amount int, currency string) (Money, error) {
+	code, err := Currency(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: amount, Currency: code}, nil
+}
+
+// Currency gives the canonical form of an ISO 4217 code, or
+// ErrUnknownCurrency if it is not one we accept.
+func Currency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if _, ok := exponents[code]; !ok {
+		return "", ErrUnknownCurrency
+	}
+	return code, nil
+}
+
+// Exponent is the number of minor unit digits in the currency of m.
+func (m Money) Exponent() int {
+	return exponents[m.Currency]
+}
+
+// IsZero reports if m is an amount of nothing.
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Add gives m + o.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, ErrMismatch
+	}
+	if (o.Amount > 0 && m.Amount > maxAmount-o.Amount) ||
+		(o.Amount < 0 && m.Amount < minAmount-o.Amount) {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+// Sub gives m - o.
+func (m Money) Sub(o Money) (Money, error) {
+	if o.Amount == minAmount {
+		return Money{}, ErrOverflow
+	}
+	return m.Add(o.Neg())
+}
+
+// Mul gives m multiplied by n.
+func (m Money) Mul(n int) (Money, error) {
+	if m.Amount == 0 || n == 0 {
+		return Money{Currency: m.Currency}, nil
+	}
+	if (m.Amount == -1 && n == minAmount) || (n == -1 && m.Amount == minAmount) {
+		return Money{}, ErrOverflow
+	}
+
+	p := m.Amount * n
+	if p/n != m.Amount {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: p, Currency: m.Currency}, nil
+}
+
+// Neg gives -m.
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// Allocate splits m in proportion to ratios. Minor units left over from
+// rounding down are handed out one at a time from the first share, so the
+// shares always add back up to m.
+func (m Money) Allocate(ratios ...int) ([]Money, error) {
+	var total int
+	for _, r := range ratios {
+		if r < 0 {
+			return nil, ErrInvalidRatios
+		}
+		total += r
+	}
+	if total == 0 {
+		return nil, ErrInvalidRatios
+	}
+
+	shares := make([]Money, len(ratios))
+	remainder := m.Amount
+	for i, r := range ratios {
+		// Divide before multiplying where possible so large amounts do not
+		// overflow.
+		a := m.Amount/total*r + m.Amount%total*r/total
+		shares[i] = Money{Amount: a, Currency: m.Currency}
+		remainder -= a
+	}
+
+	step := 1
+	if remainder < 0 {
+		step = -1
+	}
+	for i := 0; remainder != 0; i = (i + 1) % len(shares) {
+		if ratios[i] == 0 {
+			continue
+		}
+		shares[i].Amount += step
+		remainder -= step
+	}
+
+	return shares, nil
+}
+
+// Split divides m into n shares as equal as possible.
+func (m Money) Split(n int) ([]Money, error) {
+	if n <= 0 {
+		return nil, ErrInvalidRatios
+	}
+	ratios := make([]int, n)
+	for i := range ratios {
+		ratios[i] = 1
+	}
+	return m.Allocate(ratios...)
+}
+
+// String formats m in major units, like "19.99 EUR".
+func (m Money) String() string {
+	exp := m.Exponent()
+	if exp == 0 {
+		return fmt.Sprintf("%d %s", m.Amount, m.Currency)
+	}
+
+	sign := ""
+	a := m.Amount
+	if a < 0 {
+		sign = "-"
+		a = -a
+	}
+	unit := int(math.Pow10(exp))
+	return fmt.Sprintf("%s%d.%0*d %s", sign, a/unit, exp, a%unit, m.Currency)
+}
+
+// Sum adds up amounts which must all be in currency.
+func Sum(currency string, amounts ...Money) (Money, error) {
+	total := Money{Currency: currency}
+	for _, a := range amounts {
+		var err error
+		if total, err = total.Add(a); err != nil {
+			return Money{}, err
+		}
+	}
+	return total, nil
+}
+
+// Convert changes m into currency to at rate, the number of major units of to
+// one major unit of the currency of m is worth. The result is rounded to the
+// nearest minor unit with halves rounded away from zero.
+func Convert(m Money, rate *big.Rat, to string) (Money, error) {
+	to, err := Currency(to)
+	if err != nil {
+		return Money{}, err
+	}
+	if rate.Sign() <= 0 {
+		return Money{}, ErrInvalidRate
+	}
+	if m.Currency == to {
+		return m, nil
+	}
+
+	// Scale the rate from major units to minor units of each currency.
+	r := new(big.Rat).Mul(rate, big.NewRat(int64(m.Amount), 1))
+	shift := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(exponents[to]-m.Exponent()))), nil)
+	if exponents[to] > m.Exponent() {
+		r.Mul(r, new(big.Rat).SetInt(shift))
+	} else {
+		r.Quo(r, new(big.Rat).SetInt(shift))
+	}
+
+	// Round half away from zero: add or take a half then truncate.
+	half := big.NewRat(1, 2)
+	if r.Sign() < 0 {
+		half.Neg(half)
+	}
+	r.Add(r, half)
+	amount := new(big.Int).Quo(r.Num(), r.Denom())
+	if !amount.IsInt64() || amount.Int64() > int64(maxAmount) || amount.Int64() < int64(minAmount) {
+		return Money{}, ErrOverflow
+	}
+
+	return Money{Amount: int(amount.Int64()), Currency: to}, nil
+}
+
+// abs gives the absolute value of n.
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
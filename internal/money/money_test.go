@@ -0,0 +1,124 @@
+package money_test
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/vikramcse/the-service/internal/money"
+)
+
+func TestArithmetic(t *testing.T) {
+	eur, err := money.New(1999, "eur")
+	if err != nil {
+		t.Fatalf("creating money: %s", err)
+	}
+	if _, err := money.New(1, "XYZ"); err != money.ErrUnknownCurrency {
+		t.Fatalf("expected %v, got %v", money.ErrUnknownCurrency, err)
+	}
+
+	sum, err := eur.Add(money.Money{Amount: 1, Currency: "EUR"})
+	if err != nil {
+		t.Fatalf("adding: %s", err)
+	}
+	if exp := (money.Money{Amount: 2000, Currency: "EUR"}); sum != exp {
+		t.Fatalf("expected %v, got %v", exp, sum)
+	}
+
+	if _, err := eur.Add(money.Money{Amount: 1, Currency: "USD"}); err != money.ErrMismatch {
+		t.Fatalf("expected %v, got %v", money.ErrMismatch, err)
+	}
+	if _, err := eur.Mul(int(^uint(0) >> 2)); err != money.ErrOverflow {
+		t.Fatalf("expected %v, got %v", money.ErrOverflow, err)
+	}
+
+	if exp, got := "19.99 EUR", eur.String(); exp != got {
+		t.Fatalf("expected %q, got %q", exp, got)
+	}
+	if exp, got := "-0.05 USD", (money.Money{Amount: -5, Currency: "USD"}).String(); exp != got {
+		t.Fatalf("expected %q, got %q", exp, got)
+	}
+	if exp, got := "500 JPY", (money.Money{Amount: 500, Currency: "JPY"}).String(); exp != got {
+		t.Fatalf("expected %q, got %q", exp, got)
+	}
+
+	b, err := json.Marshal(eur)
+	if err != nil {
+		t.Fatalf("encoding: %s", err)
+	}
+	if exp, got := `{"amount":1999,"currency":"EUR"}`, string(b); exp != got {
+		t.Fatalf("expected %s, got %s", exp, got)
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		amount int
+		ratios []int
+		exp    []int
+	}{
+		{100, []int{1, 1, 1}, []int{34, 33, 33}},
+		{5, []int{3, 7}, []int{2, 3}},
+		{-100, []int{1, 1, 1}, []int{-34, -33, -33}},
+		{10, []int{0, 1, 1}, []int{0, 5, 5}},
+		{1, []int{0, 1, 1}, []int{0, 1, 0}},
+	}
+
+	for _, tt := range tests {
+		m := money.Money{Amount: tt.amount, Currency: "USD"}
+		shares, err := m.Allocate(tt.ratios...)
+		if err != nil {
+			t.Fatalf("allocating %d by %v: %s", tt.amount, tt.ratios, err)
+		}
+
+		var total int
+		for i, s := range shares {
+			if s.Amount != tt.exp[i] {
+				t.Errorf("allocating %d by %v: expected %v, got %v", tt.amount, tt.ratios, tt.exp, shares)
+				break
+			}
+			total += s.Amount
+		}
+		if total != tt.amount {
+			t.Errorf("allocating %d by %v: shares add up to %d", tt.amount, tt.ratios, total)
+		}
+	}
+
+	if _, err := (money.Money{Amount: 1, Currency: "USD"}).Allocate(0, 0); err != money.ErrInvalidRatios {
+		t.Fatalf("expected %v, got %v", money.ErrInvalidRatios, err)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		from money.Money
+		rate string
+		to   string
+		exp  money.Money
+	}{
+		{money.Money{Amount: 1000, Currency: "EUR"}, "1.0873", "USD", money.Money{Amount: 1087, Currency: "USD"}},
+		{money.Money{Amount: 1999, Currency: "EUR"}, "1.5", "USD", money.Money{Amount: 2999, Currency: "USD"}}, // 2998.5 rounds up.
+		{money.Money{Amount: -1999, Currency: "EUR"}, "1.5", "USD", money.Money{Amount: -2999, Currency: "USD"}},
+		{money.Money{Amount: 1999, Currency: "EUR"}, "160.25", "JPY", money.Money{Amount: 3203, Currency: "JPY"}},
+		{money.Money{Amount: 500, Currency: "JPY"}, "0.0064", "EUR", money.Money{Amount: 320, Currency: "EUR"}},
+		{money.Money{Amount: 42, Currency: "USD"}, "1", "USD", money.Money{Amount: 42, Currency: "USD"}},
+	}
+
+	for _, tt := range tests {
+		rate, ok := new(big.Rat).SetString(tt.rate)
+		if !ok {
+			t.Fatalf("parsing rate %q", tt.rate)
+		}
+		got, err := money.Convert(tt.from, rate, tt.to)
+		if err != nil {
+			t.Fatalf("converting %v: %s", tt.from, err)
+		}
+		if got != tt.exp {
+			t.Errorf("converting %v at %s: expected %v, got %v", tt.from, tt.rate, tt.exp, got)
+		}
+	}
+
+	if _, err := money.Convert(money.Money{Amount: 1, Currency: "USD"}, big.NewRat(0, 1), "EUR"); err != money.ErrInvalidRate {
+		t.Fatalf("expected %v, got %v", money.ErrInvalidRate, err)
+	}
+}
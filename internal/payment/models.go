@@ -0,0 +1,81 @@
+package payment
+
+import (
+	"time"
+
+	"github.com/vikramcse/the-service/internal/money"
+)
+
+// Tenders a Sale can be paid with.
+const (
+	Cash     = "cash"
+	Card     = "card"
+	GiftCard = "gift_card"
+)
+
+// Statuses a Payment moves through. Card payments are authorized and then
+// captured; an authorized payment can be voided instead. Cash and gift card
+// payments are captured as soon as they are taken. Captured payments can be
+// refunded in part or in full. A card payment is Unknown when the Provider
+// did not answer in time, until Reconcile finds out what became of it.
+const (
+	Pending    = "pending"
+	Unknown    = "unknown"
+	Authorized = "authorized"
+	Captured   = "captured"
+	Declined   = "declined"
+	Failed     = "failed"
+	Voided     = "voided"
+	Refunded   = "refunded"
+)
+
+// Payment is one attempt to take payment for a Sale with one tender. Change
+// is what was handed back from cash, and Reference identifies the payment
+// with the Provider that handled it.
+type Payment struct {
+	ID          string      `db:"payment_id" json:"id"`
+	SaleID      string      `db:"sale_id" json:"sale_id"`
+	Tender      string      `db:"tender" json:"tender"`
+	Provider    string      `db:"provider" json:"provider,omitempty"`
+	Amount      money.Money `db:"amount" json:"amount"`
+	Change      money.Money `db:"change" json:"change"`
+	Refunded    money.Money `db:"refunded" json:"refunded"`
+	Status      string      `db:"status" json:"status"`
+	Reference   string      `db:"reference" json:"reference,omitempty"`
+	Message     string      `db:"message" json:"message,omitempty"`
+	DateCreated time.Time   `db:"date_created" json:"date_created"`
+	DateUpdated time.Time   `db:"date_updated" json:"date_updated"`
+}
+
+// NewPayment is what we require from clients to pay for a Sale. Together the
+// Tenders must cover what is left to pay; only cash can cover more, with the
+// difference given as change.
+type NewPayment struct {
+	Tenders []NewTender `json:"tenders"`
+}
+
+// NewTender is one way a customer pays part of a Sale. Amount is in minor
+// units of the currency of the Sale. Token identifies the card of a Card
+// tender to the Provider and Code is the code of a GiftCard tender.
+type NewTender struct {
+	Tender string `json:"tender"`
+	Amount int    `json:"amount"`
+	Token  string `json:"token"`
+	Code   string `json:"code"`
+}
+
+// Request asks a Provider to authorize a card payment.
+type Request struct {
+	Amount    money.Money
+	Token     string
+	PaymentID string
+}
+
+// Result is the answer of a Provider. Reference identifies the payment with
+// the Provider for later captures, refunds and voids. Status is only given
+// by Lookup and is Authorized, Captured or Voided.
+type Result struct {
+	Reference string
+	Message   string
+	Status    string
+}
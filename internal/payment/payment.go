@@ -0,0 +1,633 @@
+// Package payment records how sales are paid for, taking card payments
+// through a Provider.
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/accounting"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/giftcard"
+	"github.com/vikramcse/the-service/internal/loyalty"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/database"
+	"github.com/vikramcse/the-service/internal/product"
+)
+
+var (
+	ErrNotFound          = errors.New("Payment not found")
+	ErrSaleNotFound      = errors.New("Sale not found")
+	ErrInvalidID         = errors.New("ID is not in it's proper form")
+	ErrNoTenders         = errors.New("Payment must have at least one tender")
+	ErrInvalidTender     = errors.New("Tender must be cash, card with a token or gift_card with a code")
+	ErrInvalidAmount     = errors.New("Amount must be positive")
+	ErrUnderpaid         = errors.New("Tenders do not cover what is owed")
+	ErrOverpaid          = errors.New("Only cash can pay more than is owed")
+	ErrInvalidTransition = errors.New("Payment can not do that in its status")
+	ErrRefundTooMuch     = errors.New("Refund is more than was paid")
+	ErrPaid              = errors.New("Sale is already paid for")
+)
+
+// auditEntity is the entity type changes to Payments are audited as.
+const auditEntity = "payment"
+
+// Pay takes payment for what is left to pay on a Sale with the tenders of np.
+// Cards are authorized first and captured last, so a declined card leaves
+// nothing taken: cards already authorized are voided and no cash or gift
+// card is recorded. Every attempt is kept, including declined ones. A card
+// the Provider did not answer for in time is left Unknown for Reconcile.
+func Pay(ctx context.Context, db *sqlx.DB, p Provider, saleID string, np NewPayment, now time.Time) ([]Payment, error) {
+	if _, err := uuid.Parse(saleID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if len(np.Tenders) == 0 {
+		return nil, ErrNoTenders
+	}
+	for _, t := range np.Tenders {
+		if t.Amount <= 0 {
+			return nil, ErrInvalidAmount
+		}
+		switch {
+		case t.Tender == Cash:
+		case t.Tender == Card && t.Token != "":
+		case t.Tender == GiftCard && t.Code != "":
+		default:
+			return nil, ErrInvalidTender
+		}
+	}
+
+	due, err := Due(ctx, db, saleID)
+	if err != nil {
+		return nil, err
+	}
+	payments, err := split(np.Tenders, due, saleID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// abort voids the cards authorized so far after err, which is what
+	// matters to the caller.
+	var authorized []*Payment
+	abort := func(err error) error {
+		if verr := voidAll(ctx, db, p, authorized, now); verr != nil {
+			return errors.Wrapf(err, "voiding: %v", verr)
+		}
+		return err
+	}
+
+	// Authorize every card before anything else is taken.
+	for i := range payments {
+		pm := &payments[i]
+		if pm.Tender != Card {
+			continue
+		}
+
+		pm.Provider = p.Name()
+		err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+			return insert(ctx, tx, pm, now)
+		})
+		if err != nil {
+			return nil, abort(err)
+		}
+
+		res, err := p.Authorize(ctx, Request{Amount: pm.Amount, Token: np.Tenders[i].Token, PaymentID: pm.ID})
+		if err != nil {
+			pm.Status, pm.Message = failure(err)
+			if uerr := save(ctx, db, pm, now); uerr != nil {
+				err = errors.Wrapf(err, "recording failure: %v", uerr)
+			}
+			return nil, abort(err)
+		}
+
+		pm.Status, pm.Reference, pm.Message = Authorized, res.Reference, res.Message
+		authorized = append(authorized, pm)
+		if err := save(ctx, db, pm, now); err != nil {
+			return nil, abort(err)
+		}
+	}
+
+	// Take cash and gift cards together, making sure nobody paid for the
+	// Sale in the meantime.
+	err = database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		const lock = `SELECT true FROM sales WHERE sale_id = $1 FOR UPDATE`
+		var locked bool
+		if err := tx.GetContext(ctx, &locked, lock, saleID); err != nil {
+			return errors.Wrap(err, "locking sale")
+		}
+
+		left, err := Due(ctx, tx, saleID)
+		if err != nil {
+			return err
+		}
+		for _, pm := range authorized {
+			left.Amount += pm.Amount.Amount
+		}
+		if left != due {
+			return ErrOverpaid
+		}
+
+		for i := range payments {
+			pm := &payments[i]
+			switch pm.Tender {
+			case Cash:
+			case GiftCard:
+				c, err := giftcard.Spend(ctx, tx, np.Tenders[i].Code, pm.Amount, saleID, now)
+				if err != nil {
+					return err
+				}
+				pm.Reference = c.Code
+			default:
+				continue
+			}
+
+			pm.Status = Captured
+			if err := record(ctx, tx, pm, now); err != nil {
+				return err
+			}
+			if err := insert(ctx, tx, pm, now); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, abort(err)
+	}
+
+	for _, pm := range authorized {
+		res, err := p.Capture(ctx, pm.Reference, pm.Amount)
+		if err != nil {
+			pm.Status, pm.Message = failure(err)
+			if uerr := save(ctx, db, pm, now); uerr != nil {
+				err = errors.Wrapf(err, "recording failure: %v", uerr)
+			}
+			return nil, errors.Wrapf(err, "capturing payment %s", pm.ID)
+		}
+
+		pm.Status, pm.Message = Captured, res.Message
+		err = database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+			if err := record(ctx, tx, pm, now); err != nil {
+				return err
+			}
+			return update(ctx, tx, pm, now)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return payments, nil
+}
+
+// Due gets what is left to pay for a Sale: what it costs less loyalty points,
+// gift cards taken with it and Payments authorized or captured for it.
+// Unknown Payments count as taken until reconciled, so a card that may have
+// been charged is not charged again.
+func Due(ctx context.Context, db sqlx.QueryerContext, saleID string) (money.Money, error) {
+	var s struct {
+		Owed     int    `db:"owed"`
+		Currency string `db:"currency"`
+		Taken    int    `db:"taken"`
+	}
+	const q = `
+		SELECT
+			s.paid - s.points_value - s.gift_card_paid as owed,
+			s.currency,
+			COALESCE((SELECT SUM(p.amount - p.change) FROM payments as p
+				WHERE p.sale_id = s.sale_id
+				AND p.status IN ('authorized', 'captured', 'refunded', 'unknown')), 0) as taken
+		FROM sales as s
+		WHERE s.sale_id = $1`
+
+	if err := sqlx.GetContext(ctx, db, &s, q, saleID); err != nil {
+		if err == sql.ErrNoRows {
+			return money.Money{}, ErrSaleNotFound
+		}
+		return money.Money{}, errors.Wrap(err, "selecting amount due")
+	}
+
+	return money.Money{Amount: s.Owed - s.Taken, Currency: s.Currency}, nil
+}
+
+// List gets every Payment attempted for a Sale, oldest first.
+func List(ctx context.Context, db *sqlx.DB, saleID string) ([]Payment, error) {
+	if _, err := uuid.Parse(saleID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	payments := []Payment{}
+	const q = `SELECT ` + columns + ` FROM payments WHERE sale_id = $1 ORDER BY date_created`
+	if err := db.SelectContext(ctx, &payments, q, saleID); err != nil {
+		return nil, errors.Wrap(err, "selecting payments")
+	}
+
+	return payments, nil
+}
+
+// Retrive finds the Payment identified by id.
+func Retrive(ctx context.Context, db *sqlx.DB, id string) (*Payment, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	return retrive(ctx, db, id, false)
+}
+
+// Refund gives back amount of a captured Payment the way it was paid: to the
+// card through the Provider, in cash, or as store credit onto the gift card.
+// The Payment and its Sale are locked from when the refund is checked until
+// it is stored, and the card is refunded once nothing can refuse it, so
+// nothing is given back twice or given back by the Provider for a refund that
+// was then refused. The card is refunded before anything is audited so the
+// audit log is not held while the Provider answers.
+func Refund(ctx context.Context, db *sqlx.DB, p Provider, id string, amount int, now time.Time) (*Payment, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+	if amount <= 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	var pm *Payment
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		var err error
+		if pm, err = retrive(ctx, tx, id, true); err != nil {
+			return err
+		}
+		if pm.Status != Captured {
+			return ErrInvalidTransition
+		}
+
+		net := pm.Amount.Amount - pm.Change.Amount
+		if pm.Refunded.Amount+amount > net {
+			return ErrRefundTooMuch
+		}
+		m := money.Money{Amount: amount, Currency: pm.Amount.Currency}
+
+		if pm.Tender == Card {
+			if err := refundable(ctx, tx, pm.SaleID, amount); err != nil {
+				return err
+			}
+			res, err := p.Refund(ctx, pm.Reference, m)
+			if err != nil {
+				return errors.Wrapf(err, "refunding payment %s", pm.ID)
+			}
+			pm.Message = res.Message
+		}
+
+		switch pm.Tender {
+		case GiftCard:
+			// Store credit is counted against the Sale and posted when the
+			// gift card is credited.
+			nr := giftcard.NewRefund{SaleID: pm.SaleID, Amount: amount, Code: pm.Reference}
+			if _, err := giftcard.Credit(ctx, tx, nr, now); err != nil {
+				return err
+			}
+		default:
+			s, err := product.RecordRefund(ctx, tx, pm.SaleID, amount, 0, now)
+			if err != nil {
+				return err
+			}
+			if err := loyalty.RecordRefund(ctx, tx, s, now); err != nil {
+				return err
+			}
+			if err := accounting.RecordRefund(ctx, tx, pm.SaleID, pm.ID, account(pm.Tender), m, now); err != nil {
+				return err
+			}
+		}
+
+		pm.Refunded.Amount += amount
+		if pm.Refunded.Amount == net {
+			pm.Status = Refunded
+		}
+		return update(ctx, tx, pm, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pm, nil
+}
+
+// Reconcile asks the Provider what became of every Unknown Payment. Payments
+// it has no record of failed and authorizations are voided, since the Sale
+// was never paid in full with them. Captured payments are recorded as taken.
+// It returns how many Payments were reconciled.
+func Reconcile(ctx context.Context, db *sqlx.DB, p Provider, now time.Time) (int, error) {
+	var payments []Payment
+	const q = `SELECT ` + columns + ` FROM payments WHERE status = $1 ORDER BY date_created`
+	if err := db.SelectContext(ctx, &payments, q, Unknown); err != nil {
+		return 0, errors.Wrap(err, "selecting unknown payments")
+	}
+
+	var n int
+	for i := range payments {
+		pm := &payments[i]
+
+		res, err := p.Lookup(ctx, pm.ID)
+		switch {
+		case errors.Cause(err) == ErrNoRecord:
+			pm.Status, pm.Message = Failed, err.Error()
+		case err != nil:
+			return n, errors.Wrapf(err, "looking up payment %s", pm.ID)
+		case res.Status == Authorized:
+			vres, err := p.Void(ctx, res.Reference)
+			if err != nil {
+				return n, errors.Wrapf(err, "voiding payment %s", pm.ID)
+			}
+			pm.Status, pm.Reference, pm.Message = Voided, res.Reference, vres.Message
+		default:
+			pm.Status, pm.Reference, pm.Message = res.Status, res.Reference, res.Message
+		}
+
+		err = database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+			locked, err := retrive(ctx, tx, pm.ID, true)
+			if err != nil {
+				return err
+			}
+			if locked.Status != Unknown {
+				return nil
+			}
+
+			if pm.Status == Captured {
+				if err := record(ctx, tx, pm, now); err != nil {
+					return err
+				}
+			}
+			if err := update(ctx, tx, pm, now); err != nil {
+				return err
+			}
+			n++
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// Reconciler periodically reconciles Unknown Payments with a Provider.
+type Reconciler struct {
+	DB       *sqlx.DB
+	Log      *log.Logger
+	Provider Provider
+	Interval time.Duration
+}
+
+// Run reconciles Payments every Interval until ctx is cancelled.
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n, err := Reconcile(ctx, r.DB, r.Provider, time.Now())
+			if err != nil {
+				r.Log.Printf("payment: reconciling: %v", err)
+				continue
+			}
+			if n > 0 {
+				r.Log.Printf("payment: reconciled %d payments", n)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// record posts a captured Payment to the journal.
+func record(ctx context.Context, tx *sqlx.Tx, pm *Payment, now time.Time) error {
+	m := money.Money{Amount: pm.Amount.Amount - pm.Change.Amount, Currency: pm.Amount.Currency}
+	return accounting.RecordPayment(ctx, tx, pm.ID, account(pm.Tender), m, now)
+}
+
+// account gives the account of the journal money taken in a tender goes to.
+func account(tender string) string {
+	switch tender {
+	case Card:
+		return accounting.CardClearing
+	case GiftCard:
+		return accounting.GiftCardLiability
+	default:
+		return accounting.Cash
+	}
+}
+
+// Void releases a card Payment that was authorized but not captured.
+func Void(ctx context.Context, db *sqlx.DB, p Provider, id string, now time.Time) (*Payment, error) {
+	pm, err := Retrive(ctx, db, id)
+	if err != nil {
+		return nil, err
+	}
+	if pm.Status != Authorized {
+		return nil, ErrInvalidTransition
+	}
+
+	res, err := p.Void(ctx, pm.Reference)
+	if err != nil {
+		return nil, errors.Wrapf(err, "voiding payment %s", pm.ID)
+	}
+
+	pm.Status, pm.Message = Voided, res.Message
+	if err := save(ctx, db, pm, now); err != nil {
+		return nil, err
+	}
+
+	return pm, nil
+}
+
+// split turns tenders into Payments of what is due. Only cash may take the
+// total over what is due, and the change comes out of the last cash tender.
+func split(tenders []NewTender, due money.Money, saleID string, now time.Time) ([]Payment, error) {
+	if due.Amount <= 0 {
+		return nil, ErrPaid
+	}
+
+	var total, nonCash, lastCash int
+	lastCash = -1
+	for i, t := range tenders {
+		total += t.Amount
+		if t.Tender == Cash {
+			lastCash = i
+		} else {
+			nonCash += t.Amount
+		}
+	}
+	if nonCash > due.Amount {
+		return nil, ErrOverpaid
+	}
+	if total < due.Amount {
+		return nil, ErrUnderpaid
+	}
+
+	payments := make([]Payment, len(tenders))
+	for i, t := range tenders {
+		payments[i] = Payment{
+			ID:          uuid.New().String(),
+			SaleID:      saleID,
+			Tender:      t.Tender,
+			Amount:      money.Money{Amount: t.Amount, Currency: due.Currency},
+			Change:      money.Money{Currency: due.Currency},
+			Refunded:    money.Money{Currency: due.Currency},
+			Status:      Pending,
+			DateCreated: now.UTC(),
+			DateUpdated: now.UTC(),
+		}
+	}
+	if lastCash >= 0 {
+		payments[lastCash].Change.Amount = total - due.Amount
+	}
+
+	return payments, nil
+}
+
+// failure gives the status and message to record for an error from a
+// Provider. A timeout leaves what the Provider did unknown.
+func failure(err error) (string, string) {
+	switch errors.Cause(err) {
+	case ErrDeclined:
+		return Declined, err.Error()
+	case ErrTimeout:
+		return Unknown, err.Error()
+	default:
+		return Failed, err.Error()
+	}
+}
+
+// voidAll voids authorized card Payments after another tender failed. Errors
+// voiding are recorded on the Payment. Every Payment is tried and the first
+// error storing one is returned.
+func voidAll(ctx context.Context, db *sqlx.DB, p Provider, payments []*Payment, now time.Time) error {
+	var first error
+	for _, pm := range payments {
+		res, err := p.Void(ctx, pm.Reference)
+		if err != nil {
+			pm.Message = "voiding: " + err.Error()
+		} else {
+			pm.Status, pm.Message = Voided, res.Message
+		}
+		if err := save(ctx, db, pm, now); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// columns selects a Payment from the payments table.
+const columns = `
+			payment_id, sale_id, tender, provider,
+			amount as "amount.amount", currency as "amount.currency",
+			change as "change.amount", currency as "change.currency",
+			refunded as "refunded.amount", currency as "refunded.currency",
+			status, reference, message, date_created, date_updated`
+
+// retrive finds the Payment identified by id, locking it until the end of
+// the transaction when forUpdate is set.
+func retrive(ctx context.Context, db sqlx.QueryerContext, id string, forUpdate bool) (*Payment, error) {
+	q := `SELECT ` + columns + ` FROM payments WHERE payment_id = $1`
+	if forUpdate {
+		q += ` FOR UPDATE`
+	}
+
+	var pm Payment
+	if err := sqlx.GetContext(ctx, db, &pm, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting payment %q", id)
+	}
+
+	return &pm, nil
+}
+
+// refundable locks a Sale and returns ErrRefundTooMuch when amount more can
+// not be refunded for it.
+func refundable(ctx context.Context, tx *sqlx.Tx, saleID string, amount int) error {
+	var left int
+	const q = `SELECT paid - points_value - refunded FROM sales WHERE sale_id = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &left, q, saleID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrSaleNotFound
+		}
+		return errors.Wrap(err, "selecting refundable amount")
+	}
+	if amount > left {
+		return ErrRefundTooMuch
+	}
+
+	return nil
+}
+
+// insert stores a new Payment as part of tx.
+func insert(ctx context.Context, tx *sqlx.Tx, pm *Payment, now time.Time) error {
+	const q = `
+		INSERT INTO payments
+		(payment_id, sale_id, tender, provider, currency, amount, change, refunded,
+		status, reference, message, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
+
+	_, err := tx.ExecContext(ctx, q,
+		pm.ID, pm.SaleID, pm.Tender, pm.Provider, pm.Amount.Currency, pm.Amount.Amount,
+		pm.Change.Amount, pm.Refunded.Amount, pm.Status, pm.Reference, pm.Message,
+		pm.DateCreated, pm.DateUpdated,
+	)
+	if err != nil {
+		return errors.Wrap(err, "inserting payment")
+	}
+
+	c := audit.Change{EntityType: auditEntity, EntityID: pm.ID, Action: audit.Create, After: audited(*pm)}
+	_, err = audit.Record(ctx, tx, c, now)
+	return err
+}
+
+// update stores the status, refunds and provider details of a Payment as
+// part of tx.
+func update(ctx context.Context, tx *sqlx.Tx, pm *Payment, now time.Time) error {
+	before, err := retrive(ctx, tx, pm.ID, true)
+	if err != nil {
+		return err
+	}
+	pm.DateUpdated = now.UTC()
+
+	const q = `
+		UPDATE payments SET
+		status = $2, refunded = $3, reference = $4, message = $5, date_updated = $6
+		WHERE payment_id = $1`
+
+	_, err = tx.ExecContext(ctx, q, pm.ID, pm.Status, pm.Refunded.Amount, pm.Reference, pm.Message, pm.DateUpdated)
+	if err != nil {
+		return errors.Wrapf(err, "updating payment %s", pm.ID)
+	}
+
+	c := audit.Change{EntityType: auditEntity, EntityID: pm.ID, Action: audit.Update, Before: audited(*before), After: audited(*pm)}
+	_, err = audit.Record(ctx, tx, c, now)
+	return err
+}
+
+// audited is pm as it is recorded in the audit log. The reference of a gift
+// card Payment is the code of the card, which is all it takes to spend it, so
+// it is left out.
+func audited(pm Payment) Payment {
+	if pm.Tender == GiftCard {
+		pm.Reference = ""
+	}
+	return pm
+}
+
+// save runs update in a transaction of its own.
+func save(ctx context.Context, db *sqlx.DB, pm *Payment, now time.Time) error {
+	return database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		return update(ctx, tx, pm, now)
+	})
+}
@@ -0,0 +1,159 @@
+package payment_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/payment"
+	"github.com/vikramcse/the-service/internal/product"
+	"github.com/vikramcse/the-service/internal/tests"
+)
+
+func TestFake(t *testing.T) {
+	ctx := context.Background()
+	f := payment.NewFake()
+	amount := money.Money{Amount: 1000, Currency: "USD"}
+
+	f.Script(payment.Decline, payment.Timeout)
+	if _, err := f.Authorize(ctx, payment.Request{Amount: amount}); err != payment.ErrDeclined {
+		t.Fatalf("expected %v, got %v", payment.ErrDeclined, err)
+	}
+	if _, err := f.Authorize(ctx, payment.Request{Amount: amount}); err != payment.ErrTimeout {
+		t.Fatalf("expected %v, got %v", payment.ErrTimeout, err)
+	}
+
+	res, err := f.Authorize(ctx, payment.Request{Amount: amount})
+	if err != nil {
+		t.Fatalf("authorizing: %s", err)
+	}
+	if exp := "fake_000001"; res.Reference != exp {
+		t.Fatalf("expected reference %q, got %q", exp, res.Reference)
+	}
+
+	more := money.Money{Amount: 1001, Currency: "USD"}
+	if _, err := f.Capture(ctx, res.Reference, more); errors.Cause(err) != payment.ErrDeclined {
+		t.Fatalf("expected capturing more than authorized to be declined, got %v", err)
+	}
+	if _, err := f.Capture(ctx, res.Reference, amount); err != nil {
+		t.Fatalf("capturing: %s", err)
+	}
+	if _, err := f.Void(ctx, res.Reference); err == nil {
+		t.Fatal("expected voiding a captured payment to fail")
+	}
+	if _, err := f.Refund(ctx, res.Reference, more); errors.Cause(err) != payment.ErrDeclined {
+		t.Fatalf("expected refunding more than captured to be declined, got %v", err)
+	}
+
+	if res, err = f.Lookup(ctx, ""); err != nil || res.Status != payment.Captured {
+		t.Fatalf("expected the payment captured, got %+v, %v", res, err)
+	}
+	if _, err := f.Lookup(ctx, "missing"); err != payment.ErrNoRecord {
+		t.Fatalf("expected %v, got %v", payment.ErrNoRecord, err)
+	}
+}
+
+func TestPay(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	p, err := product.Create(ctx, db, product.NewProduct{Name: "Comic Book", Cost: 5000, Quantity: 10}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	newSale := func() *product.Sale {
+		s, err := product.AddSale(ctx, db, product.NewSale{Quantity: 1, Paid: &money.Money{Amount: 5000}}, p.ID, now)
+		if err != nil {
+			t.Fatalf("adding sale: %s", err)
+		}
+		return s
+	}
+	f := payment.NewFake()
+
+	s := newSale()
+	np := payment.NewPayment{Tenders: []payment.NewTender{
+		{Tender: payment.Card, Amount: 3000, Token: "tok_visa"},
+		{Tender: payment.Cash, Amount: 2500},
+	}}
+	list, err := payment.Pay(ctx, db, f, s.ID, np, now)
+	if err != nil {
+		t.Fatalf("paying: %s", err)
+	}
+	if list[0].Status != payment.Captured || list[1].Status != payment.Captured || list[1].Change.Amount != 500 {
+		t.Fatalf("expected both tenders captured with 500 change, got %+v", list)
+	}
+	if _, err := payment.Pay(ctx, db, f, s.ID, payment.NewPayment{Tenders: []payment.NewTender{{Tender: payment.Cash, Amount: 1}}}, now); err != payment.ErrPaid {
+		t.Fatalf("expected %v, got %v", payment.ErrPaid, err)
+	}
+
+	pm, err := payment.Refund(ctx, db, f, list[0].ID, 3000, now)
+	if err != nil {
+		t.Fatalf("refunding: %s", err)
+	}
+	if pm.Status != payment.Refunded {
+		t.Fatalf("expected status %q, got %q", payment.Refunded, pm.Status)
+	}
+
+	// A declined second card voids the first and takes no cash.
+	s = newSale()
+	f.Script(payment.Approve, payment.Decline)
+	np = payment.NewPayment{Tenders: []payment.NewTender{
+		{Tender: payment.Card, Amount: 2000, Token: "tok_visa"},
+		{Tender: payment.Card, Amount: 2000, Token: "tok_amex"},
+		{Tender: payment.Cash, Amount: 1000},
+	}}
+	if _, err := payment.Pay(ctx, db, f, s.ID, np, now); err != payment.ErrDeclined {
+		t.Fatalf("expected %v, got %v", payment.ErrDeclined, err)
+	}
+	if list, err = payment.List(ctx, db, s.ID); err != nil {
+		t.Fatalf("listing payments: %s", err)
+	}
+	statuses := map[string]bool{}
+	for _, pm := range list {
+		statuses[pm.Status] = true
+	}
+	if len(list) != 2 || !statuses[payment.Voided] || !statuses[payment.Declined] {
+		t.Fatalf("expected a voided and a declined attempt, got %+v", list)
+	}
+	due, err := payment.Due(ctx, db, s.ID)
+	if err != nil {
+		t.Fatalf("getting amount due: %s", err)
+	}
+	if due.Amount != 5000 {
+		t.Fatalf("expected nothing taken, got %v due", due)
+	}
+
+	// A card the provider did not answer for might have been charged, so it
+	// counts as taken until reconciled.
+	s = newSale()
+	f.Script(payment.Timeout)
+	np = payment.NewPayment{Tenders: []payment.NewTender{{Tender: payment.Card, Amount: 5000, Token: "tok_visa"}}}
+	if _, err := payment.Pay(ctx, db, f, s.ID, np, now); err != payment.ErrTimeout {
+		t.Fatalf("expected %v, got %v", payment.ErrTimeout, err)
+	}
+	if due, err = payment.Due(ctx, db, s.ID); err != nil || due.Amount != 0 {
+		t.Fatalf("expected nothing due before reconciling, got %v, %v", due, err)
+	}
+
+	n, err := payment.Reconcile(ctx, db, f, now)
+	if err != nil {
+		t.Fatalf("reconciling: %s", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 payment reconciled, got %d", n)
+	}
+	if list, err = payment.List(ctx, db, s.ID); err != nil {
+		t.Fatalf("listing payments: %s", err)
+	}
+	if len(list) != 1 || list[0].Status != payment.Failed {
+		t.Fatalf("expected the payment the provider never saw to fail, got %+v", list)
+	}
+	if due, err = payment.Due(ctx, db, s.ID); err != nil || due.Amount != 5000 {
+		t.Fatalf("expected 5000 due after reconciling, got %v, %v", due, err)
+	}
+}
@@ -0,0 +1,210 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/money"
+)
+
+var (
+	ErrDeclined = errors.New("Payment was declined")
+	ErrTimeout  = errors.New("Payment provider did not answer in time")
+	ErrNoRecord = errors.New("Payment provider has no record of the payment")
+)
+
+// Provider takes card payments. Implementations return ErrDeclined when the
+// card issuer refuses a payment and ErrTimeout when the outcome is unknown;
+// either may be wrapped with more detail.
+type Provider interface {
+	// Name identifies the Provider on the Payments it handles.
+	Name() string
+
+	// Authorize reserves an amount on a card.
+	Authorize(ctx context.Context, req Request) (Result, error)
+
+	// Capture takes an authorized amount.
+	Capture(ctx context.Context, reference string, amount money.Money) (Result, error)
+
+	// Refund gives back part or all of a captured amount.
+	Refund(ctx context.Context, reference string, amount money.Money) (Result, error)
+
+	// Void releases an authorization that was not captured.
+	Void(ctx context.Context, reference string) (Result, error)
+
+	// Lookup finds what became of the payment authorized for paymentID, or
+	// returns ErrNoRecord when it never got that far.
+	Lookup(ctx context.Context, paymentID string) (Result, error)
+}
+
+// Outcomes a Fake can be scripted with.
+const (
+	Approve = "approve"
+	Decline = "decline"
+	Timeout = "timeout"
+)
+
+// Fake is an in-process Provider for tests and local development. It
+// approves everything unless scripted otherwise and hands out references in
+// sequence, so runs are repeatable. It is safe for concurrent use.
+type Fake struct {
+	mu         sync.Mutex
+	script     []string
+	seq        int
+	payments   map[string]*fakePayment
+	references map[string]string
+}
+
+// fakePayment is what a Fake remembers about a payment.
+type fakePayment struct {
+	authorized money.Money
+	captured   money.Money
+	refunded   money.Money
+	voided     bool
+}
+
+// NewFake creates a Fake that approves everything.
+func NewFake() *Fake {
+	return &Fake{payments: make(map[string]*fakePayment), references: make(map[string]string)}
+}
+
+// Script queues the outcomes of the next calls to the Fake, one per call of
+// any kind. Calls once the script has run out are approved.
+func (f *Fake) Script(outcomes ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.script = append(f.script, outcomes...)
+}
+
+// Name identifies the Fake.
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+// Authorize reserves req.Amount.
+func (f *Fake) Authorize(ctx context.Context, req Request) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.next(); err != nil {
+		return Result{}, err
+	}
+	if req.Amount.Amount <= 0 {
+		return Result{}, errors.Wrap(ErrDeclined, "amount must be positive")
+	}
+
+	f.seq++
+	ref := fmt.Sprintf("fake_%06d", f.seq)
+	f.payments[ref] = &fakePayment{
+		authorized: req.Amount,
+		captured:   money.Money{Currency: req.Amount.Currency},
+		refunded:   money.Money{Currency: req.Amount.Currency},
+	}
+	f.references[req.PaymentID] = ref
+
+	return Result{Reference: ref, Message: "approved"}, nil
+}
+
+// Capture takes up to the authorized amount.
+func (f *Fake) Capture(ctx context.Context, reference string, amount money.Money) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.next(); err != nil {
+		return Result{}, err
+	}
+	p, ok := f.payments[reference]
+	if !ok || p.voided || !p.captured.IsZero() {
+		return Result{}, errors.Errorf("fake: %s can not be captured", reference)
+	}
+	if amount.Currency != p.authorized.Currency || amount.Amount > p.authorized.Amount {
+		return Result{}, errors.Wrap(ErrDeclined, "capture is more than was authorized")
+	}
+
+	p.captured = amount
+	return Result{Reference: reference, Message: "captured"}, nil
+}
+
+// Refund gives back up to what was captured.
+func (f *Fake) Refund(ctx context.Context, reference string, amount money.Money) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.next(); err != nil {
+		return Result{}, err
+	}
+	p, ok := f.payments[reference]
+	if !ok || p.captured.IsZero() {
+		return Result{}, errors.Errorf("fake: %s has not been captured", reference)
+	}
+	refunded, err := p.refunded.Add(amount)
+	if err != nil || refunded.Amount > p.captured.Amount {
+		return Result{}, errors.Wrap(ErrDeclined, "refund is more than was captured")
+	}
+
+	p.refunded = refunded
+	return Result{Reference: reference, Message: "refunded"}, nil
+}
+
+// Void releases an authorization.
+func (f *Fake) Void(ctx context.Context, reference string) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.next(); err != nil {
+		return Result{}, err
+	}
+	p, ok := f.payments[reference]
+	if !ok || !p.captured.IsZero() {
+		return Result{}, errors.Errorf("fake: %s can not be voided", reference)
+	}
+
+	p.voided = true
+	return Result{Reference: reference, Message: "voided"}, nil
+}
+
+// Lookup finds the payment authorized for paymentID.
+func (f *Fake) Lookup(ctx context.Context, paymentID string) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.next(); err != nil {
+		return Result{}, err
+	}
+	ref, ok := f.references[paymentID]
+	if !ok {
+		return Result{}, ErrNoRecord
+	}
+
+	p := f.payments[ref]
+	switch {
+	case p.voided:
+		return Result{Reference: ref, Message: "voided", Status: Voided}, nil
+	case !p.captured.IsZero():
+		return Result{Reference: ref, Message: "captured", Status: Captured}, nil
+	default:
+		return Result{Reference: ref, Message: "authorized", Status: Authorized}, nil
+	}
+}
+
+// next takes the next outcome off the script.
+func (f *Fake) next() error {
+	if len(f.script) == 0 {
+		return nil
+	}
+
+	o := f.script[0]
+	f.script = f.script[1:]
+
+	switch o {
+	case Decline:
+		return ErrDeclined
+	case Timeout:
+		return ErrTimeout
+	default:
+		return nil
+	}
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq" // The database driver in use.
+	"github.com/pkg/errors"
 )
 
 // Config is the required properties to use the database.
@@ -48,3 +49,21 @@ func StatusCheck(ctx context.Context, db *sqlx.DB) error {
 	var temp bool
 	return db.QueryRowContext(ctx, q).Scan(&temp)
 }
+
+// WithTx runs fn inside a transaction. The transaction is committed when fn
+// returns nil and rolled back otherwise.
+func WithTx(ctx context.Context, db *sqlx.DB, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return errors.Wrapf(err, "rolling back transaction: %v", rbErr)
+		}
+		return err
+	}
+
+	return errors.Wrap(tx.Commit(), "committing transaction")
+}
@@ -20,7 +20,7 @@ type Container struct {
 func StartContainer(t *testing.T) *Container {
 	t.Helper()
 
-	cmd := exec.Command("docker", "run", "-P", "-d", "postgres:11.1-alpine")
+	cmd := exec.Command("docker", "run", "-P", "-d", "postgres:12.1-alpine")
 	var out bytes.Buffer
 	cmd.Stdout = &out
 	if err := cmd.Run(); err != nil {
@@ -0,0 +1,43 @@
+// Package period reads and checks the ranges of time that reports, exports
+// and the journal are asked for.
+package period
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidRange is returned for a period that ends before it starts.
+var ErrInvalidRange = errors.New("Period must end after it starts")
+
+// Parse reads s as either an RFC 3339 timestamp or a date, which is taken as
+// midnight in loc. An empty s gives the zero time, leaving that end of a
+// period open.
+func Parse(s string, loc *time.Location) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, loc)
+}
+
+// Args turns the period from until to into query arguments in UTC, nil for
+// an open end.
+func Args(from, to time.Time) ([]interface{}, error) {
+	if !from.IsZero() && !to.IsZero() && !to.After(from) {
+		return nil, ErrInvalidRange
+	}
+
+	args := []interface{}{nil, nil}
+	if !from.IsZero() {
+		args[0] = from.UTC()
+	}
+	if !to.IsZero() {
+		args[1] = to.UTC()
+	}
+
+	return args, nil
+}
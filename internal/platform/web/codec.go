@@ -0,0 +1,144 @@
+package web
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnsupported is returned by a Codec for a value it can not represent.
+var ErrUnsupported = errors.New("Value can not be represented in this media type")
+
+// Codec encodes values into and decodes them out of one media type.
+type Codec interface {
+	// ContentType labels what Encode writes when it was asked for as
+	// mediaType, one of the media types the Codec is registered for.
+	ContentType(mediaType string) string
+
+	// Encode writes v to w. It returns ErrUnsupported when v can not be
+	// represented at all, so another media type can be tried.
+	Encode(w io.Writer, v interface{}) error
+
+	// Decode reads a value from r into v.
+	Decode(r io.Reader, v interface{}) error
+}
+
+// registered is a Codec with the media type it is registered for.
+type registered struct {
+	mediaType string
+	codec     Codec
+}
+
+// codecs are the Codecs responses are negotiated between, in the order they
+// are preferred when the client does not mind. JSON comes first so clients
+// that do not say get what they always have.
+var codecs = []registered{
+	{"application/json", jsonCodec{}},
+	{"application/xml", xmlCodec{}},
+	{"text/xml", xmlCodec{}},
+	{"text/csv", csvCodec{}},
+	{"application/msgpack", msgpackCodec{}},
+	{"application/x-msgpack", msgpackCodec{}},
+}
+
+// Register makes c the Codec for mediaType, replacing any registered for it
+// before. A new media type is preferred least. Register is meant to be called
+// while the service starts, before any request is handled.
+func Register(mediaType string, c Codec) {
+	for i := range codecs {
+		if codecs[i].mediaType == mediaType {
+			codecs[i].codec = c
+			return
+		}
+	}
+	codecs = append(codecs, registered{mediaType, c})
+}
+
+// acceptable gets the Codecs a client accepts according to the Accept header
+// value accept, the one it prefers most first.
+func acceptable(accept string) []registered {
+	offers := make([]string, len(codecs))
+	for i, r := range codecs {
+		offers[i] = r.mediaType
+	}
+
+	var rs []registered
+	for _, mediaType := range preferred(accept, offers) {
+		c, _ := lookup(mediaType)
+		rs = append(rs, registered{mediaType, c})
+	}
+
+	return rs
+}
+
+// lookup gets the Codec registered for mediaType.
+func lookup(mediaType string) (Codec, bool) {
+	for _, r := range codecs {
+		if r.mediaType == mediaType {
+			return r.codec, true
+		}
+	}
+	return nil, false
+}
+
+// jsonCodec is the Codec for JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType(mediaType string) string {
+	return mediaType + "; charset=utf-8"
+}
+
+func (jsonCodec) Encode(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func (jsonCodec) Decode(r io.Reader, v interface{}) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+// xmlCodec is the Codec for XML. Elements are named after the fields of a
+// struct unless it has xml tags. A slice is sent as the items of a list
+// element, since a document can only have one root.
+type xmlCodec struct{}
+
+// xmlList is the root element of a slice sent as XML.
+type xmlList struct {
+	XMLName xml.Name    `xml:"list"`
+	Items   interface{} `xml:"item"`
+}
+
+func (xmlCodec) ContentType(mediaType string) string {
+	return mediaType + "; charset=utf-8"
+}
+
+func (xmlCodec) Encode(w io.Writer, v interface{}) error {
+	if k := reflect.Indirect(reflect.ValueOf(v)).Kind(); k == reflect.Slice || k == reflect.Array {
+		v = xmlList{Items: v}
+	}
+
+	b, err := xml.Marshal(v)
+	if err != nil {
+		if _, ok := err.(*xml.UnsupportedTypeError); ok {
+			return ErrUnsupported
+		}
+		return err
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func (xmlCodec) Decode(r io.Reader, v interface{}) error {
+	return xml.NewDecoder(r).Decode(v)
+}
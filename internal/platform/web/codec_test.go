@@ -0,0 +1,188 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type codecAmount struct {
+	Amount   int    `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+type codecItem struct {
+	ID      string      `json:"id"`
+	Note    *string     `json:"note,omitempty"`
+	Cost    codecAmount `json:"cost"`
+	Ratio   float64     `json:"ratio"`
+	OK      bool        `json:"ok"`
+	Tags    []string    `json:"tags"`
+	Created time.Time   `json:"created"`
+	secret  string
+}
+
+func codecItems() []codecItem {
+	note := "fragile, \"handle\" with care"
+	created := time.Date(2019, time.January, 1, 12, 0, 0, 0, time.UTC)
+	return []codecItem{
+		{ID: "a", Note: &note, Cost: codecAmount{-1250, "USD"}, Ratio: 0.5, OK: true, Tags: []string{"x"}, Created: created},
+		{ID: "b", Cost: codecAmount{1 << 40, "JPY"}, Tags: []string{}, Created: created},
+	}
+}
+
+func TestRespond(t *testing.T) {
+	tests := []struct {
+		accept      string
+		data        interface{}
+		status      int
+		contentType string
+	}{
+		{"", codecItems(), http.StatusOK, "application/json; charset=utf-8"},
+		{"text/csv;q=0.5, application/xml", codecItems(), http.StatusOK, "application/xml; charset=utf-8"},
+		{"application/json;q=0.1, text/csv", codecItems(), http.StatusOK, "text/csv; charset=utf-8"},
+		{"text/*", codecItems(), http.StatusOK, "text/xml; charset=utf-8"},
+		{"text/xml", codecItems(), http.StatusOK, "text/xml; charset=utf-8"},
+		{"application/x-msgpack", codecItems(), http.StatusOK, "application/x-msgpack"},
+		{"application/msgpack", codecItems(), http.StatusOK, "application/msgpack"},
+
+		// CSV can not represent a map, so the next best is used and when
+		// there is none the request is not acceptable. The error itself can
+		// be sent as CSV, and otherwise goes as JSON.
+		{"text/csv, application/json;q=0.5", map[string]int{"a": 1}, http.StatusOK, "application/json; charset=utf-8"},
+		{"text/csv", map[string]int{"a": 1}, http.StatusNotAcceptable, "text/csv; charset=utf-8"},
+		{"image/png", codecItems(), http.StatusNotAcceptable, "application/json; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		ctx := context.WithValue(context.Background(), KeyValues, &Values{Accept: tt.accept})
+
+		if err := Respond(ctx, rec, tt.data, http.StatusOK); err != nil {
+			if err := RespondError(ctx, rec, err); err != nil {
+				t.Fatalf("%q: responding with error: %s", tt.accept, err)
+			}
+		}
+
+		if rec.Code != tt.status || rec.Header().Get("Content-Type") != tt.contentType {
+			t.Errorf("%q: got %d %q, expected %d %q", tt.accept, rec.Code, rec.Header().Get("Content-Type"), tt.status, tt.contentType)
+		}
+	}
+}
+
+func TestRespondAs(t *testing.T) {
+	ctx := context.WithValue(context.Background(), KeyValues, &Values{Accept: "text/csv"})
+
+	// The media type the handler negotiated is used whatever the client
+	// accepts, and one that has no Codec is not acceptable.
+	rec := httptest.NewRecorder()
+	if err := RespondAs(ctx, rec, codecItems(), "text/xml", http.StatusOK); err != nil {
+		t.Fatalf("responding: %s", err)
+	}
+	if exp, got := "text/xml; charset=utf-8", rec.Header().Get("Content-Type"); exp != got {
+		t.Fatalf("expected %q, got %q", exp, got)
+	}
+
+	err := RespondAs(ctx, httptest.NewRecorder(), codecItems(), "application/pdf", http.StatusOK)
+	if webErr, ok := err.(*Error); !ok || webErr.Status != http.StatusNotAcceptable {
+		t.Fatalf("expected a %d, got %v", http.StatusNotAcceptable, err)
+	}
+}
+
+func TestCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (csvCodec{}).Encode(&buf, codecItems()); err != nil {
+		t.Fatalf("encoding: %s", err)
+	}
+
+	exp := `id,note,cost.amount,cost.currency,ratio,ok,tags,created
+a,"fragile, ""handle"" with care",-1250,USD,0.5,true,"[""x""]",2019-01-01T12:00:00Z
+b,,1099511627776,JPY,0,false,[],2019-01-01T12:00:00Z
+`
+	if diff := cmp.Diff(exp, buf.String()); diff != "" {
+		t.Fatalf("unexpected CSV:\n%s", diff)
+	}
+
+	var got []codecItem
+	if err := (csvCodec{}).Decode(&buf, &got); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	if diff := cmp.Diff(codecItems(), got, cmp.AllowUnexported(codecItem{})); diff != "" {
+		t.Fatalf("unexpected round trip:\n%s", diff)
+	}
+}
+
+func TestMsgpack(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (msgpackCodec{}).Encode(&buf, codecItems()); err != nil {
+		t.Fatalf("encoding: %s", err)
+	}
+
+	var got []codecItem
+	if err := (msgpackCodec{}).Decode(&buf, &got); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	if diff := cmp.Diff(codecItems(), got, cmp.AllowUnexported(codecItem{})); diff != "" {
+		t.Fatalf("unexpected round trip:\n%s", diff)
+	}
+
+	// {"n": -200, "s": "hi"} as another encoder writes it, with wider
+	// formats than needed.
+	b := []byte{0x82, 0xa1, 'n', 0xd1, 0xff, 0x38, 0xd9, 0x01, 's', 0xa2, 'h', 'i'}
+	var v struct {
+		N int    `json:"n"`
+		S string `json:"s"`
+	}
+	if err := (msgpackCodec{}).Decode(bytes.NewReader(b), &v); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	if v.N != -200 || v.S != "hi" {
+		t.Fatalf("unexpected value %+v", v)
+	}
+
+	if err := (msgpackCodec{}).Decode(bytes.NewReader(b[:5]), &v); err == nil {
+		t.Fatal("expected an error for a truncated value")
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{"", `{"id":"a"}`, 0},
+		{"application/json; charset=utf-8", `{"id":"a"}`, 0},
+		{"application/xml", `<codecItem><ID>a</ID></codecItem>`, 0},
+		{"text/csv", "id,cost.amount\na,5\n", 0},
+		{"text/csv", "nope\na\n", http.StatusBadRequest},
+		{"application/x-www-form-urlencoded", `{"id":"a"}`, http.StatusUnsupportedMediaType},
+		{"text/plain", `{"id":"a"}`, http.StatusUnsupportedMediaType},
+		{"not a media type", `{"id":"a"}`, http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+
+		var v codecItem
+		err := Decoder(r, &v)
+		if tt.status == 0 {
+			if err != nil || v.ID != "a" {
+				t.Errorf("%q: got %+v, %v", tt.contentType, v, err)
+			}
+			continue
+		}
+		if webErr, ok := err.(*Error); !ok || webErr.Status != tt.status {
+			t.Errorf("%q: expected a %d, got %v", tt.contentType, tt.status, err)
+		}
+	}
+}
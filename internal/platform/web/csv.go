@@ -0,0 +1,302 @@
+package web
+
+import (
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// csvCodec is the Codec for CSV. It sends a struct, or a slice of them, with
+// a header line naming the columns after their JSON names. The fields of a
+// nested struct get columns of their own, named with a dot like
+// "cost.amount", and a field that is a list or a map is sent as JSON. A nil
+// pointer leaves its columns empty.
+type csvCodec struct{}
+
+// column is a field of a struct sent as CSV, found by index through nested
+// structs.
+type column struct {
+	name  string
+	index []int
+}
+
+var (
+	textMarshaler   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func (csvCodec) ContentType(mediaType string) string {
+	return mediaType + "; charset=utf-8"
+}
+
+func (csvCodec) Encode(w io.Writer, v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+
+	var rows []reflect.Value
+	t := rv.Type()
+	switch rv.Kind() {
+	case reflect.Struct:
+		rows = append(rows, rv)
+	case reflect.Slice, reflect.Array:
+		t = t.Elem()
+		for i := 0; i < rv.Len(); i++ {
+			rows = append(rows, rv.Index(i))
+		}
+	default:
+		return ErrUnsupported
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t.Implements(textMarshaler) {
+		return ErrUnsupported
+	}
+
+	columns := csvColumns(t, "", nil)
+
+	cw := csv.NewWriter(w)
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.name
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i, c := range columns {
+			f, ok := fieldByIndex(row, c.index, false)
+			if !ok {
+				record[i] = ""
+				continue
+			}
+			s, err := formatCSV(f)
+			if err != nil {
+				return errors.Wrapf(err, "column %q", c.name)
+			}
+			record[i] = s
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// Decode reads CSV with a header line into a pointer to a struct, which takes
+// the first row, or to a slice of them. Empty fields are left as they are.
+func (csvCodec) Decode(r io.Reader, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("csv: decoding into a non-pointer")
+	}
+	rv = rv.Elem()
+
+	t := rv.Type()
+	if rv.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	elem := t
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		return errors.Errorf("csv: can not decode into %s", rv.Type())
+	}
+
+	byName := map[string]column{}
+	for _, c := range csvColumns(elem, "", nil) {
+		byName[c.name] = c
+	}
+
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		if err == io.EOF {
+			return errors.New("csv: missing header")
+		}
+		return err
+	}
+	columns := make([]column, len(header))
+	for i, h := range header {
+		c, ok := byName[strings.TrimSpace(h)]
+		if !ok {
+			return errors.Errorf("csv: unknown column %q", h)
+		}
+		columns[i] = c
+	}
+
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		row := reflect.New(elem).Elem()
+		for i, s := range record {
+			if s == "" {
+				continue
+			}
+			f, _ := fieldByIndex(row, columns[i].index, true)
+			if err := parseCSV(f, s); err != nil {
+				return errors.Wrapf(err, "csv: line %d, column %q", line, columns[i].name)
+			}
+		}
+
+		if t.Kind() == reflect.Ptr {
+			row = row.Addr()
+		}
+		if rv.Kind() != reflect.Slice {
+			rv.Set(row)
+			return nil
+		}
+		rv.Set(reflect.Append(rv, row))
+	}
+}
+
+// csvColumns lists the columns of the fields of struct type t, following
+// index from the struct the columns belong to and naming them after prefix.
+func csvColumns(t reflect.Type, prefix string, index []int) []column {
+	var columns []column
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := f.Name
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if tag != "" {
+			name = tag
+		}
+
+		idx := append(append([]int{}, index...), i)
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && !ft.Implements(textMarshaler) && !reflect.PtrTo(ft).Implements(textMarshaler) {
+			if f.Anonymous && tag == "" {
+				columns = append(columns, csvColumns(ft, prefix, idx)...)
+			} else {
+				columns = append(columns, csvColumns(ft, prefix+name+".", idx)...)
+			}
+			continue
+		}
+
+		columns = append(columns, column{name: prefix + name, index: idx})
+	}
+
+	return columns
+}
+
+// fieldByIndex follows index from struct v through nested structs. Nil
+// pointers on the way are allocated when alloc is set and otherwise mean
+// there is no field.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v, true
+}
+
+// formatCSV turns the value of a field into the text of its column.
+func formatCSV(v reflect.Value) (string, error) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Type().Implements(textMarshaler) {
+		b, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(b), err
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), nil
+	}
+
+	b, err := json.Marshal(v.Interface())
+	return string(b), err
+}
+
+// parseCSV sets field v from the text of its column.
+func parseCSV(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Ptr {
+		v.Set(reflect.New(v.Type().Elem()))
+		v = v.Elem()
+	}
+
+	if reflect.PtrTo(v.Type()).Implements(textUnmarshaler) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return json.Unmarshal([]byte(s), v.Addr().Interface())
+	}
+
+	return nil
+}
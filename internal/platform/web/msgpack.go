@@ -0,0 +1,347 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// msgpackCodec is the Codec for MessagePack. Values take the same shape they
+// do as JSON, honouring json tags and marshalers: they are turned into JSON
+// and the JSON is written as MessagePack, and the other way around when
+// decoding. Whole numbers are sent as integers and other numbers as 64 bit
+// floats.
+type msgpackCodec struct{}
+
+// maxDepth is how deeply arrays and maps may be nested in MessagePack read
+// from a client.
+const maxDepth = 100
+
+func (msgpackCodec) ContentType(mediaType string) string {
+	return mediaType
+}
+
+func (msgpackCodec) Encode(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var generic interface{}
+	if err := d.Decode(&generic); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := writeMsgpack(bw, generic); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (msgpackCodec) Decode(r io.Reader, v interface{}) error {
+	generic, err := readMsgpack(bufio.NewReader(r), 0)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return errors.Wrap(err, "msgpack")
+	}
+
+	b, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// writeMsgpack writes v, a value decoded from JSON with numbers kept as
+// json.Number, as MessagePack.
+func writeMsgpack(w *bufio.Writer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		return w.WriteByte(0xc0)
+
+	case bool:
+		if v {
+			return w.WriteByte(0xc3)
+		}
+		return w.WriteByte(0xc2)
+
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return writeInt(w, n)
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		w.WriteByte(0xcb)
+		return binary.Write(w, binary.BigEndian, math.Float64bits(f))
+
+	case string:
+		if err := writeLength(w, len(v), 0xa0, 32, 0xd9, 0xda, 0xdb); err != nil {
+			return err
+		}
+		_, err := w.WriteString(v)
+		return err
+
+	case []interface{}:
+		if err := writeLength(w, len(v), 0x90, 16, 0, 0xdc, 0xdd); err != nil {
+			return err
+		}
+		for _, e := range v {
+			if err := writeMsgpack(w, e); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case map[string]interface{}:
+		if err := writeLength(w, len(v), 0x80, 16, 0, 0xde, 0xdf); err != nil {
+			return err
+		}
+
+		// Keys are sorted so the same value is always encoded the same way.
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := writeMsgpack(w, k); err != nil {
+				return err
+			}
+			if err := writeMsgpack(w, v[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return ErrUnsupported
+}
+
+// writeInt writes n in the fewest bytes MessagePack can hold it in.
+func writeInt(w *bufio.Writer, n int64) error {
+	switch {
+	case n >= 0 && n <= 0x7f:
+		return w.WriteByte(byte(n))
+	case n < 0 && n >= -32:
+		return w.WriteByte(byte(n))
+	case n > 0:
+		switch {
+		case n <= math.MaxUint8:
+			w.WriteByte(0xcc)
+			return w.WriteByte(byte(n))
+		case n <= math.MaxUint16:
+			w.WriteByte(0xcd)
+			return binary.Write(w, binary.BigEndian, uint16(n))
+		case n <= math.MaxUint32:
+			w.WriteByte(0xce)
+			return binary.Write(w, binary.BigEndian, uint32(n))
+		}
+		w.WriteByte(0xcf)
+		return binary.Write(w, binary.BigEndian, uint64(n))
+	}
+
+	switch {
+	case n >= math.MinInt8:
+		w.WriteByte(0xd0)
+		return w.WriteByte(byte(n))
+	case n >= math.MinInt16:
+		w.WriteByte(0xd1)
+		return binary.Write(w, binary.BigEndian, int16(n))
+	case n >= math.MinInt32:
+		w.WriteByte(0xd2)
+		return binary.Write(w, binary.BigEndian, int32(n))
+	}
+	w.WriteByte(0xd3)
+	return binary.Write(w, binary.BigEndian, n)
+}
+
+// writeLength writes the header of a string, array or map of n elements. fix
+// is the first byte of its fix format, which holds lengths below fixMax, and
+// f8, f16 and f32 are the first bytes of its formats with 8, 16 and 32 bit
+// lengths. Arrays and maps have no 8 bit format and pass 0 for f8.
+func writeLength(w *bufio.Writer, n int, fix byte, fixMax int, f8, f16, f32 byte) error {
+	switch {
+	case n < fixMax:
+		return w.WriteByte(fix | byte(n))
+	case f8 != 0 && n <= math.MaxUint8:
+		w.WriteByte(f8)
+		return w.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		w.WriteByte(f16)
+		return binary.Write(w, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		w.WriteByte(f32)
+		return binary.Write(w, binary.BigEndian, uint32(n))
+	}
+	return ErrUnsupported
+}
+
+// readMsgpack reads one MessagePack value from r as the value JSON would
+// decode into an interface{}, except that integers stay integers and binary
+// data is a []byte. depth is how deeply it is nested.
+func readMsgpack(r *bufio.Reader, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("nested too deeply")
+	}
+
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xf0 == 0x80:
+		return readMap(r, uint64(b&0x0f), depth)
+	case b&0xf0 == 0x90:
+		return readArray(r, uint64(b&0x0f), depth)
+	case b&0xe0 == 0xa0:
+		return readString(r, uint64(b&0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+
+	case 0xc4, 0xc5, 0xc6:
+		n, err := readUint(r, 1<<(b-0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return readBytes(r, n)
+
+	case 0xca:
+		var bits uint32
+		if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(bits)), nil
+	case 0xcb:
+		var bits uint64
+		if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(bits), nil
+
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := readUint(r, 1<<(b-0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		n, err := readUint(r, size)
+		if err != nil {
+			return nil, err
+		}
+		// Sign extend from the size read.
+		shift := uint(64 - 8*size)
+		return int64(n<<shift) >> shift, nil
+
+	case 0xd9, 0xda, 0xdb:
+		n, err := readUint(r, 1<<(b-0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return readString(r, n)
+
+	case 0xdc, 0xdd:
+		n, err := readUint(r, 2<<(b-0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return readArray(r, n, depth)
+
+	case 0xde, 0xdf:
+		n, err := readUint(r, 2<<(b-0xde))
+		if err != nil {
+			return nil, err
+		}
+		return readMap(r, n, depth)
+	}
+
+	return nil, errors.Errorf("unsupported format 0x%02x", b)
+}
+
+// readUint reads a big endian unsigned integer of size bytes.
+func readUint(r *bufio.Reader, size int) (uint64, error) {
+	var n uint64
+	for i := 0; i < size; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n = n<<8 | uint64(b)
+	}
+	return n, nil
+}
+
+// readBytes reads n bytes. The buffer grows as they arrive rather than
+// trusting n up front.
+func readBytes(r *bufio.Reader, n uint64) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func readString(r *bufio.Reader, n uint64) (interface{}, error) {
+	b, err := readBytes(r, n)
+	return string(b), err
+}
+
+func readArray(r *bufio.Reader, n uint64, depth int) (interface{}, error) {
+	a := []interface{}{}
+	for i := uint64(0); i < n; i++ {
+		v, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+	}
+	return a, nil
+}
+
+func readMap(r *bufio.Reader, n uint64, depth int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for i := uint64(0); i < n; i++ {
+		k, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, errors.Errorf("map key %v is not a string", k)
+		}
+		if m[key], err = readMsgpack(r, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,90 @@
+package web
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotAcceptable is used when a client accepts none of the media types a
+// handler can respond with.
+var ErrNotAcceptable = errors.New("Response can not be given in any accepted media type")
+
+// Negotiate picks which of offers, media types like "text/html", to respond
+// with according to the Accept header of r. The offer the client prefers most
+// wins, earlier offers winning ties, and the first offer is used when the
+// client does not say. The error is a 406 request error when nothing offered
+// is acceptable. Respond negotiates between the registered Codecs the same
+// way, and a handler that negotiated itself responds with RespondAs.
+func Negotiate(r *http.Request, offers ...string) (string, error) {
+	if p := preferred(r.Header.Get("Accept"), offers); len(p) > 0 {
+		return p[0], nil
+	}
+	return "", NewRequestError(ErrNotAcceptable, http.StatusNotAcceptable)
+}
+
+// preferred gets the offers the Accept header value accept allows, the one
+// the client prefers most first and earlier offers first among equals. Every
+// offer is allowed when accept is empty.
+func preferred(accept string, offers []string) []string {
+	if accept == "" {
+		return offers
+	}
+
+	qs := make(map[string]float64, len(offers))
+	var ok []string
+	for _, offer := range offers {
+		if q := quality(accept, offer); q > 0 {
+			qs[offer] = q
+			ok = append(ok, offer)
+		}
+	}
+	sort.SliceStable(ok, func(i, j int) bool {
+		return qs[ok[i]] > qs[ok[j]]
+	})
+
+	return ok
+}
+
+// quality is the q-value the most specific media range of accept that
+// matches offer gives it.
+func quality(accept, offer string) float64 {
+	slash := strings.Index(offer, "/")
+
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		media := strings.ToLower(strings.TrimSpace(params[0]))
+
+		var s int
+		switch {
+		case media == offer:
+			s = 2
+		case media == offer[:slash+1]+"*":
+			s = 1
+		case media == "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s < specificity {
+			continue
+		}
+
+		mq := 1.0
+		for _, p := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+					mq = f
+				}
+			}
+		}
+		q, specificity = mq, s
+	}
+
+	return q
+}
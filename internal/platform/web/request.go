@@ -1,12 +1,36 @@
 package web
 
 import (
-	"encoding/json"
+	"mime"
 	"net/http"
+
+	"github.com/pkg/errors"
 )
 
+// ErrUnsupportedMediaType is used when a request body is in a media type no
+// Codec is registered for.
+var ErrUnsupportedMediaType = errors.New("Request body is in a media type that is not supported")
+
+// Decoder reads the body of r into val, decoding it by its Content-Type with
+// the Codec registered for that media type. A body without a Content-Type is
+// read as JSON. The error is a 415 request error for a Content-Type no Codec
+// is registered for and a 400 request error for a body that can not be
+// decoded.
 func Decoder(r *http.Request, val interface{}) error {
-	if err := json.NewDecoder(r.Body).Decode(val); err != nil {
+	var c Codec = jsonCodec{}
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return NewRequestError(ErrUnsupportedMediaType, http.StatusUnsupportedMediaType)
+		}
+		registered, ok := lookup(mediaType)
+		if !ok {
+			return NewRequestError(ErrUnsupportedMediaType, http.StatusUnsupportedMediaType)
+		}
+		c = registered
+	}
+
+	if err := c.Decode(r.Body, val); err != nil {
 		return NewRequestError(err, http.StatusBadRequest)
 	}
 
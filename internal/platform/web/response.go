@@ -1,6 +1,7 @@
 package web
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"net/http"
@@ -8,53 +9,148 @@ import (
 	"github.com/pkg/errors"
 )
 
-// Respond converts a Go value to JSON and sends it to the client.
+// Respond encodes a Go value in the media type the client prefers most
+// according to its Accept header, of those registered that can represent it,
+// and sends it to the client. JSON is used when the client does not say. The
+// error is a 406 request error when nothing acceptable can represent data.
 func Respond(ctx context.Context, w http.ResponseWriter, data interface{}, statusCode int) error {
-	// set the status code for the request logger middleware
 	v := ctx.Value(KeyValues).(*Values)
-	v.StatusCode = statusCode
+	return respond(ctx, w, data, acceptable(v.Accept), statusCode)
+}
+
+// RespondAs encodes a Go value in mediaType, which the handler negotiated
+// with Negotiate, and sends it to the client. The error is a 406 request
+// error when no Codec is registered for mediaType or it can not represent
+// data.
+func RespondAs(ctx context.Context, w http.ResponseWriter, data interface{}, mediaType string, statusCode int) error {
+	c, ok := lookup(mediaType)
+	if !ok {
+		return NewRequestError(ErrNotAcceptable, http.StatusNotAcceptable)
+	}
+	return respond(ctx, w, data, []registered{{mediaType, c}}, statusCode)
+}
 
+// respond encodes data in the first of rs that can represent it and sends it
+// to the client.
+func respond(ctx context.Context, w http.ResponseWriter, data interface{}, rs []registered, statusCode int) error {
 	if statusCode == http.StatusNoContent {
+		// set the status code for the request logger middleware
+		v := ctx.Value(KeyValues).(*Values)
+		v.StatusCode = statusCode
 		w.WriteHeader(statusCode)
 		return nil
 	}
 
-	// Convert the response value to JSON.
-	res, err := json.Marshal(data)
-	if err != nil {
-		return err
-	}
+	// Encode the response value in the most preferred media type that can
+	// represent it.
+	var buf bytes.Buffer
+	for _, r := range rs {
+		buf.Reset()
+		err := r.codec.Encode(&buf, data)
+		if err == ErrUnsupported {
+			continue
+		}
+		if err != nil {
+			return err
+		}
 
-	// Respond with the provided JSON.
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(statusCode)
-	if _, err := w.Write(res); err != nil {
-		return err
+		return RespondRaw(ctx, w, buf.Bytes(), r.codec.ContentType(r.mediaType), statusCode)
 	}
 
-	return nil
+	return NewRequestError(ErrNotAcceptable, http.StatusNotAcceptable)
 }
 
-// RespondError sends an error response back to the client
+// RespondError sends an error response back to the client. It is sent as JSON
+// when the client accepts nothing it can be sent as.
 func RespondError(ctx context.Context, w http.ResponseWriter, err error) error {
 
 	// If the error was of the type *Error, the handler has
-	// as specific status code and error to return
+	// as specific status code and error to return. If not, the handler sent
+	// any arbitary error value so we are using 500
+	er := ErrorResponse{Error: http.StatusText(http.StatusInternalServerError)}
+	status := http.StatusInternalServerError
 	if webErr, ok := errors.Cause(err).(*Error); ok {
-		er := ErrorResponse{Error: webErr.Err.Error()}
+		er, status = ErrorResponse{Error: webErr.Err.Error()}, webErr.Status
+	}
 
-		if err := Respond(ctx, w, er, webErr.Status); err != nil {
+	err = Respond(ctx, w, er, status)
+	if webErr, ok := errors.Cause(err).(*Error); ok && webErr.Err == ErrNotAcceptable {
+		res, err := json.Marshal(er)
+		if err != nil {
 			return err
 		}
-
-		return nil
+		return RespondRaw(ctx, w, res, jsonCodec{}.ContentType("application/json"), status)
 	}
 
-	// If not, the handler sent any arbitary error value so we are using 500
-	er := ErrorResponse{Error: http.StatusText(http.StatusInternalServerError)}
-	if err := Respond(ctx, w, er, http.StatusInternalServerError); err != nil {
+	return err
+}
+
+// RespondRaw sends data to the client as it is, labelled with contentType.
+func RespondRaw(ctx context.Context, w http.ResponseWriter, data []byte, contentType string, statusCode int) error {
+	// set the status code for the request logger middleware
+	v := ctx.Value(KeyValues).(*Values)
+	v.StatusCode = statusCode
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(data); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// Stream sends a response to the client as it is written rather than all at
+// once. The status code and content type go out with the first byte, so once
+// Started reports true an error can no longer be sent in place of the
+// response and can only cut it short.
+type Stream struct {
+	ctx         context.Context
+	w           http.ResponseWriter
+	contentType string
+	statusCode  int
+	started     bool
+}
+
+// NewStream constructs a Stream that responds with statusCode and data
+// labelled with contentType.
+func NewStream(ctx context.Context, w http.ResponseWriter, contentType string, statusCode int) *Stream {
+	return &Stream{ctx: ctx, w: w, contentType: contentType, statusCode: statusCode}
+}
+
+// Write sends p to the client straight away.
+func (s *Stream) Write(p []byte) (int, error) {
+	if !s.started {
+		s.start()
+	}
+
+	n, err := s.w.Write(p)
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+// Started reports if any of the response has been sent.
+func (s *Stream) Started() bool {
+	return s.started
+}
+
+// Close sends the status code and content type of a response nothing was
+// written to.
+func (s *Stream) Close() error {
+	if !s.started {
+		s.start()
+	}
+	return nil
+}
+
+func (s *Stream) start() {
+	// set the status code for the request logger middleware
+	v := s.ctx.Value(KeyValues).(*Values)
+	v.StatusCode = s.statusCode
+
+	s.w.Header().Set("Content-Type", s.contentType)
+	s.w.WriteHeader(s.statusCode)
+	s.started = true
+}
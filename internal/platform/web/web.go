@@ -19,6 +19,10 @@ const KeyValues ctxKey = 1
 type Values struct {
 	StatusCode int
 	Start      time.Time
+
+	// Accept is the Accept header of the request, which Respond negotiates
+	// the media type of the response with.
+	Accept string
 }
 
 type Handler func(http.ResponseWriter, *http.Request) error
@@ -50,7 +54,8 @@ func (a *App) Handle(method, url string, h Handler) {
 
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		v := Values{
-			Start: time.Now(),
+			Start:  time.Now(),
+			Accept: r.Header.Get("Accept"),
 		}
 		ctx := context.WithValue(r.Context(), KeyValues, &v)
 		r = r.WithContext(ctx)
@@ -0,0 +1,118 @@
+package pricing
+
+import (
+	"fmt"
+	"time"
+)
+
+// Price works out what lines cost at time now. Each line gets the single
+// Promotion that takes the most off it; promotions do not stack. coupons maps
+// the ID of a Promotion to the code presented for it, and promotions that
+// require a coupon are skipped unless one is there.
+func Price(lines []Line, promotions []Promotion, coupons map[string]string, now time.Time) Quote {
+	q := Quote{
+		Lines: make([]QuoteLine, 0, len(lines)),
+	}
+
+	for _, l := range lines {
+		ql := QuoteLine{
+			ProductID: l.ProductID,
+			VariantID: l.VariantID,
+			Quantity:  l.Quantity,
+			UnitPrice: l.UnitPrice,
+			Subtotal:  l.UnitPrice * l.Quantity,
+		}
+
+		for _, p := range promotions {
+			code, ok := coupons[p.ID]
+			if p.RequiresCoupon && !ok {
+				continue
+			}
+			if !p.appliesTo(l, now) {
+				continue
+			}
+
+			discount, why := p.discount(l)
+			if discount <= 0 || (ql.Applied != nil && discount <= ql.Applied.Discount) {
+				continue
+			}
+
+			ql.Applied = &Applied{
+				PromotionID: p.ID,
+				Name:        p.Name,
+				Kind:        p.Kind,
+				Discount:    discount,
+				Explanation: why,
+			}
+			if p.RequiresCoupon {
+				ql.Applied.Coupon = code
+			}
+		}
+
+		if ql.Applied != nil {
+			ql.Discount = ql.Applied.Discount
+		}
+		ql.Total = ql.Subtotal - ql.Discount
+
+		q.Lines = append(q.Lines, ql)
+		q.Subtotal += ql.Subtotal
+		q.Discount += ql.Discount
+		q.Total += ql.Total
+	}
+
+	return q
+}
+
+// appliesTo reports if p covers the line at time now. An amount off only
+// covers lines priced in the currency it is given in.
+func (p Promotion) appliesTo(l Line, now time.Time) bool {
+	if p.Kind == AmountOff && p.Currency != l.Currency {
+		return false
+	}
+	if p.StartsAt != nil && now.Before(*p.StartsAt) {
+		return false
+	}
+	if p.EndsAt != nil && !now.Before(*p.EndsAt) {
+		return false
+	}
+	if p.ProductID != nil && *p.ProductID != l.ProductID {
+		return false
+	}
+	if p.Category != nil && *p.Category != l.Category {
+		return false
+	}
+	return true
+}
+
+// discount is how much p takes off the line along with an explanation for
+// the customer. The discount never exceeds the line subtotal.
+func (p Promotion) discount(l Line) (int, string) {
+	subtotal := l.UnitPrice * l.Quantity
+
+	var d int
+	var why string
+	switch p.Kind {
+	case PercentOff:
+		// Round half up so 10% off 5 is 1 rather than 0.
+		d = (subtotal*p.Percent + 50) / 100
+		why = fmt.Sprintf("%d%% off %d", p.Percent, subtotal)
+
+	case AmountOff:
+		d = p.Amount * l.Quantity
+		why = fmt.Sprintf("%d off each of %d units", p.Amount, l.Quantity)
+
+	case BuyXGetY:
+		group := p.BuyQuantity + p.GetQuantity
+		if group <= 0 {
+			return 0, ""
+		}
+		free := l.Quantity / group * p.GetQuantity
+		d = free * l.UnitPrice
+		why = fmt.Sprintf("buy %d get %d free: %d of %d units free", p.BuyQuantity, p.GetQuantity, free, l.Quantity)
+	}
+
+	if d > subtotal {
+		d = subtotal
+	}
+	return d, why
+}
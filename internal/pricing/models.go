@@ -0,0 +1,136 @@
+package pricing
+
+import (
+	"time"
+)
+
+// Kinds of Promotion.
+const (
+	// PercentOff takes Percent percent off the line.
+	PercentOff = "percent_off"
+
+	// AmountOff takes Amount, in minor units of Currency, off each unit on
+	// lines priced in Currency.
+	AmountOff = "amount_off"
+
+	// BuyXGetY gives GetQuantity units free for every BuyQuantity units paid
+	// for.
+	BuyXGetY = "buy_x_get_y"
+)
+
+// Promotion is a rule that discounts what customers pay. A Promotion applies
+// to a single Product when ProductID is set, to every Product in Category
+// when that is set, and to everything otherwise. StartsAt and EndsAt limit
+// when it runs, and a Promotion that RequiresCoupon only applies when one of
+// its Coupons is presented.
+type Promotion struct {
+	ID             string     `db:"promotion_id" json:"id"`
+	Name           string     `db:"name" json:"name"`
+	Kind           string     `db:"kind" json:"kind"`
+	Percent        int        `db:"percent" json:"percent,omitempty"`
+	Amount         int        `db:"amount" json:"amount,omitempty"`
+	Currency       string     `db:"currency" json:"currency,omitempty"`
+	BuyQuantity    int        `db:"buy_quantity" json:"buy_quantity,omitempty"`
+	GetQuantity    int        `db:"get_quantity" json:"get_quantity,omitempty"`
+	ProductID      *string    `db:"product_id" json:"product_id,omitempty"`
+	Category       *string    `db:"category" json:"category,omitempty"`
+	RequiresCoupon bool       `db:"requires_coupon" json:"requires_coupon"`
+	StartsAt       *time.Time `db:"starts_at" json:"starts_at,omitempty"`
+	EndsAt         *time.Time `db:"ends_at" json:"ends_at,omitempty"`
+	DateCreated    time.Time  `db:"date_created" json:"date_created"`
+}
+
+// NewPromotion is what we require from clients when adding a Promotion.
+// Currency is only used by AmountOff promotions and defaults to the currency
+// Products are sold in when they are created without one.
+type NewPromotion struct {
+	Name           string     `json:"name"`
+	Kind           string     `json:"kind"`
+	Percent        int        `json:"percent"`
+	Amount         int        `json:"amount"`
+	Currency       string     `json:"currency"`
+	BuyQuantity    int        `json:"buy_quantity"`
+	GetQuantity    int        `json:"get_quantity"`
+	ProductID      string     `json:"product_id"`
+	Category       string     `json:"category"`
+	RequiresCoupon bool       `json:"requires_coupon"`
+	StartsAt       *time.Time `json:"starts_at"`
+	EndsAt         *time.Time `json:"ends_at"`
+}
+
+// Coupon is a code customers present to unlock a Promotion. A Coupon without
+// a UsageLimit can be used any number of times.
+type Coupon struct {
+	Code        string    `db:"code" json:"code"`
+	PromotionID string    `db:"promotion_id" json:"promotion_id"`
+	UsageLimit  *int      `db:"usage_limit" json:"usage_limit,omitempty"`
+	TimesUsed   int       `db:"times_used" json:"times_used"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
+
+// NewCoupon is what we require from clients when adding a Coupon. Codes are
+// not case sensitive.
+type NewCoupon struct {
+	Code        string `json:"code"`
+	PromotionID string `json:"promotion_id"`
+	UsageLimit  *int   `json:"usage_limit"`
+}
+
+// Basket is what clients send to have a set of items priced. Currency picks
+// the price list the items are priced from.
+type Basket struct {
+	Currency string   `json:"currency"`
+	Items    []Item   `json:"items"`
+	Coupons  []string `json:"coupons"`
+}
+
+// Item is one Product, or one of its Variants, in a Basket.
+type Item struct {
+	ProductID string `json:"product_id"`
+	VariantID string `json:"variant_id"`
+	Quantity  int    `json:"quantity"`
+}
+
+// Line is an Item with what is needed to price it. UnitPrice is in minor
+// units of Currency.
+type Line struct {
+	ProductID string
+	VariantID string
+	Category  string
+	Currency  string
+	Quantity  int
+	UnitPrice int
+}
+
+// Quote is what a Basket costs once promotions are applied. Every amount is
+// in minor units of Currency.
+type Quote struct {
+	Currency string      `json:"currency"`
+	Lines    []QuoteLine `json:"lines"`
+	Subtotal int         `json:"subtotal"`
+	Discount int         `json:"discount"`
+	Total    int         `json:"total"`
+}
+
+// QuoteLine is the price of one Line. Applied is the Promotion which gave the
+// discount, if any.
+type QuoteLine struct {
+	ProductID string   `json:"product_id"`
+	VariantID string   `json:"variant_id,omitempty"`
+	Quantity  int      `json:"quantity"`
+	UnitPrice int      `json:"unit_price"`
+	Subtotal  int      `json:"subtotal"`
+	Discount  int      `json:"discount"`
+	Total     int      `json:"total"`
+	Applied   *Applied `json:"applied,omitempty"`
+}
+
+// Applied explains how a Promotion discounted a QuoteLine.
+type Applied struct {
+	PromotionID string `json:"promotion_id"`
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`
+	Coupon      string `json:"coupon,omitempty"`
+	Discount    int    `json:"discount"`
+	Explanation string `json:"explanation"`
+}
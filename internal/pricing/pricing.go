@@ -0,0 +1,428 @@
+// Package pricing works out what customers pay once promotions and coupons
+// are taken into account.
+package pricing
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/database"
+	"github.com/vikramcse/the-service/internal/product"
+)
+
+var (
+	ErrNotFound        = errors.New("Promotion not found")
+	ErrInvalidID       = errors.New("ID is not in it's proper form")
+	ErrInvalidKind     = errors.New("Promotion kind is not recognised")
+	ErrInvalidRule     = errors.New("Promotion rule is not valid for its kind")
+	ErrInvalidWindow   = errors.New("Promotion must end after it starts")
+	ErrInvalidQuantity = errors.New("Quantity must be positive")
+	ErrInvalidCoupon   = errors.New("Coupon code must not be empty")
+	ErrCouponNotFound  = errors.New("Coupon not found")
+	ErrCouponUsedUp    = errors.New("Coupon has reached its usage limit")
+	ErrCouponWithPaid  = errors.New("Coupon can not be used when paid is given")
+)
+
+// Entity types changes to pricing are audited as. Uses of a Coupon are
+// audited with the Sale they are used for.
+const (
+	auditPromotion = "promotion"
+	auditCoupon    = "coupon"
+)
+
+// CreatePromotion adds a Promotion to the database.
+func CreatePromotion(ctx context.Context, db *sqlx.DB, np NewPromotion, now time.Time) (*Promotion, error) {
+	var currency string
+	switch np.Kind {
+	case PercentOff:
+		if np.Percent <= 0 || np.Percent > 100 {
+			return nil, ErrInvalidRule
+		}
+	case AmountOff:
+		if np.Amount <= 0 {
+			return nil, ErrInvalidRule
+		}
+		if np.Currency == "" {
+			np.Currency = product.DefaultCurrency
+		}
+		var err error
+		if currency, err = money.Currency(np.Currency); err != nil {
+			return nil, err
+		}
+	case BuyXGetY:
+		if np.BuyQuantity <= 0 || np.GetQuantity <= 0 {
+			return nil, ErrInvalidRule
+		}
+	default:
+		return nil, ErrInvalidKind
+	}
+
+	if np.StartsAt != nil && np.EndsAt != nil && !np.EndsAt.After(*np.StartsAt) {
+		return nil, ErrInvalidWindow
+	}
+
+	p := Promotion{
+		ID:             uuid.New().String(),
+		Name:           np.Name,
+		Kind:           np.Kind,
+		Percent:        np.Percent,
+		Amount:         np.Amount,
+		Currency:       currency,
+		BuyQuantity:    np.BuyQuantity,
+		GetQuantity:    np.GetQuantity,
+		RequiresCoupon: np.RequiresCoupon,
+		DateCreated:    now.UTC(),
+	}
+	if np.ProductID != "" {
+		if _, err := uuid.Parse(np.ProductID); err != nil {
+			return nil, ErrInvalidID
+		}
+		p.ProductID = &np.ProductID
+	}
+	if np.Category != "" {
+		p.Category = &np.Category
+	}
+	if np.StartsAt != nil {
+		t := np.StartsAt.UTC()
+		p.StartsAt = &t
+	}
+	if np.EndsAt != nil {
+		t := np.EndsAt.UTC()
+		p.EndsAt = &t
+	}
+
+	const q = `
+		INSERT INTO promotions
+		(promotion_id, name, kind, percent, amount, currency, buy_quantity, get_quantity,
+		product_id, category, requires_coupon, starts_at, ends_at, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`
+
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, q,
+			p.ID, p.Name, p.Kind, p.Percent, p.Amount, p.Currency, p.BuyQuantity, p.GetQuantity,
+			p.ProductID, p.Category, p.RequiresCoupon, p.StartsAt, p.EndsAt, p.DateCreated,
+		)
+		if err != nil {
+			return errors.Wrap(err, "inserting promotion")
+		}
+
+		c := audit.Change{EntityType: auditPromotion, EntityID: p.ID, Action: audit.Create, After: p}
+		_, err = audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// ListPromotions gets all Promotions, newest first.
+func ListPromotions(ctx context.Context, db *sqlx.DB) ([]Promotion, error) {
+	promotions := []Promotion{}
+
+	const q = `SELECT * FROM promotions ORDER BY date_created DESC`
+	if err := db.SelectContext(ctx, &promotions, q); err != nil {
+		return nil, errors.Wrap(err, "selecting promotions")
+	}
+
+	return promotions, nil
+}
+
+// CreateCoupon adds a Coupon for an existing Promotion.
+func CreateCoupon(ctx context.Context, db *sqlx.DB, nc NewCoupon, now time.Time) (*Coupon, error) {
+	if _, err := uuid.Parse(nc.PromotionID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	code := normalise(nc.Code)
+	if code == "" {
+		return nil, ErrInvalidCoupon
+	}
+	if nc.UsageLimit != nil && *nc.UsageLimit <= 0 {
+		return nil, ErrInvalidQuantity
+	}
+
+	var exists bool
+	const qe = `SELECT EXISTS (SELECT 1 FROM promotions WHERE promotion_id = $1)`
+	if err := db.GetContext(ctx, &exists, qe, nc.PromotionID); err != nil {
+		return nil, errors.Wrap(err, "checking promotion")
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	c := Coupon{
+		Code:        code,
+		PromotionID: nc.PromotionID,
+		UsageLimit:  nc.UsageLimit,
+		DateCreated: now.UTC(),
+	}
+
+	const q = `
+		INSERT INTO coupons
+		(code, promotion_id, usage_limit, times_used, date_created)
+		VALUES ($1, $2, $3, 0, $4)`
+
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, q, c.Code, c.PromotionID, c.UsageLimit, c.DateCreated); err != nil {
+			return errors.Wrap(err, "inserting coupon")
+		}
+
+		ac := audit.Change{EntityType: auditCoupon, EntityID: c.Code, Action: audit.Create, After: c}
+		_, err := audit.Record(ctx, tx, ac, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// ListCoupons gets every Coupon for a Promotion.
+func ListCoupons(ctx context.Context, db *sqlx.DB, promotionID string) ([]Coupon, error) {
+	if _, err := uuid.Parse(promotionID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	coupons := []Coupon{}
+
+	const q = `SELECT * FROM coupons WHERE promotion_id = $1 ORDER BY code`
+	if err := db.SelectContext(ctx, &coupons, q, promotionID); err != nil {
+		return nil, errors.Wrap(err, "selecting coupons")
+	}
+
+	return coupons, nil
+}
+
+// QuoteBasket prices a Basket at time now without recording anything. Items
+// are priced from the price list of the Basket currency when one is given;
+// otherwise every item in the Basket must be sold in the same currency.
+func QuoteBasket(ctx context.Context, db *sqlx.DB, b Basket, now time.Time) (*Quote, error) {
+	if b.Currency != "" {
+		var err error
+		if b.Currency, err = money.Currency(b.Currency); err != nil {
+			return nil, err
+		}
+	}
+
+	lines := make([]Line, 0, len(b.Items))
+	for _, it := range b.Items {
+		if _, err := uuid.Parse(it.ProductID); err != nil {
+			return nil, ErrInvalidID
+		}
+		if it.Quantity <= 0 {
+			return nil, ErrInvalidQuantity
+		}
+
+		l, err := line(ctx, db, it.ProductID, it.VariantID, b.Currency, it.Quantity, now)
+		if err != nil {
+			return nil, err
+		}
+		if len(lines) > 0 && l.Currency != lines[0].Currency {
+			return nil, money.ErrMismatch
+		}
+		lines = append(lines, *l)
+	}
+
+	q, err := quote(ctx, db, lines, b.Coupons, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) > 0 {
+		q.Currency = lines[0].Currency
+	}
+
+	return q, nil
+}
+
+// SaleHook prices Sales recorded without an amount paid. A Sale given a
+// Paid of zero is free and is left alone. The Sale is charged
+// the quoted total and records the Promotion that produced it. When that
+// Promotion was unlocked by a Coupon the use of the Coupon is counted in the
+// same transaction as the Sale.
+func SaleHook() product.SaleHook {
+	return product.SaleHook{Before: priceSale}
+}
+
+// priceSale is the Before step of SaleHook.
+func priceSale(ctx context.Context, tx *sqlx.Tx, s *product.Sale, ns product.NewSale) error {
+	if ns.Paid != nil {
+		if ns.Coupon != "" {
+			return ErrCouponWithPaid
+		}
+		return nil
+	}
+
+	category, err := categoryOf(ctx, tx, s.ProductID)
+	if err != nil {
+		return err
+	}
+
+	l := Line{
+		ProductID: s.ProductID,
+		Category:  category,
+		Quantity:  s.Quantity,
+		Currency:  s.ListPrice.Currency,
+		UnitPrice: s.ListPrice.Amount,
+	}
+	if s.VariantID != nil {
+		l.VariantID = *s.VariantID
+	}
+
+	var codes []string
+	if ns.Coupon != "" {
+		codes = append(codes, ns.Coupon)
+	}
+
+	q, err := quote(ctx, tx, []Line{l}, codes, s.DateCreated)
+	if err != nil {
+		return err
+	}
+
+	s.Paid.Amount = q.Total
+
+	a := q.Lines[0].Applied
+	if a == nil {
+		return nil
+	}
+	s.PromotionID = &a.PromotionID
+
+	if a.Coupon != "" {
+		if err := redeem(ctx, tx, a.Coupon); err != nil {
+			return err
+		}
+		s.Coupon = a.Coupon
+	}
+
+	return nil
+}
+
+// line looks up what is needed to price an item in currency, or in the
+// currency of its Product when that is empty.
+func line(ctx context.Context, db sqlx.QueryerContext, productID, variantID, currency string, quantity int, now time.Time) (*Line, error) {
+	var p struct {
+		Category string `db:"category"`
+		Currency string `db:"currency"`
+	}
+	const q = `SELECT category, currency FROM products WHERE product_id = $1`
+	if err := sqlx.GetContext(ctx, db, &p, q, productID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, product.ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting product")
+	}
+	if currency == "" {
+		currency = p.Currency
+	}
+
+	price, err := product.UnitPriceIn(ctx, db, productID, variantID, currency, now)
+	if err != nil {
+		return nil, err
+	}
+
+	l := Line{
+		ProductID: productID,
+		VariantID: variantID,
+		Category:  p.Category,
+		Currency:  currency,
+		Quantity:  quantity,
+		UnitPrice: price,
+	}
+
+	return &l, nil
+}
+
+// categoryOf gets the category of a Product.
+func categoryOf(ctx context.Context, db sqlx.QueryerContext, productID string) (string, error) {
+	var category string
+	const q = `SELECT category FROM products WHERE product_id = $1`
+	if err := sqlx.GetContext(ctx, db, &category, q, productID); err != nil {
+		if err == sql.ErrNoRows {
+			return "", product.ErrNotFound
+		}
+		return "", errors.Wrap(err, "selecting product category")
+	}
+
+	return category, nil
+}
+
+// quote prices lines with the Promotions running at now and the Promotions
+// unlocked by codes.
+func quote(ctx context.Context, db sqlx.QueryerContext, lines []Line, codes []string, now time.Time) (*Quote, error) {
+	coupons := make(map[string]string)
+	for _, code := range codes {
+		c, err := retriveCoupon(ctx, db, code)
+		if err != nil {
+			return nil, err
+		}
+		if c.UsageLimit != nil && c.TimesUsed >= *c.UsageLimit {
+			return nil, ErrCouponUsedUp
+		}
+		coupons[c.PromotionID] = c.Code
+	}
+
+	promotions := []Promotion{}
+	const q = `
+		SELECT * FROM promotions
+		WHERE (starts_at IS NULL OR starts_at <= $1)
+		AND (ends_at IS NULL OR ends_at > $1)`
+
+	if err := sqlx.SelectContext(ctx, db, &promotions, q, now.UTC()); err != nil {
+		return nil, errors.Wrap(err, "selecting running promotions")
+	}
+
+	qt := Price(lines, promotions, coupons, now.UTC())
+	return &qt, nil
+}
+
+// retriveCoupon finds the Coupon for code.
+func retriveCoupon(ctx context.Context, db sqlx.QueryerContext, code string) (*Coupon, error) {
+	var c Coupon
+	const q = `SELECT * FROM coupons WHERE code = $1`
+	if err := sqlx.GetContext(ctx, db, &c, q, normalise(code)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrCouponNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting coupon %q", code)
+	}
+
+	return &c, nil
+}
+
+// redeem counts one use of a Coupon. The check against the usage limit is
+// part of the update so concurrent sales can not use a Coupon more times than
+// it allows.
+func redeem(ctx context.Context, tx *sqlx.Tx, code string) error {
+	const q = `
+		UPDATE coupons SET times_used = times_used + 1
+		WHERE code = $1 AND (usage_limit IS NULL OR times_used < usage_limit)`
+
+	res, err := tx.ExecContext(ctx, q, code)
+	if err != nil {
+		return errors.Wrapf(err, "redeeming coupon %q", code)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "redeeming coupon %q", code)
+	}
+	if n == 0 {
+		return ErrCouponUsedUp
+	}
+
+	return nil
+}
+
+// normalise puts a coupon code in the form it is stored in.
+func normalise(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
@@ -0,0 +1,159 @@
+package pricing_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/pricing"
+	"github.com/vikramcse/the-service/internal/product"
+	"github.com/vikramcse/the-service/internal/tests"
+)
+
+func TestPrice(t *testing.T) {
+	now := time.Date(2019, time.January, 1, 12, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+	comics := "comics"
+	toyID := "a2b0639f-2cc6-44b8-b97b-15d69dbb511e"
+
+	promotions := []pricing.Promotion{
+		{ID: "tenth", Name: "10% off comics", Kind: pricing.PercentOff, Percent: 10, Category: &comics},
+		{ID: "three", Name: "Buy 2 get 1", Kind: pricing.BuyXGetY, BuyQuantity: 2, GetQuantity: 1, ProductID: &toyID},
+		{ID: "later", Name: "Starts later", Kind: pricing.AmountOff, Amount: 100, Currency: "USD", StartsAt: &later},
+		{ID: "coupon", Name: "Coupon", Kind: pricing.AmountOff, Amount: 1, Currency: "USD", RequiresCoupon: true},
+	}
+
+	lines := []pricing.Line{
+		{ProductID: "comic", Category: "comics", Currency: "USD", Quantity: 3, UnitPrice: 15},
+		{ProductID: toyID, Currency: "USD", Quantity: 7, UnitPrice: 5},
+		{ProductID: "other", Currency: "USD", Quantity: 1, UnitPrice: 20},
+	}
+
+	q := pricing.Price(lines, promotions, nil, now)
+
+	tests := []struct {
+		discount  int
+		promotion string
+	}{
+		{5, "tenth"}, // 10% of 45 rounds up from 4.5.
+		{10, "three"},
+		{0, ""},
+	}
+	for i, tt := range tests {
+		l := q.Lines[i]
+		if l.Discount != tt.discount {
+			t.Errorf("line %d: expected discount %d, got %d", i, tt.discount, l.Discount)
+		}
+		var got string
+		if l.Applied != nil {
+			got = l.Applied.PromotionID
+		}
+		if got != tt.promotion {
+			t.Errorf("line %d: expected promotion %q, got %q", i, tt.promotion, got)
+		}
+	}
+	if q.Subtotal != 100 || q.Discount != 15 || q.Total != 85 {
+		t.Fatalf("expected 100 - 15 = 85, got %d - %d = %d", q.Subtotal, q.Discount, q.Total)
+	}
+
+	// With the coupon the third line gets its discount and the first line
+	// keeps the better one it already had.
+	q = pricing.Price(lines, promotions, map[string]string{"coupon": "SAVE"}, now)
+	if a := q.Lines[2].Applied; a == nil || a.Coupon != "SAVE" || a.Discount != 1 {
+		t.Fatalf("expected coupon to apply to the last line, got %+v", a)
+	}
+	if a := q.Lines[0].Applied; a == nil || a.PromotionID != "tenth" {
+		t.Fatalf("expected the best promotion to win, got %+v", a)
+	}
+
+	// Once the later promotion starts it beats the others but never takes
+	// more than the line is worth.
+	q = pricing.Price(lines, promotions, nil, later)
+	if q.Total != 0 {
+		t.Fatalf("expected everything free, got total %d", q.Total)
+	}
+
+	// An amount off in dollars is not taken off a line priced in euros.
+	euros := []pricing.Line{{ProductID: "other", Currency: "EUR", Quantity: 1, UnitPrice: 20}}
+	q = pricing.Price(euros, promotions, nil, later)
+	if q.Discount != 0 {
+		t.Fatalf("expected no discount in another currency, got %+v", q.Lines[0].Applied)
+	}
+}
+
+func TestCoupons(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	p, err := product.Create(ctx, db, product.NewProduct{Name: "Comic Book", Category: "comics", Cost: 20, Quantity: 10}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+
+	promo, err := pricing.CreatePromotion(ctx, db, pricing.NewPromotion{
+		Name:           "Half price comics",
+		Kind:           pricing.PercentOff,
+		Percent:        50,
+		Category:       "comics",
+		RequiresCoupon: true,
+	}, now)
+	if err != nil {
+		t.Fatalf("creating promotion: %s", err)
+	}
+
+	bad := pricing.NewPromotion{Name: "Money off", Kind: pricing.AmountOff, Amount: 5, Currency: "XYZ"}
+	if _, err := pricing.CreatePromotion(ctx, db, bad, now); err != money.ErrUnknownCurrency {
+		t.Fatalf("expected %v creating an amount off in an unknown currency, got %v", money.ErrUnknownCurrency, err)
+	}
+
+	limit := 1
+	if _, err := pricing.CreateCoupon(ctx, db, pricing.NewCoupon{Code: " half ", PromotionID: promo.ID, UsageLimit: &limit}, now); err != nil {
+		t.Fatalf("creating coupon: %s", err)
+	}
+
+	b := pricing.Basket{
+		Items:   []pricing.Item{{ProductID: p.ID, Quantity: 2}},
+		Coupons: []string{"Half"},
+	}
+	q, err := pricing.QuoteBasket(ctx, db, b, now)
+	if err != nil {
+		t.Fatalf("quoting basket: %s", err)
+	}
+	if q.Total != 20 {
+		t.Fatalf("expected quote of 20, got %d", q.Total)
+	}
+
+	hook := pricing.SaleHook()
+	s, err := product.AddSale(ctx, db, product.NewSale{Quantity: 2, Coupon: "half"}, p.ID, now, hook)
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	if s.Paid.Amount != 20 || s.PromotionID == nil || *s.PromotionID != promo.ID || s.Coupon != "HALF" {
+		t.Fatalf("expected sale priced by the coupon, got %+v", s)
+	}
+
+	if _, err := product.AddSale(ctx, db, product.NewSale{Quantity: 1, Coupon: "half"}, p.ID, now, hook); err != pricing.ErrCouponUsedUp {
+		t.Fatalf("expected %v, got %v", pricing.ErrCouponUsedUp, err)
+	}
+
+	s, err = product.AddSale(ctx, db, product.NewSale{Quantity: 1}, p.ID, now, hook)
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	if s.Paid.Amount != 20 || s.PromotionID != nil {
+		t.Fatalf("expected sale at list price, got %+v", s)
+	}
+
+	// A sale given away is not priced.
+	s, err = product.AddSale(ctx, db, product.NewSale{Quantity: 1, Paid: &money.Money{}}, p.ID, now, hook)
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	if s.Paid.Amount != 0 || s.PromotionID != nil {
+		t.Fatalf("expected free sale, got %+v", s)
+	}
+}
@@ -0,0 +1,159 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/exchange"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/database"
+)
+
+var ErrBaseCurrency = errors.New("Product is priced in its own currency with SetPrice")
+
+// SetCurrencyPrice puts a Product on the price list of another currency,
+// replacing any price it had there.
+func SetCurrencyPrice(ctx context.Context, db *sqlx.DB, ncp NewCurrencyPrice, productID string, now time.Time) (*CurrencyPrice, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	if ncp.Cost < 0 {
+		return nil, ErrInvalidCost
+	}
+
+	currency, err := money.Currency(ncp.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := currencyOf(ctx, db, productID)
+	if err != nil {
+		return nil, err
+	}
+	if currency == base {
+		return nil, ErrBaseCurrency
+	}
+
+	cp := CurrencyPrice{
+		ProductID:   productID,
+		Currency:    currency,
+		Cost:        ncp.Cost,
+		DateUpdated: now.UTC(),
+	}
+
+	err = database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		var before interface{}
+		var cost int
+		const qc = `SELECT cost FROM currency_prices WHERE product_id = $1 AND currency = $2 FOR UPDATE`
+		switch err := tx.GetContext(ctx, &cost, qc, cp.ProductID, cp.Currency); err {
+		case nil:
+			before = map[string]interface{}{"currency": cp.Currency, "cost": cost}
+		case sql.ErrNoRows:
+		default:
+			return errors.Wrap(err, "selecting currency price")
+		}
+
+		const q = `
+			INSERT INTO currency_prices
+			(product_id, currency, cost, date_updated)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (product_id, currency)
+			DO UPDATE SET cost = EXCLUDED.cost, date_updated = EXCLUDED.date_updated`
+
+		if _, err := tx.ExecContext(ctx, q, cp.ProductID, cp.Currency, cp.Cost, cp.DateUpdated); err != nil {
+			return errors.Wrap(err, "inserting currency price")
+		}
+
+		c := audit.Change{
+			EntityType: auditEntity,
+			EntityID:   cp.ProductID,
+			Action:     auditSetCurrencyPrice,
+			Before:     before,
+			After:      map[string]interface{}{"currency": cp.Currency, "cost": cp.Cost},
+		}
+		_, err := audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &cp, nil
+}
+
+// PriceList gets every Product priced in currency. Products sold in currency
+// are listed at their own cost.
+func PriceList(ctx context.Context, db *sqlx.DB, currency string) ([]CurrencyPrice, error) {
+	currency, err := money.Currency(currency)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []CurrencyPrice{}
+
+	const q = `
+		SELECT product_id, currency, cost, date_updated FROM currency_prices
+		WHERE currency = $1
+		UNION ALL
+		SELECT product_id, currency, cost, date_updated FROM products
+		WHERE currency = $1
+		ORDER BY product_id`
+
+	if err := db.SelectContext(ctx, &list, q, currency); err != nil {
+		return nil, errors.Wrap(err, "selecting price list")
+	}
+
+	return list, nil
+}
+
+// UnitPriceIn is UnitPrice in currency. A Variant with its own cost, or a
+// Product missing from the price list of currency, has its price converted at
+// the exchange rate in effect at time t.
+func UnitPriceIn(ctx context.Context, db sqlx.QueryerContext, productID, variantID, currency string, t time.Time) (int, error) {
+	base, err := currencyOf(ctx, db, productID)
+	if err != nil {
+		return 0, err
+	}
+	if currency == base {
+		return UnitPrice(ctx, db, productID, variantID, t)
+	}
+
+	var own bool
+	if variantID != "" {
+		v, err := retriveVariant(ctx, db, productID, variantID)
+		if err != nil {
+			return 0, err
+		}
+		own = v.Cost != nil
+	}
+
+	if !own {
+		var cost int
+		const q = `SELECT cost FROM currency_prices WHERE product_id = $1 AND currency = $2`
+		err := sqlx.GetContext(ctx, db, &cost, q, productID, currency)
+		if err == nil {
+			return cost, nil
+		}
+		if err != sql.ErrNoRows {
+			return 0, errors.Wrap(err, "selecting currency price")
+		}
+	}
+
+	price, err := UnitPrice(ctx, db, productID, variantID, t)
+	if err != nil {
+		return 0, err
+	}
+
+	m, _, err := exchange.Convert(ctx, db, money.Money{Amount: price, Currency: base}, currency, t)
+	if err != nil {
+		return 0, err
+	}
+
+	return m.Amount, nil
+}
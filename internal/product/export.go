@@ -0,0 +1,158 @@
+package product
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/platform/period"
+)
+
+// ExportProducts writes every Product created from from until to to w in
+// format, CSV or JSONL, oldest first. A zero from or to leaves that end of the
+// period open. Products are read and written one at a time, so an export of
+// any size takes the same memory. It returns how many were written.
+func ExportProducts(ctx context.Context, db *sqlx.DB, w io.Writer, format string, from, to time.Time) (int, error) {
+	header := []string{
+		"id", "sku", "name", "category", "tax_class", "costing", "currency", "cost",
+		"quantity", "sold", "available", "revenue", "cogs", "gross_margin", "stock_value",
+		"reorder_threshold", "reorder_quantity", "date_created", "date_updated",
+	}
+	record := func(v interface{}) []string {
+		p := v.(*Product)
+		return []string{
+			p.ID, p.SKU, p.Name, p.Category, p.TaxClass, p.Costing, p.Cost.Currency, strconv.Itoa(p.Cost.Amount),
+			strconv.Itoa(p.Quantity), strconv.Itoa(p.Sold), strconv.Itoa(p.Available),
+			strconv.Itoa(p.Revenue.Amount), strconv.Itoa(p.COGS.Amount),
+			strconv.Itoa(p.GrossMargin.Amount), strconv.Itoa(p.StockValue.Amount),
+			strconv.Itoa(p.ReorderThreshold), strconv.Itoa(p.ReorderQuantity),
+			p.DateCreated.Format(time.RFC3339), p.DateUpdated.Format(time.RFC3339),
+		}
+	}
+
+	const q = `
+			SELECT ` + columns + `
+			FROM products as p
+			LEFT JOIN sales as s ON(p.product_id=s.product_id)
+			WHERE ($1::timestamp IS NULL OR p.date_created >= $1)
+			AND ($2::timestamp IS NULL OR p.date_created < $2)
+			GROUP BY p.product_id
+			ORDER BY p.date_created, p.product_id`
+
+	newRow := func() interface{} { return &Product{} }
+
+	n, err := export(ctx, db, w, format, from, to, q, header, newRow, record)
+	return n, errors.Wrap(err, "exporting products")
+}
+
+// ExportSales writes every Sale made from from until to to w in format, CSV
+// or JSONL, oldest first. A zero from or to leaves that end of the period
+// open. Sales are read and written one at a time, so an export of any size
+// takes the same memory. It returns how many were written.
+func ExportSales(ctx context.Context, db *sqlx.DB, w io.Writer, format string, from, to time.Time) (int, error) {
+	header := []string{
+		"id", "product_id", "variant_id", "location_id", "customer_id", "session_id",
+		"quantity", "currency", "list_price", "paid", "net", "tax", "gross",
+		"tax_class", "tax_rate", "tax_inclusive", "promotion_id", "coupon",
+		"points_earned", "points_redeemed", "points_value", "gift_card_paid", "refunded", "returned",
+		"base_currency", "base_paid", "exchange_rate", "cogs", "date_created",
+	}
+	record := func(v interface{}) []string {
+		s := v.(*Sale)
+		return []string{
+			s.ID, s.ProductID, optional(s.VariantID), s.LocationID, optional(s.CustomerID), optional(s.SessionID),
+			strconv.Itoa(s.Quantity), s.Paid.Currency, strconv.Itoa(s.ListPrice.Amount), strconv.Itoa(s.Paid.Amount),
+			strconv.Itoa(s.Net.Amount), strconv.Itoa(s.Tax.Amount), strconv.Itoa(s.Gross.Amount),
+			s.TaxClass, strconv.Itoa(s.TaxRate), strconv.FormatBool(s.TaxInclusive), optional(s.PromotionID), s.Coupon,
+			strconv.Itoa(s.PointsEarned), strconv.Itoa(s.PointsRedeemed),
+			strconv.Itoa(s.PointsValue.Amount), strconv.Itoa(s.GiftCardPaid.Amount), strconv.Itoa(s.Refunded.Amount), strconv.Itoa(s.Returned),
+			s.BasePaid.Currency, strconv.Itoa(s.BasePaid.Amount), s.ExchangeRate, strconv.Itoa(s.COGS.Amount),
+			s.DateCreated.Format(time.RFC3339),
+		}
+	}
+
+	const q = `
+			SELECT ` + saleColumns + ` FROM sales as s
+			WHERE ($1::timestamp IS NULL OR s.date_created >= $1)
+			AND ($2::timestamp IS NULL OR s.date_created < $2)
+			ORDER BY s.date_created, s.sale_id`
+
+	newRow := func() interface{} { return &Sale{} }
+
+	n, err := export(ctx, db, w, format, from, to, q, header, newRow, record)
+	return n, errors.Wrap(err, "exporting sales")
+}
+
+// export runs q over the period from until to and writes each row it selects
+// to w in format as it is scanned into newRow. A CSV export has header as its
+// first line and record turns each row into its fields. Nothing is written
+// when the query fails.
+func export(
+	ctx context.Context, db *sqlx.DB, w io.Writer, format string, from, to time.Time,
+	q string, header []string, newRow func() interface{}, record func(interface{}) []string,
+) (int, error) {
+	if format != CSV && format != JSONL {
+		return 0, ErrInvalidFormat
+	}
+	args, err := period.Args(from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := db.QueryxContext(ctx, q, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	bw := bufio.NewWriter(w)
+	cw := csv.NewWriter(bw)
+	enc := json.NewEncoder(bw)
+
+	if format == CSV {
+		if err := cw.Write(header); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	for rows.Next() {
+		v := newRow()
+		if err := rows.StructScan(v); err != nil {
+			return n, err
+		}
+
+		if format == CSV {
+			err = cw.Write(record(v))
+		} else {
+			err = enc.Encode(v)
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// optional is the value of s, or "" when there is none.
+func optional(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
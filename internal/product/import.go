@@ -0,0 +1,465 @@
+package product
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/money"
+)
+
+var (
+	ErrMalformed     = errors.New("Import is not well formed")
+	ErrInvalidFormat = errors.New("format must be csv or jsonl")
+	ErrInvalidMode   = errors.New("mode must be transaction or chunked")
+	ErrCostingChange = errors.New("Costing of an existing product can not change")
+)
+
+// Formats a catalog can be imported from.
+const (
+	// CSV has a header line naming the columns, which are the JSON names of
+	// the fields of NewProduct. Only name is required.
+	CSV = "csv"
+
+	// JSONL has one NewProduct as a JSON object on each line.
+	JSONL = "jsonl"
+)
+
+// Modes of importing a catalog.
+const (
+	// Transaction imports every row or, when any is rejected, none.
+	Transaction = "transaction"
+
+	// Chunked commits rows in chunks as it goes and skips rejected rows.
+	Chunked = "chunked"
+)
+
+// stockIgnored is the Reason given for an updated row that has stock, which
+// only the inventory ledger changes once a Product exists.
+const stockIgnored = "quantity and unit_cost of an existing product are ignored; adjust its stock instead"
+
+// DefaultChunkSize is how many rows a Chunked import commits at once when
+// not told.
+const DefaultChunkSize = 100
+
+// Outcomes of importing a row.
+const (
+	Created  = "created"
+	Updated  = "updated"
+	Rejected = "rejected"
+)
+
+// Import loads Products from the catalog in r, a row at a time. Every row is
+// validated like a NewProduct, and a row whose SKU is taken updates that
+// Product instead: its name, category, tax class, price and reorder settings
+// are replaced, but its currency and costing can not change and its stock is
+// left to the inventory ledger. The ImportReport says what became of each
+// row. An error is only returned when the catalog can not be read or stored
+// at all, and chunks a Chunked import already committed stay.
+func Import(ctx context.Context, db *sqlx.DB, r io.Reader, opts ImportOptions, now time.Time) (*ImportReport, error) {
+	if opts.Format == "" {
+		opts.Format = CSV
+	}
+	if opts.Mode == "" {
+		opts.Mode = Transaction
+	}
+	if opts.Mode != Transaction && opts.Mode != Chunked {
+		return nil, ErrInvalidMode
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+
+	var rows rowReader
+	switch opts.Format {
+	case CSV:
+		cr, err := newCSVReader(r)
+		if err != nil {
+			return nil, err
+		}
+		rows = cr
+	case JSONL:
+		rows = newJSONLReader(r)
+	default:
+		return nil, ErrInvalidFormat
+	}
+
+	rep := ImportReport{Rows: []ImportRow{}, Mode: opts.Mode}
+
+	// Each chunk is a transaction and each row a savepoint in it, so a row
+	// the database refuses is rejected without losing the others. The rows
+	// of a chunk are audited as it commits, so the audit chains of its
+	// Products are only locked for the end of each chunk.
+	var tx *sqlx.Tx
+	var inTx int
+	var changes []audit.Change
+	commit := func() error {
+		if tx == nil {
+			return nil
+		}
+		for _, c := range changes {
+			if _, err := audit.Record(ctx, tx, c, now); err != nil {
+				return err
+			}
+		}
+		err := tx.Commit()
+		tx, inTx, changes = nil, 0, nil
+		return errors.Wrap(err, "committing import")
+	}
+	rollback := func() {
+		if tx != nil {
+			tx.Rollback()
+			tx, inTx, changes = nil, 0, nil
+		}
+	}
+	defer rollback()
+
+	for n := 1; ; n++ {
+		np, err := rows.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && errors.Cause(err) != ErrMalformed {
+			return nil, err
+		}
+		if err == nil {
+			err = np.Validate()
+		}
+
+		row := ImportRow{Row: n, SKU: np.SKU}
+		if err != nil {
+			row.Status, row.Reason = Rejected, err.Error()
+		} else {
+			if tx == nil {
+				if tx, err = db.BeginTxx(ctx, nil); err != nil {
+					return nil, errors.Wrap(err, "beginning import")
+				}
+			}
+			var c audit.Change
+			if row, c, err = importRow(ctx, tx, row, np, opts.Costing, now); err != nil {
+				return nil, err
+			}
+			if row.Status != Rejected {
+				changes = append(changes, c)
+			}
+			inTx++
+		}
+
+		rep.Rows = append(rep.Rows, row)
+		switch row.Status {
+		case Created:
+			rep.Created++
+		case Updated:
+			rep.Updated++
+		case Rejected:
+			rep.Rejected++
+		}
+
+		if opts.Mode == Chunked && inTx == opts.ChunkSize {
+			if err := commit(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if opts.Mode == Transaction && rep.Rejected > 0 {
+		rollback()
+		return &rep, nil
+	}
+	if err := commit(); err != nil {
+		return nil, err
+	}
+	rep.Committed = true
+
+	return &rep, nil
+}
+
+// importRow creates or updates the Product of a valid row as part of tx,
+// creating it costed by costing when the row does not say. It returns the
+// Change to audit for a row that is not rejected. The row is rejected when
+// it conflicts with what is stored; any other error is returned.
+func importRow(ctx context.Context, tx *sqlx.Tx, row ImportRow, np NewProduct, costing string, now time.Time) (ImportRow, audit.Change, error) {
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT import_row`); err != nil {
+		return row, audit.Change{}, errors.Wrap(err, "saving import row")
+	}
+
+	var id, status string
+	var c audit.Change
+	err := func() error {
+		if np.SKU != "" {
+			existing, uc, err := upsert(ctx, tx, np, now)
+			if err != nil || existing != "" {
+				id, status, c = existing, Updated, uc
+				return err
+			}
+		}
+
+		if np.Costing == "" {
+			np.Costing = costing
+		}
+		p, cc, err := create(ctx, tx, np, now)
+		if err != nil {
+			return err
+		}
+		id, status, c = p.ID, Created, cc
+		return nil
+	}()
+
+	switch err {
+	case nil:
+		row.ProductID, row.Status = id, status
+		if status == Updated && (np.Quantity != 0 || np.UnitCost != 0) {
+			row.Reason = stockIgnored
+		}
+		_, err = tx.ExecContext(ctx, `RELEASE SAVEPOINT import_row`)
+		return row, c, errors.Wrap(err, "releasing import row")
+	case ErrDuplicateSKU, money.ErrMismatch, ErrCostingChange:
+		row.Status, row.Reason = Rejected, err.Error()
+		_, err = tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT import_row`)
+		return row, audit.Change{}, errors.Wrap(err, "rolling back import row")
+	default:
+		return row, audit.Change{}, err
+	}
+}
+
+// upsert updates the Product with the SKU of np as part of tx. It returns the
+// ID of the Product, or "" when there is none with that SKU, and the Change
+// to audit for it.
+func upsert(ctx context.Context, tx *sqlx.Tx, np NewProduct, now time.Time) (string, audit.Change, error) {
+	var p struct {
+		ID               string `db:"product_id"`
+		Name             string `db:"name"`
+		Category         string `db:"category"`
+		TaxClass         string `db:"tax_class"`
+		Cost             int    `db:"cost"`
+		Currency         string `db:"currency"`
+		Costing          string `db:"costing"`
+		ReorderThreshold int    `db:"reorder_threshold"`
+		ReorderQuantity  int    `db:"reorder_quantity"`
+	}
+	const q = `
+		SELECT product_id, COALESCE(name, '') as name, category, tax_class, cost, currency, costing,
+			reorder_threshold, reorder_quantity
+		FROM products WHERE sku = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &p, q, np.SKU); err != nil {
+		if err == sql.ErrNoRows {
+			return "", audit.Change{}, nil
+		}
+		return "", audit.Change{}, errors.Wrapf(err, "selecting product with sku %q", np.SKU)
+	}
+
+	if np.Currency != "" {
+		currency, err := money.Currency(np.Currency)
+		if err != nil {
+			return "", audit.Change{}, err
+		}
+		if currency != p.Currency {
+			return "", audit.Change{}, money.ErrMismatch
+		}
+	}
+	if np.Costing != "" && np.Costing != p.Costing {
+		return "", audit.Change{}, ErrCostingChange
+	}
+
+	taxClass := np.TaxClass
+	if taxClass == "" {
+		taxClass = DefaultTaxClass
+	}
+
+	if np.Cost != p.Cost {
+		pr := Price{
+			ID:            uuid.New().String(),
+			ProductID:     p.ID,
+			Cost:          np.Cost,
+			EffectiveFrom: now.UTC(),
+			DateCreated:   now.UTC(),
+		}
+		if err := insertPrice(ctx, tx, pr); err != nil {
+			return "", audit.Change{}, err
+		}
+	}
+
+	const u = `
+		UPDATE products SET
+			name = $2, category = $3, tax_class = $4, cost = $5,
+			reorder_threshold = $6, reorder_quantity = $7, date_updated = $8
+		WHERE product_id = $1`
+
+	_, err := tx.ExecContext(ctx, u,
+		p.ID, np.Name, np.Category, taxClass, np.Cost,
+		np.ReorderThreshold, np.ReorderQuantity, now.UTC(),
+	)
+	if err != nil {
+		return "", audit.Change{}, errors.Wrap(err, "updating product")
+	}
+
+	c := audit.Change{
+		EntityType: auditEntity,
+		EntityID:   p.ID,
+		Action:     audit.Update,
+		Before: map[string]interface{}{
+			"name": p.Name, "category": p.Category, "tax_class": p.TaxClass, "cost": p.Cost,
+			"reorder_threshold": p.ReorderThreshold, "reorder_quantity": p.ReorderQuantity,
+		},
+		After: map[string]interface{}{
+			"name": np.Name, "category": np.Category, "tax_class": taxClass, "cost": np.Cost,
+			"reorder_threshold": np.ReorderThreshold, "reorder_quantity": np.ReorderQuantity,
+		},
+	}
+	return p.ID, c, nil
+}
+
+// rowReader reads the rows of a catalog one at a time. next gives io.EOF
+// after the last row. A row that can not be read gives an error with
+// ErrMalformed as its cause, and reading carries on with the next row.
+type rowReader interface {
+	next() (NewProduct, error)
+}
+
+// csvReader reads rows of a CSV catalog.
+type csvReader struct {
+	r       *csv.Reader
+	columns []string
+}
+
+// columnsCSV are the columns a CSV catalog may have.
+var columnsCSV = map[string]bool{
+	"sku": true, "name": true, "category": true, "tax_class": true,
+	"cost": true, "currency": true, "quantity": true, "unit_cost": true,
+	"costing": true, "reorder_threshold": true, "reorder_quantity": true,
+}
+
+// newCSVReader reads the header of a CSV catalog from r.
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, errors.Wrap(ErrMalformed, "missing header")
+		}
+		return nil, errors.Wrap(ErrMalformed, err.Error())
+	}
+
+	columns := make([]string, len(header))
+	seen := map[string]bool{}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if !columnsCSV[h] {
+			return nil, errors.Wrapf(ErrMalformed, "unknown column %q", h)
+		}
+		if seen[h] {
+			return nil, errors.Wrapf(ErrMalformed, "column %q given twice", h)
+		}
+		seen[h] = true
+		columns[i] = h
+	}
+	if !seen["name"] {
+		return nil, errors.Wrap(ErrMalformed, "missing column \"name\"")
+	}
+
+	return &csvReader{r: cr, columns: columns}, nil
+}
+
+func (cr *csvReader) next() (NewProduct, error) {
+	var np NewProduct
+
+	rec, err := cr.r.Read()
+	if err != nil {
+		if pe, ok := err.(*csv.ParseError); ok {
+			return np, errors.Wrap(ErrMalformed, pe.Err.Error())
+		}
+		return np, err
+	}
+	if len(rec) != len(cr.columns) {
+		return np, errors.Wrapf(ErrMalformed, "expected %d fields, got %d", len(cr.columns), len(rec))
+	}
+
+	for i, v := range rec {
+		v = strings.TrimSpace(v)
+		var n *int
+		switch cr.columns[i] {
+		case "sku":
+			np.SKU = v
+		case "name":
+			np.Name = v
+		case "category":
+			np.Category = v
+		case "tax_class":
+			np.TaxClass = v
+		case "currency":
+			np.Currency = v
+		case "costing":
+			np.Costing = v
+		case "cost":
+			n = &np.Cost
+		case "quantity":
+			n = &np.Quantity
+		case "unit_cost":
+			n = &np.UnitCost
+		case "reorder_threshold":
+			n = &np.ReorderThreshold
+		case "reorder_quantity":
+			n = &np.ReorderQuantity
+		}
+		if n == nil || v == "" {
+			continue
+		}
+		if *n, err = strconv.Atoi(v); err != nil {
+			return np, errors.Wrapf(ErrMalformed, "%s %q is not a whole number", cr.columns[i], v)
+		}
+	}
+
+	return np, nil
+}
+
+// jsonlReader reads rows of a JSON Lines catalog. Blank lines are skipped.
+type jsonlReader struct {
+	s *bufio.Scanner
+}
+
+// maxLine is the longest line a JSON Lines catalog may have.
+const maxLine = 1 << 20
+
+func newJSONLReader(r io.Reader) *jsonlReader {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), maxLine)
+	return &jsonlReader{s: s}
+}
+
+func (jr *jsonlReader) next() (NewProduct, error) {
+	var np NewProduct
+	for jr.s.Scan() {
+		b := bytes.TrimSpace(jr.s.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&np); err != nil {
+			return NewProduct{}, errors.Wrap(ErrMalformed, err.Error())
+		}
+		return np, nil
+	}
+
+	// A line too long to read stops the scanner, so it ends the import.
+	if err := jr.s.Err(); err != nil {
+		return np, errors.Wrap(err, "reading catalog")
+	}
+	return np, io.EOF
+}
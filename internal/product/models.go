@@ -1,42 +1,367 @@
 package product
 
 import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
 	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/money"
 )
 
-// Product is an item we sell.
+// DefaultTaxClass is the tax class of Products created without one.
+const DefaultTaxClass = "standard"
+
+// DefaultCurrency is the currency of Products created without one.
+const DefaultCurrency = "USD"
+
+// Product is an item we sell. Available is the stock of the Product and all
+// its Variants that is neither sold nor held by an active reservation. Cost
+// is the price it sells at, and it and Revenue are in the currency the
+// Product is sold in.
 type Product struct {
-	ID          string    `db:"product_id" json:"id"`
-	Name        string    `db:"name" json:"name"`
+	ID          string      `db:"product_id" json:"id"`
+	SKU         string      `db:"sku" json:"sku,omitempty"`
+	Name        string      `db:"name" json:"name"`
+	Category    string      `db:"category" json:"category"`
+	TaxClass    string      `db:"tax_class" json:"tax_class"`
+	Cost        money.Money `db:"cost" json:"cost"`
+	Quantity    int         `db:"quantity" json:"quantity"`
+	Sold        int         `db:"sold" json:"sold"`
+	Available   int         `db:"available" json:"available"`
+	Revenue     money.Money `db:"revenue" json:"revenue"`
+	DateCreated time.Time   `db:"date_created" json:"date_created"`
+	DateUpdated time.Time   `db:"date_updated" json:"date_updated"`
+
+	// Costing is how the stock of the Product is costed as it is sold, one
+	// of inventory.FIFO or inventory.Average. COGS is what the units sold
+	// cost us, GrossMargin is Revenue less COGS and StockValue is what the
+	// stock in hand of the Product and its Variants cost. They are in the
+	// currency of Revenue.
+	Costing     string      `db:"costing" json:"costing"`
+	COGS        money.Money `db:"cogs" json:"cogs"`
+	GrossMargin money.Money `db:"gross_margin" json:"gross_margin"`
+	StockValue  money.Money `db:"stock_value" json:"stock_value"`
+
+	// ReorderThreshold is the remaining stock at or below which the Product
+	// should be reordered, and ReorderQuantity is how many units to order. A
+	// zero threshold turns low-stock alerts off.
+	ReorderThreshold int `db:"reorder_threshold" json:"reorder_threshold"`
+	ReorderQuantity  int `db:"reorder_quantity" json:"reorder_quantity"`
+
+	// Variants is the matrix of option combinations a Product is sold in. It
+	// is only populated when retrieving a single Product.
+	Variants []Variant `db:"-" json:"variants,omitempty"`
+}
+
+// SearchResult is a Product found by a search. Rank is how well it matches,
+// higher being better, and Highlight is its name escaped for HTML with the
+// words that match marked with <mark> tags.
+type SearchResult struct {
+	Product
+	Rank      float64 `db:"rank" json:"rank"`
+	Highlight string  `db:"highlight" json:"highlight"`
+}
+
+// NewProduct is what we require from clients when adding a Product. Cost is
+// in minor units of Currency. An empty TaxClass gives the Product the
+// DefaultTaxClass and an empty Currency the DefaultCurrency. UnitCost is what
+// each unit of the opening Quantity cost us, also in Currency, and an empty
+// Costing costs stock by inventory.FIFO. SKU is optional but must be unique
+// when given; it is how imports find the Product to update.
+type NewProduct struct {
+	SKU              string `json:"sku"`
+	Name             string `json:"name"`
+	Category         string `json:"category"`
+	TaxClass         string `json:"tax_class"`
+	Cost             int    `json:"cost"`
+	Currency         string `json:"currency"`
+	Quantity         int    `json:"quantity"`
+	UnitCost         int    `json:"unit_cost"`
+	Costing          string `json:"costing"`
+	ReorderThreshold int    `json:"reorder_threshold"`
+	ReorderQuantity  int    `json:"reorder_quantity"`
+}
+
+// UpdateReorder is what clients may change about when a Product is
+// reordered. Fields left nil are not changed.
+type UpdateReorder struct {
+	ReorderThreshold *int `json:"reorder_threshold"`
+	ReorderQuantity  *int `json:"reorder_quantity"`
+}
+
+// LowStock is a Product whose remaining stock has fallen to or below its
+// reorder threshold.
+type LowStock struct {
+	ProductID        string `db:"product_id" json:"product_id"`
+	Name             string `db:"name" json:"name"`
+	Quantity         int    `db:"quantity" json:"quantity"`
+	Sold             int    `db:"sold" json:"sold"`
+	Remaining        int    `db:"remaining" json:"remaining"`
+	ReorderThreshold int    `db:"reorder_threshold" json:"reorder_threshold"`
+	ReorderQuantity  int    `db:"reorder_quantity" json:"reorder_quantity"`
+}
+
+// Variant is one combination of options (e.g. size M, colour red) of a
+// Product. Each Variant tracks its own stock and may override the price of
+// its parent Product. Cost and Revenue are in the currency the Product is
+// sold in.
+type Variant struct {
+	ID          string       `db:"variant_id" json:"id"`
+	ProductID   string       `db:"product_id" json:"product_id"`
+	SKU         string       `db:"sku" json:"sku"`
+	Options     Options      `db:"options" json:"options"`
+	Cost        *money.Money `db:"-" json:"cost,omitempty"`
+	Quantity    int          `db:"quantity" json:"quantity"`
+	Sold        int          `db:"sold" json:"sold"`
+	Available   int          `db:"available" json:"available"`
+	Revenue     money.Money  `db:"revenue" json:"revenue"`
+	DateCreated time.Time    `db:"date_created" json:"date_created"`
+	DateUpdated time.Time    `db:"date_updated" json:"date_updated"`
+}
+
+// variantRow is a Variant as it is selected, with the cost it may have of its
+// own and the currency of its Product kept apart until they are combined.
+type variantRow struct {
+	Variant
+	OwnCost  *int   `db:"cost"`
+	Currency string `db:"currency"`
+}
+
+// variant gives the Variant of r with its cost in the currency of its
+// Product.
+func (r variantRow) variant() Variant {
+	v := r.Variant
+	if r.OwnCost != nil {
+		v.Cost = &money.Money{Amount: *r.OwnCost, Currency: r.Currency}
+	}
+	return v
+}
+
+// NewVariant is what we require from clients when adding a Variant to a
+// Product. Leaving Cost empty means the Variant is sold at the Product cost.
+// UnitCost is what each unit of the opening Quantity cost us.
+type NewVariant struct {
+	SKU      string  `json:"sku"`
+	Options  Options `json:"options"`
+	Cost     *int    `json:"cost"`
+	Quantity int     `json:"quantity"`
+	UnitCost int     `json:"unit_cost"`
+}
+
+// Options maps an option name such as "size" to the value a Variant has for
+// it. It is stored as a JSONB column.
+type Options map[string]string
+
+// Value implements the driver.Valuer interface.
+func (o Options) Value() (driver.Value, error) {
+	if o == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(o)
+}
+
+// Scan implements the sql.Scanner interface.
+func (o *Options) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*o = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, o)
+	case string:
+		return json.Unmarshal([]byte(v), o)
+	default:
+		return errors.Errorf("unsupported type %T for options", src)
+	}
+}
+
+// Price is the cost of a Product from a point in time onwards. Every change to
+// a Product cost is kept so historic revenue can be explained. A Price with an
+// EffectiveFrom in the future is a scheduled price change. A Price with a
+// VariantID is the cost a Variant overrides the Product cost with.
+type Price struct {
+	ID            string    `db:"price_id" json:"id"`
+	ProductID     string    `db:"product_id" json:"product_id"`
+	VariantID     *string   `db:"variant_id" json:"variant_id,omitempty"`
+	Cost          int       `db:"cost" json:"cost"`
+	EffectiveFrom time.Time `db:"effective_from" json:"effective_from"`
+	DateCreated   time.Time `db:"date_created" json:"date_created"`
+}
+
+// NewPrice is what we require from clients when changing a Product cost. An
+// empty EffectiveFrom makes the change take effect immediately.
+type NewPrice struct {
+	Cost          int        `json:"cost"`
+	EffectiveFrom *time.Time `json:"effective_from"`
+}
+
+// CurrencyPrice is the cost of a Product on the price list of a currency
+// other than the one it is sold in.
+type CurrencyPrice struct {
+	ProductID   string    `db:"product_id" json:"product_id"`
+	Currency    string    `db:"currency" json:"currency"`
 	Cost        int       `db:"cost" json:"cost"`
-	Quantity    int       `db:"quantity" json:"quantity"`
-	Sold        int       `db:"sold" json:"sold"`
-	Revenue     int       `db:"revenue" json:"revenue"`
-	DateCreated time.Time `db:"date_created" json:"date_created"`
 	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
 }
 
-// NewProduct is what we require from clients when adding a Product.
-type NewProduct struct {
-	Name     string `json:"name"`
+// NewCurrencyPrice is what we require from clients to price a Product in
+// another currency. Cost is in minor units of Currency.
+type NewCurrencyPrice struct {
+	Currency string `json:"currency"`
 	Cost     int    `json:"cost"`
-	Quantity int    `json:"quantity"`
 }
 
 // Sale represents one item of a transaction where some amount of a product was
 // sold. Quantity is the number of units sold and Paid is the total price paid.
 // Note that due to haggling the Paid value might not equal Quantity sold *
-// Product cost.
+// Product cost. ListPrice is the unit price in effect when the sale was made.
+// Every amount of a Sale but BasePaid and COGS is in the same currency.
+// BasePaid is Paid in the currency of the Product, converted at ExchangeRate
+// major units of the Product currency to one of the Sale currency.
 type Sale struct {
-	ID          string    `db:"sale_id" json:"id"`
-	ProductID   string    `db:"product_id" json:"product_id"`
-	Quantity    int       `db:"quantity" json:"quantity"`
-	Paid        int       `db:"paid" json:"paid"`
+	ID          string      `db:"sale_id" json:"id"`
+	ProductID   string      `db:"product_id" json:"product_id"`
+	VariantID   *string     `db:"variant_id" json:"variant_id,omitempty"`
+	LocationID  string      `db:"location_id" json:"location_id"`
+	CustomerID  *string     `db:"customer_id" json:"customer_id,omitempty"`
+	SessionID   *string     `db:"session_id" json:"session_id,omitempty"`
+	Quantity    int         `db:"quantity" json:"quantity"`
+	Paid        money.Money `db:"paid" json:"paid"`
+	ListPrice   money.Money `db:"list_price" json:"list_price"`
+	PromotionID *string     `db:"promotion_id" json:"promotion_id,omitempty"`
+	Coupon      string      `db:"coupon" json:"coupon,omitempty"`
+
+	// Net, Tax and Gross break Paid down for tax, with Gross equal to Paid.
+	// TaxRate is in hundredths of a percent and TaxInclusive records if the
+	// price charged already included the tax.
+	Net          money.Money `db:"net" json:"net"`
+	Tax          money.Money `db:"tax" json:"tax"`
+	Gross        money.Money `db:"gross" json:"gross"`
+	TaxClass     string      `db:"tax_class" json:"tax_class,omitempty"`
+	TaxRate      int         `db:"tax_rate" json:"tax_rate"`
+	TaxInclusive bool        `db:"tax_inclusive" json:"tax_inclusive"`
+
+	BasePaid     money.Money `db:"base_paid" json:"base_paid"`
+	ExchangeRate string      `db:"exchange_rate" json:"exchange_rate"`
+
+	// PointsRedeemed loyalty points paid PointsValue of Paid, and the Sale
+	// earned the customer PointsEarned.
+	PointsEarned   int         `db:"points_earned" json:"points_earned"`
+	PointsRedeemed int         `db:"points_redeemed" json:"points_redeemed"`
+	PointsValue    money.Money `db:"points_value" json:"points_value"`
+
+	// GiftCardPaid is the part of Paid paid with a gift card or store credit.
+	GiftCardPaid money.Money `db:"gift_card_paid" json:"gift_card_paid"`
+
+	// Refunded is how much of Paid has been given back, however it was, and
+	// Returned is how many of the units sold were brought back with it.
+	Refunded money.Money `db:"refunded" json:"refunded"`
+	Returned int         `db:"returned" json:"returned"`
+
+	// COGS is what the units sold cost us, in the currency of the Product
+	// like BasePaid.
+	COGS money.Money `db:"cogs" json:"cogs"`
+
 	DateCreated time.Time `db:"date_created" json:"date_created"`
 }
 
+// Discount is how much less than the list price was paid for the Sale. List
+// prices leave out tax that is added on top, so such sales are compared by
+// what they were paid before tax.
+func (s Sale) Discount() (money.Money, error) {
+	list, err := s.ListPrice.Mul(s.Quantity)
+	if err != nil {
+		return money.Money{}, err
+	}
+	if !s.TaxInclusive {
+		return list.Sub(s.Net)
+	}
+	return list.Sub(s.Paid)
+}
+
 // NewSale is what we require from clients for recording new transactions.
+// VariantID is optional and must belong to the Product being sold. Sales
+// without a LocationID are made at the default inventory location. Coupon is
+// not used by this package; it is there for SaleHooks that price the sale,
+// which they do only when Paid is not given. A Paid of zero is a free sale.
+// The currency of Paid, or Currency when Paid has none, picks the price list
+// the Sale is made from, and the currency of the Product is assumed when
+// neither is given. CustomerID optionally
+// attributes the Sale to a Customer. Points are loyalty points they pay part
+// of it with and GiftCard is the code of a gift card they pay GiftCardAmount
+// of it with, or as much as it covers when that is zero. SessionID is the
+// register session the Sale is rung up in. Like Coupon these are left to
+// SaleHooks.
 type NewSale struct {
-	Quantity int `json:"quantity"`
-	Paid     int `json:"paid"`
+	VariantID  string       `json:"variant_id"`
+	LocationID string       `json:"location_id"`
+	CustomerID string       `json:"customer_id"`
+	SessionID  string       `json:"session_id"`
+	Quantity   int          `json:"quantity"`
+	Paid       *money.Money `json:"paid"`
+	Currency   string       `json:"currency"`
+	Coupon     string       `json:"coupon"`
+	Points     int          `json:"points"`
+
+	GiftCard       string `json:"gift_card"`
+	GiftCardAmount int    `json:"gift_card_amount"`
+}
+
+// History is what a Customer has bought. LifetimeValue totals what they paid
+// in each currency they paid in.
+type History struct {
+	CustomerID    string        `json:"customer_id"`
+	Orders        int           `json:"orders"`
+	Units         int           `json:"units"`
+	LifetimeValue []money.Money `json:"lifetime_value"`
+	Sales         []Sale        `json:"sales"`
+}
+
+// SaleHook lets other packages take part in recording a Sale. Both funcs run
+// inside the transaction of the Sale, so an error from either undoes it.
+// Before is given the Sale about to be stored and may change it, After is
+// given the Sale once it has been stored. Either may be nil.
+type SaleHook struct {
+	Before SaleFunc
+	After  SaleFunc
+}
+
+// SaleFunc is one step of a SaleHook.
+type SaleFunc func(ctx context.Context, tx *sqlx.Tx, s *Sale, ns NewSale) error
+
+// ImportOptions control how a catalog is imported. Format is CSV or JSONL
+// and Mode is Transaction or Chunked; they default to CSV and Transaction.
+// ChunkSize is how many rows a Chunked import commits at once. Costing is how
+// Products are costed when their row does not say.
+type ImportOptions struct {
+	Format    string
+	Mode      string
+	ChunkSize int
+	Costing   string
+}
+
+// ImportReport tells what became of every row of an imported catalog.
+// Committed is false when nothing was written because a row of a Transaction
+// import was rejected.
+type ImportReport struct {
+	Mode      string      `json:"mode"`
+	Committed bool        `json:"committed"`
+	Created   int         `json:"created"`
+	Updated   int         `json:"updated"`
+	Rejected  int         `json:"rejected"`
+	Rows      []ImportRow `json:"rows"`
+}
+
+// ImportRow is the outcome of one row of a catalog, counting from 1. Status
+// is Created, Updated or Rejected, and Reason says why a row was rejected or
+// what of an updated row was ignored.
+type ImportRow struct {
+	Row       int    `json:"row"`
+	SKU       string `json:"sku,omitempty"`
+	Status    string `json:"status"`
+	ProductID string `json:"product_id,omitempty"`
+	Reason    string `json:"reason,omitempty"`
 }
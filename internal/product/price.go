@@ -0,0 +1,215 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/platform/database"
+)
+
+var ErrPriceInPast = errors.New("Price can not take effect in the past")
+
+// SetPrice records a new cost for a Product. When the price takes effect
+// immediately the Product cost is updated as well, otherwise the change is
+// scheduled and applied later by ApplyPrices.
+func SetPrice(ctx context.Context, db *sqlx.DB, np NewPrice, productID string, now time.Time) (*Price, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if np.Cost < 0 {
+		return nil, ErrInvalidCost
+	}
+
+	pr := Price{
+		ID:            uuid.New().String(),
+		ProductID:     productID,
+		Cost:          np.Cost,
+		EffectiveFrom: now.UTC(),
+		DateCreated:   now.UTC(),
+	}
+	if np.EffectiveFrom != nil {
+		if np.EffectiveFrom.Before(now) {
+			return nil, ErrPriceInPast
+		}
+		pr.EffectiveFrom = np.EffectiveFrom.UTC()
+	}
+
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		var cost int
+		const qc = `SELECT cost FROM products WHERE product_id = $1 FOR UPDATE`
+		if err := tx.GetContext(ctx, &cost, qc, productID); err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			return errors.Wrap(err, "selecting product cost")
+		}
+
+		if err := insertPrice(ctx, tx, pr); err != nil {
+			return err
+		}
+
+		if !pr.EffectiveFrom.After(pr.DateCreated) {
+			const q = `
+				UPDATE products SET cost = $2, date_updated = $3
+				WHERE product_id = $1`
+
+			if _, err := tx.ExecContext(ctx, q, pr.ProductID, pr.Cost, pr.DateCreated); err != nil {
+				return errors.Wrap(err, "updating product cost")
+			}
+		}
+
+		c := audit.Change{
+			EntityType: auditEntity,
+			EntityID:   productID,
+			Action:     auditSetPrice,
+			Before:     map[string]interface{}{"cost": cost},
+			After:      map[string]interface{}{"cost": pr.Cost, "effective_from": pr.EffectiveFrom},
+		}
+		_, err := audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &pr, nil
+}
+
+// ListPrices gives the price history of a Product, including any scheduled
+// price changes and the costs its Variants override it with, oldest first.
+func ListPrices(ctx context.Context, db *sqlx.DB, productID string) ([]Price, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	prices := []Price{}
+
+	const q = `
+			SELECT * FROM product_prices
+			WHERE product_id = $1
+			ORDER BY effective_from, date_created`
+
+	if err := db.SelectContext(ctx, &prices, q, productID); err != nil {
+		return nil, errors.Wrap(err, "selecting prices")
+	}
+
+	return prices, nil
+}
+
+// ApplyPrices brings the cost of every Product in line with the latest price
+// that is in effect at now, auditing each change. It returns the number of
+// Products changed.
+func ApplyPrices(ctx context.Context, db *sqlx.DB, now time.Time) (int64, error) {
+	var due []struct {
+		ProductID string `db:"product_id"`
+		From      int    `db:"from_cost"`
+		To        int    `db:"to_cost"`
+	}
+	const qs = `
+			SELECT p.product_id, p.cost as from_cost, pp.cost as to_cost
+			FROM products AS p
+			JOIN (
+				SELECT DISTINCT ON (product_id) product_id, cost
+				FROM product_prices
+				WHERE effective_from <= $1 AND variant_id IS NULL
+				ORDER BY product_id, effective_from DESC, date_created DESC
+			) AS pp ON(p.product_id = pp.product_id)
+			WHERE p.cost <> pp.cost
+			FOR UPDATE OF p`
+
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		if err := tx.SelectContext(ctx, &due, qs, now.UTC()); err != nil {
+			return errors.Wrap(err, "selecting scheduled prices")
+		}
+
+		const q = `UPDATE products SET cost = $2, date_updated = $3 WHERE product_id = $1`
+		for _, d := range due {
+			if _, err := tx.ExecContext(ctx, q, d.ProductID, d.To, now.UTC()); err != nil {
+				return errors.Wrap(err, "applying scheduled prices")
+			}
+		}
+
+		for _, d := range due {
+			c := audit.Change{
+				EntityType: auditEntity,
+				EntityID:   d.ProductID,
+				Action:     auditApplyPrice,
+				Before:     map[string]interface{}{"cost": d.From},
+				After:      map[string]interface{}{"cost": d.To},
+			}
+			if _, err := audit.Record(ctx, tx, c, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(due)), nil
+}
+
+// UnitPrice is the list price of one unit of a Product, or of one of its
+// Variants when variantID is not empty, at time t. A Variant with its own cost
+// is always sold at that cost.
+func UnitPrice(ctx context.Context, db sqlx.QueryerContext, productID, variantID string, t time.Time) (int, error) {
+	if variantID != "" {
+		v, err := retriveVariant(ctx, db, productID, variantID)
+		if err != nil {
+			return 0, err
+		}
+		if v.Cost != nil {
+			return v.Cost.Amount, nil
+		}
+	}
+
+	return priceAt(ctx, db, productID, t)
+}
+
+// insertPrice stores a single price row.
+func insertPrice(ctx context.Context, tx sqlx.ExecerContext, pr Price) error {
+	const q = `
+			INSERT INTO product_prices
+			(price_id, product_id, variant_id, cost, effective_from, date_created)
+			VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := tx.ExecContext(ctx, q, pr.ID, pr.ProductID, pr.VariantID, pr.Cost, pr.EffectiveFrom, pr.DateCreated)
+	if err != nil {
+		return errors.Wrap(err, "inserting price")
+	}
+
+	return nil
+}
+
+// priceAt finds the unit cost of a Product in effect at t. Products without
+// any recorded price fall back to their current cost.
+func priceAt(ctx context.Context, db sqlx.QueryerContext, productID string, t time.Time) (int, error) {
+	var cost int
+
+	const q = `
+			SELECT COALESCE(
+				(SELECT pp.cost FROM product_prices AS pp
+				 WHERE pp.product_id = p.product_id AND pp.variant_id IS NULL
+				 AND pp.effective_from <= $2
+				 ORDER BY pp.effective_from DESC, pp.date_created DESC
+				 LIMIT 1),
+				p.cost
+			)
+			FROM products AS p
+			WHERE p.product_id = $1`
+
+	if err := sqlx.GetContext(ctx, db, &cost, q, productID, t.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrNotFound
+		}
+		return 0, errors.Wrap(err, "selecting price")
+	}
+
+	return cost, nil
+}
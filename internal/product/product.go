@@ -3,24 +3,93 @@ package product
 import (
 	"context"
 	"database/sql"
+	"math/big"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/accounting"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/exchange"
+	"github.com/vikramcse/the-service/internal/inventory"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/database"
 )
 
-var ErrNotFound = errors.New("Product not found")
-var ErrInvalidID = errors.New("ID is not in it's proper form")
+var (
+	ErrNotFound       = errors.New("Product not found")
+	ErrInvalidID      = errors.New("ID is not in it's proper form")
+	ErrNoName         = errors.New("Product must have a name")
+	ErrInvalidCost    = errors.New("cost must not be negative")
+	ErrInvalidReorder = errors.New("reorder threshold and quantity must not be negative")
+	ErrDuplicateSKU   = errors.New("SKU is already used by another product")
+	ErrInvalidStock   = errors.New("quantity in stock must not be negative")
 
-func List(ctx context.Context, db *sqlx.DB) ([]Product, error) {
+	ErrInvalidQuantity   = errors.New("quantity must be positive")
+	ErrInvalidPaid       = errors.New("paid must not be negative")
+	ErrInsufficientStock = errors.New("not enough stock available")
+	ErrSaleNotFound      = errors.New("Sale not found")
+	ErrRefundTooMuch     = errors.New("Refund is more than was paid for the sale")
+	ErrReturnTooMuch     = errors.New("Return is more units than are left of the sale")
+)
+
+// Entity types changes to Products and their Sales are audited as.
+const (
+	auditEntity     = "product"
+	auditSaleEntity = "sale"
+)
+
+// Actions on Products audited besides audit.Create and audit.Update.
+const (
+	auditSetPrice         = "set_price"
+	auditApplyPrice       = "apply_price"
+	auditSetCurrencyPrice = "set_currency_price"
+	auditAddVariant       = "add_variant"
+)
+
+// available computes the available column of a Product from the products
+// table aliased as p joined with its sales aliased as s. Stock of every
+// Variant counts towards it, less whatever is sold or actively reserved.
+const available = `p.quantity
+				+ COALESCE((SELECT SUM(v.quantity) FROM variants as v
+					WHERE v.product_id = p.product_id), 0)
+				- COALESCE(SUM(s.quantity), 0)
+				- COALESCE((SELECT SUM(r.quantity) FROM reservations as r
+					WHERE r.product_id = p.product_id
+					AND r.status = 'active' AND r.expires_at > now()), 0) as available`
+
+// columns selects a Product from the products table aliased as p joined with
+// its sales aliased as s. Cost, revenue and the cost of goods sold and in
+// stock are paired with the currency of the Product so they scan into a
+// money.Money.
+const columns = `
+				p.product_id, p.sku, p.name, p.category, p.tax_class,
+				p.cost as "cost.amount", p.currency as "cost.currency",
+				p.quantity, p.date_created, p.date_updated,
+				p.reorder_threshold, p.reorder_quantity, p.costing,
+				COALESCE(SUM(s.quantity), 0) as sold,
+				COALESCE(SUM(s.base_paid), 0) as "revenue.amount", p.currency as "revenue.currency",
+				COALESCE(SUM(s.cogs), 0) as "cogs.amount", p.currency as "cogs.currency",
+				COALESCE(SUM(s.base_paid), 0) - COALESCE(SUM(s.cogs), 0) as "gross_margin.amount",
+				p.currency as "gross_margin.currency",
+				COALESCE((SELECT SUM(l.remaining * l.unit_cost) FROM cost_layers as l
+					WHERE l.product_id = p.product_id), 0) as "stock_value.amount",
+				p.currency as "stock_value.currency",
+				` + available
+
+// List gets all Products. Sales of a Variant are recorded against its parent
+// Product too, so sold, revenue and its cost are aggregated across all
+// Variants. Revenue, its cost, the margin on it and the value of stock are in
+// the currency of each Product unless a reporting currency is given, in which
+// case they are converted at the exchange rates in effect at now.
+func List(ctx context.Context, db *sqlx.DB, currency string, now time.Time) ([]Product, error) {
 	products := []Product{}
 
 	const q = `
-			SELECT 
-				p.*,
-				COALESCE(SUM(s.quantity), 0) as sold,
-				COALESCE(SUM(s.paid), 0) as revenue
+			SELECT ` + columns + `
 			FROM products as p
 			LEFT JOIN sales as s ON(p.product_id=s.product_id)
 			GROUP BY p.product_id`
@@ -29,9 +98,35 @@ func List(ctx context.Context, db *sqlx.DB) ([]Product, error) {
 		return nil, errors.Wrap(err, "selecting products")
 	}
 
+	if currency == "" {
+		return products, nil
+	}
+
+	currency, err := money.Currency(currency)
+	if err != nil {
+		return nil, err
+	}
+
+	rates := make(map[string]*big.Rat)
+	for i, p := range products {
+		rate, ok := rates[p.Revenue.Currency]
+		if !ok {
+			if rate, err = exchange.RateAt(ctx, db, p.Revenue.Currency, currency, now); err != nil {
+				return nil, err
+			}
+			rates[p.Revenue.Currency] = rate
+		}
+		for _, m := range []*money.Money{&products[i].Revenue, &products[i].COGS, &products[i].GrossMargin, &products[i].StockValue} {
+			if *m, err = money.Convert(*m, rate, currency); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	return products, nil
 }
 
+// Retrive finds the Product identified by id along with its Variants.
 func Retrive(ctx context.Context, db *sqlx.DB, id string) (*Product, error) {
 	if _, err := uuid.Parse(id); err != nil {
 		return nil, ErrInvalidID
@@ -39,10 +134,7 @@ func Retrive(ctx context.Context, db *sqlx.DB, id string) (*Product, error) {
 
 	var p Product
 	const q = `
-			SELECT 
-				p.*,
-				COALESCE(SUM(s.quantity), 0) as sold,
-				COALESCE(SUM(s.paid), 0) as revenue
+			SELECT ` + columns + `
 			FROM products as p
 			LEFT JOIN sales as s ON(p.product_id=s.product_id)
 			WHERE p.product_id = $1
@@ -52,30 +144,282 @@ func Retrive(ctx context.Context, db *sqlx.DB, id string) (*Product, error) {
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
 		}
+		return nil, errors.Wrapf(err, "selecting product %q", id)
+	}
+
+	variants, err := ListVariants(ctx, db, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(variants) > 0 {
+		p.Variants = variants
 	}
 
 	return &p, nil
 }
 
+// ListLowStock gets every Product whose remaining stock, its quantity minus
+// the units sold, is at or below its reorder threshold. Products without a
+// threshold are never low on stock. Variants keep their own stock, so only
+// sales of the Product itself are counted.
+func ListLowStock(ctx context.Context, db sqlx.QueryerContext) ([]LowStock, error) {
+	return lowStock(ctx, db, "")
+}
+
+// CheckLowStock gets the Product identified by id when it is low on stock as
+// ListLowStock has it, and nil when it is not.
+func CheckLowStock(ctx context.Context, db sqlx.QueryerContext, id string) (*LowStock, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	low, err := lowStock(ctx, db, id)
+	if err != nil || len(low) == 0 {
+		return nil, err
+	}
+
+	return &low[0], nil
+}
+
+// lowStock gets the Products low on stock, only looking at the one
+// identified by id when it is not empty.
+func lowStock(ctx context.Context, db sqlx.QueryerContext, id string) ([]LowStock, error) {
+	low := []LowStock{}
+
+	const q = `
+			SELECT * FROM (
+				SELECT
+					p.product_id,
+					p.name,
+					p.quantity,
+					COALESCE(SUM(s.quantity), 0) as sold,
+					p.quantity - COALESCE(SUM(s.quantity), 0) as remaining,
+					p.reorder_threshold,
+					p.reorder_quantity
+				FROM products as p
+				LEFT JOIN sales as s ON(p.product_id=s.product_id AND s.variant_id IS NULL)
+				WHERE p.reorder_threshold > 0 AND ($1 = '' OR p.product_id::text = $1)
+				GROUP BY p.product_id
+			) as stock
+			WHERE remaining <= reorder_threshold
+			ORDER BY remaining - reorder_threshold, name`
+
+	if err := sqlx.SelectContext(ctx, db, &low, q, id); err != nil {
+		return nil, errors.Wrap(err, "selecting low stock")
+	}
+
+	return low, nil
+}
+
+// SetReorder changes when the Product identified by id should be reordered
+// and how many units to order. Fields of ur left nil are left as they are.
+func SetReorder(ctx context.Context, db *sqlx.DB, id string, ur UpdateReorder, now time.Time) (*Product, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+	if (ur.ReorderThreshold != nil && *ur.ReorderThreshold < 0) || (ur.ReorderQuantity != nil && *ur.ReorderQuantity < 0) {
+		return nil, ErrInvalidReorder
+	}
+
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		var before struct {
+			ReorderThreshold int `db:"reorder_threshold" json:"reorder_threshold"`
+			ReorderQuantity  int `db:"reorder_quantity" json:"reorder_quantity"`
+		}
+		const qs = `
+			SELECT reorder_threshold, reorder_quantity FROM products
+			WHERE product_id = $1 FOR UPDATE`
+		if err := tx.GetContext(ctx, &before, qs, id); err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			return errors.Wrap(err, "selecting reorder settings")
+		}
+
+		after := before
+		if ur.ReorderThreshold != nil {
+			after.ReorderThreshold = *ur.ReorderThreshold
+		}
+		if ur.ReorderQuantity != nil {
+			after.ReorderQuantity = *ur.ReorderQuantity
+		}
+
+		const q = `
+			UPDATE products SET reorder_threshold = $2, reorder_quantity = $3, date_updated = $4
+			WHERE product_id = $1`
+		if _, err := tx.ExecContext(ctx, q, id, after.ReorderThreshold, after.ReorderQuantity, now.UTC()); err != nil {
+			return errors.Wrap(err, "updating reorder settings")
+		}
+
+		c := audit.Change{EntityType: auditEntity, EntityID: id, Action: audit.Update, Before: before, After: after}
+		_, err := audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return Retrive(ctx, db, id)
+}
+
+// Validate checks np against the rules every new Product must meet, giving
+// the first it breaks.
+func (np NewProduct) Validate() error {
+	if strings.TrimSpace(np.Name) == "" {
+		return ErrNoName
+	}
+	if np.Cost < 0 {
+		return ErrInvalidCost
+	}
+	if np.UnitCost < 0 {
+		return inventory.ErrInvalidCost
+	}
+	if np.ReorderThreshold < 0 || np.ReorderQuantity < 0 {
+		return ErrInvalidReorder
+	}
+	if np.Currency != "" {
+		if _, err := money.Currency(np.Currency); err != nil {
+			return err
+		}
+	}
+	if np.Costing != "" {
+		if err := inventory.CheckCosting(np.Costing); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create adds a Product to the database. It returns the created Product with
+// fields like ID and DateCreated populated.
 func Create(ctx context.Context, db *sqlx.DB, np NewProduct, now time.Time) (*Product, error) {
+	if err := np.Validate(); err != nil {
+		return nil, err
+	}
+
+	var p *Product
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		var c audit.Change
+		var err error
+		if p, c, err = create(ctx, tx, np, now); err != nil {
+			return err
+		}
+		_, err = audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// create does the work of Create as part of tx for an np that is valid. It
+// returns the Change to audit for it.
+func create(ctx context.Context, tx *sqlx.Tx, np NewProduct, now time.Time) (*Product, audit.Change, error) {
+	currency := np.Currency
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	cost, err := money.New(np.Cost, currency)
+	if err != nil {
+		return nil, audit.Change{}, err
+	}
+	costing := np.Costing
+	if costing == "" {
+		costing = inventory.FIFO
+	}
+	zero := money.Money{Currency: cost.Currency}
+
 	p := Product{
-		ID:          uuid.New().String(),
-		Name:        np.Name,
-		Cost:        np.Cost,
-		Quantity:    np.Quantity,
-		DateCreated: now.UTC(),
-		DateUpdated: now.UTC(),
+		ID:               uuid.New().String(),
+		SKU:              np.SKU,
+		Name:             np.Name,
+		Category:         np.Category,
+		TaxClass:         np.TaxClass,
+		Cost:             cost,
+		Revenue:          zero,
+		Costing:          costing,
+		COGS:             zero,
+		GrossMargin:      zero,
+		StockValue:       zero,
+		Quantity:         np.Quantity,
+		DateCreated:      now.UTC(),
+		DateUpdated:      now.UTC(),
+		ReorderThreshold: np.ReorderThreshold,
+		ReorderQuantity:  np.ReorderQuantity,
+	}
+	p.Available = p.Quantity
+	if p.Quantity > 0 {
+		p.StockValue.Amount = p.Quantity * np.UnitCost
+	}
+	if p.TaxClass == "" {
+		p.TaxClass = DefaultTaxClass
 	}
 
+	pr := Price{
+		ID:            uuid.New().String(),
+		ProductID:     p.ID,
+		Cost:          p.Cost.Amount,
+		EffectiveFrom: p.DateCreated,
+		DateCreated:   p.DateCreated,
+	}
+
+	// The product starts out empty and its opening stock is received through
+	// the inventory ledger, which brings the stored quantity up to date.
 	const q = `
-			INSERT INTO products
-			(product_id, name, cost, quantity, date_created, date_updated)
-			VALUES ($1, $2, $3, $4, $5, $6)`
+		INSERT INTO products
+		(product_id, sku, name, category, tax_class, cost, currency, quantity,
+		date_created, date_updated, reorder_threshold, reorder_quantity, costing)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, 0, $8, $9, $10, $11, $12)`
 
-	_, err := db.ExecContext(ctx, q, p.ID, p.Name, p.Cost, p.Quantity, p.DateCreated, p.DateUpdated)
+	_, err = tx.ExecContext(ctx, q,
+		p.ID, p.SKU, p.Name, p.Category, p.TaxClass, p.Cost.Amount, p.Cost.Currency,
+		p.DateCreated, p.DateUpdated, p.ReorderThreshold, p.ReorderQuantity, p.Costing,
+	)
 	if err != nil {
-		return nil, errors.Wrap(err, "inserting product")
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, audit.Change{}, ErrDuplicateSKU
+		}
+		return nil, audit.Change{}, errors.Wrap(err, "inserting product")
 	}
 
-	return &p, nil
+	if err := insertPrice(ctx, tx, pr); err != nil {
+		return nil, audit.Change{}, err
+	}
+
+	if err := openingStock(ctx, tx, p.ID, nil, p.Quantity, np.UnitCost, p.DateCreated); err != nil {
+		return nil, audit.Change{}, err
+	}
+
+	c := audit.Change{EntityType: auditEntity, EntityID: p.ID, Action: audit.Create, After: p}
+	return &p, c, nil
+}
+
+// openingStock records the initial quantity of a new product or variant in
+// the inventory ledger at unitCost a unit and posts its value to the journal.
+func openingStock(ctx context.Context, tx *sqlx.Tx, productID string, variantID *string, quantity, unitCost int, now time.Time) error {
+	if quantity == 0 {
+		return nil
+	}
+
+	m := inventory.Movement{
+		ProductID:   productID,
+		VariantID:   variantID,
+		Kind:        inventory.Receipt,
+		Quantity:    quantity,
+		UnitCost:    &unitCost,
+		Reason:      "opening stock",
+		DateCreated: now,
+	}
+	if quantity < 0 {
+		m.Kind = inventory.Adjustment
+	}
+
+	rec, err := inventory.Record(ctx, tx, m)
+	if err != nil {
+		return errors.Wrap(err, "recording opening stock")
+	}
+
+	return accounting.RecordOpeningStock(ctx, tx, rec.ID, productID, rec.Value, now)
 }
@@ -2,10 +2,14 @@ package product_test
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/period"
 	"github.com/vikramcse/the-service/internal/product"
 	"github.com/vikramcse/the-service/internal/schema"
 	"github.com/vikramcse/the-service/internal/tests"
@@ -46,7 +50,7 @@ func TestProductList(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	ps, err := product.List(context.Background(), db)
+	ps, err := product.List(context.Background(), db, "", time.Now())
 	if err != nil {
 		t.Fatalf("listing products: %s", err)
 	}
@@ -54,3 +58,402 @@ func TestProductList(t *testing.T) {
 		t.Fatalf("expected product list size %v, got %v", exp, got)
 	}
 }
+
+func TestVariants(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	p, err := product.Create(ctx, db, product.NewProduct{Name: "T-Shirt", Cost: 20}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+
+	large := 25
+	nvs := []product.NewVariant{
+		{SKU: "TS-S-RED", Options: product.Options{"size": "S", "colour": "red"}, Quantity: 10},
+		{SKU: "TS-L-RED", Options: product.Options{"size": "L", "colour": "red"}, Cost: &large, Quantity: 5},
+	}
+	var variants []*product.Variant
+	for _, nv := range nvs {
+		v, err := product.AddVariant(ctx, db, nv, p.ID, now)
+		if err != nil {
+			t.Fatalf("adding variant %s: %s", nv.SKU, err)
+		}
+		variants = append(variants, v)
+	}
+
+	negative := -1
+	invalid := []struct {
+		nv  product.NewVariant
+		err error
+	}{
+		{product.NewVariant{SKU: "TS-M-RED", Cost: &negative}, product.ErrInvalidCost},
+		{product.NewVariant{SKU: "TS-M-RED", Quantity: -1}, product.ErrInvalidStock},
+		{product.NewVariant{SKU: "TS-S-RED", Quantity: 1}, product.ErrDuplicateSKU},
+	}
+	for _, tt := range invalid {
+		if _, err := product.AddVariant(ctx, db, tt.nv, p.ID, now); err != tt.err {
+			t.Fatalf("adding variant %+v: expected %v, got %v", tt.nv, tt.err, err)
+		}
+	}
+
+	sales := []product.NewSale{
+		{VariantID: variants[0].ID, Quantity: 2, Paid: &money.Money{Amount: 40}},
+		{VariantID: variants[1].ID, Quantity: 1, Paid: &money.Money{Amount: 25}},
+	}
+	for _, ns := range sales {
+		if _, err := product.AddSale(ctx, db, ns, p.ID, now); err != nil {
+			t.Fatalf("adding sale: %s", err)
+		}
+	}
+
+	got, err := product.Retrive(ctx, db, p.ID)
+	if err != nil {
+		t.Fatalf("retrieving product: %s", err)
+	}
+	if exp, got := 3, got.Sold; exp != got {
+		t.Fatalf("expected product sold %v, got %v", exp, got)
+	}
+	if exp, got := (money.Money{Amount: 65, Currency: "USD"}), got.Revenue; exp != got {
+		t.Fatalf("expected product revenue %v, got %v", exp, got)
+	}
+	if exp, got := 2, len(got.Variants); exp != got {
+		t.Fatalf("expected %v variants, got %v", exp, got)
+	}
+	for _, v := range got.Variants {
+		if v.SKU == "TS-S-RED" && v.Sold != 2 {
+			t.Fatalf("expected variant %s sold %v, got %v", v.SKU, 2, v.Sold)
+		}
+		if exp := (money.Money{Amount: 40, Currency: "USD"}); v.SKU == "TS-S-RED" && v.Revenue != exp {
+			t.Fatalf("expected variant %s revenue %v, got %v", v.SKU, exp, v.Revenue)
+		}
+		if exp := (money.Money{Amount: large, Currency: "USD"}); v.SKU == "TS-L-RED" && (v.Cost == nil || *v.Cost != exp) {
+			t.Fatalf("expected variant %s cost %v, got %v", v.SKU, exp, v.Cost)
+		}
+	}
+
+	prices, err := product.ListPrices(ctx, db, p.ID)
+	if err != nil {
+		t.Fatalf("listing prices: %s", err)
+	}
+	if exp, got := 2, len(prices); exp != got {
+		t.Fatalf("expected %v prices, got %v", exp, got)
+	}
+	if vp := prices[1]; vp.VariantID == nil || *vp.VariantID != variants[1].ID || vp.Cost != large {
+		t.Fatalf("expected the cost of variant %s in the price history, got %+v", variants[1].SKU, vp)
+	}
+
+	other, err := product.Create(ctx, db, product.NewProduct{Name: "Mug", Cost: 5}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	ns := product.NewSale{VariantID: variants[0].ID, Quantity: 1, Paid: &money.Money{Amount: 20}}
+	if _, err := product.AddSale(ctx, db, ns, other.ID, now); err != product.ErrVariantNotFound {
+		t.Fatalf("expected %v selling a variant of another product, got %v", product.ErrVariantNotFound, err)
+	}
+}
+
+func TestSaleValidation(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	p, err := product.Create(ctx, db, product.NewProduct{Name: "Comic Book", Cost: 10, Quantity: 5}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+
+	invalid := []struct {
+		name string
+		ns   product.NewSale
+		err  error
+	}{
+		{"zero quantity", product.NewSale{Quantity: 0, Paid: &money.Money{Amount: 10}}, product.ErrInvalidQuantity},
+		{"negative quantity", product.NewSale{Quantity: -2, Paid: &money.Money{Amount: -20}}, product.ErrInvalidQuantity},
+		{"negative paid", product.NewSale{Quantity: 1, Paid: &money.Money{Amount: -10}}, product.ErrInvalidPaid},
+	}
+	for _, tt := range invalid {
+		if _, err := product.AddSale(ctx, db, tt.ns, p.ID, now); err != tt.err {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+
+	got, err := product.Retrive(ctx, db, p.ID)
+	if err != nil {
+		t.Fatalf("retrieving product: %s", err)
+	}
+	if got.Sold != 0 {
+		t.Fatalf("expected no units sold after rejected sales, got %v", got.Sold)
+	}
+}
+
+func TestPrices(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	p, err := product.Create(ctx, db, product.NewProduct{Name: "Comic Book", Cost: 10, Quantity: 20}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+
+	later := now.Add(time.Hour)
+	if _, err := product.SetPrice(ctx, db, product.NewPrice{Cost: 12, EffectiveFrom: &later}, p.ID, now); err != nil {
+		t.Fatalf("scheduling price: %s", err)
+	}
+
+	earlier := now.Add(-time.Hour)
+	if _, err := product.SetPrice(ctx, db, product.NewPrice{Cost: 8, EffectiveFrom: &earlier}, p.ID, now); err != product.ErrPriceInPast {
+		t.Fatalf("expected %v scheduling a price in the past, got %v", product.ErrPriceInPast, err)
+	}
+	if _, err := product.SetPrice(ctx, db, product.NewPrice{Cost: -1}, p.ID, now); err != product.ErrInvalidCost {
+		t.Fatalf("expected %v setting a negative price, got %v", product.ErrInvalidCost, err)
+	}
+
+	s, err := product.AddSale(ctx, db, product.NewSale{Quantity: 2, Paid: &money.Money{Amount: 18}}, p.ID, now)
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	if exp, got := 10, s.ListPrice.Amount; exp != got {
+		t.Fatalf("expected list price %v before the change, got %v", exp, got)
+	}
+	d, err := s.Discount()
+	if err != nil {
+		t.Fatalf("working out discount: %s", err)
+	}
+	if exp, got := 2, d.Amount; exp != got {
+		t.Fatalf("expected discount %v, got %v", exp, got)
+	}
+
+	if _, err := product.ApplyPrices(ctx, db, later); err != nil {
+		t.Fatalf("applying prices: %s", err)
+	}
+
+	got, err := product.Retrive(ctx, db, p.ID)
+	if err != nil {
+		t.Fatalf("retrieving product: %s", err)
+	}
+	if exp, got := 12, got.Cost.Amount; exp != got {
+		t.Fatalf("expected cost %v after the change, got %v", exp, got)
+	}
+
+	s, err = product.AddSale(ctx, db, product.NewSale{Quantity: 1, Paid: &money.Money{Amount: 12}}, p.ID, later)
+	if err != nil {
+		t.Fatalf("adding sale: %s", err)
+	}
+	if exp, got := 12, s.ListPrice.Amount; exp != got {
+		t.Fatalf("expected list price %v after the change, got %v", exp, got)
+	}
+
+	prices, err := product.ListPrices(ctx, db, p.ID)
+	if err != nil {
+		t.Fatalf("listing prices: %s", err)
+	}
+	if exp, got := 2, len(prices); exp != got {
+		t.Fatalf("expected %v prices, got %v", exp, got)
+	}
+}
+
+func TestImport(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	catalog := `sku,name,cost,quantity,unit_cost
+CB-1,Comic Book,500,10,200
+,,300,1,0
+TOY-1,Toy,abc,1,0
+TOY-2,Toy,750,5,300
+`
+	statuses := func(rep *product.ImportReport) []string {
+		var s []string
+		for _, row := range rep.Rows {
+			s = append(s, row.Status)
+		}
+		return s
+	}
+
+	// A rejected row stops a transaction from importing anything.
+	rep, err := product.Import(ctx, db, strings.NewReader(catalog), product.ImportOptions{}, now)
+	if err != nil {
+		t.Fatalf("importing: %s", err)
+	}
+	exp := []string{product.Created, product.Rejected, product.Rejected, product.Created}
+	if diff := cmp.Diff(exp, statuses(rep)); diff != "" {
+		t.Fatalf("unexpected statuses:\n%s", diff)
+	}
+	if rep.Committed {
+		t.Fatal("expected nothing to be committed")
+	}
+	if ps, err := product.List(ctx, db, "", now); err != nil || len(ps) != 0 {
+		t.Fatalf("expected no products, got %d (%v)", len(ps), err)
+	}
+
+	// Chunked imports skip the rejected rows.
+	opts := product.ImportOptions{Mode: product.Chunked, ChunkSize: 1}
+	if rep, err = product.Import(ctx, db, strings.NewReader(catalog), opts, now); err != nil {
+		t.Fatalf("importing: %s", err)
+	}
+	if !rep.Committed || rep.Created != 2 || rep.Rejected != 2 {
+		t.Fatalf("expected 2 created and 2 rejected, got %+v", rep)
+	}
+	if exp, got := product.ErrNoName.Error(), rep.Rows[1].Reason; exp != got {
+		t.Fatalf("expected reason %q, got %q", exp, got)
+	}
+
+	// Importing by SKU again updates the product instead, saying the stock
+	// it gives is left alone.
+	jsonl := `{"sku": "CB-1", "name": "Comic Book Deluxe", "cost": 600, "quantity": 99}` + "\n"
+	opts = product.ImportOptions{Format: product.JSONL}
+	if rep, err = product.Import(ctx, db, strings.NewReader(jsonl), opts, now); err != nil {
+		t.Fatalf("importing: %s", err)
+	}
+	if rep.Updated != 1 || rep.Rows[0].Reason == "" {
+		t.Fatalf("expected 1 updated with its stock ignored, got %+v", rep)
+	}
+
+	p, err := product.Retrive(ctx, db, rep.Rows[0].ProductID)
+	if err != nil {
+		t.Fatalf("retrieving product: %s", err)
+	}
+	if p.Name != "Comic Book Deluxe" || p.Cost.Amount != 600 || p.Quantity != 10 {
+		t.Fatalf("expected updated name and price with stock kept, got %+v", p)
+	}
+
+	if _, err := product.Import(ctx, db, strings.NewReader("sku,colour\n"), product.ImportOptions{}, now); errors.Cause(err) != product.ErrMalformed {
+		t.Fatalf("expected %v, got %v", product.ErrMalformed, err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	jan := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	feb := time.Date(2019, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, err := product.Create(ctx, db, product.NewProduct{SKU: "CB-1", Name: "Comic Book", Cost: 500}, jan); err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	if _, err := product.Create(ctx, db, product.NewProduct{SKU: "TOY-1", Name: "Toy, Wooden", Cost: 750}, feb); err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+
+	var buf strings.Builder
+	n, err := product.ExportProducts(ctx, db, &buf, product.CSV, time.Time{}, feb)
+	if err != nil {
+		t.Fatalf("exporting products: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if n != 1 || len(lines) != 2 || !strings.HasPrefix(lines[0], "id,sku,name,") || !strings.Contains(lines[1], ",CB-1,Comic Book,") {
+		t.Fatalf("unexpected CSV export of %d products:\n%s", n, buf.String())
+	}
+
+	buf.Reset()
+	if n, err = product.ExportProducts(ctx, db, &buf, product.JSONL, feb, time.Time{}); err != nil {
+		t.Fatalf("exporting products: %s", err)
+	}
+	if n != 1 || !strings.Contains(buf.String(), `"name":"Toy, Wooden"`) || strings.Count(buf.String(), "\n") != 1 {
+		t.Fatalf("unexpected JSON Lines export of %d products:\n%s", n, buf.String())
+	}
+
+	buf.Reset()
+	if n, err = product.ExportSales(ctx, db, &buf, product.CSV, time.Time{}, time.Time{}); err != nil || n != 0 {
+		t.Fatalf("exporting sales: %d, %v", n, err)
+	}
+	if !strings.HasPrefix(buf.String(), "id,product_id,") {
+		t.Fatalf("expected only a header, got:\n%s", buf.String())
+	}
+
+	if _, err := product.ExportSales(ctx, db, &buf, product.CSV, feb, jan); errors.Cause(err) != period.ErrInvalidRange {
+		t.Fatalf("expected %v, got %v", period.ErrInvalidRange, err)
+	}
+	if _, err := product.ExportSales(ctx, db, &buf, "parquet", time.Time{}, time.Time{}); errors.Cause(err) != product.ErrInvalidFormat {
+		t.Fatalf("expected %v, got %v", product.ErrInvalidFormat, err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, np := range []product.NewProduct{
+		{Name: "Comic Book", Category: "Books"},
+		{Name: "McDonalds Toys", Category: "Toys"},
+		{Name: "Cookbook", Category: "Books", SKU: "COOK-1"},
+		{Name: "Tom & Jerry <3", Category: "Cartoons"},
+	} {
+		if _, err := product.Create(ctx, db, np, now); err != nil {
+			t.Fatalf("creating product: %s", err)
+		}
+	}
+
+	testSearch(t, &product.PostgresSearch{DB: db})
+}
+
+func TestMemorySearch(t *testing.T) {
+	testSearch(t, product.NewMemorySearch(
+		product.Product{Name: "Comic Book", Category: "Books"},
+		product.Product{Name: "McDonalds Toys", Category: "Toys"},
+		product.Product{Name: "Cookbook", Category: "Books", SKU: "COOK-1"},
+		product.Product{Name: "Tom & Jerry <3", Category: "Cartoons"},
+	))
+}
+
+// testSearch checks that s finds the products of TestSearch like clerks
+// expect.
+func testSearch(t *testing.T, s product.Searcher) {
+	t.Helper()
+	ctx := context.Background()
+
+	tests := []struct {
+		q         string
+		names     []string
+		highlight string
+	}{
+		{"comic", []string{"Comic Book"}, "<mark>Comic</mark> Book"},
+		{"com", []string{"Comic Book"}, "<mark>Comic</mark> Book"},
+		{"comik", []string{"Comic Book"}, "Comic Book"},
+		{"cook-1", []string{"Cookbook"}, "<mark>Cookbook</mark>"},
+		{"book", []string{"Comic Book", "Cookbook"}, "Comic <mark>Book</mark>"},
+		{"toys mcd", []string{"McDonalds Toys"}, "<mark>McDonalds</mark> <mark>Toys</mark>"},
+		{"jerry", []string{"Tom & Jerry <3"}, "Tom &amp; <mark>Jerry</mark> &lt;3"},
+		{"zzz", []string{}, ""},
+	}
+
+	for _, tt := range tests {
+		results, err := s.Search(ctx, tt.q, 0)
+		if err != nil {
+			t.Fatalf("searching %q: %s", tt.q, err)
+		}
+
+		names := []string{}
+		for _, r := range results {
+			names = append(names, r.Name)
+		}
+		if diff := cmp.Diff(tt.names, names); diff != "" {
+			t.Errorf("searching %q:\n%s", tt.q, diff)
+			continue
+		}
+		if len(results) > 0 && results[0].Highlight != tt.highlight {
+			t.Errorf("searching %q: highlighted %q, expected %q", tt.q, results[0].Highlight, tt.highlight)
+		}
+	}
+
+	if _, err := s.Search(ctx, " ", 0); err != product.ErrNoQuery {
+		t.Fatalf("expected %v, got %v", product.ErrNoQuery, err)
+	}
+}
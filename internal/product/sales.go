@@ -2,35 +2,365 @@ package product
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/accounting"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/customer"
+	"github.com/vikramcse/the-service/internal/exchange"
+	"github.com/vikramcse/the-service/internal/inventory"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/database"
 )
 
-// AddSale records a sales transaction for a single Product.
-func AddSale(ctx context.Context, db *sqlx.DB, ns NewSale, productID string, now time.Time) (*Sale, error) {
+// AddSale records a sales transaction for a single Product. When the sale is
+// for a Variant it must belong to that Product. The list price in effect at
+// now is captured on the Sale so any discount given can be worked out later.
+// The units sold are taken out of stock in the inventory ledger. Any hooks
+// given take part in the sale in order. Only stock on hand where the sale is
+// made can be sold, and none held by active reservations.
+func AddSale(ctx context.Context, db *sqlx.DB, ns NewSale, productID string, now time.Time, hooks ...SaleHook) (*Sale, error) {
+	var s *Sale
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		var err error
+		s, err = RecordSale(ctx, tx, ns, productID, now, hooks...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// RecordSale does the work of AddSale as part of tx, so a sale can be made
+// together with other changes that must succeed or fail with it.
+func RecordSale(ctx context.Context, tx *sqlx.Tx, ns NewSale, productID string, now time.Time, hooks ...SaleHook) (*Sale, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if ns.Quantity <= 0 {
+		return nil, ErrInvalidQuantity
+	}
+	if ns.Paid != nil && ns.Paid.Amount < 0 {
+		return nil, ErrInvalidPaid
+	}
+
+	base, err := currencyOf(ctx, tx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	paid := money.Money{Currency: ns.Currency}
+	if ns.Paid != nil {
+		paid = *ns.Paid
+		if paid.Currency == "" {
+			paid.Currency = ns.Currency
+		}
+	}
+	if paid.Currency == "" {
+		paid.Currency = base
+	}
+	currency, err := money.Currency(paid.Currency)
+	if err != nil {
+		return nil, err
+	}
+	paid.Currency = currency
+
+	listPrice, err := UnitPriceIn(ctx, tx, productID, ns.VariantID, currency, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// The rate is rounded to the scale it is stored at before it is used so
+	// the recorded rate reproduces BasePaid.
+	rate, err := exchange.RateAt(ctx, tx, currency, base, now)
+	if err != nil {
+		return nil, err
+	}
+	exchangeRate := rate.FloatString(exchange.Scale)
+	rate.SetString(exchangeRate)
+
+	var variantID *string
+	if ns.VariantID != "" {
+		variantID = &ns.VariantID
+	}
+
+	// Lock the product so two sales, or a sale and a reservation, can not
+	// take the same last unit.
+	var exists bool
+	const lock = `SELECT true FROM products WHERE product_id = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &exists, lock, productID); err != nil {
+		return nil, errors.Wrap(err, "locking product")
+	}
+
+	avail, err := Available(ctx, tx, productID, variantID, now)
+	if err != nil {
+		return nil, err
+	}
+	if avail < ns.Quantity {
+		return nil, ErrInsufficientStock
+	}
+
+	locationID := ns.LocationID
+	if locationID == "" {
+		locationID = inventory.DefaultLocation
+	}
+	if err := inventory.CheckLocation(ctx, tx, locationID); err != nil {
+		return nil, err
+	}
+
+	var customerID *string
+	if ns.CustomerID != "" {
+		if err := customer.CheckCustomer(ctx, tx, ns.CustomerID); err != nil {
+			return nil, err
+		}
+		customerID = &ns.CustomerID
+	}
+
 	s := Sale{
-		ID:          uuid.New().String(),
-		ProductID:   productID,
-		Quantity:    ns.Quantity,
-		Paid:        ns.Paid,
-		DateCreated: now,
+		ID:         uuid.New().String(),
+		ProductID:  productID,
+		VariantID:  variantID,
+		LocationID: locationID,
+		CustomerID: customerID,
+		Quantity:   ns.Quantity,
+		Paid:       paid,
+		ListPrice:  money.Money{Amount: listPrice, Currency: currency},
+		Net:        money.Money{Currency: currency},
+		Tax:        money.Money{Currency: currency},
+		Gross:      money.Money{Currency: currency},
+
+		ExchangeRate: exchangeRate,
+		PointsValue:  money.Money{Currency: currency},
+		GiftCardPaid: money.Money{Currency: currency},
+		Refunded:     money.Money{Currency: currency},
+		COGS:         money.Money{Currency: base},
+		DateCreated:  now.UTC(),
+	}
+
+	for _, h := range hooks {
+		if h.Before == nil {
+			continue
+		}
+		if err := h.Before(ctx, tx, &s, ns); err != nil {
+			return nil, err
+		}
+	}
+
+	// Hooks can move the sale, as registers do, so the stock where it is
+	// made is checked once they have run.
+	avail, err = inventory.AvailableAt(ctx, tx, s.LocationID, s.ProductID, s.VariantID, now)
+	if err != nil {
+		return nil, err
+	}
+	if avail < s.Quantity {
+		return nil, ErrInsufficientStock
+	}
+
+	// A sale no hook has taxed is tax free.
+	if s.Net.IsZero() && s.Gross.IsZero() {
+		s.Net = s.Paid
+		s.Gross = s.Paid
+	}
+
+	// The amounts share one currency column so hooks must not change it.
+	for _, m := range []money.Money{s.ListPrice, s.Net, s.Tax, s.Gross} {
+		if m.Currency != s.Paid.Currency {
+			return nil, money.ErrMismatch
+		}
+	}
+
+	if s.BasePaid, err = money.Convert(s.Paid, rate, base); err != nil {
+		return nil, err
 	}
 
 	const q = `INSERT INTO sales
-			(sale_id, product_id, quantity, paid, date_created)
-			VALUES ($1, $2, $3, $4, $5)`
+		(sale_id, product_id, variant_id, location_id, customer_id, quantity,
+		currency, paid, list_price, promotion_id, coupon, net, tax, gross,
+		tax_class, tax_rate, tax_inclusive, base_paid, base_currency,
+		exchange_rate, points_earned, points_redeemed, points_value,
+		gift_card_paid, session_id, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15,
+		$16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26)`
 
-	_, err := db.ExecContext(ctx, q,
-		s.ID, s.ProductID, s.Quantity,
-		s.Paid, s.DateCreated,
+	_, err = tx.ExecContext(ctx, q,
+		s.ID, s.ProductID, s.VariantID, s.LocationID, s.CustomerID, s.Quantity, s.Paid.Currency,
+		s.Paid.Amount, s.ListPrice.Amount, s.PromotionID, s.Coupon,
+		s.Net.Amount, s.Tax.Amount, s.Gross.Amount, s.TaxClass, s.TaxRate,
+		s.TaxInclusive, s.BasePaid.Amount, s.BasePaid.Currency, s.ExchangeRate,
+		s.PointsEarned, s.PointsRedeemed, s.PointsValue.Amount, s.GiftCardPaid.Amount,
+		s.SessionID, s.DateCreated,
 	)
 	if err != nil {
 		return nil, errors.Wrap(err, "inserting sale")
 	}
 
+	m := inventory.Movement{
+		ProductID:   s.ProductID,
+		VariantID:   s.VariantID,
+		SaleID:      &s.ID,
+		LocationID:  s.LocationID,
+		Kind:        inventory.Sale,
+		Quantity:    -s.Quantity,
+		DateCreated: s.DateCreated,
+	}
+	rec, err := inventory.Record(ctx, tx, m)
+	if err != nil {
+		return nil, errors.Wrap(err, "recording stock movement")
+	}
+
+	s.COGS = money.Money{Amount: -rec.Value, Currency: base}
+	const u = `UPDATE sales SET cogs = $2 WHERE sale_id = $1`
+	if _, err := tx.ExecContext(ctx, u, s.ID, s.COGS.Amount); err != nil {
+		return nil, errors.Wrap(err, "updating cost of goods sold")
+	}
+
+	for _, h := range hooks {
+		if h.After == nil {
+			continue
+		}
+		if err := h.After(ctx, tx, &s, ns); err != nil {
+			return nil, err
+		}
+	}
+
+	as := accounting.Sale{
+		ID:            s.ID,
+		Gross:         s.Gross,
+		Tax:           s.Tax,
+		PointsValue:   s.PointsValue,
+		GiftCardPaid:  s.GiftCardPaid,
+		Cost:          s.COGS.Amount,
+		StockCurrency: base,
+		DateCreated:   s.DateCreated,
+	}
+	if err := accounting.RecordSale(ctx, tx, as); err != nil {
+		return nil, err
+	}
+
+	c := audit.Change{EntityType: auditSaleEntity, EntityID: s.ID, Action: audit.Create, After: s}
+	if _, err := audit.Record(ctx, tx, c, now); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// Available is the stock of a Product, or of one of its Variants when
+// variantID is set, that is neither sold nor held by an active reservation.
+func Available(ctx context.Context, tx *sqlx.Tx, productID string, variantID *string, now time.Time) (int, error) {
+	var n int
+
+	if variantID != nil {
+		const q = `
+			SELECT
+				v.quantity
+				- COALESCE((SELECT SUM(s.quantity) FROM sales as s
+					WHERE s.variant_id = v.variant_id), 0)
+				- COALESCE((SELECT SUM(r.quantity) FROM reservations as r
+					WHERE r.variant_id = v.variant_id
+					AND r.status = 'active' AND r.expires_at > $3), 0)
+			FROM variants as v
+			WHERE v.variant_id = $1 AND v.product_id = $2`
+
+		if err := tx.GetContext(ctx, &n, q, *variantID, productID, now.UTC()); err != nil {
+			if err == sql.ErrNoRows {
+				return 0, ErrVariantNotFound
+			}
+			return 0, errors.Wrap(err, "selecting available stock")
+		}
+		return n, nil
+	}
+
+	const q = `
+		SELECT
+			p.quantity
+			- COALESCE((SELECT SUM(s.quantity) FROM sales as s
+				WHERE s.product_id = p.product_id AND s.variant_id IS NULL), 0)
+			- COALESCE((SELECT SUM(r.quantity) FROM reservations as r
+				WHERE r.product_id = p.product_id AND r.variant_id IS NULL
+				AND r.status = 'active' AND r.expires_at > $2), 0)
+		FROM products as p
+		WHERE p.product_id = $1`
+
+	if err := tx.GetContext(ctx, &n, q, productID, now.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrNotFound
+		}
+		return 0, errors.Wrap(err, "selecting available stock")
+	}
+
+	return n, nil
+}
+
+// RecordRefund records amount, in the currency of a Sale, as given back for
+// it as part of tx, along with quantity units brought back. Refunds of a Sale
+// can not add up to more than was paid for it in money, as the loyalty points
+// that paid for the rest are given back instead, nor return more units than
+// were sold. Units brought back are put back in stock where they were sold, at
+// what they cost when sold. The Sale is locked until tx ends and is returned
+// with the refund counted.
+func RecordRefund(ctx context.Context, tx *sqlx.Tx, saleID string, amount, quantity int, now time.Time) (*Sale, error) {
+	if _, err := uuid.Parse(saleID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var s Sale
+	const q = `SELECT ` + saleColumns + ` FROM sales as s WHERE s.sale_id = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &s, q, saleID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSaleNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting sale %q", saleID)
+	}
+
+	if s.Refunded.Amount+amount > s.Paid.Amount-s.PointsValue.Amount {
+		return nil, ErrRefundTooMuch
+	}
+	if quantity < 0 || s.Returned+quantity > s.Quantity {
+		return nil, ErrReturnTooMuch
+	}
+	before := s
+	s.Refunded.Amount += amount
+	s.Returned += quantity
+
+	const u = `UPDATE sales SET refunded = $2, returned = $3 WHERE sale_id = $1`
+	if _, err := tx.ExecContext(ctx, u, s.ID, s.Refunded.Amount, s.Returned); err != nil {
+		return nil, errors.Wrap(err, "updating refunded amount")
+	}
+
+	if quantity > 0 {
+		unitCost := s.COGS.Amount / s.Quantity
+		m := inventory.Movement{
+			ProductID:   s.ProductID,
+			VariantID:   s.VariantID,
+			SaleID:      &s.ID,
+			LocationID:  s.LocationID,
+			Kind:        inventory.Refund,
+			Quantity:    quantity,
+			UnitCost:    &unitCost,
+			Actor:       audit.Actor(ctx),
+			DateCreated: now,
+		}
+		rec, err := inventory.Record(ctx, tx, m)
+		if err != nil {
+			return nil, errors.Wrap(err, "recording stock movement")
+		}
+		if err := accounting.RecordReturn(ctx, tx, rec.ID, s.ProductID, rec.Value, now); err != nil {
+			return nil, err
+		}
+	}
+
+	c := audit.Change{EntityType: auditSaleEntity, EntityID: s.ID, Action: audit.Update, Before: before, After: s}
+	if _, err := audit.Record(ctx, tx, c, now); err != nil {
+		return nil, err
+	}
+
 	return &s, nil
 }
 
@@ -38,10 +368,88 @@ func AddSale(ctx context.Context, db *sqlx.DB, ns NewSale, productID string, now
 func ListSales(ctx context.Context, db *sqlx.DB, productID string) ([]Sale, error) {
 	sales := []Sale{}
 
-	const q = `SELECT * FROM sales WHERE product_id = $1`
+	const q = `SELECT ` + saleColumns + ` FROM sales as s WHERE s.product_id = $1`
 	if err := db.SelectContext(ctx, &sales, q, productID); err != nil {
 		return nil, errors.Wrap(err, "selecting sales")
 	}
 
 	return sales, nil
 }
+
+// CustomerHistory gets every Sale made to a Customer, latest first, with
+// what they have spent in total.
+func CustomerHistory(ctx context.Context, db *sqlx.DB, customerID string) (*History, error) {
+	if err := customer.CheckCustomer(ctx, db, customerID); err != nil {
+		return nil, err
+	}
+
+	h := History{
+		CustomerID:    customerID,
+		LifetimeValue: []money.Money{},
+		Sales:         []Sale{},
+	}
+
+	const q = `
+		SELECT ` + saleColumns + ` FROM sales as s
+		WHERE s.customer_id = $1
+		ORDER BY s.date_created DESC`
+
+	if err := db.SelectContext(ctx, &h.Sales, q, customerID); err != nil {
+		return nil, errors.Wrap(err, "selecting customer sales")
+	}
+
+	// Sales are totalled per currency they were paid in, in order of first
+	// appearance, so nothing is lost to conversion.
+	for _, s := range h.Sales {
+		h.Orders++
+		h.Units += s.Quantity
+
+		i := 0
+		for i < len(h.LifetimeValue) && h.LifetimeValue[i].Currency != s.Paid.Currency {
+			i++
+		}
+		if i == len(h.LifetimeValue) {
+			h.LifetimeValue = append(h.LifetimeValue, money.Money{Currency: s.Paid.Currency})
+		}
+
+		var err error
+		if h.LifetimeValue[i], err = h.LifetimeValue[i].Add(s.Paid); err != nil {
+			return nil, err
+		}
+	}
+
+	return &h, nil
+}
+
+// saleColumns selects a Sale from the sales table aliased as s. Each amount
+// is paired with the currency of the sale so it scans into a money.Money.
+const saleColumns = `
+			s.sale_id, s.product_id, s.variant_id, s.location_id, s.customer_id, s.session_id, s.quantity,
+			s.paid as "paid.amount", s.currency as "paid.currency",
+			s.list_price as "list_price.amount", s.currency as "list_price.currency",
+			s.promotion_id, s.coupon,
+			s.net as "net.amount", s.currency as "net.currency",
+			s.tax as "tax.amount", s.currency as "tax.currency",
+			s.gross as "gross.amount", s.currency as "gross.currency",
+			s.tax_class, s.tax_rate, s.tax_inclusive,
+			s.base_paid as "base_paid.amount", s.base_currency as "base_paid.currency",
+			s.exchange_rate, s.points_earned, s.points_redeemed,
+			s.points_value as "points_value.amount", s.currency as "points_value.currency",
+			s.gift_card_paid as "gift_card_paid.amount", s.currency as "gift_card_paid.currency",
+			s.refunded as "refunded.amount", s.currency as "refunded.currency", s.returned,
+			s.cogs as "cogs.amount", s.base_currency as "cogs.currency",
+			s.date_created`
+
+// currencyOf gets the currency a Product is sold in.
+func currencyOf(ctx context.Context, db sqlx.QueryerContext, productID string) (string, error) {
+	var currency string
+	const q = `SELECT currency FROM products WHERE product_id = $1`
+	if err := sqlx.GetContext(ctx, db, &currency, q, productID); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrNotFound
+		}
+		return "", errors.Wrap(err, "selecting product currency")
+	}
+
+	return currency, nil
+}
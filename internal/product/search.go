@@ -0,0 +1,289 @@
+package product
+
+import (
+	"context"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/platform/database"
+)
+
+// ErrNoQuery is returned when a search is not given anything to search for.
+var ErrNoQuery = errors.New("Search must have a query")
+
+// DefaultSearchLimit is how many results a search gives when not told.
+const DefaultSearchLimit = 20
+
+// MaxSearchLimit is the most results a search gives.
+const MaxSearchLimit = 100
+
+// typoThreshold is how similar, from 0 to 1, a query must be to a word of the
+// name of a Product to match it when its words do not.
+const typoThreshold = 0.6
+
+// markStart and markStop are what the database puts around the words of a
+// name that match, to be turned into <mark> tags once the name is escaped.
+const (
+	markStart = "\x01"
+	markStop  = "\x02"
+)
+
+// Searcher finds Products by their name, SKU and category.
+type Searcher interface {
+	// Search gets up to limit Products matching q, the best match first. A
+	// Product matches when every word of q starts a word of its name, SKU or
+	// category, or when q is close enough to a word of its name to be taken
+	// for a misspelling of it.
+	Search(ctx context.Context, q string, limit int) ([]SearchResult, error)
+}
+
+// PostgresSearch is a Searcher using the full text search of the database,
+// with trigram similarity to tolerate typos.
+type PostgresSearch struct {
+	DB *sqlx.DB
+}
+
+// Search implements Searcher. Results are ranked by how well their words
+// match plus how similar their name is to q.
+func (s *PostgresSearch) Search(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	terms, limit, err := searchTerms(q, limit)
+	if err != nil {
+		return nil, err
+	}
+	results := []SearchResult{}
+	if len(terms) == 0 {
+		return results, nil
+	}
+
+	// Every term matches as a prefix so partial words find whole ones.
+	tsquery := strings.Join(terms, ":* & ") + ":*"
+
+	const q1 = `
+			SELECT ` + columns + `,
+				ts_rank(p.search, to_tsquery('english', $1)) + word_similarity($2, p.name) as rank,
+				ts_headline('english', p.name, to_tsquery('english', $1),
+					E'StartSel=\x01, StopSel=\x02, HighlightAll=true') as highlight
+			FROM products as p
+			LEFT JOIN sales as s ON(p.product_id=s.product_id)
+			WHERE p.search @@ to_tsquery('english', $1) OR $2 <% p.name
+			GROUP BY p.product_id
+			ORDER BY rank DESC, p.name
+			LIMIT $3`
+
+	err = database.WithTx(ctx, s.DB, func(tx *sqlx.Tx) error {
+		const set = `SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`
+		if _, err := tx.ExecContext(ctx, set, strconv.FormatFloat(typoThreshold, 'f', -1, 64)); err != nil {
+			return errors.Wrap(err, "setting typo threshold")
+		}
+
+		if err := tx.SelectContext(ctx, &results, q1, tsquery, strings.Join(terms, " "), limit); err != nil {
+			return errors.Wrap(err, "searching products")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Names are written by clerks, so they are escaped before being marked
+	// up to keep them from injecting HTML of their own.
+	marks := strings.NewReplacer(markStart, "<mark>", markStop, "</mark>")
+	for i := range results {
+		results[i].Highlight = marks.Replace(html.EscapeString(results[i].Highlight))
+	}
+
+	return results, nil
+}
+
+// MemorySearch is a Searcher over Products held in memory. It matches like
+// PostgresSearch, without its stemming, and ranks roughly the same way. It is
+// meant for tests that do not have a database to search.
+type MemorySearch struct {
+	mu       sync.Mutex
+	products []Product
+}
+
+// NewMemorySearch constructs a MemorySearch over products.
+func NewMemorySearch(products ...Product) *MemorySearch {
+	return &MemorySearch{products: products}
+}
+
+// Add makes products searchable.
+func (m *MemorySearch) Add(products ...Product) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.products = append(m.products, products...)
+}
+
+// Search implements Searcher.
+func (m *MemorySearch) Search(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	terms, limit, err := searchTerms(q, limit)
+	if err != nil {
+		return nil, err
+	}
+	results := []SearchResult{}
+	if len(terms) == 0 {
+		return results, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range m.products {
+		name := searchWords(p.Name)
+
+		// Names and SKUs weigh more than categories, as they do in the
+		// database.
+		var rank float64
+		matched := true
+		for _, t := range terms {
+			switch {
+			case hasPrefix(name, t) || hasPrefix(searchWords(p.SKU), t):
+				rank += 1
+			case hasPrefix(searchWords(p.Category), t):
+				rank += 0.4
+			default:
+				matched = false
+			}
+		}
+
+		similarity := wordSimilarity(strings.Join(terms, " "), name)
+		if !matched {
+			if similarity < typoThreshold {
+				continue
+			}
+			rank = 0
+		}
+
+		results = append(results, SearchResult{
+			Product:   p,
+			Rank:      rank/float64(len(terms)) + similarity,
+			Highlight: highlight(p.Name, terms),
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].Name < results[j].Name
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+// searchTerms splits q into the lower case words it searches for and bounds
+// limit.
+func searchTerms(q string, limit int) ([]string, int, error) {
+	if strings.TrimSpace(q) == "" {
+		return nil, 0, ErrNoQuery
+	}
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+
+	return searchWords(q), limit, nil
+}
+
+// searchWords splits s into lower case words of letters and digits.
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// hasPrefix reports if any of words starts with prefix.
+func hasPrefix(words []string, prefix string) bool {
+	for _, w := range words {
+		if strings.HasPrefix(w, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// highlight marks the words of name that start with any of terms, the way
+// the database does, escaping the rest of name for HTML.
+func highlight(name string, terms []string) string {
+	var b strings.Builder
+	word := -1
+	flush := func(end int) {
+		if word < 0 {
+			return
+		}
+		w := html.EscapeString(name[word:end])
+		for _, t := range terms {
+			if strings.HasPrefix(strings.ToLower(name[word:end]), t) {
+				w = "<mark>" + w + "</mark>"
+				break
+			}
+		}
+		b.WriteString(w)
+		word = -1
+	}
+
+	for i, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if word < 0 {
+				word = i
+			}
+			continue
+		}
+		flush(i)
+		b.WriteString(html.EscapeString(string(r)))
+	}
+	flush(len(name))
+
+	return b.String()
+}
+
+// wordSimilarity is how similar q is to the word of words most like it, from
+// 0 to 1: the share of the trigrams of q found in that word, much as pg_trgm
+// works it out.
+func wordSimilarity(q string, words []string) float64 {
+	qt := trigrams(q)
+	if len(qt) == 0 {
+		return 0
+	}
+
+	var best float64
+	for _, w := range words {
+		wt := trigrams(w)
+		shared := 0
+		for t := range qt {
+			if wt[t] {
+				shared++
+			}
+		}
+		if s := float64(shared) / float64(len(qt)); s > best {
+			best = s
+		}
+	}
+	return best
+}
+
+// trigrams gets the trigrams of each word of s, padded with two spaces in
+// front and one behind.
+func trigrams(s string) map[string]bool {
+	ts := map[string]bool{}
+	for _, w := range searchWords(s) {
+		r := []rune("  " + w + " ")
+		for i := 0; i+3 <= len(r); i++ {
+			ts[string(r[i:i+3])] = true
+		}
+	}
+	return ts
+}
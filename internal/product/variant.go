@@ -0,0 +1,169 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/inventory"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/database"
+)
+
+var ErrVariantNotFound = errors.New("Variant not found")
+
+// AddVariant adds a new Variant to the Product identified by productID. Its
+// SKU must not be used by another Variant.
+func AddVariant(ctx context.Context, db *sqlx.DB, nv NewVariant, productID string, now time.Time) (*Variant, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	if nv.Cost != nil && *nv.Cost < 0 {
+		return nil, ErrInvalidCost
+	}
+	if nv.Quantity < 0 {
+		return nil, ErrInvalidStock
+	}
+	if nv.UnitCost < 0 {
+		return nil, inventory.ErrInvalidCost
+	}
+
+	currency, err := currencyOf(ctx, db, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	v := Variant{
+		ID:          uuid.New().String(),
+		ProductID:   productID,
+		SKU:         nv.SKU,
+		Options:     nv.Options,
+		Quantity:    nv.Quantity,
+		Available:   nv.Quantity,
+		Revenue:     money.Money{Currency: currency},
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+	if nv.Cost != nil {
+		v.Cost = &money.Money{Amount: *nv.Cost, Currency: currency}
+	}
+
+	err = database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		const q = `
+			INSERT INTO variants
+			(variant_id, product_id, sku, options, cost, quantity, date_created, date_updated)
+			VALUES ($1, $2, $3, $4, $5, 0, $6, $7)`
+
+		_, err := tx.ExecContext(ctx, q,
+			v.ID, v.ProductID, v.SKU, v.Options, nv.Cost,
+			v.DateCreated, v.DateUpdated,
+		)
+		if err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				return ErrDuplicateSKU
+			}
+			return errors.Wrap(err, "inserting variant")
+		}
+
+		if err := openingStock(ctx, tx, v.ProductID, &v.ID, v.Quantity, nv.UnitCost, v.DateCreated); err != nil {
+			return err
+		}
+
+		// A Variant sold at its own cost has that cost in the price history of
+		// its Product from the start.
+		if v.Cost != nil {
+			pr := Price{
+				ID:            uuid.New().String(),
+				ProductID:     v.ProductID,
+				VariantID:     &v.ID,
+				Cost:          v.Cost.Amount,
+				EffectiveFrom: v.DateCreated,
+				DateCreated:   v.DateCreated,
+			}
+			if err := insertPrice(ctx, tx, pr); err != nil {
+				return err
+			}
+		}
+
+		c := audit.Change{EntityType: auditEntity, EntityID: v.ProductID, Action: auditAddVariant, After: v}
+		_, err = audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
+
+// ListVariants gives all Variants of a Product along with the units sold,
+// revenue made and stock available for each of them. Revenue is what the
+// sales of a Variant came to in the currency of the Product.
+func ListVariants(ctx context.Context, db *sqlx.DB, productID string) ([]Variant, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var rows []variantRow
+
+	const q = `
+			SELECT
+				v.*,
+				p.currency,
+				COALESCE(SUM(s.quantity), 0) as sold,
+				COALESCE(SUM(s.base_paid), 0) as "revenue.amount", p.currency as "revenue.currency",
+				v.quantity
+				- COALESCE(SUM(s.quantity), 0)
+				- COALESCE((SELECT SUM(r.quantity) FROM reservations as r
+					WHERE r.variant_id = v.variant_id
+					AND r.status = 'active' AND r.expires_at > now()), 0) as available
+			FROM variants as v
+			JOIN products as p ON(p.product_id = v.product_id)
+			LEFT JOIN sales as s ON(v.variant_id=s.variant_id)
+			WHERE v.product_id = $1
+			GROUP BY v.variant_id, p.currency
+			ORDER BY v.sku`
+
+	if err := db.SelectContext(ctx, &rows, q, productID); err != nil {
+		return nil, errors.Wrap(err, "selecting variants")
+	}
+
+	variants := make([]Variant, len(rows))
+	for i, r := range rows {
+		variants[i] = r.variant()
+	}
+
+	return variants, nil
+}
+
+// retriveVariant finds a Variant and makes sure it belongs to productID.
+func retriveVariant(ctx context.Context, db sqlx.QueryerContext, productID, variantID string) (*Variant, error) {
+	if _, err := uuid.Parse(variantID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var r variantRow
+	const q = `
+			SELECT
+				v.*, p.currency, 0 as sold,
+				0 as "revenue.amount", p.currency as "revenue.currency", 0 as available
+			FROM variants as v
+			JOIN products as p ON(p.product_id = v.product_id)
+			WHERE v.variant_id = $1 AND v.product_id = $2`
+
+	if err := sqlx.GetContext(ctx, db, &r, q, variantID, productID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrVariantNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting variant %q", variantID)
+	}
+
+	v := r.variant()
+	return &v, nil
+}
@@ -0,0 +1,92 @@
+package receipt
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vikramcse/the-service/internal/money"
+)
+
+// Store is what is printed on every Receipt around the sale itself, such as
+// the name and address of the shop and a thank you.
+type Store struct {
+	Header []string
+	Footer []string
+}
+
+// Receipt is what a customer is handed for one or more Sales rung up
+// together. Every amount is in the currency of the Sales. Subtotal is at list
+// price; Total is what was charged after Discount and with Tax, which is
+// already part of Subtotal when TaxInclusive. Tenders are what paid for the
+// Sales and Due is what they have not covered yet.
+type Receipt struct {
+	Header       []string    `json:"header,omitempty"`
+	Location     string      `json:"location,omitempty"`
+	Number       string      `json:"number"`
+	Date         time.Time   `json:"date"`
+	Customer     string      `json:"customer,omitempty"`
+	Lines        []Line      `json:"lines"`
+	Subtotal     money.Money `json:"subtotal"`
+	Discount     money.Money `json:"discount"`
+	Tax          money.Money `json:"tax"`
+	TaxInclusive bool        `json:"tax_inclusive"`
+	Total        money.Money `json:"total"`
+	Tenders      []Tender    `json:"tenders"`
+	Change       money.Money `json:"change"`
+	Refunded     money.Money `json:"refunded"`
+	Due          money.Money `json:"due"`
+	PointsEarned int         `json:"points_earned,omitempty"`
+	Footer       []string    `json:"footer,omitempty"`
+}
+
+// Line is one Sale on a Receipt. Amount is Quantity at UnitPrice, the list
+// price, and Discount is taken off it. TaxRate is in hundredths of a percent.
+type Line struct {
+	SaleID    string            `json:"sale_id"`
+	Name      string            `json:"name"`
+	Options   map[string]string `json:"options,omitempty"`
+	Quantity  int               `json:"quantity"`
+	UnitPrice money.Money       `json:"unit_price"`
+	Amount    money.Money       `json:"amount"`
+	Discount  money.Money       `json:"discount"`
+	TaxRate   int               `json:"tax_rate"`
+}
+
+// Description names what was sold on a Line, with the options of a variant
+// in the order of their names.
+func (l Line) Description() string {
+	if len(l.Options) == 0 {
+		return l.Name
+	}
+
+	names := make([]string, 0, len(l.Options))
+	for name := range l.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = l.Options[name]
+	}
+	return l.Name + " (" + strings.Join(values, "/") + ")"
+}
+
+// Tender is one way the Sales of a Receipt were paid for: a payment tender,
+// a gift card taken with the sale, or loyalty points. Reference is the end
+// of the card or gift card code it was paid with.
+type Tender struct {
+	Tender    string      `json:"tender"`
+	Amount    money.Money `json:"amount"`
+	Reference string      `json:"reference,omitempty"`
+}
+
+// Label is how a Tender is printed.
+func (t Tender) Label() string {
+	label := strings.Title(strings.Replace(t.Tender, "_", " ", -1))
+	if t.Reference != "" {
+		label += " *" + t.Reference
+	}
+	return label
+}
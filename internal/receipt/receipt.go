@@ -0,0 +1,224 @@
+// Package receipt renders what customers are handed for their purchases, as
+// text for the receipt printers at our tills, as HTML and as PDF.
+package receipt
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/payment"
+	"github.com/vikramcse/the-service/internal/product"
+)
+
+var (
+	ErrNotFound            = errors.New("Sale not found")
+	ErrReservationNotFound = errors.New("Reservation not found")
+	ErrInvalidID           = errors.New("ID is not in it's proper form")
+	ErrNoSales             = errors.New("Receipt must have at least one sale")
+	ErrNotConfirmed        = errors.New("Reservation has not been confirmed")
+)
+
+// Tenders printed on a Receipt besides those of package payment.
+const (
+	// Points are loyalty points redeemed on a Sale.
+	Points = "loyalty_points"
+)
+
+// Build makes the Receipt for the Sales identified by saleIDs, which must all
+// be in one currency. Only payments that were captured are tendered.
+func Build(ctx context.Context, db *sqlx.DB, saleIDs []string, store Store) (*Receipt, error) {
+	if len(saleIDs) == 0 {
+		return nil, ErrNoSales
+	}
+
+	ids := make([]string, 0, len(saleIDs))
+	seen := map[string]bool{}
+	for _, id := range saleIDs {
+		if _, err := uuid.Parse(id); err != nil {
+			return nil, ErrInvalidID
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	var rows []struct {
+		SaleID       string          `db:"sale_id"`
+		Name         string          `db:"name"`
+		Options      product.Options `db:"options"`
+		Quantity     int             `db:"quantity"`
+		Currency     string          `db:"currency"`
+		ListPrice    int             `db:"list_price"`
+		Net          int             `db:"net"`
+		Tax          int             `db:"tax"`
+		Gross        int             `db:"gross"`
+		TaxRate      int             `db:"tax_rate"`
+		TaxInclusive bool            `db:"tax_inclusive"`
+		PointsEarned int             `db:"points_earned"`
+		PointsValue  int             `db:"points_value"`
+		GiftCardPaid int             `db:"gift_card_paid"`
+		Customer     sql.NullString  `db:"customer"`
+		Location     sql.NullString  `db:"location"`
+		DateCreated  time.Time       `db:"date_created"`
+	}
+	const q = `
+		SELECT
+			s.sale_id, p.name, v.options, s.quantity, s.currency, s.list_price,
+			s.net, s.tax, s.gross, s.tax_rate, s.tax_inclusive,
+			s.points_earned, s.points_value, s.gift_card_paid,
+			c.name as customer, l.name as location, s.date_created
+		FROM sales as s
+		JOIN products as p ON p.product_id = s.product_id
+		LEFT JOIN variants as v ON v.variant_id = s.variant_id
+		LEFT JOIN customers as c ON c.customer_id = s.customer_id
+		LEFT JOIN locations as l ON l.location_id = s.location_id
+		WHERE s.sale_id = ANY($1)
+		ORDER BY s.date_created, s.sale_id`
+
+	if err := db.SelectContext(ctx, &rows, q, pq.Array(ids)); err != nil {
+		return nil, errors.Wrap(err, "selecting sales")
+	}
+	if len(rows) != len(ids) {
+		return nil, ErrNotFound
+	}
+
+	currency := rows[0].Currency
+	zero := money.Money{Currency: currency}
+	r := Receipt{
+		Header:   store.Header,
+		Number:   ids[0],
+		Lines:    []Line{},
+		Subtotal: zero,
+		Discount: zero,
+		Tax:      zero,
+		Total:    zero,
+		Tenders:  []Tender{},
+		Change:   zero,
+		Refunded: zero,
+		Due:      zero,
+		Footer:   store.Footer,
+
+		TaxInclusive: true,
+	}
+
+	var points, giftCards int
+	for _, row := range rows {
+		if row.Currency != currency {
+			return nil, money.ErrMismatch
+		}
+
+		// What the line was priced at leaves out tax that was added on top.
+		priced := row.Gross
+		if row.TaxRate > 0 && !row.TaxInclusive {
+			priced = row.Net
+			r.TaxInclusive = false
+		}
+		amount := row.ListPrice * row.Quantity
+		discount := amount - priced
+		if discount < 0 {
+			amount, discount = priced, 0
+		}
+
+		r.Lines = append(r.Lines, Line{
+			SaleID:    row.SaleID,
+			Name:      row.Name,
+			Options:   row.Options,
+			Quantity:  row.Quantity,
+			UnitPrice: money.Money{Amount: row.ListPrice, Currency: currency},
+			Amount:    money.Money{Amount: amount, Currency: currency},
+			Discount:  money.Money{Amount: discount, Currency: currency},
+			TaxRate:   row.TaxRate,
+		})
+
+		r.Subtotal.Amount += amount
+		r.Discount.Amount += discount
+		r.Tax.Amount += row.Tax
+		r.Total.Amount += row.Gross
+		r.PointsEarned += row.PointsEarned
+		points += row.PointsValue
+		giftCards += row.GiftCardPaid
+
+		if row.DateCreated.After(r.Date) {
+			r.Date = row.DateCreated
+		}
+		if r.Location == "" && row.Location.Valid {
+			r.Location = row.Location.String
+		}
+		if r.Customer == "" && row.Customer.Valid {
+			r.Customer = row.Customer.String
+		}
+	}
+
+	if points > 0 {
+		r.Tenders = append(r.Tenders, Tender{Tender: Points, Amount: money.Money{Amount: points, Currency: currency}})
+	}
+	if giftCards > 0 {
+		r.Tenders = append(r.Tenders, Tender{Tender: payment.GiftCard, Amount: money.Money{Amount: giftCards, Currency: currency}})
+	}
+	paid := points + giftCards
+
+	for _, id := range ids {
+		payments, err := payment.List(ctx, db, id)
+		if err != nil {
+			return nil, err
+		}
+		for _, pm := range payments {
+			if pm.Status != payment.Captured && pm.Status != payment.Refunded {
+				continue
+			}
+
+			t := Tender{Tender: pm.Tender, Amount: pm.Amount}
+			if pm.Tender != payment.Cash {
+				t.Reference = last(pm.Reference, 4)
+			}
+			r.Tenders = append(r.Tenders, t)
+
+			paid += pm.Amount.Amount - pm.Change.Amount
+			r.Change.Amount += pm.Change.Amount
+			r.Refunded.Amount += pm.Refunded.Amount
+		}
+	}
+
+	if due := r.Total.Amount - paid; due > 0 {
+		r.Due.Amount = due
+	}
+
+	return &r, nil
+}
+
+// ForReservation makes the Receipt for the Sale a Reservation was confirmed
+// as.
+func ForReservation(ctx context.Context, db *sqlx.DB, reservationID string, store Store) (*Receipt, error) {
+	if _, err := uuid.Parse(reservationID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var saleID sql.NullString
+	const q = `SELECT sale_id FROM reservations WHERE reservation_id = $1`
+	if err := db.GetContext(ctx, &saleID, q, reservationID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrReservationNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting reservation %q", reservationID)
+	}
+	if !saleID.Valid {
+		return nil, ErrNotConfirmed
+	}
+
+	return Build(ctx, db, []string{saleID.String}, store)
+}
+
+// last gives the last n characters of s.
+func last(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[len(s)-n:]
+}
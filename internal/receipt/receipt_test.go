@@ -0,0 +1,149 @@
+package receipt_test
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/payment"
+	"github.com/vikramcse/the-service/internal/product"
+	"github.com/vikramcse/the-service/internal/receipt"
+	"github.com/vikramcse/the-service/internal/tests"
+)
+
+// sample is a Receipt for two lines, one discounted, paid in cash with
+// change.
+func sample() receipt.Receipt {
+	usd := func(n int) money.Money { return money.Money{Amount: n, Currency: "USD"} }
+
+	return receipt.Receipt{
+		Header:   []string{"THE SERVICE"},
+		Location: "High Street",
+		Number:   "0d5b2e4c-8a3f-4c1e-9b7a-2f6d1e3c4b5a",
+		Date:     time.Date(2019, time.January, 1, 12, 30, 0, 0, time.UTC),
+		Lines: []receipt.Line{
+			{Name: "Comic Book", Quantity: 2, UnitPrice: usd(500), Amount: usd(1000), Discount: usd(100)},
+			{Name: "T-Shirt", Options: map[string]string{"size": "M", "colour": "red"}, Quantity: 1, UnitPrice: usd(1500), Amount: usd(1500), Discount: usd(0)},
+		},
+		Subtotal:     usd(2500),
+		Discount:     usd(100),
+		Tax:          usd(400),
+		TaxInclusive: true,
+		Total:        usd(2400),
+		Tenders:      []receipt.Tender{{Tender: payment.Cash, Amount: usd(3000)}},
+		Change:       usd(600),
+		Refunded:     usd(0),
+		Due:          usd(0),
+		Footer:       []string{"Thank you <3"},
+	}
+}
+
+func TestRender(t *testing.T) {
+	r := sample()
+
+	var text bytes.Buffer
+	if err := r.WriteText(&text); err != nil {
+		t.Fatalf("writing text: %s", err)
+	}
+	for _, want := range []string{
+		"THE SERVICE",
+		"Receipt                     2F6D1E3C4B5A",
+		"T-Shirt (red/M)",
+		"  2 x 5.00 USD                 10.00 USD",
+		"  Discount                     -1.00 USD",
+		"Tax included                    4.00 USD",
+		"TOTAL                          24.00 USD",
+		"Cash                           30.00 USD",
+		"Change                          6.00 USD",
+	} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("expected text to contain %q:\n%s", want, text.String())
+		}
+	}
+	if strings.Contains(text.String(), "BALANCE DUE") {
+		t.Errorf("expected nothing due:\n%s", text.String())
+	}
+	for _, line := range strings.Split(text.String(), "\n") {
+		if len(line) > 40 {
+			t.Errorf("line %q is wider than the printer", line)
+		}
+	}
+
+	var html bytes.Buffer
+	if err := r.WriteHTML(&html); err != nil {
+		t.Fatalf("writing html: %s", err)
+	}
+	if !strings.Contains(html.String(), "Thank you &lt;3") {
+		t.Errorf("expected footer to be escaped:\n%s", html.String())
+	}
+
+	var pdf bytes.Buffer
+	if err := r.WritePDF(&pdf); err != nil {
+		t.Fatalf("writing pdf: %s", err)
+	}
+	doc := pdf.String()
+	if !strings.HasPrefix(doc, "%PDF-1.4\n") || !strings.HasSuffix(doc, "%%EOF\n") {
+		t.Fatalf("expected a PDF document, got %q", doc)
+	}
+	if !strings.Contains(doc, "(TOTAL                          24.00 USD) Tj") {
+		t.Errorf("expected the total in the PDF:\n%s", doc)
+	}
+
+	// Every entry of the cross-reference table must point at its object.
+	xref := doc[strings.Index(doc, "\nxref\n")+1:]
+	for i, entry := range strings.Split(xref, "\n")[3:8] {
+		off, err := strconv.Atoi(entry[:10])
+		if err != nil {
+			t.Fatalf("reading xref entry %q: %s", entry, err)
+		}
+		if want := strings.TrimSpace(strings.SplitN(doc[off:], "\n", 2)[0]); want != strconv.Itoa(i+1)+" 0 obj" {
+			t.Errorf("xref entry %d points at %q", i+1, want)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	p, err := product.Create(ctx, db, product.NewProduct{Name: "Comic Book", Cost: 500, Quantity: 10}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+
+	var ids []string
+	for _, paid := range []int{1000, 900} {
+		s, err := product.AddSale(ctx, db, product.NewSale{Quantity: 2, Paid: &money.Money{Amount: paid}}, p.ID, now)
+		if err != nil {
+			t.Fatalf("adding sale: %s", err)
+		}
+		ids = append(ids, s.ID)
+	}
+
+	np := payment.NewPayment{Tenders: []payment.NewTender{{Tender: payment.Cash, Amount: 2000}}}
+	if _, err := payment.Pay(ctx, db, payment.NewFake(), ids[0], np, now); err != nil {
+		t.Fatalf("paying: %s", err)
+	}
+
+	r, err := receipt.Build(ctx, db, ids, receipt.Store{Header: []string{"THE SERVICE"}})
+	if err != nil {
+		t.Fatalf("building receipt: %s", err)
+	}
+	if len(r.Lines) != 2 || r.Subtotal.Amount != 2000 || r.Discount.Amount != 100 || r.Total.Amount != 1900 {
+		t.Fatalf("unexpected receipt: %+v", r)
+	}
+	if r.Change.Amount != 1000 || r.Due.Amount != 900 {
+		t.Fatalf("expected 1000 change and 900 due, got %v and %v", r.Change, r.Due)
+	}
+
+	if _, err := receipt.Build(ctx, db, []string{"bad"}, receipt.Store{}); err != receipt.ErrInvalidID {
+		t.Fatalf("expected %v, got %v", receipt.ErrInvalidID, err)
+	}
+}
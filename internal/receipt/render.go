@@ -0,0 +1,142 @@
+package receipt
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// width is the number of characters in a line of a text Receipt, which fits
+// the receipt printers at our tills.
+const width = 40
+
+// funcs lay out text Receipts.
+var funcs = template.FuncMap{
+	"center": func(s string) string {
+		s = trunc(s)
+		return strings.Repeat(" ", (width-len(s))/2) + s
+	},
+	"field": func(label string, value interface{}) string {
+		v := fmt.Sprint(value)
+		pad := width - len(label) - len(v)
+		if pad < 1 {
+			pad = 1
+		}
+		return label + strings.Repeat(" ", pad) + v
+	},
+	"rule":  func() string { return strings.Repeat("-", width) },
+	"trunc": trunc,
+	"date":  func(r Receipt) string { return r.Date.Format("2006-01-02 15:04") },
+	"short": func(id string) string { return strings.ToUpper(last(strings.Replace(id, "-", "", -1), 12)) },
+	"rate":  func(rate int) string { return fmt.Sprintf("%d.%02d%%", rate/100, rate%100) },
+	"taxLabel": func(r Receipt) string {
+		if r.TaxInclusive {
+			return "Tax included"
+		}
+		return "Tax"
+	},
+}
+
+var text = template.Must(template.New("text").Funcs(funcs).Parse(
+	`{{range .Header}}{{center .}}
+{{end}}{{with .Location}}{{center .}}
+{{end}}{{rule}}
+{{field "Receipt" (short .Number)}}
+{{field "Date" (date .)}}
+{{with .Customer}}{{field "Customer" .}}
+{{end}}{{rule}}
+{{range .Lines}}{{trunc .Description}}
+{{field (printf "  %d x %s" .Quantity .UnitPrice) .Amount}}
+{{if .Discount.Amount}}{{field "  Discount" .Discount.Neg}}
+{{end}}{{end}}{{rule}}
+{{field "Subtotal" .Subtotal}}
+{{if .Discount.Amount}}{{field "Discount" .Discount.Neg}}
+{{end}}{{field (taxLabel .) .Tax}}
+{{field "TOTAL" .Total}}
+{{rule}}
+{{range .Tenders}}{{field .Label .Amount}}
+{{end}}{{if .Change.Amount}}{{field "Change" .Change}}
+{{end}}{{if .Refunded.Amount}}{{field "Refunded" .Refunded}}
+{{end}}{{if .Due.Amount}}{{field "BALANCE DUE" .Due}}
+{{end}}{{if .PointsEarned}}{{rule}}
+{{field "Points earned" .PointsEarned}}
+{{end}}{{if .Footer}}{{rule}}
+{{range .Footer}}{{center .}}
+{{end}}{{end}}`))
+
+var html = htmltemplate.Must(htmltemplate.New("html").Funcs(htmltemplate.FuncMap{
+	"date":  funcs["date"],
+	"short": funcs["short"],
+	"rate":  funcs["rate"],
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Receipt {{short .Number}}</title>
+<style>
+body { font-family: sans-serif; max-width: 26em; margin: 1em auto; }
+header, footer { text-align: center; }
+table { width: 100%; border-collapse: collapse; }
+td.amount { text-align: right; white-space: nowrap; }
+tr.total td { font-weight: bold; border-top: 1px solid; }
+.detail { color: #555; font-size: smaller; }
+</style>
+</head>
+<body>
+<header>
+{{range .Header}}<div>{{.}}</div>
+{{end}}{{with .Location}}<div>{{.}}</div>
+{{end}}</header>
+<p>Receipt {{short .Number}}<br>{{date .}}{{with .Customer}}<br>Customer: {{.}}{{end}}</p>
+<table>
+{{range .Lines}}<tr><td>{{.Description}}<div class="detail">{{.Quantity}} x {{.UnitPrice}}{{if .TaxRate}}, tax {{rate .TaxRate}}{{end}}</div></td><td class="amount">{{.Amount}}</td></tr>
+{{if .Discount.Amount}}<tr class="detail"><td>Discount</td><td class="amount">{{.Discount.Neg}}</td></tr>
+{{end}}{{end}}<tr class="total"><td>Subtotal</td><td class="amount">{{.Subtotal}}</td></tr>
+{{if .Discount.Amount}}<tr><td>Discount</td><td class="amount">{{.Discount.Neg}}</td></tr>
+{{end}}<tr><td>{{if .TaxInclusive}}Tax included{{else}}Tax{{end}}</td><td class="amount">{{.Tax}}</td></tr>
+<tr class="total"><td>Total</td><td class="amount">{{.Total}}</td></tr>
+{{range .Tenders}}<tr><td>{{.Label}}</td><td class="amount">{{.Amount}}</td></tr>
+{{end}}{{if .Change.Amount}}<tr><td>Change</td><td class="amount">{{.Change}}</td></tr>
+{{end}}{{if .Refunded.Amount}}<tr><td>Refunded</td><td class="amount">{{.Refunded}}</td></tr>
+{{end}}{{if .Due.Amount}}<tr class="total"><td>Balance due</td><td class="amount">{{.Due}}</td></tr>
+{{end}}</table>
+{{if .PointsEarned}}<p>Points earned: {{.PointsEarned}}</p>
+{{end}}<footer>
+{{range .Footer}}<div>{{.}}</div>
+{{end}}</footer>
+</body>
+</html>
+`))
+
+// WriteText prints r as plain text for a receipt printer.
+func (r Receipt) WriteText(w io.Writer) error {
+	return text.Execute(w, r)
+}
+
+// WriteHTML prints r as an HTML page.
+func (r Receipt) WriteHTML(w io.Writer) error {
+	return html.Execute(w, r)
+}
+
+// WritePDF prints r as a PDF document of one page the size of the slip a
+// receipt printer would give.
+func (r Receipt) WritePDF(w io.Writer) error {
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		return err
+	}
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	return writePDF(w, lines)
+}
+
+// trunc cuts s down to the width of a text Receipt.
+func trunc(s string) string {
+	if r := []rune(s); len(r) > width {
+		return string(r[:width])
+	}
+	return s
+}
@@ -0,0 +1,111 @@
+package register
+
+import (
+	"time"
+
+	"github.com/vikramcse/the-service/internal/money"
+)
+
+// Statuses of a Session.
+const (
+	Open   = "open"
+	Closed = "closed"
+)
+
+// Kinds of CashMovement.
+const (
+	CashIn  = "in"
+	CashOut = "out"
+)
+
+// Register is a till at a shop. Sales rung up on it are in its Currency.
+type Register struct {
+	ID          string    `db:"register_id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	LocationID  string    `db:"location_id" json:"location_id"`
+	Currency    string    `db:"currency" json:"currency"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
+
+// NewRegister is what we require from clients when adding a Register. An
+// empty LocationID puts it at the default inventory location and an empty
+// Currency gives it the default currency of products.
+type NewRegister struct {
+	Name       string `json:"name"`
+	LocationID string `json:"location_id"`
+	Currency   string `json:"currency"`
+}
+
+// Session is a Register being open for a shift, from when its drawer is
+// filled with OpeningFloat until the cash in it is counted at closing. A
+// Register has at most one open Session.
+type Session struct {
+	ID           string      `db:"session_id" json:"id"`
+	RegisterID   string      `db:"register_id" json:"register_id"`
+	Status       string      `db:"status" json:"status"`
+	OpeningFloat money.Money `db:"opening_float" json:"opening_float"`
+	Notes        string      `db:"notes" json:"notes,omitempty"`
+	OpenedAt     time.Time   `db:"opened_at" json:"opened_at"`
+	ClosedAt     *time.Time  `db:"closed_at" json:"closed_at,omitempty"`
+}
+
+// NewSession is what we require from clients to open a Register. OpeningFloat
+// is in minor units of the currency of the Register.
+type NewSession struct {
+	OpeningFloat int `json:"opening_float"`
+}
+
+// CashMovement is cash put into or taken out of the drawer of a Register
+// other than for a sale, such as change brought from the bank or takings
+// taken to the safe.
+type CashMovement struct {
+	ID          string      `db:"movement_id" json:"id"`
+	SessionID   string      `db:"session_id" json:"session_id"`
+	Kind        string      `db:"kind" json:"kind"`
+	Amount      money.Money `db:"amount" json:"amount"`
+	Reason      string      `db:"reason" json:"reason,omitempty"`
+	DateCreated time.Time   `db:"date_created" json:"date_created"`
+}
+
+// NewCashMovement is what we require from clients to move cash in or out of
+// a drawer. Amount is in minor units of the currency of the Register.
+type NewCashMovement struct {
+	Kind   string `json:"kind"`
+	Amount int    `json:"amount"`
+	Reason string `json:"reason"`
+}
+
+// Closing is what we require from clients to close a Session. Counted is what
+// was counted for each tender, in minor units; cash must be counted, other
+// tenders such as the card terminal total are optional.
+type Closing struct {
+	Counted map[string]int `json:"counted"`
+	Notes   string         `json:"notes"`
+}
+
+// Report sums up a Session. Once the Session is closed it is the Z report of
+// the shift, with what was expected in each tender fixed at closing; while it
+// is open it shows the takings so far and nothing is counted.
+type Report struct {
+	Session    Session       `json:"session"`
+	Register   string        `json:"register"`
+	LocationID string        `json:"location_id"`
+	Sales      int           `json:"sales"`
+	Units      int           `json:"units"`
+	Takings    money.Money   `json:"takings"`
+	CashIn     money.Money   `json:"cash_in"`
+	CashOut    money.Money   `json:"cash_out"`
+	Tenders    []TenderCount `json:"tenders"`
+}
+
+// TenderCount compares what a tender should hold at the end of a Session with
+// what was counted. Expected cash includes the opening float and cash moved
+// in or out. Variance is Counted less Expected, so a short drawer has a
+// negative Variance. Counted and Variance are missing for tenders that were
+// not counted.
+type TenderCount struct {
+	Tender   string       `db:"tender" json:"tender"`
+	Expected money.Money  `db:"expected" json:"expected"`
+	Counted  *money.Money `db:"-" json:"counted,omitempty"`
+	Variance *money.Money `db:"-" json:"variance,omitempty"`
+}
@@ -0,0 +1,573 @@
+// Package register keeps the tills of our shops and the shifts they are open
+// for, and balances the cash in them at the end of each shift.
+package register
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/inventory"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/payment"
+	"github.com/vikramcse/the-service/internal/platform/database"
+	"github.com/vikramcse/the-service/internal/product"
+)
+
+var (
+	ErrNotFound        = errors.New("Register not found")
+	ErrSessionNotFound = errors.New("Session not found")
+	ErrInvalidID       = errors.New("ID is not in it's proper form")
+	ErrNoName          = errors.New("Register must have a name")
+	ErrInvalidAmount   = errors.New("Amount must not be negative")
+	ErrInvalidKind     = errors.New("Cash movement must be in or out and of a positive amount")
+	ErrInvalidTender   = errors.New("Counted tender must be cash, card or gift_card")
+	ErrNoCashCount     = errors.New("Cash must be counted to close a session")
+	ErrAlreadyOpen     = errors.New("Register already has an open session")
+	ErrNotOpen         = errors.New("Session is not open")
+	ErrNotEnoughCash   = errors.New("Drawer does not hold that much cash")
+	ErrWrongLocation   = errors.New("Sale must be made where the register is")
+)
+
+// Entity types changes to Registers are audited as.
+const (
+	auditRegister     = "register"
+	auditSession      = "register_session"
+	auditCashMovement = "cash_movement"
+)
+
+// Tenders are what a Session is balanced by, in the order they are reported.
+var Tenders = []string{payment.Cash, payment.Card, payment.GiftCard}
+
+// CreateRegister adds a Register to a shop.
+func CreateRegister(ctx context.Context, db *sqlx.DB, nr NewRegister, now time.Time) (*Register, error) {
+	name := strings.TrimSpace(nr.Name)
+	if name == "" {
+		return nil, ErrNoName
+	}
+
+	if nr.LocationID == "" {
+		nr.LocationID = inventory.DefaultLocation
+	}
+	if err := inventory.CheckLocation(ctx, db, nr.LocationID); err != nil {
+		return nil, err
+	}
+
+	if nr.Currency == "" {
+		nr.Currency = product.DefaultCurrency
+	}
+	currency, err := money.Currency(nr.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	r := Register{
+		ID:          uuid.New().String(),
+		Name:        name,
+		LocationID:  nr.LocationID,
+		Currency:    currency,
+		DateCreated: now.UTC(),
+	}
+
+	const q = `
+		INSERT INTO registers
+		(register_id, name, location_id, currency, date_created)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	err = database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, q, r.ID, r.Name, r.LocationID, r.Currency, r.DateCreated); err != nil {
+			return errors.Wrap(err, "inserting register")
+		}
+
+		c := audit.Change{EntityType: auditRegister, EntityID: r.ID, Action: audit.Create, After: r}
+		_, err := audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// ListRegisters gets all Registers.
+func ListRegisters(ctx context.Context, db *sqlx.DB) ([]Register, error) {
+	registers := []Register{}
+
+	const q = `SELECT * FROM registers ORDER BY name`
+	if err := db.SelectContext(ctx, &registers, q); err != nil {
+		return nil, errors.Wrap(err, "selecting registers")
+	}
+
+	return registers, nil
+}
+
+// RetriveRegister finds the Register identified by id.
+func RetriveRegister(ctx context.Context, db *sqlx.DB, id string) (*Register, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	return retriveRegister(ctx, db, id)
+}
+
+// OpenSession opens a Register for a shift with the float in its drawer.
+func OpenSession(ctx context.Context, db *sqlx.DB, registerID string, ns NewSession, now time.Time) (*Session, error) {
+	r, err := RetriveRegister(ctx, db, registerID)
+	if err != nil {
+		return nil, err
+	}
+	if ns.OpeningFloat < 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	s := Session{
+		ID:           uuid.New().String(),
+		RegisterID:   r.ID,
+		Status:       Open,
+		OpeningFloat: money.Money{Amount: ns.OpeningFloat, Currency: r.Currency},
+		OpenedAt:     now.UTC(),
+	}
+
+	const q = `
+		INSERT INTO register_sessions
+		(session_id, register_id, status, opening_float, opened_at)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	err = database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, q, s.ID, s.RegisterID, s.Status, s.OpeningFloat.Amount, s.OpenedAt)
+		if err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				return ErrAlreadyOpen
+			}
+			return errors.Wrap(err, "inserting session")
+		}
+
+		c := audit.Change{EntityType: auditSession, EntityID: s.ID, Action: audit.Create, After: s}
+		_, err = audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// ListSessions gets every Session of a Register, latest first.
+func ListSessions(ctx context.Context, db *sqlx.DB, registerID string) ([]Session, error) {
+	if _, err := RetriveRegister(ctx, db, registerID); err != nil {
+		return nil, err
+	}
+
+	sessions := []Session{}
+	const q = `SELECT ` + sessionColumns + ` WHERE s.register_id = $1 ORDER BY s.opened_at DESC`
+	if err := db.SelectContext(ctx, &sessions, q, registerID); err != nil {
+		return nil, errors.Wrap(err, "selecting sessions")
+	}
+
+	return sessions, nil
+}
+
+// RetriveSession finds the Session identified by id.
+func RetriveSession(ctx context.Context, db *sqlx.DB, id string) (*Session, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	return retriveSession(ctx, db, id, "")
+}
+
+// MoveCash records cash put into or taken out of the drawer of an open
+// Session. More cash than the drawer should hold can not be taken out.
+func MoveCash(ctx context.Context, db *sqlx.DB, sessionID string, nm NewCashMovement, now time.Time) (*CashMovement, error) {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if (nm.Kind != CashIn && nm.Kind != CashOut) || nm.Amount <= 0 {
+		return nil, ErrInvalidKind
+	}
+
+	var m CashMovement
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		s, err := retriveSession(ctx, tx, sessionID, "FOR UPDATE")
+		if err != nil {
+			return err
+		}
+		if s.Status != Open {
+			return ErrNotOpen
+		}
+
+		if nm.Kind == CashOut {
+			counts, err := expected(ctx, tx, s)
+			if err != nil {
+				return err
+			}
+			if counts[0].Expected.Amount < nm.Amount {
+				return ErrNotEnoughCash
+			}
+		}
+
+		m = CashMovement{
+			ID:          uuid.New().String(),
+			SessionID:   s.ID,
+			Kind:        nm.Kind,
+			Amount:      money.Money{Amount: nm.Amount, Currency: s.OpeningFloat.Currency},
+			Reason:      strings.TrimSpace(nm.Reason),
+			DateCreated: now.UTC(),
+		}
+
+		const q = `
+			INSERT INTO cash_movements
+			(movement_id, session_id, kind, amount, reason, date_created)
+			VALUES ($1, $2, $3, $4, $5, $6)`
+
+		if _, err := tx.ExecContext(ctx, q, m.ID, m.SessionID, m.Kind, m.Amount.Amount, m.Reason, m.DateCreated); err != nil {
+			return errors.Wrap(err, "inserting cash movement")
+		}
+
+		c := audit.Change{EntityType: auditCashMovement, EntityID: m.ID, Action: audit.Create, After: m}
+		_, err = audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// ListCash gets the cash moved in and out of the drawer in a Session.
+func ListCash(ctx context.Context, db *sqlx.DB, sessionID string) ([]CashMovement, error) {
+	s, err := RetriveSession(ctx, db, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	movements := []CashMovement{}
+	const q = `
+		SELECT movement_id, session_id, kind, amount as "amount.amount", $2::text as "amount.currency",
+		reason, date_created
+		FROM cash_movements WHERE session_id = $1
+		ORDER BY date_created`
+
+	if err := db.SelectContext(ctx, &movements, q, s.ID, s.OpeningFloat.Currency); err != nil {
+		return nil, errors.Wrap(err, "selecting cash movements")
+	}
+
+	return movements, nil
+}
+
+// CloseSession closes an open Session with what was counted in each tender.
+// What each tender should hold is worked out and kept with the counts, so
+// the Z report of the Session does not change when its sales are refunded
+// later.
+func CloseSession(ctx context.Context, db *sqlx.DB, sessionID string, c Closing, now time.Time) (*Report, error) {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if _, ok := c.Counted[payment.Cash]; !ok {
+		return nil, ErrNoCashCount
+	}
+	for tender, n := range c.Counted {
+		if !known(tender) {
+			return nil, ErrInvalidTender
+		}
+		if n < 0 {
+			return nil, ErrInvalidAmount
+		}
+	}
+
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+
+		// Locking the Session waits for sales being rung up in it to finish.
+		s, err := retriveSession(ctx, tx, sessionID, "FOR UPDATE")
+		if err != nil {
+			return err
+		}
+		if s.Status != Open {
+			return ErrNotOpen
+		}
+
+		counts, err := expected(ctx, tx, s)
+		if err != nil {
+			return err
+		}
+
+		const ins = `
+			INSERT INTO session_counts (session_id, tender, expected, counted)
+			VALUES ($1, $2, $3, $4)`
+
+		for i, tc := range counts {
+			var counted *int
+			if n, ok := c.Counted[tc.Tender]; ok {
+				counted = &n
+				counts[i].Counted = &money.Money{Amount: n, Currency: tc.Expected.Currency}
+			}
+			if _, err := tx.ExecContext(ctx, ins, s.ID, tc.Tender, tc.Expected.Amount, counted); err != nil {
+				return errors.Wrap(err, "inserting session count")
+			}
+		}
+
+		closed := *s
+		closed.Status, closed.Notes = Closed, strings.TrimSpace(c.Notes)
+		closedAt := now.UTC()
+		closed.ClosedAt = &closedAt
+
+		const q = `
+			UPDATE register_sessions SET status = $2, notes = $3, closed_at = $4
+			WHERE session_id = $1`
+
+		if _, err := tx.ExecContext(ctx, q, closed.ID, closed.Status, closed.Notes, closed.ClosedAt); err != nil {
+			return errors.Wrap(err, "closing session")
+		}
+
+		after := struct {
+			Session
+			Counts []TenderCount `json:"counts"`
+		}{closed, counts}
+		ac := audit.Change{EntityType: auditSession, EntityID: s.ID, Action: audit.Update, Before: s, After: after}
+		_, err = audit.Record(ctx, tx, ac, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ReportOf(ctx, db, sessionID)
+}
+
+// ReportOf gets the Report of a Session.
+func ReportOf(ctx context.Context, db *sqlx.DB, sessionID string) (*Report, error) {
+	s, err := RetriveSession(ctx, db, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	r, err := retriveRegister(ctx, db, s.RegisterID)
+	if err != nil {
+		return nil, err
+	}
+	currency := r.Currency
+
+	rep := Report{
+		Session:    *s,
+		Register:   r.Name,
+		LocationID: r.LocationID,
+		Takings:    money.Money{Currency: currency},
+		CashIn:     money.Money{Currency: currency},
+		CashOut:    money.Money{Currency: currency},
+	}
+
+	var sales struct {
+		Sales   int `db:"sales"`
+		Units   int `db:"units"`
+		Takings int `db:"takings"`
+	}
+	const qs = `
+		SELECT COUNT(*) as sales, COALESCE(SUM(quantity), 0) as units, COALESCE(SUM(paid), 0) as takings
+		FROM sales WHERE session_id = $1`
+
+	if err := db.GetContext(ctx, &sales, qs, s.ID); err != nil {
+		return nil, errors.Wrap(err, "selecting session sales")
+	}
+	rep.Sales, rep.Units, rep.Takings.Amount = sales.Sales, sales.Units, sales.Takings
+
+	const qc = `
+		SELECT
+			COALESCE(SUM(CASE WHEN kind = 'in' THEN amount END), 0),
+			COALESCE(SUM(CASE WHEN kind = 'out' THEN amount END), 0)
+		FROM cash_movements WHERE session_id = $1`
+
+	if err := db.QueryRowxContext(ctx, qc, s.ID).Scan(&rep.CashIn.Amount, &rep.CashOut.Amount); err != nil {
+		return nil, errors.Wrap(err, "selecting session cash movements")
+	}
+
+	if s.Status == Open {
+		if rep.Tenders, err = expected(ctx, db, s); err != nil {
+			return nil, err
+		}
+		return &rep, nil
+	}
+
+	var rows []struct {
+		Tender   string        `db:"tender"`
+		Expected int           `db:"expected"`
+		Counted  sql.NullInt64 `db:"counted"`
+	}
+	const qt = `SELECT tender, expected, counted FROM session_counts WHERE session_id = $1`
+	if err := db.SelectContext(ctx, &rows, qt, s.ID); err != nil {
+		return nil, errors.Wrap(err, "selecting session counts")
+	}
+
+	for _, tender := range Tenders {
+		for _, row := range rows {
+			if row.Tender != tender {
+				continue
+			}
+
+			tc := TenderCount{Tender: tender, Expected: money.Money{Amount: row.Expected, Currency: currency}}
+			if row.Counted.Valid {
+				counted := money.Money{Amount: int(row.Counted.Int64), Currency: currency}
+				variance := money.Money{Amount: counted.Amount - row.Expected, Currency: currency}
+				tc.Counted, tc.Variance = &counted, &variance
+			}
+			rep.Tenders = append(rep.Tenders, tc)
+		}
+	}
+
+	return &rep, nil
+}
+
+// SaleHook rings Sales up in the open Session they give. The Session is
+// shared locked until the Sale is stored, so it can not be closed with a
+// Sale half made. A Sale made without saying where is made where the
+// Register is, and it must be in the currency of the Register. It has to
+// run before any hook that taxes Sales by location.
+func SaleHook() product.SaleHook {
+	return product.SaleHook{Before: ringUp}
+}
+
+// ringUp links a Sale to its Session.
+func ringUp(ctx context.Context, tx *sqlx.Tx, s *product.Sale, ns product.NewSale) error {
+	if ns.SessionID == "" {
+		return nil
+	}
+	if _, err := uuid.Parse(ns.SessionID); err != nil {
+		return ErrInvalidID
+	}
+
+	ses, err := retriveSession(ctx, tx, ns.SessionID, "FOR SHARE")
+	if err != nil {
+		return err
+	}
+	if ses.Status != Open {
+		return ErrNotOpen
+	}
+	r, err := retriveRegister(ctx, tx, ses.RegisterID)
+	if err != nil {
+		return err
+	}
+
+	if ns.LocationID == "" {
+		s.LocationID = r.LocationID
+	}
+	if s.LocationID != r.LocationID {
+		return ErrWrongLocation
+	}
+	if s.Paid.Currency != r.Currency {
+		return money.ErrMismatch
+	}
+
+	s.SessionID = &ses.ID
+	return nil
+}
+
+// expected works out what each tender of an open Session should hold: the
+// float and cash moved in or out of the drawer, and what was taken in each
+// tender for its sales less what has been refunded.
+func expected(ctx context.Context, db sqlx.QueryerContext, s *Session) ([]TenderCount, error) {
+	amounts := map[string]int{}
+
+	var rows []struct {
+		Tender string `db:"tender"`
+		Total  int    `db:"total"`
+	}
+	const qp = `
+		SELECT p.tender, SUM(p.amount - p.change - p.refunded) as total
+		FROM payments as p
+		JOIN sales as s ON s.sale_id = p.sale_id
+		WHERE s.session_id = $1 AND p.status IN ('captured', 'refunded')
+		GROUP BY p.tender`
+
+	if err := sqlx.SelectContext(ctx, db, &rows, qp, s.ID); err != nil {
+		return nil, errors.Wrap(err, "selecting session payments")
+	}
+	for _, row := range rows {
+		amounts[row.Tender] += row.Total
+	}
+
+	// Gift cards can also be taken with the sale itself rather than as a
+	// payment of it.
+	var giftCards, cash int
+	const qg = `SELECT COALESCE(SUM(gift_card_paid), 0) FROM sales WHERE session_id = $1`
+	if err := sqlx.GetContext(ctx, db, &giftCards, qg, s.ID); err != nil {
+		return nil, errors.Wrap(err, "selecting session gift cards")
+	}
+	amounts[payment.GiftCard] += giftCards
+
+	const qc = `
+		SELECT COALESCE(SUM(CASE WHEN kind = 'in' THEN amount ELSE -amount END), 0)
+		FROM cash_movements WHERE session_id = $1`
+
+	if err := sqlx.GetContext(ctx, db, &cash, qc, s.ID); err != nil {
+		return nil, errors.Wrap(err, "selecting session cash movements")
+	}
+	amounts[payment.Cash] += s.OpeningFloat.Amount + cash
+
+	counts := make([]TenderCount, len(Tenders))
+	for i, tender := range Tenders {
+		counts[i] = TenderCount{
+			Tender:   tender,
+			Expected: money.Money{Amount: amounts[tender], Currency: s.OpeningFloat.Currency},
+		}
+	}
+
+	return counts, nil
+}
+
+// known reports if tender is one of Tenders.
+func known(tender string) bool {
+	for _, t := range Tenders {
+		if t == tender {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionColumns selects a Session with the currency of its Register.
+const sessionColumns = `
+		s.session_id, s.register_id, s.status,
+		s.opening_float as "opening_float.amount", r.currency as "opening_float.currency",
+		s.notes, s.opened_at, s.closed_at
+		FROM register_sessions as s
+		JOIN registers as r ON r.register_id = s.register_id`
+
+// retriveRegister loads a Register.
+func retriveRegister(ctx context.Context, db sqlx.QueryerContext, id string) (*Register, error) {
+	var r Register
+	const q = `SELECT * FROM registers WHERE register_id = $1`
+	if err := sqlx.GetContext(ctx, db, &r, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting register %q", id)
+	}
+
+	return &r, nil
+}
+
+// retriveSession loads a Session, locking its row with lock when it is not
+// empty.
+func retriveSession(ctx context.Context, db sqlx.QueryerContext, id, lock string) (*Session, error) {
+	q := `SELECT ` + sessionColumns + ` WHERE s.session_id = $1`
+	if lock != "" {
+		q += ` ` + lock + ` OF s`
+	}
+
+	var s Session
+	if err := sqlx.GetContext(ctx, db, &s, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSessionNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting session %q", id)
+	}
+
+	return &s, nil
+}
@@ -0,0 +1,148 @@
+package register_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/payment"
+	"github.com/vikramcse/the-service/internal/product"
+	"github.com/vikramcse/the-service/internal/register"
+	"github.com/vikramcse/the-service/internal/tests"
+)
+
+func TestWriteText(t *testing.T) {
+	usd := func(n int) *money.Money { return &money.Money{Amount: n, Currency: "USD"} }
+	closed := time.Date(2019, time.January, 1, 17, 30, 0, 0, time.UTC)
+
+	rep := register.Report{
+		Session: register.Session{
+			ID:           "8a1b2c3d-0000-0000-0000-000000000000",
+			Status:       register.Closed,
+			OpeningFloat: *usd(10000),
+			OpenedAt:     time.Date(2019, time.January, 1, 9, 0, 0, 0, time.UTC),
+			ClosedAt:     &closed,
+		},
+		Register: "Front Till",
+		Sales:    2,
+		Units:    3,
+		Takings:  *usd(5000),
+		CashIn:   *usd(0),
+		CashOut:  *usd(2000),
+		Tenders: []register.TenderCount{
+			{Tender: payment.Cash, Expected: *usd(10500), Counted: usd(10450), Variance: usd(-50)},
+			{Tender: payment.Card, Expected: *usd(2500)},
+		},
+	}
+
+	var b strings.Builder
+	if err := rep.WriteText(&b); err != nil {
+		t.Fatalf("writing report: %s", err)
+	}
+	text := b.String()
+
+	for _, want := range []string{
+		"Z REPORT",
+		"Front Till",
+		"Closed                  2019-01-01 17:30",
+		"Opening float                 100.00 USD",
+		"CASH\n",
+		"  Variance                     -0.50 USD",
+		"  Counted                              -",
+		"TOTAL VARIANCE                 -0.50 USD",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected report to contain %q:\n%s", want, text)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		if len(line) > 40 {
+			t.Errorf("line %q is wider than the printer", line)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 9, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	r, err := register.CreateRegister(ctx, db, register.NewRegister{Name: "Front Till"}, now)
+	if err != nil {
+		t.Fatalf("creating register: %s", err)
+	}
+	s, err := register.OpenSession(ctx, db, r.ID, register.NewSession{OpeningFloat: 10000}, now)
+	if err != nil {
+		t.Fatalf("opening session: %s", err)
+	}
+	if _, err := register.OpenSession(ctx, db, r.ID, register.NewSession{}, now); err != register.ErrAlreadyOpen {
+		t.Fatalf("expected %v, got %v", register.ErrAlreadyOpen, err)
+	}
+
+	p, err := product.Create(ctx, db, product.NewProduct{Name: "Comic Book", Cost: 2500, Quantity: 10}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+
+	// One sale paid in cash with change and one by card.
+	f := payment.NewFake()
+	tenders := [][]payment.NewTender{
+		{{Tender: payment.Cash, Amount: 3000}},
+		{{Tender: payment.Card, Amount: 2500, Token: "tok_visa"}},
+	}
+	for _, tt := range tenders {
+		ns := product.NewSale{SessionID: s.ID, Quantity: 1, Paid: &money.Money{Amount: 2500}}
+		sale, err := product.AddSale(ctx, db, ns, p.ID, now, register.SaleHook())
+		if err != nil {
+			t.Fatalf("adding sale: %s", err)
+		}
+		if sale.SessionID == nil || *sale.SessionID != s.ID {
+			t.Fatalf("expected sale in session %s, got %v", s.ID, sale.SessionID)
+		}
+		if _, err := payment.Pay(ctx, db, f, sale.ID, payment.NewPayment{Tenders: tt}, now); err != nil {
+			t.Fatalf("paying: %s", err)
+		}
+	}
+
+	if _, err := register.MoveCash(ctx, db, s.ID, register.NewCashMovement{Kind: register.CashOut, Amount: 20000}, now); err != register.ErrNotEnoughCash {
+		t.Fatalf("expected %v, got %v", register.ErrNotEnoughCash, err)
+	}
+	if _, err := register.MoveCash(ctx, db, s.ID, register.NewCashMovement{Kind: register.CashOut, Amount: 5000, Reason: "safe drop"}, now); err != nil {
+		t.Fatalf("moving cash: %s", err)
+	}
+
+	if _, err := register.CloseSession(ctx, db, s.ID, register.Closing{Counted: map[string]int{payment.Card: 2500}}, now); err != register.ErrNoCashCount {
+		t.Fatalf("expected %v, got %v", register.ErrNoCashCount, err)
+	}
+	c := register.Closing{Counted: map[string]int{payment.Cash: 7450, payment.Card: 2500}}
+	rep, err := register.CloseSession(ctx, db, s.ID, c, now.Add(8*time.Hour))
+	if err != nil {
+		t.Fatalf("closing session: %s", err)
+	}
+
+	if rep.Sales != 2 || rep.Takings.Amount != 5000 || rep.CashOut.Amount != 5000 {
+		t.Fatalf("unexpected totals in report: %+v", rep)
+	}
+	exp := map[string][2]int{payment.Cash: {7500, -50}, payment.Card: {2500, 0}}
+	for _, tc := range rep.Tenders {
+		want, ok := exp[tc.Tender]
+		if !ok {
+			if tc.Counted != nil {
+				t.Errorf("expected %s not to be counted", tc.Tender)
+			}
+			continue
+		}
+		if tc.Expected.Amount != want[0] || tc.Variance == nil || tc.Variance.Amount != want[1] {
+			t.Errorf("%s: expected %d with variance %d, got %+v", tc.Tender, want[0], want[1], tc)
+		}
+	}
+
+	ns := product.NewSale{SessionID: s.ID, Quantity: 1, Paid: &money.Money{Amount: 2500}}
+	if _, err := product.AddSale(ctx, db, ns, p.ID, now, register.SaleHook()); err != register.ErrNotOpen {
+		t.Fatalf("expected %v, got %v", register.ErrNotOpen, err)
+	}
+}
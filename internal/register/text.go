@@ -0,0 +1,95 @@
+package register
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/vikramcse/the-service/internal/money"
+)
+
+// width is the number of characters in a line of a printed Report, which
+// fits the receipt printers at our tills.
+const width = 40
+
+// WriteText prints r as plain text for a receipt printer. A closed Session
+// prints as a Z report and an open one as an X report of the shift so far.
+func (r Report) WriteText(w io.Writer) error {
+	b := bufio.NewWriter(w)
+
+	title := "Z REPORT"
+	if r.Session.Status == Open {
+		title = "X REPORT"
+	}
+	center(b, title)
+	center(b, r.Register)
+	rule(b)
+
+	const layout = "2006-01-02 15:04"
+	field(b, "Session", r.Session.ID[:8])
+	field(b, "Opened", r.Session.OpenedAt.Format(layout))
+	if r.Session.ClosedAt != nil {
+		field(b, "Closed", r.Session.ClosedAt.Format(layout))
+	}
+	rule(b)
+
+	field(b, "Sales", fmt.Sprint(r.Sales))
+	field(b, "Units", fmt.Sprint(r.Units))
+	field(b, "Takings", r.Takings.String())
+	field(b, "Opening float", r.Session.OpeningFloat.String())
+	field(b, "Cash in", r.CashIn.String())
+	field(b, "Cash out", r.CashOut.String())
+
+	var short money.Money
+	counted := false
+	for _, tc := range r.Tenders {
+		rule(b)
+		fmt.Fprintln(b, strings.ToUpper(strings.Replace(tc.Tender, "_", " ", -1)))
+		field(b, "  Expected", tc.Expected.String())
+		if tc.Counted == nil {
+			field(b, "  Counted", "-")
+			continue
+		}
+		field(b, "  Counted", tc.Counted.String())
+		field(b, "  Variance", tc.Variance.String())
+
+		short.Amount += tc.Variance.Amount
+		short.Currency = tc.Variance.Currency
+		counted = true
+	}
+
+	if counted {
+		rule(b)
+		field(b, "TOTAL VARIANCE", short.String())
+	}
+	if r.Session.Notes != "" {
+		rule(b)
+		fmt.Fprintln(b, r.Session.Notes)
+	}
+
+	return b.Flush()
+}
+
+// field prints a label on the left of a line and its value on the right.
+func field(w io.Writer, label, value string) {
+	pad := width - len(label) - len(value)
+	if pad < 1 {
+		pad = 1
+	}
+	fmt.Fprintf(w, "%s%s%s\n", label, strings.Repeat(" ", pad), value)
+}
+
+// center prints s in the middle of a line.
+func center(w io.Writer, s string) {
+	pad := (width - len(s)) / 2
+	if pad < 0 {
+		pad = 0
+	}
+	fmt.Fprintf(w, "%s%s\n", strings.Repeat(" ", pad), s)
+}
+
+// rule prints a line across.
+func rule(w io.Writer) {
+	fmt.Fprintln(w, strings.Repeat("-", width))
+}
@@ -0,0 +1,101 @@
+package report
+
+import (
+	"time"
+)
+
+// Bucket sizes sales can be grouped by.
+const (
+	Hour  = "hour"
+	Day   = "day"
+	Week  = "week"
+	Month = "month"
+)
+
+// Measures top products can be ranked by.
+const (
+	ByRevenue = "revenue"
+	ByUnits   = "units"
+)
+
+// SalesQuery describes which sales make up a SalesReport and how they are
+// grouped. Zero From and To values leave that end of the range open and an
+// empty ProductID reports on every product. Revenue is reported in Currency,
+// converted at the exchange rates in effect at RatesAt. Currency may only be
+// left empty when every sale was paid in the same currency.
+type SalesQuery struct {
+	Bucket    string
+	Location  *time.Location
+	ProductID string
+	From      time.Time
+	To        time.Time
+	Top       int
+	TopBy     string
+	Currency  string
+	RatesAt   time.Time
+}
+
+// SalesBucket holds the sales totals for one period. Start is the beginning of
+// the period in the time zone the report was requested in. AvgDiscount is the
+// average amount each sale was paid below its list price.
+type SalesBucket struct {
+	Start       time.Time `db:"bucket" json:"start"`
+	Units       int       `db:"units" json:"units"`
+	Revenue     int       `db:"revenue" json:"revenue"`
+	Orders      int       `db:"orders" json:"orders"`
+	AvgDiscount float64   `db:"avg_discount" json:"avg_discount"`
+}
+
+// TopProduct is a product ranked by its sales over the reported range.
+type TopProduct struct {
+	ProductID string `db:"product_id" json:"product_id"`
+	Name      string `db:"name" json:"name"`
+	Units     int    `db:"units" json:"units"`
+	Revenue   int    `db:"revenue" json:"revenue"`
+}
+
+// SalesReport is the result of running a SalesQuery.
+type SalesReport struct {
+	Bucket   string        `json:"bucket"`
+	TimeZone string        `json:"time_zone"`
+	Currency string        `json:"currency,omitempty"`
+	Buckets  []SalesBucket `json:"buckets"`
+	Top      []TopProduct  `json:"top"`
+}
+
+// TaxQuery describes which sales make up a TaxReport. Zero From and To values
+// leave that end of the range open and an empty LocationID reports on every
+// location.
+type TaxQuery struct {
+	LocationID string
+	From       time.Time
+	To         time.Time
+}
+
+// TaxLine totals the sales taxed at one rate for a tax class at a location
+// and paid in one currency.
+type TaxLine struct {
+	LocationID string `db:"location_id" json:"location_id"`
+	TaxClass   string `db:"tax_class" json:"tax_class"`
+	TaxRate    int    `db:"tax_rate" json:"tax_rate"`
+	Inclusive  bool   `db:"tax_inclusive" json:"inclusive"`
+	Currency   string `db:"currency" json:"currency"`
+	Sales      int    `db:"sales" json:"sales"`
+	Net        int    `db:"net" json:"net"`
+	Tax        int    `db:"tax" json:"tax"`
+	Gross      int    `db:"gross" json:"gross"`
+}
+
+// TaxTotal sums the TaxLines in one currency.
+type TaxTotal struct {
+	Currency string `json:"currency"`
+	Net      int    `json:"net"`
+	Tax      int    `json:"tax"`
+	Gross    int    `json:"gross"`
+}
+
+// TaxReport is the result of running a TaxQuery.
+type TaxReport struct {
+	Lines  []TaxLine  `json:"lines"`
+	Totals []TaxTotal `json:"totals"`
+}
@@ -0,0 +1,314 @@
+package report
+
+import (
+	"context"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/exchange"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/period"
+)
+
+var (
+	ErrInvalidBucket = errors.New("bucket must be one of hour, day, week or month")
+	ErrInvalidTopBy  = errors.New("top products can only be ranked by revenue or units")
+	ErrInvalidID     = errors.New("ID is not in it's proper form")
+	ErrMixedCurrency = errors.New("sales were paid in more than one currency so a reporting currency must be given")
+)
+
+// Sales builds a SalesReport of the sales matching sq. Sales are bucketed by
+// their time in sq.Location so a day runs from local midnight to midnight.
+// Without sq.Currency the sales must all have been paid in one currency,
+// which the report is then in.
+func Sales(ctx context.Context, db *sqlx.DB, sq SalesQuery) (*SalesReport, error) {
+	switch sq.Bucket {
+	case Hour, Day, Week, Month:
+	default:
+		return nil, ErrInvalidBucket
+	}
+
+	var byUnits bool
+	switch sq.TopBy {
+	case ByRevenue, "":
+	case ByUnits:
+		byUnits = true
+	default:
+		return nil, ErrInvalidTopBy
+	}
+
+	loc := sq.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	args, err := filterArgs(sq)
+	if err != nil {
+		return nil, err
+	}
+
+	var currency string
+	if sq.Currency != "" {
+		if currency, err = money.Currency(sq.Currency); err != nil {
+			return nil, err
+		}
+	}
+
+	// Amounts are totalled per currency and merged once converted.
+	var rows []struct {
+		Start    time.Time `db:"bucket"`
+		Currency string    `db:"currency"`
+		Units    int       `db:"units"`
+		Revenue  int       `db:"revenue"`
+		Orders   int       `db:"orders"`
+		Discount int       `db:"discount"`
+	}
+
+	q := `
+		SELECT
+			date_trunc($4, s.date_created AT TIME ZONE 'UTC' AT TIME ZONE $5) as bucket,
+			s.currency,
+			SUM(s.quantity) as units,
+			SUM(s.paid) as revenue,
+			COUNT(*) as orders,
+			COALESCE(SUM(s.quantity * s.list_price - CASE WHEN s.tax_inclusive THEN s.paid ELSE s.net END), 0) as discount
+		FROM sales as s
+		` + filter + `
+		GROUP BY bucket, s.currency
+		ORDER BY bucket, s.currency`
+
+	if err := db.SelectContext(ctx, &rows, q, append(args, sq.Bucket, loc.String())...); err != nil {
+		return nil, errors.Wrap(err, "selecting sales buckets")
+	}
+
+	if currency == "" {
+		for _, row := range rows {
+			if currency != "" && row.Currency != currency {
+				return nil, ErrMixedCurrency
+			}
+			currency = row.Currency
+		}
+	}
+
+	conv := converter{db: db, to: currency, at: sq.RatesAt, rates: make(map[string]*big.Rat)}
+
+	buckets := []SalesBucket{}
+	discounts := []int{}
+	for _, row := range rows {
+		revenue, err := conv.convert(ctx, row.Revenue, row.Currency)
+		if err != nil {
+			return nil, err
+		}
+		discount, err := conv.convert(ctx, row.Discount, row.Currency)
+		if err != nil {
+			return nil, err
+		}
+
+		// Postgres hands back the local wall clock time of each bucket
+		// without a zone, so put the zone back on.
+		s := row.Start
+		start := time.Date(s.Year(), s.Month(), s.Day(), s.Hour(), s.Minute(), s.Second(), s.Nanosecond(), loc)
+
+		n := len(buckets)
+		if n == 0 || !buckets[n-1].Start.Equal(start) {
+			buckets = append(buckets, SalesBucket{Start: start})
+			discounts = append(discounts, 0)
+			n++
+		}
+		buckets[n-1].Units += row.Units
+		buckets[n-1].Revenue += revenue
+		buckets[n-1].Orders += row.Orders
+		discounts[n-1] += discount
+	}
+	for i := range buckets {
+		buckets[i].AvgDiscount = float64(discounts[i]) / float64(buckets[i].Orders)
+	}
+
+	top := []TopProduct{}
+	if sq.Top > 0 {
+		var rows []struct {
+			TopProduct
+			Currency string `db:"currency"`
+		}
+
+		q := `
+		SELECT
+			s.product_id,
+			p.name,
+			s.currency,
+			SUM(s.quantity) as units,
+			SUM(s.paid) as revenue
+		FROM sales as s
+		JOIN products as p ON(p.product_id=s.product_id)
+		` + filter + `
+		GROUP BY s.product_id, p.name, s.currency`
+
+		if err := db.SelectContext(ctx, &rows, q, args...); err != nil {
+			return nil, errors.Wrap(err, "selecting top products")
+		}
+
+		index := make(map[string]int)
+		for _, row := range rows {
+			revenue, err := conv.convert(ctx, row.Revenue, row.Currency)
+			if err != nil {
+				return nil, err
+			}
+
+			i, ok := index[row.ProductID]
+			if !ok {
+				i = len(top)
+				index[row.ProductID] = i
+				top = append(top, TopProduct{ProductID: row.ProductID, Name: row.Name})
+			}
+			top[i].Units += row.Units
+			top[i].Revenue += revenue
+		}
+
+		sort.Slice(top, func(i, j int) bool {
+			a, b := top[i], top[j]
+			if byUnits && a.Units != b.Units {
+				return a.Units > b.Units
+			}
+			if a.Revenue != b.Revenue {
+				return a.Revenue > b.Revenue
+			}
+			if a.Units != b.Units {
+				return a.Units > b.Units
+			}
+			return a.ProductID < b.ProductID
+		})
+		if len(top) > sq.Top {
+			top = top[:sq.Top]
+		}
+	}
+
+	r := SalesReport{
+		Bucket:   sq.Bucket,
+		TimeZone: loc.String(),
+		Currency: currency,
+		Buckets:  buckets,
+		Top:      top,
+	}
+
+	return &r, nil
+}
+
+// converter changes amounts into the reporting currency to at the rates in
+// effect at time at. Rates are looked up once per currency and amounts
+// already in the reporting currency are left as they are.
+type converter struct {
+	db    *sqlx.DB
+	to    string
+	at    time.Time
+	rates map[string]*big.Rat
+}
+
+// convert changes amount minor units of currency into the reporting currency.
+func (c converter) convert(ctx context.Context, amount int, currency string) (int, error) {
+	if currency == c.to {
+		return amount, nil
+	}
+
+	rate, ok := c.rates[currency]
+	if !ok {
+		var err error
+		if rate, err = exchange.RateAt(ctx, c.db, currency, c.to, c.at); err != nil {
+			return 0, err
+		}
+		c.rates[currency] = rate
+	}
+
+	m, err := money.Convert(money.Money{Amount: amount, Currency: currency}, rate, c.to)
+	if err != nil {
+		return 0, err
+	}
+	return m.Amount, nil
+}
+
+// filter restricts sales to those matching the first three arguments returned
+// by filterArgs.
+const filter = `
+		WHERE ($1::uuid IS NULL OR s.product_id = $1)
+		AND ($2::timestamp IS NULL OR s.date_created >= $2)
+		AND ($3::timestamp IS NULL OR s.date_created < $3)`
+
+// filterArgs turns the range and product of sq into arguments for filter.
+func filterArgs(sq SalesQuery) ([]interface{}, error) {
+	var productID interface{}
+	if sq.ProductID != "" {
+		if _, err := uuid.Parse(sq.ProductID); err != nil {
+			return nil, ErrInvalidID
+		}
+		productID = sq.ProductID
+	}
+
+	args, err := period.Args(sq.From, sq.To)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]interface{}{productID}, args...), nil
+}
+
+// Tax builds a TaxReport of the sales matching tq, totalled per location,
+// tax class, rate and currency. Amounts are never converted so the report
+// holds the tax as it was charged.
+func Tax(ctx context.Context, db *sqlx.DB, tq TaxQuery) (*TaxReport, error) {
+	var locationID interface{}
+	if tq.LocationID != "" {
+		if _, err := uuid.Parse(tq.LocationID); err != nil {
+			return nil, ErrInvalidID
+		}
+		locationID = tq.LocationID
+	}
+
+	args, err := period.Args(tq.From, tq.To)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []TaxLine{}
+
+	const q = `
+		SELECT
+			s.location_id,
+			s.tax_class,
+			s.tax_rate,
+			s.tax_inclusive,
+			s.currency,
+			COUNT(*) as sales,
+			SUM(s.net) as net,
+			SUM(s.tax) as tax,
+			SUM(s.gross) as gross
+		FROM sales as s
+		WHERE ($1::uuid IS NULL OR s.location_id = $1)
+		AND ($2::timestamp IS NULL OR s.date_created >= $2)
+		AND ($3::timestamp IS NULL OR s.date_created < $3)
+		GROUP BY s.location_id, s.tax_class, s.tax_rate, s.tax_inclusive, s.currency
+		ORDER BY s.location_id, s.tax_class, s.tax_rate, s.currency`
+
+	if err := db.SelectContext(ctx, &lines, q, append([]interface{}{locationID}, args...)...); err != nil {
+		return nil, errors.Wrap(err, "selecting tax totals")
+	}
+
+	// Lines are totalled per currency in order of first appearance.
+	r := TaxReport{Lines: lines, Totals: []TaxTotal{}}
+	for _, l := range lines {
+		i := 0
+		for i < len(r.Totals) && r.Totals[i].Currency != l.Currency {
+			i++
+		}
+		if i == len(r.Totals) {
+			r.Totals = append(r.Totals, TaxTotal{Currency: l.Currency})
+		}
+		r.Totals[i].Net += l.Net
+		r.Totals[i].Tax += l.Tax
+		r.Totals[i].Gross += l.Gross
+	}
+
+	return &r, nil
+}
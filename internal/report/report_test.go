@@ -0,0 +1,72 @@
+package report_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vikramcse/the-service/internal/platform/period"
+	"github.com/vikramcse/the-service/internal/report"
+	"github.com/vikramcse/the-service/internal/schema"
+	"github.com/vikramcse/the-service/internal/tests"
+)
+
+func TestSales(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	if err := schema.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	r, err := report.Sales(ctx, db, report.SalesQuery{Bucket: report.Day, Top: 1})
+	if err != nil {
+		t.Fatalf("building report: %s", err)
+	}
+	if exp, got := 1, len(r.Buckets); exp != got {
+		t.Fatalf("expected %v buckets, got %v", exp, got)
+	}
+	b := r.Buckets[0]
+	if exp, got := 10, b.Units; exp != got {
+		t.Fatalf("expected %v units, got %v", exp, got)
+	}
+	if exp, got := 3, b.Orders; exp != got {
+		t.Fatalf("expected %v orders, got %v", exp, got)
+	}
+	if exp, got := 575, b.Revenue; exp != got {
+		t.Fatalf("expected revenue %v, got %v", exp, got)
+	}
+	if exp, got := 1, len(r.Top); exp != got {
+		t.Fatalf("expected %v top products, got %v", exp, got)
+	}
+	if exp, got := "Comic Books", r.Top[0].Name; exp != got {
+		t.Fatalf("expected top product %q, got %q", exp, got)
+	}
+
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = report.Sales(ctx, db, report.SalesQuery{Bucket: report.Day, Location: loc})
+	if err != nil {
+		t.Fatalf("building report: %s", err)
+	}
+	exp := time.Date(2018, time.December, 31, 0, 0, 0, 0, loc)
+	if got := r.Buckets[0].Start; !got.Equal(exp) {
+		t.Fatalf("expected bucket to start at %v, got %v", exp, got)
+	}
+
+	if _, err := report.Sales(ctx, db, report.SalesQuery{Bucket: "fortnight"}); err != report.ErrInvalidBucket {
+		t.Fatalf("expected %v, got %v", report.ErrInvalidBucket, err)
+	}
+
+	backwards := report.SalesQuery{Bucket: report.Day, From: exp, To: exp.AddDate(0, 0, -1)}
+	if _, err := report.Sales(ctx, db, backwards); err != period.ErrInvalidRange {
+		t.Fatalf("expected %v, got %v", period.ErrInvalidRange, err)
+	}
+	if _, err := report.Tax(ctx, db, report.TaxQuery{From: backwards.From, To: backwards.To}); err != period.ErrInvalidRange {
+		t.Fatalf("expected %v, got %v", period.ErrInvalidRange, err)
+	}
+}
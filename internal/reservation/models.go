@@ -0,0 +1,62 @@
+package reservation
+
+import (
+	"time"
+
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/product"
+)
+
+// Statuses of a Reservation. Only active reservations hold stock.
+const (
+	Active    = "active"
+	Confirmed = "confirmed"
+	Released  = "released"
+	Expired   = "expired"
+)
+
+// DefaultTTL is how long stock is held when the client does not say.
+const DefaultTTL = 15 * time.Minute
+
+// Reservation holds stock of a product or variant while a customer pays. It
+// either turns into a sale when confirmed or gives the stock back when it is
+// released or expires. Sale is the sale it was confirmed as, and is only
+// given when it is confirmed.
+type Reservation struct {
+	ID          string    `db:"reservation_id" json:"id"`
+	ProductID   string    `db:"product_id" json:"product_id"`
+	VariantID   *string   `db:"variant_id" json:"variant_id,omitempty"`
+	LocationID  string    `db:"location_id" json:"location_id"`
+	SaleID      *string   `db:"sale_id" json:"sale_id,omitempty"`
+	Quantity    int       `db:"quantity" json:"quantity"`
+	Status      string    `db:"status" json:"status"`
+	ExpiresAt   time.Time `db:"expires_at" json:"expires_at"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+
+	Sale *product.Sale `db:"-" json:"sale,omitempty"`
+}
+
+// NewReservation is what we require from clients to hold stock. TTL is the
+// number of seconds to hold it for.
+type NewReservation struct {
+	ProductID  string `json:"product_id"`
+	VariantID  string `json:"variant_id"`
+	LocationID string `json:"location_id"`
+	Quantity   int    `json:"quantity"`
+	TTL        int    `json:"ttl"`
+}
+
+// Confirmation is what we require from clients to turn a Reservation into a
+// sale. Everything in it is passed on to the sale.
+type Confirmation struct {
+	CustomerID string       `json:"customer_id"`
+	SessionID  string       `json:"session_id"`
+	Paid       *money.Money `json:"paid"`
+	Currency   string       `json:"currency"`
+	Coupon     string       `json:"coupon"`
+	Points     int          `json:"points"`
+
+	GiftCard       string `json:"gift_card"`
+	GiftCardAmount int    `json:"gift_card_amount"`
+}
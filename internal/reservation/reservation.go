@@ -0,0 +1,324 @@
+// Package reservation holds stock for carts and pending orders until they
+// are paid for.
+package reservation
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/inventory"
+	"github.com/vikramcse/the-service/internal/platform/database"
+	"github.com/vikramcse/the-service/internal/product"
+)
+
+var (
+	ErrNotFound          = errors.New("Reservation not found")
+	ErrInvalidID         = errors.New("ID is not in it's proper form")
+	ErrInvalidQuantity   = errors.New("quantity must be positive")
+	ErrInsufficientStock = errors.New("not enough stock available")
+	ErrNotActive         = errors.New("Reservation is no longer active")
+)
+
+// auditEntity is the entity type changes to Reservations are audited as.
+const auditEntity = "reservation"
+
+// Create holds stock for a NewReservation if enough of it is available, both
+// in all and at the Location it is held at.
+func Create(ctx context.Context, db *sqlx.DB, nr NewReservation, now time.Time) (*Reservation, error) {
+	if _, err := uuid.Parse(nr.ProductID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if nr.Quantity <= 0 {
+		return nil, ErrInvalidQuantity
+	}
+
+	ttl := DefaultTTL
+	if nr.TTL > 0 {
+		ttl = time.Duration(nr.TTL) * time.Second
+	}
+
+	r := Reservation{
+		ID:          uuid.New().String(),
+		ProductID:   nr.ProductID,
+		LocationID:  nr.LocationID,
+		Quantity:    nr.Quantity,
+		Status:      Active,
+		ExpiresAt:   now.Add(ttl).UTC(),
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+	if nr.VariantID != "" {
+		if _, err := uuid.Parse(nr.VariantID); err != nil {
+			return nil, ErrInvalidID
+		}
+		r.VariantID = &nr.VariantID
+	}
+	if r.LocationID == "" {
+		r.LocationID = inventory.DefaultLocation
+	}
+
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		if err := inventory.CheckLocation(ctx, tx, r.LocationID); err != nil {
+			return err
+		}
+
+		// Lock the product so two carts can not take the same last unit.
+		var exists bool
+		const lock = `SELECT true FROM products WHERE product_id = $1 FOR UPDATE`
+		if err := tx.GetContext(ctx, &exists, lock, r.ProductID); err != nil {
+			if err == sql.ErrNoRows {
+				return product.ErrNotFound
+			}
+			return errors.Wrap(err, "locking product")
+		}
+
+		avail, err := product.Available(ctx, tx, r.ProductID, r.VariantID, now)
+		if err != nil {
+			return err
+		}
+		if avail < r.Quantity {
+			return ErrInsufficientStock
+		}
+		avail, err = inventory.AvailableAt(ctx, tx, r.LocationID, r.ProductID, r.VariantID, now)
+		if err != nil {
+			return err
+		}
+		if avail < r.Quantity {
+			return ErrInsufficientStock
+		}
+
+		const q = `
+			INSERT INTO reservations
+			(reservation_id, product_id, variant_id, location_id, quantity, status,
+			expires_at, date_created, date_updated)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+		_, err = tx.ExecContext(ctx, q,
+			r.ID, r.ProductID, r.VariantID, r.LocationID, r.Quantity, r.Status,
+			r.ExpiresAt, r.DateCreated, r.DateUpdated,
+		)
+		if err != nil {
+			return errors.Wrap(err, "inserting reservation")
+		}
+
+		c := audit.Change{EntityType: auditEntity, EntityID: r.ID, Action: audit.Create, After: r}
+		_, err = audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// Retrive finds the Reservation identified by id.
+func Retrive(ctx context.Context, db *sqlx.DB, id string) (*Reservation, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	return retrive(ctx, db, id, false)
+}
+
+// Confirm turns an active Reservation into a sale of the held stock. The
+// hooks take part in the sale as they do for product.AddSale, so the sale is
+// priced and taxed like any other.
+func Confirm(ctx context.Context, db *sqlx.DB, c Confirmation, id string, now time.Time, hooks ...product.SaleHook) (*Reservation, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var r *Reservation
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		var err error
+		if r, err = retrive(ctx, tx, id, true); err != nil {
+			return err
+		}
+		if r.Status != Active || !now.Before(r.ExpiresAt) {
+			return ErrNotActive
+		}
+		before := *r
+
+		ns := product.NewSale{
+			LocationID: r.LocationID,
+			Quantity:   r.Quantity,
+			CustomerID: c.CustomerID,
+			SessionID:  c.SessionID,
+			Paid:       c.Paid,
+			Currency:   c.Currency,
+			Coupon:     c.Coupon,
+			Points:     c.Points,
+
+			GiftCard:       c.GiftCard,
+			GiftCardAmount: c.GiftCardAmount,
+		}
+		if r.VariantID != nil {
+			ns.VariantID = *r.VariantID
+		}
+
+		// The reservation stops holding its stock before the sale so the
+		// sale can take it.
+		if err := setStatus(ctx, tx, r, Confirmed, now); err != nil {
+			return err
+		}
+
+		s, err := product.RecordSale(ctx, tx, ns, r.ProductID, now, hooks...)
+		if err != nil {
+			return err
+		}
+
+		r.SaleID = &s.ID
+		if err := setStatus(ctx, tx, r, Confirmed, now); err != nil {
+			return err
+		}
+
+		// The sale is audited on its own, so only its ID is recorded here.
+		if err := record(ctx, tx, before, *r, now); err != nil {
+			return err
+		}
+		r.Sale = s
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Release gives the stock held by an active Reservation back.
+func Release(ctx context.Context, db *sqlx.DB, id string, now time.Time) (*Reservation, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var r *Reservation
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		var err error
+		if r, err = retrive(ctx, tx, id, true); err != nil {
+			return err
+		}
+		if r.Status != Active {
+			return ErrNotActive
+		}
+		before := *r
+
+		if err := setStatus(ctx, tx, r, Released, now); err != nil {
+			return err
+		}
+		return record(ctx, tx, before, *r, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Expire marks every active Reservation whose time is up as expired. It
+// returns the number of reservations expired.
+func Expire(ctx context.Context, db *sqlx.DB, now time.Time) (int64, error) {
+	const q = `
+		UPDATE reservations SET status = $1, date_updated = $2
+		WHERE status = $3 AND expires_at <= $2
+		RETURNING *`
+
+	var expired []Reservation
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		if err := tx.SelectContext(ctx, &expired, q, Expired, now.UTC(), Active); err != nil {
+			return errors.Wrap(err, "expiring reservations")
+		}
+
+		for _, r := range expired {
+			before := r
+			before.Status = Active
+			if err := record(ctx, tx, before, r, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(expired)), nil
+}
+
+// Sweeper periodically expires reservations whose time is up.
+type Sweeper struct {
+	DB       *sqlx.DB
+	Log      *log.Logger
+	Interval time.Duration
+}
+
+// Run expires reservations every Interval until ctx is cancelled.
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n, err := Expire(ctx, s.DB, time.Now())
+			if err != nil {
+				s.Log.Printf("reservation: sweeping: %v", err)
+				continue
+			}
+			if n > 0 {
+				s.Log.Printf("reservation: expired %d reservations", n)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// retrive loads a Reservation. When lock is set its row is locked for the
+// rest of the transaction.
+func retrive(ctx context.Context, db sqlx.QueryerContext, id string, lock bool) (*Reservation, error) {
+	q := `SELECT * FROM reservations WHERE reservation_id = $1`
+	if lock {
+		q += ` FOR UPDATE`
+	}
+
+	var r Reservation
+	if err := sqlx.GetContext(ctx, db, &r, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting reservation %q", id)
+	}
+
+	return &r, nil
+}
+
+// record audits the change of a Reservation from before to after.
+func record(ctx context.Context, tx *sqlx.Tx, before, after Reservation, now time.Time) error {
+	c := audit.Change{EntityType: auditEntity, EntityID: after.ID, Action: audit.Update, Before: before, After: after}
+	_, err := audit.Record(ctx, tx, c, now)
+	return err
+}
+
+// setStatus stores a new status and sale of r.
+func setStatus(ctx context.Context, tx *sqlx.Tx, r *Reservation, status string, now time.Time) error {
+	r.Status = status
+	r.DateUpdated = now.UTC()
+
+	const q = `
+		UPDATE reservations SET status = $2, sale_id = $3, date_updated = $4
+		WHERE reservation_id = $1`
+
+	if _, err := tx.ExecContext(ctx, q, r.ID, r.Status, r.SaleID, r.DateUpdated); err != nil {
+		return errors.Wrap(err, "updating reservation")
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package reservation_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/product"
+	"github.com/vikramcse/the-service/internal/reservation"
+	"github.com/vikramcse/the-service/internal/tests"
+)
+
+func TestReservations(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	// Products count reservations against the database clock, so this test
+	// has to run in the present.
+	now := time.Now()
+	ctx := context.Background()
+
+	p, err := product.Create(ctx, db, product.NewProduct{Name: "Comic Book", Cost: 10, Quantity: 5}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+
+	availability := func(exp int) {
+		t.Helper()
+		got, err := product.Retrive(ctx, db, p.ID)
+		if err != nil {
+			t.Fatalf("retrieving product: %s", err)
+		}
+		if exp != got.Available {
+			t.Fatalf("expected %v available, got %v", exp, got.Available)
+		}
+	}
+
+	r, err := reservation.Create(ctx, db, reservation.NewReservation{ProductID: p.ID, Quantity: 3}, now)
+	if err != nil {
+		t.Fatalf("reserving stock: %s", err)
+	}
+	availability(2)
+
+	if _, err := reservation.Create(ctx, db, reservation.NewReservation{ProductID: p.ID, Quantity: 3}, now); err != reservation.ErrInsufficientStock {
+		t.Fatalf("expected %v, got %v", reservation.ErrInsufficientStock, err)
+	}
+
+	r, err = reservation.Confirm(ctx, db, reservation.Confirmation{Paid: &money.Money{Amount: 30}}, r.ID, now)
+	if err != nil {
+		t.Fatalf("confirming reservation: %s", err)
+	}
+	if r.SaleID == nil {
+		t.Fatal("expected confirmed reservation to have a sale")
+	}
+	availability(2)
+
+	if _, err := reservation.Create(ctx, db, reservation.NewReservation{ProductID: p.ID, Quantity: 2, TTL: 60}, now); err != nil {
+		t.Fatalf("reserving stock: %s", err)
+	}
+	availability(0)
+
+	// Stock held by a cart can not be sold over the counter.
+	ns := product.NewSale{Quantity: 1, Paid: &money.Money{Amount: 10}}
+	if _, err := product.AddSale(ctx, db, ns, p.ID, now); err != product.ErrInsufficientStock {
+		t.Fatalf("expected %v, got %v", product.ErrInsufficientStock, err)
+	}
+
+	n, err := reservation.Expire(ctx, db, now.Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("expiring reservations: %s", err)
+	}
+	if exp, got := int64(1), n; exp != got {
+		t.Fatalf("expected %v expired reservations, got %v", exp, got)
+	}
+	availability(2)
+}
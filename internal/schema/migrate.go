@@ -42,6 +42,765 @@ var migrations = []darwin.Migration{
 				ON DELETE CASCADE
 		)`,
 	},
+	{
+		Version:     3,
+		Description: "Add Variants",
+		Script: `
+		CREATE TABLE variants (
+				variant_id   UUID,
+				product_id   UUID,
+				sku          TEXT,
+				options      JSONB,
+				cost         INT,
+				quantity     INT,
+				date_created TIMESTAMP,
+				date_updated TIMESTAMP,
+				PRIMARY KEY (variant_id),
+				UNIQUE (sku),
+				FOREIGN KEY (product_id) REFERENCES products(product_id)
+				ON DELETE CASCADE
+		);
+
+		ALTER TABLE sales
+				ADD COLUMN variant_id UUID REFERENCES variants(variant_id)
+				ON DELETE SET NULL;`,
+	},
+	{
+		Version:     4,
+		Description: "Add Product Prices",
+		Script: `
+		CREATE TABLE product_prices (
+				price_id       UUID,
+				product_id     UUID,
+				cost           INT,
+				effective_from TIMESTAMP,
+				date_created   TIMESTAMP,
+				PRIMARY KEY (price_id),
+				FOREIGN KEY (product_id) REFERENCES products(product_id)
+				ON DELETE CASCADE
+		);
+
+		CREATE INDEX product_prices_effective_idx
+				ON product_prices (product_id, effective_from);
+
+		INSERT INTO product_prices (price_id, product_id, cost, effective_from, date_created)
+				SELECT md5(product_id::text || 'initial')::uuid, product_id, cost, date_created, date_created
+				FROM products;
+
+		ALTER TABLE sales ADD COLUMN list_price INT;
+
+		UPDATE sales AS s
+				SET list_price = COALESCE(
+					(SELECT v.cost FROM variants AS v WHERE v.variant_id = s.variant_id),
+					p.cost
+				)
+				FROM products AS p
+				WHERE s.product_id = p.product_id;`,
+	},
+	{
+		Version:     5,
+		Description: "Add Inventory Movements",
+		Script: `
+		CREATE TABLE inventory_movements (
+				movement_id  UUID,
+				product_id   UUID,
+				variant_id   UUID,
+				sale_id      UUID,
+				kind         TEXT,
+				quantity     INT,
+				reason       TEXT,
+				actor        TEXT,
+				date_created TIMESTAMP,
+				PRIMARY KEY (movement_id),
+				FOREIGN KEY (product_id) REFERENCES products(product_id),
+				FOREIGN KEY (variant_id) REFERENCES variants(variant_id),
+				FOREIGN KEY (sale_id) REFERENCES sales(sale_id)
+		);
+
+		CREATE INDEX inventory_movements_product_idx
+				ON inventory_movements (product_id, variant_id);
+
+		CREATE FUNCTION inventory_movements_append_only() RETURNS trigger AS $$
+		BEGIN
+				RAISE EXCEPTION 'inventory_movements is append-only';
+		END;
+		$$ LANGUAGE plpgsql;
+
+		CREATE TRIGGER inventory_movements_append_only
+				BEFORE UPDATE OR DELETE ON inventory_movements
+				FOR EACH ROW EXECUTE PROCEDURE inventory_movements_append_only();
+
+		INSERT INTO inventory_movements (movement_id, product_id, kind, quantity, reason, actor, date_created)
+				SELECT md5(product_id::text || 'opening')::uuid, product_id, 'receipt', quantity, 'opening balance', 'migration', date_created
+				FROM products;
+
+		INSERT INTO inventory_movements (movement_id, product_id, variant_id, kind, quantity, reason, actor, date_created)
+				SELECT md5(variant_id::text || 'opening')::uuid, product_id, variant_id, 'receipt', quantity, 'opening balance', 'migration', date_created
+				FROM variants;
+
+		INSERT INTO inventory_movements (movement_id, product_id, variant_id, sale_id, kind, quantity, reason, actor, date_created)
+				SELECT md5(sale_id::text)::uuid, product_id, variant_id, sale_id, 'sale', -quantity, '', 'migration', date_created
+				FROM sales;`,
+	},
+	{
+		Version:     6,
+		Description: "Add Reorder Thresholds",
+		Script: `
+		ALTER TABLE products
+				ADD COLUMN reorder_threshold INT NOT NULL DEFAULT 0,
+				ADD COLUMN reorder_quantity INT NOT NULL DEFAULT 0;`,
+	},
+	{
+		Version:     7,
+		Description: "Add Suppliers and Purchase Orders",
+		Script: `
+		CREATE TABLE suppliers (
+				supplier_id  UUID,
+				name         TEXT,
+				email        TEXT,
+				phone        TEXT,
+				date_created TIMESTAMP,
+				date_updated TIMESTAMP,
+				PRIMARY KEY (supplier_id)
+		);
+
+		CREATE TABLE purchase_orders (
+				order_id     UUID,
+				supplier_id  UUID,
+				status       TEXT,
+				date_created TIMESTAMP,
+				date_updated TIMESTAMP,
+				PRIMARY KEY (order_id),
+				FOREIGN KEY (supplier_id) REFERENCES suppliers(supplier_id)
+		);
+
+		CREATE TABLE purchase_order_lines (
+				line_id    UUID,
+				order_id   UUID,
+				product_id UUID,
+				variant_id UUID,
+				quantity   INT,
+				unit_cost  INT,
+				received   INT NOT NULL DEFAULT 0,
+				PRIMARY KEY (line_id),
+				FOREIGN KEY (order_id) REFERENCES purchase_orders(order_id)
+				ON DELETE CASCADE,
+				FOREIGN KEY (product_id) REFERENCES products(product_id),
+				FOREIGN KEY (variant_id) REFERENCES variants(variant_id)
+		);
+
+		CREATE TABLE goods_receipts (
+				receipt_id   UUID,
+				order_id     UUID,
+				line_id      UUID,
+				quantity     INT,
+				landed_cost  INT,
+				actor        TEXT,
+				date_created TIMESTAMP,
+				PRIMARY KEY (receipt_id),
+				FOREIGN KEY (order_id) REFERENCES purchase_orders(order_id),
+				FOREIGN KEY (line_id) REFERENCES purchase_order_lines(line_id)
+		);`,
+	},
+	{
+		Version:     8,
+		Description: "Add Locations and Transfers",
+		Script: `
+		CREATE TABLE locations (
+				location_id  UUID,
+				name         TEXT,
+				kind         TEXT,
+				date_created TIMESTAMP,
+				date_updated TIMESTAMP,
+				PRIMARY KEY (location_id)
+		);
+
+		INSERT INTO locations (location_id, name, kind, date_created, date_updated)
+				VALUES ('00000000-0000-0000-0000-000000000001', 'Main Warehouse', 'warehouse', now(), now());
+
+		ALTER TABLE inventory_movements
+				ADD COLUMN location_id UUID NOT NULL
+				DEFAULT '00000000-0000-0000-0000-000000000001'
+				REFERENCES locations(location_id);
+		ALTER TABLE inventory_movements ALTER COLUMN location_id DROP DEFAULT;
+
+		ALTER TABLE sales
+				ADD COLUMN location_id UUID NOT NULL
+				DEFAULT '00000000-0000-0000-0000-000000000001'
+				REFERENCES locations(location_id);
+		ALTER TABLE sales ALTER COLUMN location_id DROP DEFAULT;
+
+		ALTER TABLE goods_receipts
+				ADD COLUMN location_id UUID NOT NULL
+				DEFAULT '00000000-0000-0000-0000-000000000001'
+				REFERENCES locations(location_id);
+		ALTER TABLE goods_receipts ALTER COLUMN location_id DROP DEFAULT;
+
+		CREATE TABLE transfers (
+				transfer_id      UUID,
+				product_id       UUID,
+				variant_id       UUID,
+				from_location_id UUID,
+				to_location_id   UUID,
+				quantity         INT,
+				status           TEXT,
+				actor            TEXT,
+				date_created     TIMESTAMP,
+				date_updated     TIMESTAMP,
+				PRIMARY KEY (transfer_id),
+				FOREIGN KEY (product_id) REFERENCES products(product_id),
+				FOREIGN KEY (variant_id) REFERENCES variants(variant_id),
+				FOREIGN KEY (from_location_id) REFERENCES locations(location_id),
+				FOREIGN KEY (to_location_id) REFERENCES locations(location_id)
+		);`,
+	},
+	{
+		Version:     9,
+		Description: "Add Reservations",
+		Script: `
+		CREATE TABLE reservations (
+				reservation_id UUID,
+				product_id     UUID,
+				variant_id     UUID,
+				location_id    UUID,
+				sale_id        UUID,
+				quantity       INT,
+				status         TEXT,
+				expires_at     TIMESTAMP,
+				date_created   TIMESTAMP,
+				date_updated   TIMESTAMP,
+				PRIMARY KEY (reservation_id),
+				FOREIGN KEY (product_id) REFERENCES products(product_id),
+				FOREIGN KEY (variant_id) REFERENCES variants(variant_id),
+				FOREIGN KEY (location_id) REFERENCES locations(location_id),
+				FOREIGN KEY (sale_id) REFERENCES sales(sale_id)
+		);
+
+		CREATE INDEX reservations_active_idx
+				ON reservations (product_id, variant_id)
+				WHERE status = 'active';`,
+	},
+	{
+		Version:     10,
+		Description: "Add Promotions and Coupons",
+		Script: `
+		ALTER TABLE products ADD COLUMN category TEXT NOT NULL DEFAULT '';
+
+		CREATE TABLE promotions (
+				promotion_id    UUID,
+				name            TEXT,
+				kind            TEXT,
+				percent         INT NOT NULL DEFAULT 0,
+				amount          INT NOT NULL DEFAULT 0,
+				buy_quantity    INT NOT NULL DEFAULT 0,
+				get_quantity    INT NOT NULL DEFAULT 0,
+				product_id      UUID,
+				category        TEXT,
+				requires_coupon BOOLEAN NOT NULL DEFAULT false,
+				starts_at       TIMESTAMP,
+				ends_at         TIMESTAMP,
+				date_created    TIMESTAMP,
+				PRIMARY KEY (promotion_id),
+				FOREIGN KEY (product_id) REFERENCES products(product_id)
+		);
+
+		CREATE TABLE coupons (
+				code         TEXT,
+				promotion_id UUID,
+				usage_limit  INT,
+				times_used   INT NOT NULL DEFAULT 0,
+				date_created TIMESTAMP,
+				PRIMARY KEY (code),
+				FOREIGN KEY (promotion_id) REFERENCES promotions(promotion_id)
+		);
+
+		ALTER TABLE sales
+				ADD COLUMN promotion_id UUID REFERENCES promotions(promotion_id),
+				ADD COLUMN coupon TEXT NOT NULL DEFAULT '';`,
+	},
+	{
+		Version:     11,
+		Description: "Add Tax Classes and Rates",
+		Script: `
+		ALTER TABLE products ADD COLUMN tax_class TEXT NOT NULL DEFAULT 'standard';
+
+		CREATE TABLE tax_rates (
+				rate_id        UUID,
+				location_id    UUID NOT NULL,
+				tax_class      TEXT NOT NULL,
+				rate           INT NOT NULL,
+				inclusive      BOOLEAN NOT NULL,
+				effective_from TIMESTAMP,
+				date_created   TIMESTAMP,
+				PRIMARY KEY (rate_id),
+				FOREIGN KEY (location_id) REFERENCES locations(location_id)
+		);
+
+		CREATE INDEX tax_rates_lookup_idx ON tax_rates (location_id, tax_class, effective_from);
+
+		ALTER TABLE sales
+				ADD COLUMN net INT NOT NULL DEFAULT 0,
+				ADD COLUMN tax INT NOT NULL DEFAULT 0,
+				ADD COLUMN gross INT NOT NULL DEFAULT 0,
+				ADD COLUMN tax_class TEXT NOT NULL DEFAULT '',
+				ADD COLUMN tax_rate INT NOT NULL DEFAULT 0,
+				ADD COLUMN tax_inclusive BOOLEAN NOT NULL DEFAULT false;
+
+		UPDATE sales SET net = paid, gross = paid;`,
+	},
+	{
+		Version:     12,
+		Description: "Add Currencies",
+		Script: `
+		ALTER TABLE products ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+		ALTER TABLE sales ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';`,
+	},
+	{
+		Version:     13,
+		Description: "Add Exchange Rates and Currency Price Lists",
+		Script: `
+		CREATE TABLE exchange_rates (
+				base           CHAR(3),
+				quote          CHAR(3),
+				rate           NUMERIC(18,8) NOT NULL,
+				effective_from TIMESTAMP,
+				date_created   TIMESTAMP,
+				PRIMARY KEY (base, quote, effective_from)
+		);
+
+		CREATE TABLE currency_prices (
+				product_id   UUID,
+				currency     CHAR(3),
+				cost         INT NOT NULL,
+				date_updated TIMESTAMP,
+				PRIMARY KEY (product_id, currency),
+				FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE
+		);
+
+		ALTER TABLE sales
+				ADD COLUMN base_paid INT NOT NULL DEFAULT 0,
+				ADD COLUMN base_currency CHAR(3) NOT NULL DEFAULT 'USD',
+				ADD COLUMN exchange_rate NUMERIC(18,8) NOT NULL DEFAULT 1;
+
+		UPDATE sales SET base_paid = paid, base_currency = currency;`,
+	},
+	{
+		Version:     14,
+		Description: "Add Customers",
+		Script: `
+		CREATE TABLE customers (
+				customer_id  UUID,
+				name         TEXT NOT NULL,
+				email        TEXT NOT NULL DEFAULT '',
+				phone        TEXT NOT NULL DEFAULT '',
+				notes        TEXT NOT NULL DEFAULT '',
+				date_created TIMESTAMP,
+				date_updated TIMESTAMP,
+				PRIMARY KEY (customer_id)
+		);
+
+		CREATE UNIQUE INDEX customers_email_key ON customers (email) WHERE email <> '';
+		CREATE UNIQUE INDEX customers_phone_key ON customers (phone) WHERE phone <> '';
+
+		ALTER TABLE sales ADD COLUMN customer_id UUID REFERENCES customers(customer_id) ON DELETE SET NULL;
+		CREATE INDEX sales_customer_id_idx ON sales (customer_id);`,
+	},
+	{
+		Version:     15,
+		Description: "Add Loyalty Points",
+		Script: `
+		CREATE TABLE loyalty_program (
+				program_id      INT,
+				points_per_unit INT NOT NULL,
+				point_value     INT NOT NULL,
+				currency        CHAR(3) NOT NULL,
+				expiry_days     INT NOT NULL,
+				date_updated    TIMESTAMP,
+				PRIMARY KEY (program_id),
+				CHECK (program_id = 1)
+		);
+
+		INSERT INTO loyalty_program
+		(program_id, points_per_unit, point_value, currency, expiry_days, date_updated)
+		VALUES (1, 1, 1, 'USD', 365, NOW());
+
+		CREATE TABLE loyalty_multipliers (
+				category     TEXT,
+				multiplier   INT NOT NULL,
+				date_updated TIMESTAMP,
+				PRIMARY KEY (category)
+		);
+
+		CREATE TABLE loyalty_entries (
+				entry_id     UUID,
+				customer_id  UUID NOT NULL,
+				sale_id      UUID,
+				kind         TEXT NOT NULL,
+				points       INT NOT NULL,
+				remaining    INT NOT NULL DEFAULT 0,
+				expires_at   TIMESTAMP,
+				date_created TIMESTAMP,
+				PRIMARY KEY (entry_id),
+				FOREIGN KEY (customer_id) REFERENCES customers(customer_id) ON DELETE CASCADE,
+				FOREIGN KEY (sale_id) REFERENCES sales(sale_id) ON DELETE SET NULL
+		);
+
+		CREATE INDEX loyalty_entries_customer_idx ON loyalty_entries (customer_id, date_created);
+
+		ALTER TABLE sales
+				ADD COLUMN points_earned INT NOT NULL DEFAULT 0,
+				ADD COLUMN points_redeemed INT NOT NULL DEFAULT 0,
+				ADD COLUMN points_value INT NOT NULL DEFAULT 0;`,
+	},
+	{
+		Version:     16,
+		Description: "Add Gift Cards",
+		Script: `
+		CREATE TABLE gift_cards (
+				card_id      UUID,
+				code         TEXT NOT NULL UNIQUE,
+				kind         TEXT NOT NULL,
+				currency     CHAR(3) NOT NULL,
+				balance      INT NOT NULL,
+				customer_id  UUID REFERENCES customers(customer_id) ON DELETE SET NULL,
+				date_created TIMESTAMP,
+				date_updated TIMESTAMP,
+				PRIMARY KEY (card_id),
+				CHECK (balance >= 0)
+		);
+
+		CREATE TABLE gift_card_entries (
+				entry_id     UUID,
+				card_id      UUID NOT NULL,
+				sale_id      UUID,
+				kind         TEXT NOT NULL,
+				amount       INT NOT NULL,
+				balance      INT NOT NULL,
+				date_created TIMESTAMP,
+				PRIMARY KEY (entry_id),
+				FOREIGN KEY (card_id) REFERENCES gift_cards(card_id) ON DELETE CASCADE,
+				FOREIGN KEY (sale_id) REFERENCES sales(sale_id) ON DELETE SET NULL
+		);
+
+		CREATE INDEX gift_card_entries_card_idx ON gift_card_entries (card_id, date_created);
+
+		ALTER TABLE sales ADD COLUMN gift_card_paid INT NOT NULL DEFAULT 0;`,
+	},
+	{
+		Version:     17,
+		Description: "Add Payments",
+		Script: `
+		CREATE TABLE payments (
+				payment_id   UUID,
+				sale_id      UUID NOT NULL,
+				tender       TEXT NOT NULL,
+				provider     TEXT NOT NULL DEFAULT '',
+				currency     CHAR(3) NOT NULL,
+				amount       INT NOT NULL,
+				change       INT NOT NULL DEFAULT 0,
+				refunded     INT NOT NULL DEFAULT 0,
+				status       TEXT NOT NULL,
+				reference    TEXT NOT NULL DEFAULT '',
+				message      TEXT NOT NULL DEFAULT '',
+				date_created TIMESTAMP,
+				date_updated TIMESTAMP,
+				PRIMARY KEY (payment_id),
+				FOREIGN KEY (sale_id) REFERENCES sales(sale_id) ON DELETE CASCADE
+		);
+
+		CREATE INDEX payments_sale_idx ON payments (sale_id, date_created);`,
+	},
+	{
+		Version:     18,
+		Description: "Add Registers and Sessions",
+		Script: `
+		CREATE TABLE registers (
+				register_id  UUID,
+				name         TEXT NOT NULL,
+				location_id  UUID NOT NULL,
+				currency     CHAR(3) NOT NULL,
+				date_created TIMESTAMP,
+				PRIMARY KEY (register_id),
+				FOREIGN KEY (location_id) REFERENCES locations(location_id)
+		);
+
+		CREATE TABLE register_sessions (
+				session_id    UUID,
+				register_id   UUID NOT NULL,
+				status        TEXT NOT NULL,
+				opening_float INT NOT NULL,
+				notes         TEXT NOT NULL DEFAULT '',
+				opened_at     TIMESTAMP,
+				closed_at     TIMESTAMP,
+				PRIMARY KEY (session_id),
+				FOREIGN KEY (register_id) REFERENCES registers(register_id) ON DELETE CASCADE
+		);
+
+		CREATE UNIQUE INDEX register_sessions_open_key ON register_sessions (register_id) WHERE status = 'open';
+
+		CREATE TABLE cash_movements (
+				movement_id  UUID,
+				session_id   UUID NOT NULL,
+				kind         TEXT NOT NULL,
+				amount       INT NOT NULL,
+				reason       TEXT NOT NULL DEFAULT '',
+				date_created TIMESTAMP,
+				PRIMARY KEY (movement_id),
+				FOREIGN KEY (session_id) REFERENCES register_sessions(session_id) ON DELETE CASCADE
+		);
+
+		CREATE TABLE session_counts (
+				session_id UUID,
+				tender     TEXT,
+				expected   INT NOT NULL,
+				counted    INT,
+				PRIMARY KEY (session_id, tender),
+				FOREIGN KEY (session_id) REFERENCES register_sessions(session_id) ON DELETE CASCADE
+		);
+
+		ALTER TABLE sales ADD COLUMN session_id UUID REFERENCES register_sessions(session_id) ON DELETE SET NULL;
+		CREATE INDEX sales_session_idx ON sales (session_id);`,
+	},
+	{
+		Version:     19,
+		Description: "Add Accounting Journal",
+		Script: `
+		CREATE TABLE accounts (
+				code TEXT,
+				name TEXT NOT NULL,
+				kind TEXT NOT NULL,
+				PRIMARY KEY (code)
+		);
+
+		INSERT INTO accounts (code, name, kind) VALUES
+				('1000', 'Cash', 'asset'),
+				('1010', 'Card clearing', 'asset'),
+				('1100', 'Accounts receivable', 'asset'),
+				('1200', 'Inventory', 'asset'),
+				('2000', 'Tax payable', 'liability'),
+				('2100', 'Gift card liability', 'liability'),
+				('2200', 'Accounts payable', 'liability'),
+				('4000', 'Sales revenue', 'revenue'),
+				('4100', 'Sales returns', 'revenue'),
+				('5000', 'Cost of goods sold', 'expense'),
+				('5100', 'Inventory adjustments', 'expense'),
+				('5200', 'Loyalty redemptions', 'expense');
+
+		CREATE TABLE journal_entries (
+				entry_id     UUID,
+				kind         TEXT NOT NULL,
+				source_id    TEXT NOT NULL DEFAULT '',
+				memo         TEXT NOT NULL DEFAULT '',
+				date_created TIMESTAMP,
+				PRIMARY KEY (entry_id)
+		);
+
+		CREATE INDEX journal_entries_date_idx ON journal_entries (date_created);
+
+		CREATE TABLE journal_lines (
+				entry_id UUID,
+				line_no  INT,
+				account  TEXT NOT NULL,
+				currency CHAR(3) NOT NULL,
+				debit    INT NOT NULL DEFAULT 0,
+				credit   INT NOT NULL DEFAULT 0,
+				PRIMARY KEY (entry_id, line_no),
+				FOREIGN KEY (entry_id) REFERENCES journal_entries(entry_id) ON DELETE CASCADE,
+				FOREIGN KEY (account) REFERENCES accounts(code),
+				CHECK (debit >= 0 AND credit >= 0 AND (debit = 0 OR credit = 0))
+		);
+
+		CREATE FUNCTION journal_balanced() RETURNS trigger AS $$
+		BEGIN
+			IF EXISTS (
+				SELECT 1 FROM journal_lines WHERE entry_id = NEW.entry_id
+				GROUP BY currency HAVING SUM(debit) <> SUM(credit)
+			) THEN
+				RAISE EXCEPTION 'journal entry % is not balanced', NEW.entry_id;
+			END IF;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;
+
+		CREATE CONSTRAINT TRIGGER journal_lines_balanced
+		AFTER INSERT OR UPDATE ON journal_lines
+		DEFERRABLE INITIALLY DEFERRED
+		FOR EACH ROW EXECUTE PROCEDURE journal_balanced();`,
+	},
+	{
+		Version:     20,
+		Description: "Add Cost Layers",
+		Script: `
+		ALTER TABLE products ADD COLUMN costing TEXT NOT NULL DEFAULT 'fifo';
+		ALTER TABLE inventory_movements ADD COLUMN value INT NOT NULL DEFAULT 0;
+		ALTER TABLE sales ADD COLUMN cogs INT NOT NULL DEFAULT 0;
+
+		CREATE TABLE cost_layers (
+				movement_id  UUID,
+				seq          BIGSERIAL,
+				product_id   UUID NOT NULL,
+				variant_id   UUID,
+				unit_cost    INT NOT NULL,
+				quantity     INT NOT NULL,
+				remaining    INT NOT NULL,
+				date_created TIMESTAMP,
+				PRIMARY KEY (movement_id),
+				FOREIGN KEY (movement_id) REFERENCES inventory_movements(movement_id),
+				FOREIGN KEY (product_id) REFERENCES products(product_id),
+				FOREIGN KEY (variant_id) REFERENCES variants(variant_id),
+				CHECK (remaining >= 0)
+		);
+
+		CREATE INDEX cost_layers_open_idx ON cost_layers (product_id, variant_id, date_created, seq) WHERE remaining > 0;`,
+	},
+	{
+		Version:     21,
+		Description: "Add Product SKUs",
+		Script: `
+		ALTER TABLE products ADD COLUMN sku TEXT NOT NULL DEFAULT '';
+		CREATE UNIQUE INDEX products_sku_key ON products (sku) WHERE sku <> '';`,
+	},
+	{
+		Version:     22,
+		Description: "Add Product Search",
+		Script: `
+		CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+		ALTER TABLE products ADD COLUMN search tsvector GENERATED ALWAYS AS (
+			setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+			setweight(to_tsvector('english', sku), 'A') ||
+			setweight(to_tsvector('english', category), 'B')
+		) STORED;
+
+		CREATE INDEX products_search_idx ON products USING GIN (search);
+		CREATE INDEX products_name_trgm_idx ON products USING GIN (name gin_trgm_ops);`,
+	},
+	{
+		Version:     23,
+		Description: "Add Audit Log",
+		Script: `
+		CREATE TABLE audit_entries (
+				entry_id     UUID,
+				seq          BIGSERIAL,
+				actor        TEXT NOT NULL,
+				action       TEXT NOT NULL,
+				entity_type  TEXT NOT NULL,
+				entity_id    TEXT NOT NULL DEFAULT '',
+				before       TEXT NOT NULL DEFAULT '',
+				after        TEXT NOT NULL DEFAULT '',
+				changes      TEXT NOT NULL DEFAULT '',
+				request_id   TEXT NOT NULL DEFAULT '',
+				prev_hash    TEXT NOT NULL,
+				hash         TEXT NOT NULL,
+				date_created TIMESTAMP NOT NULL,
+				PRIMARY KEY (entry_id),
+				UNIQUE (seq)
+		);
+
+		CREATE INDEX audit_entries_entity_idx
+				ON audit_entries (entity_type, entity_id, seq);
+
+		CREATE FUNCTION audit_entries_append_only() RETURNS trigger AS $$
+		BEGIN
+				RAISE EXCEPTION 'audit_entries is append-only';
+		END;
+		$$ LANGUAGE plpgsql;
+
+		CREATE TRIGGER audit_entries_append_only
+				BEFORE UPDATE OR DELETE ON audit_entries
+				FOR EACH ROW EXECUTE PROCEDURE audit_entries_append_only();`,
+	},
+	{
+		Version:     24,
+		Description: "Add Variant Prices",
+		Script: `
+		ALTER TABLE product_prices
+				ADD COLUMN variant_id UUID REFERENCES variants(variant_id) ON DELETE CASCADE;
+
+		INSERT INTO product_prices (price_id, product_id, variant_id, cost, effective_from, date_created)
+				SELECT md5(variant_id::text || 'initial')::uuid, product_id, variant_id, cost, date_created, date_created
+				FROM variants
+				WHERE cost IS NOT NULL;`,
+	},
+	{
+		Version:     25,
+		Description: "Add Stock Alerts",
+		Script: `
+		CREATE TABLE stock_alerts (
+				alert_id          UUID,
+				product_id        UUID NOT NULL,
+				remaining         INT NOT NULL,
+				reorder_threshold INT NOT NULL,
+				reorder_quantity  INT NOT NULL,
+				date_created      TIMESTAMP NOT NULL,
+				date_notified     TIMESTAMP,
+				date_cleared      TIMESTAMP,
+				PRIMARY KEY (alert_id),
+				FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE
+		);
+
+		CREATE UNIQUE INDEX stock_alerts_open_key
+				ON stock_alerts (product_id) WHERE date_cleared IS NULL;`,
+	},
+	{
+		Version:     26,
+		Description: "Add Sale Refunds",
+		Script: `
+		ALTER TABLE sales ADD COLUMN refunded INT NOT NULL DEFAULT 0;
+
+		UPDATE sales as s SET refunded =
+				COALESCE((SELECT SUM(e.amount) FROM gift_card_entries as e
+					WHERE e.sale_id = s.sale_id AND e.kind = 'refund'), 0)
+				+ COALESCE((SELECT SUM(p.refunded) FROM payments as p
+					WHERE p.sale_id = s.sale_id AND p.tender <> 'gift_card'), 0);`,
+	},
+	{
+		Version:     27,
+		Description: "Add Sale Returns",
+		Script: `
+		ALTER TABLE sales ADD COLUMN returned INT NOT NULL DEFAULT 0;`,
+	},
+	{
+		Version:     28,
+		Description: "Add Opening Balance Equity",
+		Script: `
+		INSERT INTO accounts (code, name, kind) VALUES
+				('3000', 'Opening balance equity', 'equity');
+
+		INSERT INTO journal_entries (entry_id, kind, source_id, date_created)
+		SELECT md5('opening' || m.movement_id)::uuid, 'opening', m.movement_id::text, m.date_created
+		FROM inventory_movements as m
+		WHERE m.reason = 'opening stock' AND m.value <> 0;
+
+		INSERT INTO journal_lines (entry_id, line_no, account, currency, debit, credit)
+		SELECT md5('opening' || m.movement_id)::uuid, l.line_no,
+				CASE WHEN (m.value > 0) = l.debit THEN '1200' ELSE '3000' END,
+				p.currency,
+				CASE WHEN l.debit THEN ABS(m.value) ELSE 0 END,
+				CASE WHEN l.debit THEN 0 ELSE ABS(m.value) END
+		FROM inventory_movements as m
+		JOIN products as p ON p.product_id = m.product_id
+		CROSS JOIN (VALUES (1, true), (2, false)) as l(line_no, debit)
+		WHERE m.reason = 'opening stock' AND m.value <> 0;`,
+	},
+	{
+		Version:     29,
+		Description: "Add Promotion Currency",
+		Script: `
+		ALTER TABLE promotions ADD COLUMN currency TEXT NOT NULL DEFAULT '';
+
+		UPDATE promotions as r SET currency = COALESCE(
+				(SELECT p.currency FROM products as p WHERE p.product_id = r.product_id), 'USD')
+		WHERE r.kind = 'amount_off';`,
+	},
+	{
+		Version:     30,
+		Description: "Chain Audit Entries by Entity",
+		Script: `
+		ALTER TABLE audit_entries ADD COLUMN chain TEXT NOT NULL DEFAULT '';
+
+		CREATE INDEX audit_entries_chain_idx ON audit_entries (chain, seq);`,
+	},
 }
 
 // Migrate attempts to bring the schema for db up to date with the migrations
@@ -23,11 +23,24 @@ const seeds = `
 			('72f8b983-3eb4-48db-9ed0-e45cc6bd716b', 'McDonalds Toys', 75, 120, '2019-01-01 00:00:02.000001+00', '2019-01-01 00:00:02.000001+00')
 				ON CONFLICT DO NOTHING;
 
-INSERT INTO sales (sale_id, product_id, quantity, paid, date_created) VALUES
-	('98b6d4b8-f04b-4c79-8c2e-a0aef46854b7', 'a2b0639f-2cc6-44b8-b97b-15d69dbb511e', 2, 100, '2019-01-01 00:00:03.000001+00'),
-		('85f6fb09-eb05-4874-ae39-82d1a30fe0d7', 'a2b0639f-2cc6-44b8-b97b-15d69dbb511e', 5, 250, '2019-01-01 00:00:04.000001+00'),
-			('a235be9e-ab5d-44e6-a987-fa1c749264c7', '72f8b983-3eb4-48db-9ed0-e45cc6bd716b', 3, 225, '2019-01-01 00:00:05.000001+00')
+INSERT INTO product_prices (price_id, product_id, cost, effective_from, date_created) VALUES
+	('0bd7b2c4-4f1b-4bd5-9d0f-1e2b6a0c8a11', 'a2b0639f-2cc6-44b8-b97b-15d69dbb511e', 50, '2019-01-01 00:00:01.000001+00', '2019-01-01 00:00:01.000001+00'),
+		('5f0c2a8e-6c3d-4e52-8f3a-7d9b1c2e4f22', '72f8b983-3eb4-48db-9ed0-e45cc6bd716b', 75, '2019-01-01 00:00:02.000001+00', '2019-01-01 00:00:02.000001+00')
+			ON CONFLICT DO NOTHING;
+
+INSERT INTO sales (sale_id, product_id, quantity, paid, list_price, net, gross, base_paid, location_id, date_created) VALUES
+	('98b6d4b8-f04b-4c79-8c2e-a0aef46854b7', 'a2b0639f-2cc6-44b8-b97b-15d69dbb511e', 2, 100, 50, 100, 100, 100, '00000000-0000-0000-0000-000000000001', '2019-01-01 00:00:03.000001+00'),
+		('85f6fb09-eb05-4874-ae39-82d1a30fe0d7', 'a2b0639f-2cc6-44b8-b97b-15d69dbb511e', 5, 250, 50, 250, 250, 250, '00000000-0000-0000-0000-000000000001', '2019-01-01 00:00:04.000001+00'),
+			('a235be9e-ab5d-44e6-a987-fa1c749264c7', '72f8b983-3eb4-48db-9ed0-e45cc6bd716b', 3, 225, 75, 225, 225, 225, '00000000-0000-0000-0000-000000000001', '2019-01-01 00:00:05.000001+00')
 				ON CONFLICT DO NOTHING;
+
+INSERT INTO inventory_movements (movement_id, product_id, sale_id, location_id, kind, quantity, reason, actor, date_created) VALUES
+	('3c1e7a52-90d4-4a8b-b6f1-2d5e8c7a9b01', 'a2b0639f-2cc6-44b8-b97b-15d69dbb511e', NULL, '00000000-0000-0000-0000-000000000001', 'receipt', 42, 'opening balance', 'seed', '2019-01-01 00:00:01.000001+00'),
+		('7e2f9b63-a1c5-4d9e-8a02-3f6d9e8b0c12', '72f8b983-3eb4-48db-9ed0-e45cc6bd716b', NULL, '00000000-0000-0000-0000-000000000001', 'receipt', 120, 'opening balance', 'seed', '2019-01-01 00:00:02.000001+00'),
+			('b4a0c8d1-2e6f-4b13-9c57-8d1f0a2b3c23', 'a2b0639f-2cc6-44b8-b97b-15d69dbb511e', '98b6d4b8-f04b-4c79-8c2e-a0aef46854b7', '00000000-0000-0000-0000-000000000001', 'sale', -2, '', 'seed', '2019-01-01 00:00:03.000001+00'),
+				('c5b1d9e2-3f70-4c24-8d68-9e2a1b3c4d34', 'a2b0639f-2cc6-44b8-b97b-15d69dbb511e', '85f6fb09-eb05-4874-ae39-82d1a30fe0d7', '00000000-0000-0000-0000-000000000001', 'sale', -5, '', 'seed', '2019-01-01 00:00:04.000001+00'),
+					('d6c2eaf3-4081-4d35-9e79-af3b2c4d5e45', '72f8b983-3eb4-48db-9ed0-e45cc6bd716b', 'a235be9e-ab5d-44e6-a987-fa1c749264c7', '00000000-0000-0000-0000-000000000001', 'sale', -3, '', 'seed', '2019-01-01 00:00:05.000001+00')
+						ON CONFLICT DO NOTHING;
 				`
 
 // Seed runs the set of seed-data queries against db. The queries are ran in a
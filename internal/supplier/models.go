@@ -0,0 +1,99 @@
+package supplier
+
+import (
+	"time"
+)
+
+// Statuses a PurchaseOrder moves through. A draft can be sent or cancelled,
+// a sent order is received in one or more deliveries and can be cancelled
+// until it has been received in full.
+const (
+	Draft             = "draft"
+	Sent              = "sent"
+	PartiallyReceived = "partially_received"
+	Received          = "received"
+	Cancelled         = "cancelled"
+)
+
+// Supplier is a business we buy stock from.
+type Supplier struct {
+	ID          string    `db:"supplier_id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	Email       string    `db:"email" json:"email"`
+	Phone       string    `db:"phone" json:"phone"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// NewSupplier is what we require from clients when adding a Supplier.
+type NewSupplier struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+}
+
+// PurchaseOrder is a request to a Supplier for stock.
+type PurchaseOrder struct {
+	ID          string    `db:"order_id" json:"id"`
+	SupplierID  string    `db:"supplier_id" json:"supplier_id"`
+	Status      string    `db:"status" json:"status"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+	Lines       []Line    `db:"-" json:"lines"`
+}
+
+// Line is one product on a PurchaseOrder. UnitCost is what the Supplier
+// charges per unit and Received counts the units delivered so far.
+type Line struct {
+	ID        string  `db:"line_id" json:"id"`
+	OrderID   string  `db:"order_id" json:"order_id"`
+	ProductID string  `db:"product_id" json:"product_id"`
+	VariantID *string `db:"variant_id" json:"variant_id,omitempty"`
+	Quantity  int     `db:"quantity" json:"quantity"`
+	UnitCost  int     `db:"unit_cost" json:"unit_cost"`
+	Received  int     `db:"received" json:"received"`
+}
+
+// NewPurchaseOrder is what we require from clients when raising a
+// PurchaseOrder. New orders start out as drafts.
+type NewPurchaseOrder struct {
+	SupplierID string    `json:"supplier_id"`
+	Lines      []NewLine `json:"lines"`
+}
+
+// NewLine is one product requested on a NewPurchaseOrder.
+type NewLine struct {
+	ProductID string `json:"product_id"`
+	VariantID string `json:"variant_id"`
+	Quantity  int    `json:"quantity"`
+	UnitCost  int    `json:"unit_cost"`
+}
+
+// Receipt records units of a Line arriving in stock. LandedCost is the cost
+// per unit including freight and duties.
+type Receipt struct {
+	ID          string    `db:"receipt_id" json:"id"`
+	OrderID     string    `db:"order_id" json:"order_id"`
+	LineID      string    `db:"line_id" json:"line_id"`
+	LocationID  string    `db:"location_id" json:"location_id"`
+	Quantity    int       `db:"quantity" json:"quantity"`
+	LandedCost  int       `db:"landed_cost" json:"landed_cost"`
+	Actor       string    `db:"actor" json:"actor"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
+
+// NewReceipt is what we require from clients when goods arrive against a
+// PurchaseOrder. Goods are received at the default inventory location unless
+// a LocationID is given. Who receives them is taken from the request.
+type NewReceipt struct {
+	LocationID string           `json:"location_id"`
+	Lines      []NewReceiptLine `json:"lines"`
+}
+
+// NewReceiptLine is the quantity of one Line that arrived. An empty
+// LandedCost means the units cost exactly the Line unit cost.
+type NewReceiptLine struct {
+	LineID     string `json:"line_id"`
+	Quantity   int    `json:"quantity"`
+	LandedCost *int   `json:"landed_cost"`
+}
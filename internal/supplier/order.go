@@ -0,0 +1,415 @@
+package supplier
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/accounting"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/inventory"
+	"github.com/vikramcse/the-service/internal/platform/database"
+)
+
+var (
+	ErrOrderNotFound     = errors.New("Purchase order not found")
+	ErrLineNotFound      = errors.New("Purchase order line not found")
+	ErrProductNotFound   = errors.New("Product not found")
+	ErrEmptyOrder        = errors.New("Purchase order must have at least one line")
+	ErrInvalidQuantity   = errors.New("quantity must be positive")
+	ErrInvalidTransition = errors.New("Purchase order can not move to that status")
+	ErrOverReceipt       = errors.New("more units received than were ordered")
+	ErrInvalidCost       = errors.New("unit and landed cost must not be negative")
+)
+
+// transitions lists the statuses each status can be moved to by hand.
+// Receiving goods moves orders on to partially received or received.
+var transitions = map[string][]string{
+	Draft:             {Sent, Cancelled},
+	Sent:              {Cancelled},
+	PartiallyReceived: {Cancelled},
+}
+
+// CreateOrder raises a draft PurchaseOrder with a Supplier.
+func CreateOrder(ctx context.Context, db *sqlx.DB, npo NewPurchaseOrder, now time.Time) (*PurchaseOrder, error) {
+	if _, err := uuid.Parse(npo.SupplierID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if len(npo.Lines) == 0 {
+		return nil, ErrEmptyOrder
+	}
+
+	po := PurchaseOrder{
+		ID:          uuid.New().String(),
+		SupplierID:  npo.SupplierID,
+		Status:      Draft,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		var exists bool
+		const check = `SELECT EXISTS(SELECT 1 FROM suppliers WHERE supplier_id = $1)`
+		if err := tx.GetContext(ctx, &exists, check, po.SupplierID); err != nil {
+			return errors.Wrap(err, "checking supplier")
+		}
+		if !exists {
+			return ErrNotFound
+		}
+
+		const q = `
+			INSERT INTO purchase_orders
+			(order_id, supplier_id, status, date_created, date_updated)
+			VALUES ($1, $2, $3, $4, $5)`
+
+		if _, err := tx.ExecContext(ctx, q, po.ID, po.SupplierID, po.Status, po.DateCreated, po.DateUpdated); err != nil {
+			return errors.Wrap(err, "inserting purchase order")
+		}
+
+		for _, nl := range npo.Lines {
+			l, err := addLine(ctx, tx, po.ID, nl)
+			if err != nil {
+				return err
+			}
+			po.Lines = append(po.Lines, *l)
+		}
+
+		c := audit.Change{EntityType: auditOrder, EntityID: po.ID, Action: audit.Create, After: po}
+		_, err := audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &po, nil
+}
+
+// ListOrders gets all PurchaseOrders, or only those raised with supplierID
+// when it is not empty. Lines are not included.
+func ListOrders(ctx context.Context, db *sqlx.DB, supplierID string) ([]PurchaseOrder, error) {
+	var sid interface{}
+	if supplierID != "" {
+		if _, err := uuid.Parse(supplierID); err != nil {
+			return nil, ErrInvalidID
+		}
+		sid = supplierID
+	}
+
+	orders := []PurchaseOrder{}
+
+	const q = `
+		SELECT * FROM purchase_orders
+		WHERE $1::uuid IS NULL OR supplier_id = $1
+		ORDER BY date_created`
+
+	if err := db.SelectContext(ctx, &orders, q, sid); err != nil {
+		return nil, errors.Wrap(err, "selecting purchase orders")
+	}
+
+	return orders, nil
+}
+
+// RetriveOrder finds the PurchaseOrder identified by id along with its Lines.
+func RetriveOrder(ctx context.Context, db *sqlx.DB, id string) (*PurchaseOrder, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	return retriveOrder(ctx, db, id, false)
+}
+
+// SetStatus moves a PurchaseOrder to status by hand, which can only be done
+// along the allowed transitions.
+func SetStatus(ctx context.Context, db *sqlx.DB, id, status string, now time.Time) (*PurchaseOrder, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var po *PurchaseOrder
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		var err error
+		if po, err = retriveOrder(ctx, tx, id, true); err != nil {
+			return err
+		}
+
+		if !canMove(po.Status, status) {
+			return ErrInvalidTransition
+		}
+
+		before := *po
+		po.Status = status
+		po.DateUpdated = now.UTC()
+		if err := updateStatus(ctx, tx, po); err != nil {
+			return err
+		}
+
+		c := audit.Change{EntityType: auditOrder, EntityID: po.ID, Action: audit.Update, Before: before, After: po}
+		_, err = audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return po, nil
+}
+
+// Receive books goods arriving against a sent PurchaseOrder. Each delivered
+// line is received into stock through the inventory ledger at its landed
+// cost, and the order becomes received once every line has arrived in full.
+// The goods are received by the actor of the audit.Request in ctx.
+func Receive(ctx context.Context, db *sqlx.DB, nr NewReceipt, id string, now time.Time) ([]Receipt, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+	if len(nr.Lines) == 0 {
+		return nil, ErrEmptyOrder
+	}
+
+	locationID := nr.LocationID
+	if locationID == "" {
+		locationID = inventory.DefaultLocation
+	}
+
+	receipts := []Receipt{}
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		if err := inventory.CheckLocation(ctx, tx, locationID); err != nil {
+			return err
+		}
+
+		po, err := retriveOrder(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+		if po.Status != Sent && po.Status != PartiallyReceived {
+			return ErrInvalidTransition
+		}
+		before := *po
+		before.Lines = append([]Line(nil), po.Lines...)
+
+		lines := make(map[string]*Line, len(po.Lines))
+		for i := range po.Lines {
+			lines[po.Lines[i].ID] = &po.Lines[i]
+		}
+
+		for _, nrl := range nr.Lines {
+			l, ok := lines[nrl.LineID]
+			if !ok {
+				return ErrLineNotFound
+			}
+			if nrl.Quantity <= 0 {
+				return ErrInvalidQuantity
+			}
+			if nrl.LandedCost != nil && *nrl.LandedCost < 0 {
+				return ErrInvalidCost
+			}
+			if l.Received+nrl.Quantity > l.Quantity {
+				return ErrOverReceipt
+			}
+
+			r := Receipt{
+				ID:          uuid.New().String(),
+				OrderID:     po.ID,
+				LineID:      l.ID,
+				LocationID:  locationID,
+				Quantity:    nrl.Quantity,
+				LandedCost:  l.UnitCost,
+				Actor:       audit.Actor(ctx),
+				DateCreated: now.UTC(),
+			}
+			if nrl.LandedCost != nil {
+				r.LandedCost = *nrl.LandedCost
+			}
+
+			if err := receiveLine(ctx, tx, l, r); err != nil {
+				return err
+			}
+			receipts = append(receipts, r)
+		}
+
+		po.Status = Received
+		for _, l := range po.Lines {
+			if l.Received < l.Quantity {
+				po.Status = PartiallyReceived
+				break
+			}
+		}
+		po.DateUpdated = now.UTC()
+
+		if err := updateStatus(ctx, tx, po); err != nil {
+			return err
+		}
+
+		after := struct {
+			*PurchaseOrder
+			Receipts []Receipt `json:"receipts"`
+		}{po, receipts}
+		c := audit.Change{EntityType: auditOrder, EntityID: po.ID, Action: audit.Update, Before: before, After: after}
+		_, err = audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return receipts, nil
+}
+
+// ListReceipts gets every delivery booked against a PurchaseOrder.
+func ListReceipts(ctx context.Context, db *sqlx.DB, id string) ([]Receipt, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	receipts := []Receipt{}
+
+	const q = `SELECT * FROM goods_receipts WHERE order_id = $1 ORDER BY date_created`
+	if err := db.SelectContext(ctx, &receipts, q, id); err != nil {
+		return nil, errors.Wrap(err, "selecting receipts")
+	}
+
+	return receipts, nil
+}
+
+// canMove reports whether an order may be moved from one status to another
+// by hand.
+func canMove(from, to string) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// addLine validates and stores one line of a new order.
+func addLine(ctx context.Context, tx *sqlx.Tx, orderID string, nl NewLine) (*Line, error) {
+	if _, err := uuid.Parse(nl.ProductID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if nl.Quantity <= 0 {
+		return nil, ErrInvalidQuantity
+	}
+	if nl.UnitCost < 0 {
+		return nil, ErrInvalidCost
+	}
+
+	l := Line{
+		ID:        uuid.New().String(),
+		OrderID:   orderID,
+		ProductID: nl.ProductID,
+		Quantity:  nl.Quantity,
+		UnitCost:  nl.UnitCost,
+	}
+
+	var exists bool
+	if nl.VariantID != "" {
+		if _, err := uuid.Parse(nl.VariantID); err != nil {
+			return nil, ErrInvalidID
+		}
+		l.VariantID = &nl.VariantID
+
+		const q = `SELECT EXISTS(SELECT 1 FROM variants WHERE variant_id = $1 AND product_id = $2)`
+		if err := tx.GetContext(ctx, &exists, q, nl.VariantID, nl.ProductID); err != nil {
+			return nil, errors.Wrap(err, "checking variant")
+		}
+	} else {
+		const q = `SELECT EXISTS(SELECT 1 FROM products WHERE product_id = $1)`
+		if err := tx.GetContext(ctx, &exists, q, nl.ProductID); err != nil {
+			return nil, errors.Wrap(err, "checking product")
+		}
+	}
+	if !exists {
+		return nil, ErrProductNotFound
+	}
+
+	const q = `
+		INSERT INTO purchase_order_lines
+		(line_id, order_id, product_id, variant_id, quantity, unit_cost)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+
+	if _, err := tx.ExecContext(ctx, q, l.ID, l.OrderID, l.ProductID, l.VariantID, l.Quantity, l.UnitCost); err != nil {
+		return nil, errors.Wrap(err, "inserting purchase order line")
+	}
+
+	return &l, nil
+}
+
+// receiveLine stores r, counts it against l and takes the units into stock.
+func receiveLine(ctx context.Context, tx *sqlx.Tx, l *Line, r Receipt) error {
+	const q = `
+		INSERT INTO goods_receipts
+		(receipt_id, order_id, line_id, location_id, quantity, landed_cost, actor, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := tx.ExecContext(ctx, q,
+		r.ID, r.OrderID, r.LineID, r.LocationID,
+		r.Quantity, r.LandedCost, r.Actor, r.DateCreated,
+	)
+	if err != nil {
+		return errors.Wrap(err, "inserting receipt")
+	}
+
+	l.Received += r.Quantity
+
+	const u = `UPDATE purchase_order_lines SET received = $2 WHERE line_id = $1`
+	if _, err := tx.ExecContext(ctx, u, l.ID, l.Received); err != nil {
+		return errors.Wrap(err, "updating received quantity")
+	}
+
+	m := inventory.Movement{
+		ProductID:   l.ProductID,
+		VariantID:   l.VariantID,
+		LocationID:  r.LocationID,
+		Kind:        inventory.Receipt,
+		Quantity:    r.Quantity,
+		UnitCost:    &r.LandedCost,
+		Reason:      fmt.Sprintf("purchase order %s", r.OrderID),
+		Actor:       r.Actor,
+		DateCreated: r.DateCreated,
+	}
+	if _, err := inventory.Record(ctx, tx, m); err != nil {
+		return errors.Wrap(err, "recording stock movement")
+	}
+
+	return accounting.RecordReceipt(ctx, tx, r.ID, l.ProductID, r.Quantity, r.LandedCost, r.DateCreated)
+}
+
+// retriveOrder loads an order and its lines. When lock is set the order row
+// is locked for the rest of the transaction.
+func retriveOrder(ctx context.Context, db sqlx.QueryerContext, id string, lock bool) (*PurchaseOrder, error) {
+	q := `SELECT * FROM purchase_orders WHERE order_id = $1`
+	if lock {
+		q += ` FOR UPDATE`
+	}
+
+	var po PurchaseOrder
+	if err := sqlx.GetContext(ctx, db, &po, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOrderNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting purchase order %q", id)
+	}
+
+	po.Lines = []Line{}
+	const lq = `SELECT * FROM purchase_order_lines WHERE order_id = $1 ORDER BY line_id`
+	if err := sqlx.SelectContext(ctx, db, &po.Lines, lq, id); err != nil {
+		return nil, errors.Wrap(err, "selecting purchase order lines")
+	}
+
+	return &po, nil
+}
+
+// updateStatus stores the status of po.
+func updateStatus(ctx context.Context, tx *sqlx.Tx, po *PurchaseOrder) error {
+	const q = `UPDATE purchase_orders SET status = $2, date_updated = $3 WHERE order_id = $1`
+	if _, err := tx.ExecContext(ctx, q, po.ID, po.Status, po.DateUpdated); err != nil {
+		return errors.Wrap(err, "updating purchase order status")
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Package supplier manages who we buy stock from and the purchase orders
+// raised with them.
+package supplier
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/platform/database"
+)
+
+var (
+	ErrNotFound  = errors.New("Supplier not found")
+	ErrInvalidID = errors.New("ID is not in it's proper form")
+)
+
+// Entity types changes to Suppliers and their orders are audited as.
+const (
+	auditSupplier = "supplier"
+	auditOrder    = "purchase_order"
+)
+
+// List gets all Suppliers.
+func List(ctx context.Context, db *sqlx.DB) ([]Supplier, error) {
+	suppliers := []Supplier{}
+
+	const q = `SELECT * FROM suppliers ORDER BY name`
+	if err := db.SelectContext(ctx, &suppliers, q); err != nil {
+		return nil, errors.Wrap(err, "selecting suppliers")
+	}
+
+	return suppliers, nil
+}
+
+// Retrive finds the Supplier identified by id.
+func Retrive(ctx context.Context, db *sqlx.DB, id string) (*Supplier, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var s Supplier
+	const q = `SELECT * FROM suppliers WHERE supplier_id = $1`
+	if err := db.GetContext(ctx, &s, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting supplier %q", id)
+	}
+
+	return &s, nil
+}
+
+// Create adds a Supplier to the database.
+func Create(ctx context.Context, db *sqlx.DB, ns NewSupplier, now time.Time) (*Supplier, error) {
+	s := Supplier{
+		ID:          uuid.New().String(),
+		Name:        ns.Name,
+		Email:       ns.Email,
+		Phone:       ns.Phone,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `
+		INSERT INTO suppliers
+		(supplier_id, name, email, phone, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, q, s.ID, s.Name, s.Email, s.Phone, s.DateCreated, s.DateUpdated)
+		if err != nil {
+			return errors.Wrap(err, "inserting supplier")
+		}
+
+		c := audit.Change{EntityType: auditSupplier, EntityID: s.ID, Action: audit.Create, After: s}
+		_, err = audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
@@ -0,0 +1,99 @@
+package supplier_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vikramcse/the-service/internal/product"
+	"github.com/vikramcse/the-service/internal/supplier"
+	"github.com/vikramcse/the-service/internal/tests"
+)
+
+func TestPurchaseOrders(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	p, err := product.Create(ctx, db, product.NewProduct{Name: "Comic Book", Cost: 10}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	s, err := supplier.Create(ctx, db, supplier.NewSupplier{Name: "Comics Wholesale"}, now)
+	if err != nil {
+		t.Fatalf("creating supplier: %s", err)
+	}
+
+	npo := supplier.NewPurchaseOrder{
+		SupplierID: s.ID,
+		Lines:      []supplier.NewLine{{ProductID: p.ID, Quantity: 10, UnitCost: -4}},
+	}
+	if _, err := supplier.CreateOrder(ctx, db, npo, now); err != supplier.ErrInvalidCost {
+		t.Fatalf("expected %v ordering at a negative cost, got %v", supplier.ErrInvalidCost, err)
+	}
+
+	npo.Lines[0].UnitCost = 4
+	po, err := supplier.CreateOrder(ctx, db, npo, now)
+	if err != nil {
+		t.Fatalf("creating purchase order: %s", err)
+	}
+	if exp, got := supplier.Draft, po.Status; exp != got {
+		t.Fatalf("expected status %q, got %q", exp, got)
+	}
+
+	receive := func(n int) error {
+		nr := supplier.NewReceipt{Lines: []supplier.NewReceiptLine{{LineID: po.Lines[0].ID, Quantity: n}}}
+		_, err := supplier.Receive(ctx, db, nr, po.ID, now)
+		return err
+	}
+
+	if err := receive(4); err != supplier.ErrInvalidTransition {
+		t.Fatalf("expected %v receiving a draft, got %v", supplier.ErrInvalidTransition, err)
+	}
+	if _, err := supplier.SetStatus(ctx, db, po.ID, supplier.Sent, now); err != nil {
+		t.Fatalf("sending purchase order: %s", err)
+	}
+
+	landed := -1
+	nr := supplier.NewReceipt{Lines: []supplier.NewReceiptLine{{LineID: po.Lines[0].ID, Quantity: 4, LandedCost: &landed}}}
+	if _, err := supplier.Receive(ctx, db, nr, po.ID, now); err != supplier.ErrInvalidCost {
+		t.Fatalf("expected %v receiving at a negative landed cost, got %v", supplier.ErrInvalidCost, err)
+	}
+
+	if err := receive(4); err != nil {
+		t.Fatalf("receiving goods: %s", err)
+	}
+	if po, err = supplier.RetriveOrder(ctx, db, po.ID); err != nil {
+		t.Fatalf("retrieving purchase order: %s", err)
+	}
+	if exp, got := supplier.PartiallyReceived, po.Status; exp != got {
+		t.Fatalf("expected status %q, got %q", exp, got)
+	}
+
+	if err := receive(7); err != supplier.ErrOverReceipt {
+		t.Fatalf("expected %v, got %v", supplier.ErrOverReceipt, err)
+	}
+	if err := receive(6); err != nil {
+		t.Fatalf("receiving goods: %s", err)
+	}
+	if po, err = supplier.RetriveOrder(ctx, db, po.ID); err != nil {
+		t.Fatalf("retrieving purchase order: %s", err)
+	}
+	if exp, got := supplier.Received, po.Status; exp != got {
+		t.Fatalf("expected status %q, got %q", exp, got)
+	}
+
+	if _, err := supplier.SetStatus(ctx, db, po.ID, supplier.Cancelled, now); err != supplier.ErrInvalidTransition {
+		t.Fatalf("expected %v cancelling a received order, got %v", supplier.ErrInvalidTransition, err)
+	}
+
+	got, err := product.Retrive(ctx, db, p.ID)
+	if err != nil {
+		t.Fatalf("retrieving product: %s", err)
+	}
+	if exp, got := 10, got.Quantity; exp != got {
+		t.Fatalf("expected quantity %v after receiving, got %v", exp, got)
+	}
+}
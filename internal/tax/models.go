@@ -0,0 +1,36 @@
+package tax
+
+import (
+	"time"
+)
+
+// Rate is the tax charged on a tax class at a Location from EffectiveFrom
+// until a later Rate for the same class and Location takes over. Rate is in
+// hundredths of a percent, so 2000 is 20%. Inclusive prices at the Location
+// already include the tax, otherwise it is added on top.
+type Rate struct {
+	ID            string    `db:"rate_id" json:"id"`
+	LocationID    string    `db:"location_id" json:"location_id"`
+	TaxClass      string    `db:"tax_class" json:"tax_class"`
+	Rate          int       `db:"rate" json:"rate"`
+	Inclusive     bool      `db:"inclusive" json:"inclusive"`
+	EffectiveFrom time.Time `db:"effective_from" json:"effective_from"`
+	DateCreated   time.Time `db:"date_created" json:"date_created"`
+}
+
+// NewRate is what we require from clients when setting a Rate. A nil
+// EffectiveFrom means the Rate takes effect immediately.
+type NewRate struct {
+	LocationID    string     `json:"location_id"`
+	TaxClass      string     `json:"tax_class"`
+	Rate          int        `json:"rate"`
+	Inclusive     bool       `json:"inclusive"`
+	EffectiveFrom *time.Time `json:"effective_from"`
+}
+
+// Amounts is an amount split into the part before tax and the tax on it.
+type Amounts struct {
+	Net   int `json:"net"`
+	Tax   int `json:"tax"`
+	Gross int `json:"gross"`
+}
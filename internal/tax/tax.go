@@ -0,0 +1,193 @@
+// Package tax works out the tax charged on sales from the rates set for each
+// location.
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/vikramcse/the-service/internal/audit"
+	"github.com/vikramcse/the-service/internal/inventory"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/platform/database"
+	"github.com/vikramcse/the-service/internal/product"
+)
+
+var (
+	ErrInvalidID   = errors.New("ID is not in it's proper form")
+	ErrInvalidRate = errors.New("Rate must be between 0 and 10000 hundredths of a percent")
+	ErrNoClass     = errors.New("Tax class must not be empty")
+	ErrRateInPast  = errors.New("Rate can not take effect in the past")
+)
+
+// auditEntity is the entity type changes to Rates are audited as.
+const auditEntity = "tax_rate"
+
+// Calculate splits amount charged at rate, in hundredths of a percent. When
+// inclusive is true amount already has the tax in it, otherwise the tax is
+// added on top. The tax is rounded to the nearest minor unit with halves
+// rounded away from zero, and Net plus Tax always equals Gross.
+func Calculate(amount, rate int, inclusive bool) Amounts {
+	if inclusive {
+		t := divRound(amount*rate, 10000+rate)
+		return Amounts{Net: amount - t, Tax: t, Gross: amount}
+	}
+
+	t := divRound(amount*rate, 10000)
+	return Amounts{Net: amount, Tax: t, Gross: amount + t}
+}
+
+// divRound divides n by the positive d, rounding halves away from zero.
+func divRound(n, d int) int {
+	if n < 0 {
+		return -((-n*2 + d) / (2 * d))
+	}
+	return (n*2 + d) / (2 * d)
+}
+
+// SetRate schedules a new Rate. Rates can not be changed once set; a new Rate
+// with a later EffectiveFrom replaces an old one, which is audited as what
+// came before it.
+func SetRate(ctx context.Context, db *sqlx.DB, nr NewRate, now time.Time) (*Rate, error) {
+	if nr.TaxClass == "" {
+		return nil, ErrNoClass
+	}
+	if nr.Rate < 0 || nr.Rate > 10000 {
+		return nil, ErrInvalidRate
+	}
+	if err := inventory.CheckLocation(ctx, db, nr.LocationID); err != nil {
+		return nil, err
+	}
+
+	r := Rate{
+		ID:            uuid.New().String(),
+		LocationID:    nr.LocationID,
+		TaxClass:      nr.TaxClass,
+		Rate:          nr.Rate,
+		Inclusive:     nr.Inclusive,
+		EffectiveFrom: now.UTC(),
+		DateCreated:   now.UTC(),
+	}
+	if nr.EffectiveFrom != nil {
+		if nr.EffectiveFrom.Before(now) {
+			return nil, ErrRateInPast
+		}
+		r.EffectiveFrom = nr.EffectiveFrom.UTC()
+	}
+
+	const q = `
+		INSERT INTO tax_rates
+		(rate_id, location_id, tax_class, rate, inclusive, effective_from, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	err := database.WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		replaced, err := RateAt(ctx, tx, r.LocationID, r.TaxClass, r.EffectiveFrom)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, q,
+			r.ID, r.LocationID, r.TaxClass, r.Rate, r.Inclusive, r.EffectiveFrom, r.DateCreated,
+		)
+		if err != nil {
+			return errors.Wrap(err, "inserting tax rate")
+		}
+
+		c := audit.Change{EntityType: auditEntity, EntityID: r.ID, Action: audit.Create, Before: replaced, After: r}
+		_, err = audit.Record(ctx, tx, c, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// ListRates gets every Rate set for a Location, oldest first.
+func ListRates(ctx context.Context, db *sqlx.DB, locationID string) ([]Rate, error) {
+	if _, err := uuid.Parse(locationID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	rates := []Rate{}
+
+	const q = `
+		SELECT * FROM tax_rates
+		WHERE location_id = $1
+		ORDER BY tax_class, effective_from, date_created`
+
+	if err := db.SelectContext(ctx, &rates, q, locationID); err != nil {
+		return nil, errors.Wrap(err, "selecting tax rates")
+	}
+
+	return rates, nil
+}
+
+// RateAt finds the Rate for a tax class at a Location at time t. It returns
+// nil when no Rate has been set, in which case no tax is charged.
+func RateAt(ctx context.Context, db sqlx.QueryerContext, locationID, class string, t time.Time) (*Rate, error) {
+	var r Rate
+	const q = `
+		SELECT * FROM tax_rates
+		WHERE location_id = $1 AND tax_class = $2 AND effective_from <= $3
+		ORDER BY effective_from DESC, date_created DESC
+		LIMIT 1`
+
+	if err := sqlx.GetContext(ctx, db, &r, q, locationID, class, t.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "selecting tax rate")
+	}
+
+	return &r, nil
+}
+
+// SaleHook taxes Sales at the Rate for the tax class of the Product at the
+// Location of the Sale. At a tax exclusive Location the tax is added to what
+// the Sale was priced at, so it has to run after any hook that prices Sales.
+// A Sale given what was paid, as when the price was haggled, was paid that
+// amount with its tax in it wherever it was made.
+func SaleHook() product.SaleHook {
+	return product.SaleHook{Before: taxSale}
+}
+
+// taxSale is the Before step of SaleHook.
+func taxSale(ctx context.Context, tx *sqlx.Tx, s *product.Sale, ns product.NewSale) error {
+	var class string
+	const q = `SELECT tax_class FROM products WHERE product_id = $1`
+	if err := tx.GetContext(ctx, &class, q, s.ProductID); err != nil {
+		if err == sql.ErrNoRows {
+			return product.ErrNotFound
+		}
+		return errors.Wrap(err, "selecting tax class")
+	}
+
+	r, err := RateAt(ctx, tx, s.LocationID, class, s.DateCreated)
+	if err != nil {
+		return err
+	}
+
+	s.TaxClass = class
+	if r == nil {
+		s.Net, s.Gross = s.Paid, s.Paid
+		s.Tax = money.Money{Currency: s.Paid.Currency}
+		return nil
+	}
+
+	inclusive := r.Inclusive || ns.Paid != nil
+	a := Calculate(s.Paid.Amount, r.Rate, inclusive)
+	s.Net = money.Money{Amount: a.Net, Currency: s.Paid.Currency}
+	s.Tax = money.Money{Amount: a.Tax, Currency: s.Paid.Currency}
+	s.Gross = money.Money{Amount: a.Gross, Currency: s.Paid.Currency}
+	s.Paid = s.Gross
+	s.TaxRate = r.Rate
+	s.TaxInclusive = inclusive
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package tax_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vikramcse/the-service/internal/inventory"
+	"github.com/vikramcse/the-service/internal/money"
+	"github.com/vikramcse/the-service/internal/pricing"
+	"github.com/vikramcse/the-service/internal/product"
+	"github.com/vikramcse/the-service/internal/report"
+	"github.com/vikramcse/the-service/internal/reservation"
+	"github.com/vikramcse/the-service/internal/tax"
+	"github.com/vikramcse/the-service/internal/tests"
+)
+
+func TestCalculate(t *testing.T) {
+	tests := []struct {
+		amount    int
+		rate      int
+		inclusive bool
+		exp       tax.Amounts
+	}{
+		{1000, 2000, false, tax.Amounts{Net: 1000, Tax: 200, Gross: 1200}},
+		{1200, 2000, true, tax.Amounts{Net: 1000, Tax: 200, Gross: 1200}},
+		{999, 2000, true, tax.Amounts{Net: 832, Tax: 167, Gross: 999}}, // 166.5 rounds up.
+		{5, 1000, false, tax.Amounts{Net: 5, Tax: 1, Gross: 6}},        // 0.5 rounds up.
+		{-5, 1000, false, tax.Amounts{Net: -5, Tax: -1, Gross: -6}},    // Refunds mirror sales.
+		{1999, 0, true, tax.Amounts{Net: 1999, Tax: 0, Gross: 1999}},
+	}
+
+	for _, tt := range tests {
+		got := tax.Calculate(tt.amount, tt.rate, tt.inclusive)
+		if got != tt.exp {
+			t.Errorf("Calculate(%d, %d, %v): expected %+v, got %+v", tt.amount, tt.rate, tt.inclusive, tt.exp, got)
+		}
+	}
+}
+
+func TestSaleHook(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	later := now.Add(24 * time.Hour)
+	ctx := context.Background()
+
+	p, err := product.Create(ctx, db, product.NewProduct{Name: "Comic Book", Cost: 10, Quantity: 10}, now)
+	if err != nil {
+		t.Fatalf("creating product: %s", err)
+	}
+	if p.TaxClass != product.DefaultTaxClass {
+		t.Fatalf("expected tax class %q, got %q", product.DefaultTaxClass, p.TaxClass)
+	}
+
+	store, err := inventory.CreateLocation(ctx, db, inventory.NewLocation{Name: "Store", Kind: inventory.Store}, now)
+	if err != nil {
+		t.Fatalf("creating location: %s", err)
+	}
+
+	rates := []tax.NewRate{
+		{LocationID: inventory.DefaultLocation, TaxClass: product.DefaultTaxClass, Rate: 2000, Inclusive: true},
+		{LocationID: store.ID, TaxClass: product.DefaultTaxClass, Rate: 1000},
+		{LocationID: store.ID, TaxClass: product.DefaultTaxClass, Rate: 500, EffectiveFrom: &later},
+	}
+	for _, nr := range rates {
+		if _, err := tax.SetRate(ctx, db, nr, now); err != nil {
+			t.Fatalf("setting rate: %s", err)
+		}
+	}
+
+	// Sales priced at the list price have tax added at the store, while a
+	// haggled price is what the customer pays with the tax in it.
+	hooks := []product.SaleHook{pricing.SaleHook(), tax.SaleHook()}
+	sales := []struct {
+		ns       product.NewSale
+		at       time.Time
+		exp      tax.Amounts
+		discount int
+	}{
+		{product.NewSale{Quantity: 1, Paid: &money.Money{Amount: 12}}, now, tax.Amounts{Net: 10, Tax: 2, Gross: 12}, -2},
+		{product.NewSale{LocationID: store.ID, Quantity: 2}, now, tax.Amounts{Net: 20, Tax: 2, Gross: 22}, 0},
+		{product.NewSale{LocationID: store.ID, Quantity: 2}, later, tax.Amounts{Net: 20, Tax: 1, Gross: 21}, 0},
+		{product.NewSale{LocationID: store.ID, Quantity: 2, Paid: &money.Money{Amount: 20}}, later, tax.Amounts{Net: 19, Tax: 1, Gross: 20}, 0},
+	}
+	for i, tt := range sales {
+		s, err := product.AddSale(ctx, db, tt.ns, p.ID, tt.at, hooks...)
+		if err != nil {
+			t.Fatalf("adding sale %d: %s", i, err)
+		}
+		got := tax.Amounts{Net: s.Net.Amount, Tax: s.Tax.Amount, Gross: s.Gross.Amount}
+		if got != tt.exp || s.Paid.Amount != tt.exp.Gross {
+			t.Fatalf("sale %d: expected %+v, got %+v paid %v", i, tt.exp, got, s.Paid)
+		}
+		if d, err := s.Discount(); err != nil || d.Amount != tt.discount {
+			t.Fatalf("sale %d: expected discount %v, got %v %v", i, tt.discount, d, err)
+		}
+	}
+
+	rep, err := report.Tax(ctx, db, report.TaxQuery{LocationID: store.ID})
+	if err != nil {
+		t.Fatalf("building tax report: %s", err)
+	}
+	exp := []report.TaxTotal{{Currency: "USD", Net: 59, Tax: 4, Gross: 63}}
+	if len(rep.Lines) != 3 || !cmp.Equal(exp, rep.Totals) {
+		t.Fatalf("expected three lines totalling 59 + 4 = 63 USD, got %+v", rep)
+	}
+
+	// An order confirmed at the store is taxed like a sale made there.
+	r, err := reservation.Create(ctx, db, reservation.NewReservation{ProductID: p.ID, LocationID: store.ID, Quantity: 1}, later)
+	if err != nil {
+		t.Fatalf("reserving stock: %s", err)
+	}
+	r, err = reservation.Confirm(ctx, db, reservation.Confirmation{}, r.ID, later, hooks...)
+	if err != nil {
+		t.Fatalf("confirming reservation: %s", err)
+	}
+	if r.Sale == nil || r.Sale.Net.Amount != 10 || r.Sale.Tax.Amount != 1 || r.Sale.Gross.Amount != 11 {
+		t.Fatalf("expected order taxed 10 + 1 = 11, got %+v", r.Sale)
+	}
+}